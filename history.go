@@ -0,0 +1,188 @@
+//go:build windows && amd64
+
+package main
+
+import (
+	"sync"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/workturnedplay/wincoe"
+
+	"github.com/workturnedplay/winbollocks/winlayout"
+)
+
+/* ---------------- Per-window geometry undo/redo ---------------- */
+
+// ESC mid-gesture (see cancelActiveGesture) only helps while the gesture
+// is still in progress. Once a carefully sized window has been dropped
+// somewhere else -- or swept up by a layout restore, a tile or a rescue --
+// its old geometry is gone. So every completed gesture and every such bulk
+// action first records the geometry it's about to change, per window, and
+// the undo/redo hotkeys step the window under the cursor back and forth
+// through that history.
+//
+// History is keyed by HWND and pruned when the window is destroyed (see
+// winEventProc's EVENT_OBJECT_DESTROY case), so a recycled HWND value never
+// inherits a dead window's history.
+
+// windowGeometry is one history entry: what placeWindow needs to put a
+// window back. rect is the restored (normal) rect in screen coordinates,
+// also for a maximized window -- see liveWindowState.
+type windowGeometry struct {
+	state winlayout.State
+	rect  winlayout.Rect
+}
+
+// geometryHistory is one window's undo and redo stacks, most recent last.
+type geometryHistory struct {
+	undo, redo []windowGeometry
+}
+
+// maxGeometryHistoryPerWindow bounds each window's undo (and redo) stack;
+// the oldest entries fall off. maxGeometryHistoryWindows bounds how many
+// windows have a history at all, as a backstop for destroy events we
+// never saw (e.g. while a higher-integrity window had our hooks blinded).
+const (
+	maxGeometryHistoryPerWindow = 32
+	maxGeometryHistoryWindows   = 512
+)
+
+// geometryHistories is written from the hook thread (gesture ends, see
+// softReset) as well as the main thread, hence the mutex.
+var (
+	geometryHistoryMu sync.Mutex
+	geometryHistories = map[windows.Handle]*geometryHistory{}
+)
+
+// pushGeometryHistory records g as hwnd's geometry before a change,
+// clearing hwnd's redo stack as any new change does. A minimized geometry
+// isn't recorded (the undo hotkey acts on the window under the cursor,
+// which can't be minimized), and neither is a repeat of the newest entry.
+func pushGeometryHistory(hwnd windows.Handle, g windowGeometry) {
+	if hwnd == 0 || g.state == winlayout.StateMinimized {
+		return
+	}
+	journalGeometry(hwnd, g)
+	geometryHistoryMu.Lock()
+	defer geometryHistoryMu.Unlock()
+	h := geometryHistories[hwnd]
+	if h == nil {
+		if len(geometryHistories) >= maxGeometryHistoryWindows {
+			for victim := range geometryHistories { // any one will do; see maxGeometryHistoryWindows
+				delete(geometryHistories, victim)
+				break
+			}
+		}
+		h = &geometryHistory{}
+		geometryHistories[hwnd] = h
+	}
+	if n := len(h.undo); n > 0 && h.undo[n-1] == g {
+		return
+	}
+	h.undo = appendBounded(h.undo, g)
+	h.redo = h.redo[:0]
+}
+
+// appendBounded appends g to stack, dropping the oldest entry if that
+// would exceed maxGeometryHistoryPerWindow.
+func appendBounded(stack []windowGeometry, g windowGeometry) []windowGeometry {
+	if len(stack) >= maxGeometryHistoryPerWindow {
+		stack = append(stack[:0], stack[1:]...)
+	}
+	return append(stack, g)
+}
+
+// recordWindowGeometry records hwnd's current geometry, for bulk actions
+// about to move it (see pushGeometryHistory). Main thread only.
+func recordWindowGeometry(hwnd windows.Handle, wsDX, wsDY int32) {
+	if state, rect, ok := liveWindowState(hwnd, wsDX, wsDY); ok {
+		pushGeometryHistory(hwnd, windowGeometry{state, rect})
+	}
+}
+
+// recordGestureGeometry records where session's window was before the
+// gesture, when that gesture ends (see softReset). Uses the session's own
+// gesture-start snapshot rather than querying the window, since softReset
+// mostly runs on the hook thread. A gesture that didn't actually change
+// anything (a click without a drag, an ESC-cancelled one) leaves an entry
+// equal to the window's current geometry, which stepGeometryHistory simply
+// skips.
+func recordGestureGeometry(session *dragSession) {
+	r := session.originalRect
+	g := windowGeometry{state: winlayout.StateNormal, rect: winlayout.Rect{Left: r.Left, Top: r.Top, Right: r.Right, Bottom: r.Bottom}}
+	if session.wasMaximizedAtStart {
+		g.state = winlayout.StateMaximized
+	}
+	pushGeometryHistory(session.targetWnd, g)
+}
+
+// forgetGeometryHistory drops hwnd's history; called when it's destroyed.
+func forgetGeometryHistory(hwnd windows.Handle) {
+	geometryHistoryMu.Lock()
+	delete(geometryHistories, hwnd)
+	geometryHistoryMu.Unlock()
+}
+
+// stepGeometryHistory undoes (back) or redoes (!back) hwnd's last change,
+// moving its current geometry onto the opposite stack so the step can
+// itself be reversed. Entries equal to the current geometry are discarded
+// on the way. Reports whether there was anything to step to. Main thread
+// only.
+func stepGeometryHistory(hwnd windows.Handle, back bool) bool {
+	wsDX, wsDY := primaryWorkspaceOffset()
+	state, rect, ok := liveWindowState(hwnd, wsDX, wsDY)
+	if !ok {
+		return false
+	}
+	cur := windowGeometry{state, rect}
+
+	geometryHistoryMu.Lock()
+	h := geometryHistories[hwnd]
+	if h == nil {
+		geometryHistoryMu.Unlock()
+		return false
+	}
+	from, to := &h.undo, &h.redo
+	if !back {
+		from, to = to, from
+	}
+	for len(*from) > 0 && (*from)[len(*from)-1] == cur {
+		*from = (*from)[:len(*from)-1]
+	}
+	if len(*from) == 0 {
+		geometryHistoryMu.Unlock()
+		return false
+	}
+	target := (*from)[len(*from)-1]
+	*from = (*from)[:len(*from)-1]
+	*to = appendBounded(*to, cur)
+	geometryHistoryMu.Unlock()
+
+	return placeWindow(hwnd, cur.state, target.state, target.rect)
+}
+
+// stepGeometryHistoryUnderCursor is the undo/redo hotkeys' action: step
+// the top-level window under the mouse cursor.
+func stepGeometryHistoryUnderCursor(back bool) {
+	verb := "redo"
+	if back {
+		verb = "undo"
+	}
+	var pt wincoe.POINT
+	if res := wincoe.GetCursorPos(&pt); res.Failed() {
+		logf("stepGeometryHistoryUnderCursor(%s): GetCursorPos failed: %v", verb, res.Err)
+		return
+	}
+	hwnd, res := wincoe.RootWindowFromPoint(pt)
+	if hwnd == 0 {
+		logf("stepGeometryHistoryUnderCursor(%s): no window under the cursor at (%d,%d): %v", verb, pt.X, pt.Y, res)
+		return
+	}
+	if !stepGeometryHistory(hwnd, back) {
+		logf("stepGeometryHistoryUnderCursor(%s): nothing to %s for HWND=0x%X %q", verb, verb, hwnd, getWindowTextFast(hwnd))
+		showTrayInfo(selfName, "Nothing to "+verb+" for this window.")
+		return
+	}
+	logf("stepGeometryHistoryUnderCursor(%s): stepped HWND=0x%X %q", verb, hwnd, getWindowTextFast(hwnd))
+}
@@ -0,0 +1,107 @@
+// Package hotcorner decides which screen corner, if any, the cursor is in.
+// Like the other geometry packages it is pure -- monitor rectangles in, a
+// corner out, no Win32 -- so multi-monitor arrangements are tested on
+// synthetic desktops; the main package (see hotcorners.go) feeds it the
+// monitors and times the dwell.
+//
+// Rectangles follow Win32 RECT conventions (exclusive Right/Bottom, screen
+// pixels).
+package hotcorner
+
+// Point is a screen position.
+type Point struct {
+	X, Y int32
+}
+
+// Rect is a monitor's rectangle, with exclusive Right/Bottom.
+type Rect struct {
+	Left, Top, Right, Bottom int32
+}
+
+func (r Rect) contains(p Point) bool {
+	return p.X >= r.Left && p.X < r.Right && p.Y >= r.Top && p.Y < r.Bottom
+}
+
+// Corner is one of a monitor's four corners.
+type Corner uint8
+
+const (
+	None Corner = iota
+	TopLeft
+	TopRight
+	BottomLeft
+	BottomRight
+)
+
+// Corners lists the four real corners, in the order their names are.
+var Corners = []Corner{TopLeft, TopRight, BottomLeft, BottomRight}
+
+// String returns the corner's name as settings spell it.
+func (c Corner) String() string {
+	switch c {
+	case TopLeft:
+		return "topLeft"
+	case TopRight:
+		return "topRight"
+	case BottomLeft:
+		return "bottomLeft"
+	case BottomRight:
+		return "bottomRight"
+	}
+	return "none"
+}
+
+// Hit is a corner the cursor is in: which one, of which monitor (an index
+// into the monitors Locate was given).
+type Hit struct {
+	Corner  Corner
+	Monitor int
+}
+
+// Locate returns the corner pt is within sizePx pixels of (both ways), or
+// a zero Hit. Only corners the cursor can actually press into count: a
+// corner where the desktop goes on, past either of its edges, onto
+// another monitor is just a point on the way there, and triggering on it
+// would fire every time the cursor crosses between the monitors.
+func Locate(monitors []Rect, pt Point, sizePx int32) Hit {
+	if sizePx < 1 {
+		sizePx = 1
+	}
+	for i, m := range monitors {
+		if !m.contains(pt) {
+			continue
+		}
+		var c Corner
+		left, top := pt.X < m.Left+sizePx, pt.Y < m.Top+sizePx
+		right, bottom := pt.X >= m.Right-sizePx, pt.Y >= m.Bottom-sizePx
+		// beyondX/beyondY are the pixels just past the corner's vertical
+		// and horizontal edges.
+		var beyondX, beyondY Point
+		switch {
+		case left && top:
+			c, beyondX, beyondY = TopLeft, Point{m.Left - 1, m.Top}, Point{m.Left, m.Top - 1}
+		case right && top:
+			c, beyondX, beyondY = TopRight, Point{m.Right, m.Top}, Point{m.Right - 1, m.Top - 1}
+		case left && bottom:
+			c, beyondX, beyondY = BottomLeft, Point{m.Left - 1, m.Bottom - 1}, Point{m.Left, m.Bottom}
+		case right && bottom:
+			c, beyondX, beyondY = BottomRight, Point{m.Right, m.Bottom - 1}, Point{m.Right - 1, m.Bottom}
+		default:
+			return Hit{}
+		}
+		if onAny(monitors, beyondX) || onAny(monitors, beyondY) {
+			return Hit{}
+		}
+		return Hit{Corner: c, Monitor: i}
+	}
+	return Hit{}
+}
+
+func onAny(monitors []Rect, p Point) bool {
+	for _, m := range monitors {
+		if m.contains(p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package hotcorner
+
+import "testing"
+
+// desktop is a 1920x1080 primary monitor with a 1280x1024 one to its left,
+// bottom-aligned, so the left monitor's top sits 56px lower.
+var desktop = []Rect{
+	{0, 0, 1920, 1080},
+	{-1280, 56, 0, 1080},
+}
+
+func TestLocate(t *testing.T) {
+	tests := []struct {
+		name string
+		pt   Point
+		want Hit
+	}{
+		{"middle of the screen", Point{960, 540}, Hit{}},
+		{"primary top-right", Point{1919, 0}, Hit{TopRight, 0}},
+		{"primary bottom-right within size", Point{1917, 1077}, Hit{BottomRight, 0}},
+		{"just outside size", Point{1915, 1077}, Hit{}},
+		{"left monitor top-left", Point{-1280, 56}, Hit{TopLeft, 1}},
+		{"left monitor bottom-left", Point{-1280, 1079}, Hit{BottomLeft, 1}},
+		{"primary top-left is a real corner: the left monitor starts lower", Point{0, 0}, Hit{TopLeft, 0}},
+		{"primary bottom-left leads onto the left monitor", Point{0, 1079}, Hit{}},
+		{"left monitor bottom-right leads onto the primary", Point{-1, 1079}, Hit{}},
+		{"left monitor top-right: the primary goes on past its right edge", Point{-1, 56}, Hit{}},
+		{"off every monitor", Point{-1, 0}, Hit{}},
+	}
+	for _, tt := range tests {
+		if got := Locate(desktop, tt.pt, 4); got != tt.want {
+			t.Errorf("%s: Locate = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLocateMinimumSize(t *testing.T) {
+	if got := Locate(desktop, Point{1919, 0}, 0); got != (Hit{TopRight, 0}) {
+		t.Errorf("size 0 must still catch the corner pixel, got %+v", got)
+	}
+	if got := Locate(desktop, Point{1918, 0}, 0); got != (Hit{}) {
+		t.Errorf("size 0 must act as 1px, got %+v", got)
+	}
+}
+
+func TestCornerString(t *testing.T) {
+	want := []string{"topLeft", "topRight", "bottomLeft", "bottomRight"}
+	for i, c := range Corners {
+		if c.String() != want[i] {
+			t.Errorf("Corners[%d].String() = %q, want %q", i, c.String(), want[i])
+		}
+	}
+	if None.String() != "none" {
+		t.Errorf("None.String() = %q", None.String())
+	}
+}
@@ -0,0 +1,328 @@
+//go:build windows && amd64
+
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/workturnedplay/wincoe"
+
+	"github.com/workturnedplay/winbollocks/hotcorner"
+)
+
+/* ---------------- Hot corners ---------------- */
+
+// Resting the cursor in a screen corner -- or pushing it into one, which
+// is the same thing to the cursor -- for hotCornerDelayMs runs the action
+// configured for that corner: show the desktop, send the foreground window
+// to the back, tile, or pick a window from a menu. Every monitor's corners
+// count, as long as the cursor can really press into them (see
+// hotcorner.Locate); each corner position (top-left, ...) has one action,
+// shared by all monitors.
+//
+// Like focus-follows-mouse, mouseProc only notices things: on each move
+// outside a gesture it looks the cursor up in hotCornerMonitors, a
+// snapshot the main thread keeps, and only when the corner it's in changes
+// does it publish that and post WM_HOT_CORNER, at most one in flight. The
+// main thread (re)arms or kills a one-shot timer, and the action runs when
+// it fires, unless a gesture started or a button went down meanwhile. An
+// action fires once per visit; the cursor has to leave the corner to fire
+// it again.
+
+// hotCornerAction is what a corner does; persisted by name (see
+// hotCornerActionNames).
+type hotCornerAction int32
+
+const (
+	hotCornerNone hotCornerAction = iota
+	hotCornerShowDesktop
+	hotCornerSendToBack
+	hotCornerTile
+	hotCornerWindowPicker
+	hotCornerActionCount // not an action; bounds the ones above
+)
+
+// hotCornerActionNames are the actions' names in settingsFilePath, and
+// hotCornerActionLabels their tray texts; both indexed by hotCornerAction.
+var (
+	hotCornerActionNames  = []string{"none", "show-desktop", "send-to-back", "tile", "window-picker"}
+	hotCornerActionLabels = []string{"Nothing", "Show the desktop", "Send the focused window to the back", "Tile the windows on that monitor", "Pick a window from a menu"}
+)
+
+// hotCornerActions holds each corner's action, indexed by
+// hotcorner.Corner-1 (see hotcorner.Corners). All hotCornerNone by
+// default. Persisted (see hotCornerActionSetting).
+var hotCornerActions [4]atomic.Int32
+
+// hotCornerDelayMs is how long the cursor must stay in a corner. Persisted,
+// settable like focusFollowsMouseDelayMs.
+var hotCornerDelayMs atomic.Int32
+
+// Allowed range for hotCornerDelayMs -- see atomicInt32Setting.
+const (
+	hotCornerDelayMsMin int32 = 50
+	hotCornerDelayMsMax int32 = 5000
+)
+
+// hotCornerDelayMsPresets are the values offered by the tray's delay
+// submenu (see MENU_HOT_CORNER_DELAY_BASE). Each must lie within
+// [hotCornerDelayMsMin, hotCornerDelayMsMax].
+var hotCornerDelayMsPresets = []int32{100, 250, 400, 750, 1500}
+
+// hotCornerSizePx is how close to a corner, both ways, counts as in it.
+const hotCornerSizePx = 3
+
+// maxWindowsInPicker bounds the window picker's menu.
+const maxWindowsInPicker = 40
+
+// hotCornerMonitors is the desktop's monitors for mouseProc to look the
+// cursor up in, refreshed on the main thread (refreshHotCornerMonitors)
+// at startup and on display changes.
+var hotCornerMonitors atomic.Pointer[[]hotcorner.Rect]
+
+// hotCornerLastHit is the corner the cursor was in at the last move
+// mouseProc looked at. Hook thread only.
+var hotCornerLastHit hotcorner.Hit
+
+// hotCornerHit is hotCornerLastHit as published to the main thread, and
+// hotCornerPending is true while a WM_HOT_CORNER is in flight.
+var (
+	hotCornerHit     atomic.Pointer[hotcorner.Hit]
+	hotCornerPending atomic.Bool
+)
+
+// hotCornersWanted reports whether any corner has an action.
+func hotCornersWanted() bool {
+	for i := range hotCornerActions {
+		if hotCornerAction(hotCornerActions[i].Load()) != hotCornerNone {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshHotCornerMonitors snapshots the monitors for mouseProc. Main
+// thread only (see enumMonitors).
+func refreshHotCornerMonitors() {
+	mons := enumMonitors()
+	rects := make([]hotcorner.Rect, 0, len(mons))
+	for _, m := range mons {
+		r := m.rcMonitor
+		rects = append(rects, hotcorner.Rect{Left: r.Left, Top: r.Top, Right: r.Right, Bottom: r.Bottom})
+	}
+	hotCornerMonitors.Store(&rects)
+}
+
+// noteHotCornerMove is called by mouseProc on every mouse move outside a
+// gesture; see the top of this file.
+func noteHotCornerMove(pt wincoe.POINT) {
+	var hit hotcorner.Hit
+	if mons := hotCornerMonitors.Load(); mons != nil && hotCornersWanted() {
+		hit = hotcorner.Locate(*mons, hotcorner.Point{X: pt.X, Y: pt.Y}, hotCornerSizePx)
+	}
+	if hit == hotCornerLastHit {
+		return
+	}
+	hotCornerLastHit = hit
+	hotCornerHit.Store(&hit)
+	if !hotCornerPending.CompareAndSwap(false, true) {
+		return
+	}
+	main := loadMainMsgHwnd()
+	if main == 0 {
+		hotCornerPending.Store(false)
+		return
+	}
+	if res := wincoe.PostMessage(main, WM_HOT_CORNER, 0, 0); res.Failed() {
+		hotCornerPending.Store(false) // the next corner change retries
+	}
+}
+
+// handleHotCorner is wndProc's WM_HOT_CORNER handler: start timing the
+// corner the cursor entered, or stop when it left.
+func handleHotCorner(hwnd windows.Handle) {
+	hotCornerPending.Store(false)
+	if hit := hotCornerHit.Load(); hit == nil || hit.Corner == hotcorner.None {
+		_ = wincoe.KillTimer(hwnd, hotCornerTimerID) // fails harmlessly if it isn't running
+		return
+	}
+	// #nosec G115 -- safe: clamped to [hotCornerDelayMsMin, hotCornerDelayMsMax] when loaded
+	if _, res := wincoe.SetTimer(hwnd, hotCornerTimerID, uint32(hotCornerDelayMs.Load()), 0); res.Failed() {
+		logf("handleHotCorner: SetTimer failed: %v", res.Err)
+	}
+}
+
+// onHotCornerTimer runs once the cursor has stayed in a corner for the
+// delay: run its action, unless a gesture is running, a button is held or
+// a menu is open.
+func onHotCornerTimer(hwnd windows.Handle) {
+	if res := wincoe.KillTimer(hwnd, hotCornerTimerID); res.Failed() {
+		logf("onHotCornerTimer: KillTimer failed: %v", res.Err)
+	}
+	hit := hotCornerHit.Load()
+	if hit == nil || hit.Corner == hotcorner.None || activeSession.Load() != nil || anyMouseButtonDown() || foregroundThreadBusy() {
+		return
+	}
+	action := hotCornerAction(hotCornerActions[hit.Corner-1].Load())
+	if action == hotCornerNone {
+		return
+	}
+	logf("onHotCornerTimer: %s corner of monitor #%d: %s", hit.Corner, hit.Monitor, hotCornerActionNames[action])
+	switch action {
+	case hotCornerShowDesktop:
+		showDesktop()
+	case hotCornerSendToBack:
+		sendForegroundToBack()
+	case hotCornerTile:
+		_ = tileWindowsAndNotify("") // the cursor's monitor, which is the corner's
+	case hotCornerWindowPicker:
+		pickWindowFromMenu(hwnd)
+	}
+}
+
+// shellToggleDesktopCmd is the taskbar's own "Show the desktop" command,
+// the one Win+D runs; undocumented but stable since Windows 7.
+const shellToggleDesktopCmd = 407
+
+// WM_COMMAND is the menu/command message, which wincoe doesn't export.
+const WM_COMMAND = 0x0111
+
+// showDesktop toggles the desktop the way Win+D does, through the taskbar,
+// so a second trip to the corner brings the windows back.
+func showDesktop() {
+	className, err := windows.UTF16PtrFromString("Shell_TrayWnd")
+	if err != nil {
+		return
+	}
+	res := procFindWindowW.Call(uintptr(unsafe.Pointer(className)), 0)
+	if res.Failed() {
+		logf("showDesktop: no taskbar to ask: %v", res.Err)
+		return
+	}
+	if r := wincoe.PostMessage(windows.Handle(res.R1), WM_COMMAND, shellToggleDesktopCmd, 0); r.Failed() {
+		logf("showDesktop: PostMessage failed: %v", r.Err)
+	}
+}
+
+// sendForegroundToBack sends the foreground window to HWND_BOTTOM exactly
+// like a Win+MMB on it, including the sent-to-back stack and
+// unfocusSentToBackWindow. Main thread only.
+func sendForegroundToBack() {
+	hwnd := getForegroundWindow()
+	if hwnd == 0 || !isManageableTopLevelWindow(hwnd) {
+		logf("sendForegroundToBack: no eligible foreground window (HWND=0x%X)", hwnd)
+		return
+	}
+	applyZOrderChangeNow(WindowMoveData{
+		Hwnd:                                hwnd,
+		InsertAfter:                         wincoe.HWND_BOTTOM,
+		Flags:                               wincoe.SWP_NOMOVE | wincoe.SWP_NOSIZE | wincoe.SWP_NOACTIVATE,
+		ZOrderAction:                        zOrderActionSendToBack,
+		TargetWasForegroundBeforeSendToBack: true,
+		UnfocusAfterSuccessfulSendToBack:    unfocusSentToBackWindow.Load(),
+	})
+}
+
+// pickWindowFromMenu pops up a menu of every window, topmost first, at the
+// cursor, and focuses (un-minimizing if need be) the one picked. Uses the
+// same foreground dance as the tray menu (see WM_MYSYSTRAY), so clicking
+// away dismisses it.
+func pickWindowFromMenu(hwnd windows.Handle) {
+	var picks []windows.Handle
+	forEachTopLevelWindow(func(w windows.Handle) bool {
+		if isManageableTopLevelWindow(w) {
+			picks = append(picks, w)
+		}
+		return len(picks) < maxWindowsInPicker
+	})
+	if len(picks) == 0 {
+		return
+	}
+	hMenu, res := wincoe.CreatePopupMenu()
+	if res.Failed() {
+		logf("pickWindowFromMenu: CreatePopupMenu failed: %v", res.Err)
+		return
+	}
+	defer func() { _ = wincoe.DestroyMenu(hMenu) }()
+	for i, w := range picks {
+		title := pinMenuTitle(w)
+		if isMinimized(w) {
+			title += "  (minimized)"
+		}
+		appendMenuChecked(hMenu, wincoe.MF_STRING, uintptr(i+1), title) // 0 means dismissed
+	}
+	var pt wincoe.POINT
+	if r := wincoe.GetCursorPos(&pt); r.Failed() {
+		return
+	}
+	setForegroundWindow(hwnd, "pickWindowFromMenu: SetForegroundWindow(self) failed")
+	cmd, _ := wincoe.TrackPopupMenuCmd(hMenu, wincoe.TPM_RETURNCMD, pt.X, pt.Y, hwnd, nil) //nolint:errcheck // see WM_MYSYSTRAY
+	_ = wincoe.SendMessage(hwnd, wincoe.WM_NULL, 0, 0)
+	if cmd == 0 || int(cmd) > len(picks) {
+		return
+	}
+	w := picks[cmd-1]
+	if isMinimized(w) {
+		showWindowAsync(w, windows.SW_RESTORE)
+	}
+	if !forceForeground(w) {
+		logf("pickWindowFromMenu: couldn't activate HWND=0x%X %q", w, getWindowTextFast(w))
+	}
+}
+
+// hotCornerMenuTexts are the corners' names in the tray, indexed like
+// hotCornerActions.
+var hotCornerMenuTexts = []string{"Top-left corner", "Top-right corner", "Bottom-left corner", "Bottom-right corner"}
+
+// appendHotCornersMenu appends the tray's "Hot corners" submenu: one
+// action submenu per corner, then the delay submenu.
+func appendHotCornersMenu(hMenu windows.Handle) {
+	hSub, res := wincoe.CreatePopupMenu()
+	if res.Failed() {
+		logf("appendHotCornersMenu: CreatePopupMenu failed: %v", res.Err)
+		return
+	}
+	for i, text := range hotCornerMenuTexts {
+		hCorner, res2 := wincoe.CreatePopupMenu()
+		if res2.Failed() {
+			logf("appendHotCornersMenu: CreatePopupMenu failed for %s: %v", text, res2.Err)
+			continue
+		}
+		cur := hotCornerAction(hotCornerActions[i].Load())
+		for a := range hotCornerActionCount {
+			var flags uint32 = wincoe.MF_STRING
+			if a == cur {
+				flags |= wincoe.MF_CHECKED
+			}
+			appendMenuChecked(hCorner, flags, uintptr(MENU_HOT_CORNER_ACTION_BASE+i*int(hotCornerActionCount)+int(a)), hotCornerActionLabels[a])
+		}
+		appendMenuChecked(hSub, wincoe.MF_STRING|MF_POPUP, uintptr(hCorner), text+": "+hotCornerActionLabels[cur])
+	}
+	appendInt32PresetSubmenu(hSub, "Delay", hotCornerDelayMsPresets, hotCornerDelayMs.Load(),
+		MENU_HOT_CORNER_DELAY_BASE, func(v int32) string { return fmt.Sprintf("%dms", v) })
+	label := "Hot corners: off"
+	if hotCornersWanted() {
+		label = "Hot corners: on"
+	}
+	appendMenuChecked(hMenu, wincoe.MF_STRING|MF_POPUP, uintptr(hSub), label)
+}
+
+// handleHotCornersMenuCommand runs the tray command produced by
+// appendHotCornersMenu, reporting whether cmd was one.
+func handleHotCornersMenuCommand(cmd uint32) bool {
+	n := len(hotCornerActions) * int(hotCornerActionCount)
+	switch {
+	case cmd >= MENU_HOT_CORNER_ACTION_BASE && int(cmd) < MENU_HOT_CORNER_ACTION_BASE+n:
+		i := int(cmd) - MENU_HOT_CORNER_ACTION_BASE
+		setInt32AndPersist(&hotCornerActions[i/int(hotCornerActionCount)], int32(i%int(hotCornerActionCount))) // #nosec G115 -- tiny index
+	case cmd >= MENU_HOT_CORNER_DELAY_BASE && int(cmd) < MENU_HOT_CORNER_DELAY_BASE+len(hotCornerDelayMsPresets):
+		setInt32AndPersist(&hotCornerDelayMs, hotCornerDelayMsPresets[cmd-MENU_HOT_CORNER_DELAY_BASE])
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,151 @@
+//go:build windows && amd64
+
+package main
+
+import (
+	"golang.org/x/sys/windows"
+
+	"github.com/workturnedplay/winbollocks/snapengine"
+)
+
+/* ---------------- Global hotkeys ---------------- */
+
+// Global hotkeys are registered with RegisterHotKey on the main message
+// window, so WM_HOTKEY arrives in wndProc on the main thread and each
+// hotkey's run may do anything a tray action may. They are deliberately
+// NOT detected in keyboardProc: the hook thread must never do window
+// management itself, only post it to the main thread, and
+// RegisterHotKey also gets "someone else already owns this combination"
+// reported to us instead of silently double-firing.
+
+// WM_HOTKEY and RegisterHotKey's modifier flags, which wincoe doesn't
+// export.
+const (
+	WM_HOTKEY    = 0x0312
+	MOD_ALT      = 0x0001
+	MOD_CONTROL  = 0x0002
+	MOD_SHIFT    = 0x0004
+	MOD_WIN      = 0x0008
+	MOD_NOREPEAT = 0x4000
+
+	VK_HOME = 0x24
+	VK_A    = 0x41
+	VK_B    = 0x42
+	VK_C    = 0x43
+	VK_H    = 0x48
+	VK_P    = 0x50
+	VK_S    = 0x53
+	VK_T    = 0x54
+	VK_V    = 0x56
+	VK_W    = 0x57
+	VK_Y    = 0x59
+	VK_Z    = 0x5A
+)
+
+// globalHotkey is one entry of globalHotkeys.
+type globalHotkey struct {
+	id    uintptr // RegisterHotKey id, unique among globalHotkeys
+	mods  uint32  // MOD_* (MOD_NOREPEAT is always added)
+	vk    uint32
+	label string // human-readable combination, shown in the tray next to the matching action
+	run   func()
+}
+
+// Hotkey ids, for hotkeyLabel lookups from the tray.
+const (
+	hotkeyRescueWindows = 1
+	hotkeyTileWindows   = 2
+	hotkeyUndoGeometry  = 3
+	hotkeyRedoGeometry  = 4
+	hotkeyTogglePin     = 5
+	hotkeyToggleShade   = 6
+	hotkeyHideToTray    = 7
+	hotkeyRaiseApp      = 8
+	hotkeyKeepAtBottom  = 9
+	hotkeyCenter        = 10
+	hotkeyMaxVertical   = 11
+	hotkeyMaxHorizontal = 12
+)
+
+// globalHotkeys is every hotkey winbollocks registers. All use Ctrl+Alt+Win
+// as the base, which Windows itself and common apps leave alone.
+var globalHotkeys = []globalHotkey{
+	{id: hotkeyRescueWindows, mods: MOD_CONTROL | MOD_ALT | MOD_WIN, vk: VK_HOME, label: "Ctrl+Alt+Win+Home",
+		run: rescueOffscreenWindowsAndNotify},
+	{id: hotkeyTileWindows, mods: MOD_CONTROL | MOD_ALT | MOD_WIN, vk: VK_T, label: "Ctrl+Alt+Win+T",
+		run: func() { _ = tileWindowsAndNotify("") }},
+	// Win+Z/Win+Y themselves belong to Windows (snap layouts, mixed reality).
+	{id: hotkeyUndoGeometry, mods: MOD_CONTROL | MOD_ALT | MOD_WIN, vk: VK_Z, label: "Ctrl+Alt+Win+Z",
+		run: func() { stepGeometryHistoryUnderCursor(true) }},
+	{id: hotkeyRedoGeometry, mods: MOD_CONTROL | MOD_ALT | MOD_WIN, vk: VK_Y, label: "Ctrl+Alt+Win+Y",
+		run: func() { stepGeometryHistoryUnderCursor(false) }},
+	{id: hotkeyTogglePin, mods: MOD_CONTROL | MOD_ALT | MOD_WIN, vk: VK_P, label: "Ctrl+Alt+Win+P",
+		run: togglePinUnderCursor},
+	{id: hotkeyToggleShade, mods: MOD_CONTROL | MOD_ALT | MOD_WIN, vk: VK_S, label: "Ctrl+Alt+Win+S",
+		run: toggleShadeUnderCursor},
+	{id: hotkeyHideToTray, mods: MOD_CONTROL | MOD_ALT | MOD_WIN, vk: VK_H, label: "Ctrl+Alt+Win+H",
+		run: hideWindowUnderCursorToTray},
+	{id: hotkeyRaiseApp, mods: MOD_CONTROL | MOD_ALT | MOD_WIN, vk: VK_A, label: "Ctrl+Alt+Win+A",
+		run: raiseAppUnderCursor},
+	{id: hotkeyKeepAtBottom, mods: MOD_CONTROL | MOD_ALT | MOD_WIN, vk: VK_B, label: "Ctrl+Alt+Win+B",
+		run: toggleKeepAtBottomUnderCursor},
+	{id: hotkeyCenter, mods: MOD_CONTROL | MOD_ALT | MOD_WIN, vk: VK_C, label: "Ctrl+Alt+Win+C",
+		run: func() { togglePlacementUnderCursor(snapengine.PlaceCenter) }},
+	{id: hotkeyMaxVertical, mods: MOD_CONTROL | MOD_ALT | MOD_WIN, vk: VK_V, label: "Ctrl+Alt+Win+V",
+		run: func() { togglePlacementUnderCursor(snapengine.PlaceFullHeight) }},
+	{id: hotkeyMaxHorizontal, mods: MOD_CONTROL | MOD_ALT | MOD_WIN, vk: VK_W, label: "Ctrl+Alt+Win+W",
+		run: func() { togglePlacementUnderCursor(snapengine.PlaceFullWidth) }},
+}
+
+// registeredHotkeys records which globalHotkeys ids registered successfully,
+// so the tray only advertises combinations that actually work. Main thread
+// only.
+var registeredHotkeys = map[uintptr]bool{}
+
+// hotkeyLabel returns the " (combination)" suffix for id's tray entry, or
+// "" if that hotkey isn't registered.
+func hotkeyLabel(id uintptr) string {
+	if !registeredHotkeys[id] {
+		return ""
+	}
+	for _, hk := range globalHotkeys {
+		if hk.id == id {
+			return " (" + hk.label + ")"
+		}
+	}
+	return ""
+}
+
+// registerGlobalHotkeys registers every globalHotkeys entry on hwnd and
+// returns the matching unregister func. A combination some other program
+// already holds is logged and skipped; the action stays available from
+// the tray (and as a remote command, where it has one).
+func registerGlobalHotkeys(hwnd windows.Handle) (unregister func()) {
+	for _, hk := range globalHotkeys {
+		if res := procRegisterHotKey.Call(uintptr(hwnd), hk.id, uintptr(hk.mods|MOD_NOREPEAT), uintptr(hk.vk)); res.Failed() {
+			logf("RegisterHotKey(%s) failed, err: %v; it's probably taken by another program, so this hotkey won't work this run.", hk.label, res.Err)
+			continue
+		}
+		registeredHotkeys[hk.id] = true
+	}
+	return func() {
+		for id := range registeredHotkeys {
+			if res := procUnregisterHotKey.Call(uintptr(hwnd), id); res.Failed() {
+				logf("UnregisterHotKey(id=%d) failed, err: %v", id, res.Err)
+			}
+		}
+		clear(registeredHotkeys)
+	}
+}
+
+// handleHotkey is wndProc's WM_HOTKEY handler; wParam is the hotkey id.
+func handleHotkey(wParam uintptr) {
+	for _, hk := range globalHotkeys {
+		if hk.id == wParam {
+			logf("handleHotkey: %s pressed", hk.label)
+			hk.run()
+			return
+		}
+	}
+	logf("handleHotkey: unknown hotkey id %d", wParam)
+}
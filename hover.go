@@ -0,0 +1,332 @@
+//go:build windows && amd64
+
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/workturnedplay/wincoe"
+)
+
+/* ---------------- Focus follows mouse ---------------- */
+
+// Focus-follows-mouse ("sloppy focus", as X11 window managers call it)
+// activates whichever window the cursor comes to rest over, after
+// focusFollowsMouseDelayMs. Sloppy, because resting over something that
+// isn't an eligible window (the desktop, the taskbar, a menu) leaves focus
+// where it was.
+//
+// The mouse hook only notices that the cursor moved (noteHoverMove); it
+// never decides anything itself, since that needs window queries the hook
+// thread must not risk. It posts WM_HOVER_MOVED, at most one in flight,
+// and the main thread (re)arms a one-shot timer, so the timer only fires
+// once the cursor has stayed put for the whole delay; the decision is
+// made then, on the main thread (onFocusFollowsMouseTimer). Auto-raise
+// (further down) shares the same plumbing with a timer and delay of its own.
+
+// focusFollowsMouse turns the feature on. Off by default: it changes how
+// the whole desktop behaves. Persisted (see persistedSettings).
+var focusFollowsMouse atomic.Bool
+
+// focusFollowsMouseRaises makes the activated window also come to the front,
+// as activation normally does. Off, the window keeps its place in the
+// Z-order -- the classic X11 arrangement, where a window can have focus
+// while partly covered by others. Persisted.
+var focusFollowsMouseRaises atomic.Bool
+
+// focusFollowsMouseDelayMs is how long the cursor must rest over a window
+// before it's activated. Persisted; settable from a tray submenu of presets
+// or any value within range by hand-editing settingsFilePath.
+var focusFollowsMouseDelayMs atomic.Int32
+
+// Allowed range for focusFollowsMouseDelayMs, enforced when loading a
+// (possibly hand-edited) settings file -- see atomicInt32Setting.
+const (
+	focusFollowsMouseDelayMsMin int32 = 50
+	focusFollowsMouseDelayMsMax int32 = 5000
+)
+
+// focusFollowsMouseDelayMsPresets are the values offered by the tray's delay
+// submenu (see MENU_FOCUS_FOLLOWS_MOUSE_DELAY_BASE). Each must lie within
+// [focusFollowsMouseDelayMsMin, focusFollowsMouseDelayMsMax].
+var focusFollowsMouseDelayMsPresets = []int32{100, 250, 400, 750, 1500}
+
+// hoverMovePending is true while a WM_HOVER_MOVED is in flight, so a
+// mouse move costs the hook thread one atomic load per event at most.
+var hoverMovePending atomic.Bool
+
+// noteHoverMove is called by mouseProc on every mouse move outside a
+// gesture; see the top of this file.
+func noteHoverMove() {
+	if !hoverWanted() || !hoverMovePending.CompareAndSwap(false, true) {
+		return
+	}
+	main := loadMainMsgHwnd()
+	if main == 0 {
+		hoverMovePending.Store(false)
+		return
+	}
+	if res := wincoe.PostMessage(main, WM_HOVER_MOVED, 0, 0); res.Failed() {
+		hoverMovePending.Store(false) // the next move retries
+	}
+}
+
+// hoverWanted reports whether anything needs to hear about cursor moves:
+// focus-follows-mouse, or auto-raise, globally or by some rule.
+func hoverWanted() bool {
+	return focusFollowsMouse.Load() || autoRaiseOnHover.Load() || activeRules.Load().Mentions(ruleAutoRaiseOnHover.key)
+}
+
+// handleHoverMoved is wndProc's WM_HOVER_MOVED handler: restart the
+// rest-delay timers.
+func handleHoverMoved(hwnd windows.Handle) {
+	hoverMovePending.Store(false)
+	if focusFollowsMouse.Load() {
+		// #nosec G115 -- safe: clamped to [focusFollowsMouseDelayMsMin, focusFollowsMouseDelayMsMax] when loaded
+		if _, res := wincoe.SetTimer(hwnd, focusFollowsMouseTimerID, uint32(focusFollowsMouseDelayMs.Load()), 0); res.Failed() {
+			logf("handleHoverMoved: SetTimer(focus follows mouse) failed: %v", res.Err)
+		}
+	}
+	if autoRaiseOnHover.Load() || activeRules.Load().Mentions(ruleAutoRaiseOnHover.key) {
+		// #nosec G115 -- safe: clamped to [autoRaiseDelayMsMin, autoRaiseDelayMsMax] when loaded
+		if _, res := wincoe.SetTimer(hwnd, autoRaiseTimerID, uint32(autoRaiseDelayMs.Load()), 0); res.Failed() {
+			logf("handleHoverMoved: SetTimer(auto-raise) failed: %v", res.Err)
+		}
+	}
+}
+
+// GUITHREADINFO is GetGUIThreadInfo's output; wincoe doesn't export it.
+type GUITHREADINFO struct {
+	CbSize        uint32
+	Flags         uint32
+	HwndActive    windows.Handle
+	HwndFocus     windows.Handle
+	HwndCapture   windows.Handle
+	HwndMenuOwner windows.Handle
+	HwndMoveSize  windows.Handle
+	HwndCaret     windows.Handle
+	RcCaret       wincoe.RECT
+}
+
+// GUITHREADINFO.Flags bits meaning the user is busy with a menu or a
+// native move/size loop.
+const (
+	GUI_INMOVESIZE     = 0x00000002
+	GUI_INMENUMODE     = 0x00000004
+	GUI_SYSTEMMENUMODE = 0x00000008
+	GUI_POPUPMENUMODE  = 0x00000010
+)
+
+// foregroundThreadBusy reports whether the foreground thread has a menu
+// open, is in a native move/size loop, or holds mouse capture (a drag in
+// progress) -- all times when moving focus would yank something out from
+// under the user.
+func foregroundThreadBusy() bool {
+	var gti GUITHREADINFO
+	gti.CbSize = uint32(unsafe.Sizeof(gti))
+	if res := procGetGUIThreadInfo.Call(0, uintptr(unsafe.Pointer(&gti))); res.Failed() {
+		return false // no foreground thread at all, e.g. while the desktop switches
+	}
+	return gti.Flags&(GUI_INMOVESIZE|GUI_INMENUMODE|GUI_SYSTEMMENUMODE|GUI_POPUPMENUMODE) != 0 || gti.HwndCapture != 0
+}
+
+// VK_XBUTTON1/VK_XBUTTON2 are the side buttons' virtual-key codes, which
+// wincoe doesn't export.
+const (
+	VK_XBUTTON1 = 0x05
+	VK_XBUTTON2 = 0x06
+)
+
+// anyMouseButtonDown reports whether any mouse button is held.
+func anyMouseButtonDown() bool {
+	return keyDown(wincoe.VK_LBUTTON) || keyDown(wincoe.VK_RBUTTON) || keyDown(wincoe.VK_MBUTTON) ||
+		keyDown(VK_XBUTTON1) || keyDown(VK_XBUTTON2)
+}
+
+// hoverTargetAt returns the top-level window at pt that focus-follows-mouse
+// may activate, or 0 if there's none: not one of ours, not a menu, not the
+// shell's desktop/taskbar, and nothing shouldSkipFocusingIt rejects.
+// Unlike isManageableTopLevelWindow, owned windows (dialogs) qualify.
+func hoverTargetAt(pt wincoe.POINT) windows.Handle {
+	hwnd, _ := wincoe.RootWindowFromPoint(pt)
+	if hwnd == 0 || isOwnWindow(hwnd) || !wincoe.IsWindowVisible(hwnd) || isWindowCloaked(hwnd) {
+		return 0
+	}
+	if skip, _ := shouldSkipFocusingIt(hwnd); skip {
+		return 0
+	}
+	class, res := wincoe.GetClassName(hwnd)
+	if res.Failed() {
+		return 0
+	}
+	switch class {
+	case "#32768", "Progman", "WorkerW", "Shell_TrayWnd", "Shell_SecondaryTrayWnd": // #32768 is the menu class
+		return 0
+	}
+	return hwnd
+}
+
+// onFocusFollowsMouseTimer runs once the cursor has rested for the delay:
+// activate the window under it if that's appropriate right now.
+func onFocusFollowsMouseTimer(hwnd windows.Handle) {
+	if res := wincoe.KillTimer(hwnd, focusFollowsMouseTimerID); res.Failed() {
+		logf("onFocusFollowsMouseTimer: KillTimer failed: %v", res.Err)
+	}
+	if !focusFollowsMouse.Load() || activeSession.Load() != nil || anyMouseButtonDown() || foregroundThreadBusy() {
+		return
+	}
+	var pt wincoe.POINT
+	if res := wincoe.GetCursorPos(&pt); res.Failed() {
+		return
+	}
+	target := hoverTargetAt(pt)
+	if target == 0 || target == getForegroundWindow() {
+		return
+	}
+	if ov := windowRulesFor(target); ov.Ignored() {
+		return
+	}
+	// Activation raises; to keep target where it is, remember what's
+	// directly above it and put it back underneath that afterwards.
+	var above windows.Handle
+	if !focusFollowsMouseRaises.Load() {
+		above = windows.Handle(wincoe.GetWindow(target, wincoe.GW_HWNDPREV).R1)
+	}
+	if !forceForeground(target) {
+		logf("onFocusFollowsMouseTimer: couldn't activate HWND=0x%X %q", target, getWindowTextFast(target))
+		return
+	}
+	if above != 0 {
+		if res := wincoe.SetWindowPos(target, above, 0, 0, 0, 0,
+			wincoe.SWP_NOMOVE|wincoe.SWP_NOSIZE|wincoe.SWP_NOACTIVATE|wincoe.SWP_ASYNCWINDOWPOS); res.Failed() {
+			logf("onFocusFollowsMouseTimer: couldn't put HWND=0x%X back under HWND=0x%X: %v", target, above, res.Err)
+		}
+	}
+}
+
+// appendFocusFollowsMouseMenuItems appends the tray's focus-follows-mouse
+// toggles and delay submenu to hMenu.
+func appendFocusFollowsMouseMenuItems(hMenu windows.Handle) {
+	var flags uint32 = wincoe.MF_STRING
+	if focusFollowsMouse.Load() {
+		flags |= wincoe.MF_CHECKED
+	}
+	appendMenuChecked(hMenu, flags, MENU_TOGGLE_FOCUS_FOLLOWS_MOUSE,
+		"Focus follows mouse: activate the window the mouse rests over")
+	var raiseFlags uint32 = wincoe.MF_STRING
+	if focusFollowsMouseRaises.Load() {
+		raiseFlags |= wincoe.MF_CHECKED
+	}
+	if !focusFollowsMouse.Load() {
+		raiseFlags |= wincoe.MF_GRAYED
+	}
+	appendMenuChecked(hMenu, raiseFlags, MENU_TOGGLE_FOCUS_FOLLOWS_MOUSE_RAISES,
+		"    Also bring it to the front")
+	appendInt32PresetSubmenu(hMenu, "    Focus follows mouse delay", focusFollowsMouseDelayMsPresets, focusFollowsMouseDelayMs.Load(),
+		MENU_FOCUS_FOLLOWS_MOUSE_DELAY_BASE, func(v int32) string { return fmt.Sprintf("%dms", v) })
+}
+
+// handleFocusFollowsMouseMenuCommand runs the tray command produced by
+// appendFocusFollowsMouseMenuItems, reporting whether cmd was one.
+func handleFocusFollowsMouseMenuCommand(cmd uint32) bool {
+	switch {
+	case cmd == MENU_TOGGLE_FOCUS_FOLLOWS_MOUSE:
+		toggleAndPersist(&focusFollowsMouse)
+	case cmd == MENU_TOGGLE_FOCUS_FOLLOWS_MOUSE_RAISES:
+		toggleAndPersist(&focusFollowsMouseRaises)
+	case cmd >= MENU_FOCUS_FOLLOWS_MOUSE_DELAY_BASE && int(cmd) < MENU_FOCUS_FOLLOWS_MOUSE_DELAY_BASE+len(focusFollowsMouseDelayMsPresets):
+		setInt32AndPersist(&focusFollowsMouseDelayMs, focusFollowsMouseDelayMsPresets[cmd-MENU_FOCUS_FOLLOWS_MOUSE_DELAY_BASE])
+	default:
+		return false
+	}
+	return true
+}
+
+/* ---------------- Auto-raise on hover ---------------- */
+
+// Auto-raise brings the window the cursor rests over to the front of the
+// Z-order without activating it: keyboard focus stays where it was. It is
+// independent of focus-follows-mouse (either, both or neither can be on),
+// and like bring-to-front on gesture start it goes through
+// bringToFrontWithoutActivating, so raising never steals focus.
+
+// autoRaiseOnHover turns auto-raise on globally. Off by default. A rule can
+// turn it on or off per application (see ruleAutoRaiseOnHover); hoverWanted
+// keeps the hook posting moves whenever any rule mentions it. Persisted.
+var autoRaiseOnHover atomic.Bool
+
+// autoRaiseDelayMs is how long the cursor must rest over a window before
+// it's raised. Persisted, settable like focusFollowsMouseDelayMs.
+var autoRaiseDelayMs atomic.Int32
+
+// Allowed range for autoRaiseDelayMs -- see atomicInt32Setting.
+const (
+	autoRaiseDelayMsMin int32 = 50
+	autoRaiseDelayMsMax int32 = 5000
+)
+
+// autoRaiseDelayMsPresets are the values offered by the tray's auto-raise
+// delay submenu (see MENU_AUTO_RAISE_DELAY_BASE). Each must lie within
+// [autoRaiseDelayMsMin, autoRaiseDelayMsMax].
+var autoRaiseDelayMsPresets = []int32{150, 300, 500, 800, 1200}
+
+// onAutoRaiseTimer runs once the cursor has rested for autoRaiseDelayMs:
+// raise the window under it, unless a gesture is running or any button is
+// held (the user is in the middle of something, e.g. a drag-and-drop whose
+// source window must stay visible) or a menu is open.
+func onAutoRaiseTimer(hwnd windows.Handle) {
+	if res := wincoe.KillTimer(hwnd, autoRaiseTimerID); res.Failed() {
+		logf("onAutoRaiseTimer: KillTimer failed: %v", res.Err)
+	}
+	if activeSession.Load() != nil || anyMouseButtonDown() || foregroundThreadBusy() {
+		return
+	}
+	var pt wincoe.POINT
+	if res := wincoe.GetCursorPos(&pt); res.Failed() {
+		return
+	}
+	target := hoverTargetAt(pt)
+	if target == 0 {
+		return
+	}
+	// Checked per target so a rule can turn this on (or off) for one
+	// application regardless of the global toggle.
+	if ov := windowRulesFor(target); ov.Ignored() || !ruleAutoRaiseOnHover.in(ov) {
+		return
+	}
+	if above := wincoe.GetWindow(target, wincoe.GW_HWNDPREV).R1; above == 0 {
+		return // already on top
+	}
+	bringToFrontWithoutActivating(target, "onAutoRaiseTimer")
+}
+
+// appendAutoRaiseMenuItems appends the tray's auto-raise toggle and delay
+// submenu to hMenu.
+func appendAutoRaiseMenuItems(hMenu windows.Handle) {
+	var flags uint32 = wincoe.MF_STRING
+	if autoRaiseOnHover.Load() {
+		flags |= wincoe.MF_CHECKED
+	}
+	appendMenuChecked(hMenu, flags, MENU_TOGGLE_AUTO_RAISE,
+		"Auto-raise: bring the window the mouse rests over to the front, without focusing it")
+	appendInt32PresetSubmenu(hMenu, "    Auto-raise delay", autoRaiseDelayMsPresets, autoRaiseDelayMs.Load(),
+		MENU_AUTO_RAISE_DELAY_BASE, func(v int32) string { return fmt.Sprintf("%dms", v) })
+}
+
+// handleAutoRaiseMenuCommand runs the tray command produced by
+// appendAutoRaiseMenuItems, reporting whether cmd was one.
+func handleAutoRaiseMenuCommand(cmd uint32) bool {
+	switch {
+	case cmd == MENU_TOGGLE_AUTO_RAISE:
+		toggleAndPersist(&autoRaiseOnHover)
+	case cmd >= MENU_AUTO_RAISE_DELAY_BASE && int(cmd) < MENU_AUTO_RAISE_DELAY_BASE+len(autoRaiseDelayMsPresets):
+		setInt32AndPersist(&autoRaiseDelayMs, autoRaiseDelayMsPresets[cmd-MENU_AUTO_RAISE_DELAY_BASE])
+	default:
+		return false
+	}
+	return true
+}
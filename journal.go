@@ -0,0 +1,318 @@
+//go:build windows && amd64
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/workturnedplay/wincoe"
+
+	"github.com/workturnedplay/winbollocks/winlayout"
+)
+
+/* ---------------- Session journal and revert ---------------- */
+
+// The undo history (see history.go) steps one window back at a time, and
+// only through geometry. While the session journal is on, the first time
+// winbollocks touches a window during this run -- moving or resizing it
+// by any means, shading it, restacking it (send to back, raise, keep at
+// bottom) or pinning it -- it notes what the window looked like before:
+// its geometry, whether it was topmost, and which window sat directly
+// above it. "Revert every window" then puts every window still around
+// back the way the journal found it, and can do so automatically on exit.
+// That's mostly for demos and for trying out rules and settings without
+// leaving the desktop rearranged.
+//
+// Only the first change per window is journaled, and in journal order, so
+// reverting restacks the most recently journaled window first and the
+// stack unwinds roughly the way it was built. A window kept at bottom
+// (see keepbottom.go) is restacked too, but sinks again right after if
+// it's still kept there.
+//
+// Entries are pruned when their window is destroyed (see winEventProc's
+// EVENT_OBJECT_DESTROY case), like the undo history.
+
+// sessionJournalEnabled turns the journal on; nothing is recorded while
+// it's off, and turning it off drops what was recorded. revertJournalOnExit
+// reverts everything at deinit. Both off by default; persisted (see
+// persistedSettings).
+var (
+	sessionJournalEnabled atomic.Bool
+	revertJournalOnExit   atomic.Bool
+)
+
+// maxJournaledWindows bounds the journal, as a backstop for destroy events
+// we never saw; windows touched past it just aren't journaled.
+const maxJournaledWindows = 512
+
+// journalEntry is what one window looked like before winbollocks first
+// changed it. Geometry and stacking are recorded independently, each the
+// first time that aspect is changed.
+type journalEntry struct {
+	geometry    windowGeometry
+	hasGeometry bool
+
+	above       windows.Handle // directly above it in Z-order, 0 if it was on top of its band
+	topmost     bool
+	hasStacking bool
+}
+
+// The journal is written from the hook thread (gesture ends go through
+// pushGeometryHistory) as well as the main thread, hence the mutex.
+// journalOrder holds the journaled windows in the order they were first
+// journaled.
+var (
+	journalMu    sync.Mutex
+	journal      = map[windows.Handle]*journalEntry{}
+	journalOrder []windows.Handle
+)
+
+// journalEntryFor returns hwnd's entry, creating it if there's room.
+// Caller holds journalMu.
+func journalEntryFor(hwnd windows.Handle) *journalEntry {
+	if e := journal[hwnd]; e != nil {
+		return e
+	}
+	if len(journal) >= maxJournaledWindows {
+		return nil
+	}
+	e := &journalEntry{}
+	journal[hwnd] = e
+	journalOrder = append(journalOrder, hwnd)
+	return e
+}
+
+// journalGeometry records g as hwnd's original geometry unless one is
+// already recorded; pushGeometryHistory's hook, so everything that feeds
+// the undo history feeds the journal too.
+func journalGeometry(hwnd windows.Handle, g windowGeometry) {
+	if hwnd == 0 || !sessionJournalEnabled.Load() {
+		return
+	}
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	if e := journalEntryFor(hwnd); e != nil && !e.hasGeometry {
+		e.geometry, e.hasGeometry = g, true
+	}
+}
+
+// journalLiveGeometry records hwnd's current geometry as its original one,
+// for changes that don't go through pushGeometryHistory (shading, owned
+// windows moved along with their owner). Main thread only.
+func journalLiveGeometry(hwnd windows.Handle) {
+	if hwnd == 0 || !sessionJournalEnabled.Load() {
+		return
+	}
+	journalMu.Lock()
+	done := journal[hwnd] != nil && journal[hwnd].hasGeometry
+	journalMu.Unlock()
+	if done {
+		return // the common case, e.g. every move of a drag's followers
+	}
+	wsDX, wsDY := primaryWorkspaceOffset()
+	state, rect, ok := liveWindowState(hwnd, wsDX, wsDY)
+	if !ok || state == winlayout.StateMinimized {
+		return
+	}
+	journalGeometry(hwnd, windowGeometry{state, rect})
+}
+
+// journalStacking records hwnd's current place in the Z-order as its
+// original one unless one is already recorded; called right before
+// anything of ours restacks hwnd or changes its topmost state.
+func journalStacking(hwnd windows.Handle) {
+	if hwnd == 0 || !sessionJournalEnabled.Load() {
+		return
+	}
+	journalMu.Lock()
+	done := journal[hwnd] != nil && journal[hwnd].hasStacking
+	journalMu.Unlock()
+	if done {
+		return
+	}
+	above := windowAbove(hwnd)
+	topmost := isWindowTopmost(hwnd)
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	if e := journalEntryFor(hwnd); e != nil && !e.hasStacking {
+		e.above, e.topmost, e.hasStacking = above, topmost, true
+	}
+}
+
+// windowAbove returns the window directly above hwnd in Z-order, skipping
+// our own (overlays, badges, highlights come and go), or 0 if there's
+// none.
+func windowAbove(hwnd windows.Handle) windows.Handle {
+	const maxWalkSteps = 64 // our own windows are few
+	for i := 0; i < maxWalkSteps; i++ {
+		res := wincoe.GetWindow(hwnd, wincoe.GW_HWNDPREV)
+		if res.Failed() {
+			return 0 // top of the Z-order, or hwnd died
+		}
+		hwnd = windows.Handle(res.R1)
+		if !isOwnWindow(hwnd) {
+			return hwnd
+		}
+	}
+	return 0
+}
+
+// forgetJournal drops hwnd's entry; called when it's destroyed.
+func forgetJournal(hwnd windows.Handle) {
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	if _, ok := journal[hwnd]; !ok {
+		return
+	}
+	delete(journal, hwnd)
+	journalOrder = slices.DeleteFunc(journalOrder, func(h windows.Handle) bool { return h == hwnd })
+}
+
+// clearJournal drops every entry, returning them in journal order.
+func clearJournal() ([]windows.Handle, map[windows.Handle]*journalEntry) {
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	order, entries := journalOrder, journal
+	journalOrder, journal = nil, map[windows.Handle]*journalEntry{}
+	return order, entries
+}
+
+// journaledWindowCount is the number of windows revertJournal would visit.
+func journaledWindowCount() int {
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	return len(journal)
+}
+
+// revertJournal puts every journaled window that still exists back the
+// way the journal found it, then empties the journal. Reports how many
+// windows it reverted. Main thread only.
+func revertJournal() int {
+	order, entries := clearJournal()
+	wsDX, wsDY := primaryWorkspaceOffset()
+	failed := make(map[windows.Handle]bool)
+	var live []windows.Handle
+	var steps []restackStep
+	for _, hwnd := range slices.Backward(order) {
+		e := entries[hwnd]
+		if !wincoe.IsWindow(hwnd) {
+			continue
+		}
+		live = append(live, hwnd)
+		if e.hasGeometry {
+			// The original geometry is the unshaded one (shading is
+			// journaled before it happens), so just drop the shade.
+			forgetShade(hwnd)
+			if state, _, ok := liveWindowState(hwnd, wsDX, wsDY); ok && !placeWindow(hwnd, state, e.geometry.state, e.geometry.rect) {
+				failed[hwnd] = true
+			}
+		}
+		if e.hasStacking {
+			steps = append(steps, restackStep{hwnd: hwnd, insertAfter: stackingAnchor(e)})
+		}
+	}
+	// One batch, so the windows end up stacked exactly as recorded.
+	restacked := restackWindows("revertJournal", steps)
+	for _, st := range steps {
+		if !slices.Contains(restacked, st.hwnd) {
+			failed[st.hwnd] = true
+		} else if !entries[st.hwnd].topmost {
+			setPinned(st.hwnd, false) // drops the badge, if we pinned it
+		}
+	}
+	reverted := len(live) - len(failed)
+	logf("revertJournal: reverted %d of %d journaled window(s)", reverted, len(order))
+	return reverted
+}
+
+// stackingAnchor is where e's window goes back to: under the window that
+// was above it, if that one still exists, or at the top of its band
+// (topmost or not) otherwise. A window pinned by us and not topmost
+// originally loses topmost on the way.
+func stackingAnchor(e *journalEntry) windows.Handle {
+	insertAfter := e.above
+	if insertAfter == 0 || !wincoe.IsWindow(insertAfter) || isWindowTopmost(insertAfter) != e.topmost {
+		// A topmost window put after a non-topmost one loses topmost, and
+		// vice versa doesn't gain it, so only an anchor in the right band
+		// will do.
+		insertAfter = wincoe.HWND_NOTOPMOST
+		if e.topmost {
+			insertAfter = wincoe.HWND_TOPMOST
+		}
+	}
+	return insertAfter
+}
+
+// sessionJournalToggled drops what was recorded when the journal is turned
+// off, from the tray or by editing the settings file.
+func sessionJournalToggled() {
+	if !sessionJournalEnabled.Load() {
+		clearJournal()
+	}
+}
+
+// revertJournalAndNotify is the tray's and the remote command's revert.
+func revertJournalAndNotify() {
+	n := revertJournal()
+	showTrayInfo(selfName, fmt.Sprintf("Put %d window(s) back the way they were.", n))
+}
+
+// deinitJournal reverts everything if revertJournalOnExit is on. Main
+// thread only (see deinit); before deinitPinBadges and deinitShading,
+// whose state it updates.
+func deinitJournal() {
+	if !sessionJournalEnabled.Load() || !revertJournalOnExit.Load() {
+		return
+	}
+	revertJournal()
+}
+
+// appendJournalMenuItems appends the tray's session journal options and
+// the revert action to hMenu.
+func appendJournalMenuItems(hMenu windows.Handle) {
+	enabled := sessionJournalEnabled.Load()
+	var flags uint32 = wincoe.MF_STRING
+	if enabled {
+		flags |= wincoe.MF_CHECKED
+	}
+	appendMenuChecked(hMenu, flags, MENU_TOGGLE_SESSION_JOURNAL,
+		"Remember the original place of every window moved, shaded or restacked this run")
+
+	flags = wincoe.MF_STRING
+	if revertJournalOnExit.Load() {
+		flags |= wincoe.MF_CHECKED
+	}
+	if !enabled {
+		flags |= wincoe.MF_GRAYED
+	}
+	appendMenuChecked(hMenu, flags, MENU_TOGGLE_REVERT_JOURNAL_ON_EXIT, "Put them all back on exit")
+
+	n := journaledWindowCount()
+	flags = wincoe.MF_STRING
+	if n == 0 {
+		flags |= wincoe.MF_GRAYED
+	}
+	appendMenuChecked(hMenu, flags, MENU_REVERT_JOURNAL, fmt.Sprintf("Put back every window moved this run (%d)", n))
+}
+
+// handleJournalMenuCommand runs the tray command produced by
+// appendJournalMenuItems, reporting whether cmd was one.
+func handleJournalMenuCommand(cmd uint32) bool {
+	switch cmd {
+	case MENU_TOGGLE_SESSION_JOURNAL:
+		toggleAndPersist(&sessionJournalEnabled)
+		sessionJournalToggled()
+	case MENU_TOGGLE_REVERT_JOURNAL_ON_EXIT:
+		toggleAndPersist(&revertJournalOnExit)
+	case MENU_REVERT_JOURNAL:
+		revertJournalAndNotify()
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,205 @@
+//go:build windows && amd64
+
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/workturnedplay/wincoe"
+)
+
+/* ---------------- Keep at bottom ---------------- */
+
+// Win+MMB sends a window to the bottom once; the next click on it brings
+// it right back up. A window marked "keep at bottom" is sent back down
+// every time winEventProc sees it come up (activated or shown), so clocks,
+// system monitors and sticky notes can live on the desktop like widgets:
+// usable, but never covering anything. The re-sink is a
+// zOrderActionSendToBack like Win+MMB's, as for a window that wasn't
+// foreground, so it neither moves focus nor touches sentToBackStack -- a
+// widget clicked to type into it keeps focus, just not the top spot.
+//
+// Marks come from the hotkey (for this run) or from rules: a rule with
+// keepAtBottom = true marks every window it matches, whenever one is
+// seen, so marks survive restarts by being written as rules.
+// winbollocks never writes the rules file itself.
+
+// keepAtBottomMark is one keptAtBottom entry's state.
+type keepAtBottomMark uint8
+
+const (
+	keepAtBottomByHotkey keepAtBottomMark = iota + 1 // marked for this run
+	keepAtBottomByRule                               // matched a keepAtBottom rule; re-evaluated on rules reload
+	keepAtBottomReleased                             // explicitly unmarked; overrides any rule for this window
+)
+
+// keptAtBottom is only written on the main thread (winEventProc, an
+// out-of-context WinEvent hook, runs there too), but isKeptAtBottom may be
+// called from any thread, hence the mutex. Entries are dropped when their
+// window is destroyed.
+var (
+	keptAtBottomMu sync.Mutex
+	keptAtBottom   = map[windows.Handle]keepAtBottomMark{}
+)
+
+// maxKeptAtBottomInTrayMenu bounds the tray submenu;
+// MENU_KEEP_AT_BOTTOM_RELEASE_BASE reserves this many IDs.
+const maxKeptAtBottomInTrayMenu = 20
+
+// isKeptAtBottom reports whether hwnd is marked, consulting the rules the
+// first time a window is seen (only if some rule mentions keepAtBottom, so
+// this costs a map lookup otherwise). Any thread.
+func isKeptAtBottom(hwnd windows.Handle) bool {
+	keptAtBottomMu.Lock()
+	mark, ok := keptAtBottom[hwnd]
+	keptAtBottomMu.Unlock()
+	if ok {
+		return mark != keepAtBottomReleased
+	}
+	if hwnd == 0 || !activeRules.Load().Mentions(ruleKeepAtBottom) || !isManageableTopLevelWindow(hwnd) ||
+		!windowRulesFor(hwnd).Bool(ruleKeepAtBottom, false) {
+		return false
+	}
+	keptAtBottomMu.Lock()
+	if _, ok := keptAtBottom[hwnd]; !ok {
+		keptAtBottom[hwnd] = keepAtBottomByRule
+	}
+	keptAtBottomMu.Unlock()
+	return true
+}
+
+// noteKeepAtBottomPromoted is winEventProc's EVENT_SYSTEM_FOREGROUND and
+// EVENT_OBJECT_SHOW hook: sink hwnd again if it's marked. Main thread.
+func noteKeepAtBottomPromoted(hwnd windows.Handle) {
+	if isKeptAtBottom(hwnd) {
+		applyZOrderChangeNow(keepAtBottomSinkData(hwnd))
+	}
+}
+
+// keepAtBottomSinkData is hwnd's trip to HWND_BOTTOM.
+func keepAtBottomSinkData(hwnd windows.Handle) WindowMoveData {
+	return WindowMoveData{
+		Hwnd:         hwnd,
+		InsertAfter:  wincoe.HWND_BOTTOM,
+		Flags:        wincoe.SWP_NOMOVE | wincoe.SWP_NOSIZE | wincoe.SWP_NOACTIVATE,
+		ZOrderAction: zOrderActionSendToBack,
+	}
+}
+
+// forgetKeepAtBottom drops hwnd's mark; called when it's destroyed.
+func forgetKeepAtBottom(hwnd windows.Handle) {
+	keptAtBottomMu.Lock()
+	delete(keptAtBottom, hwnd)
+	keptAtBottomMu.Unlock()
+}
+
+// setKeepAtBottom marks or releases hwnd, sinking it when marked.
+func setKeepAtBottom(hwnd windows.Handle, keep bool) {
+	mark := keepAtBottomReleased
+	if keep {
+		mark = keepAtBottomByHotkey
+	}
+	keptAtBottomMu.Lock()
+	keptAtBottom[hwnd] = mark
+	keptAtBottomMu.Unlock()
+	verb := "released"
+	if keep {
+		verb = "marked"
+		applyZOrderChangeNow(keepAtBottomSinkData(hwnd))
+	}
+	logf("setKeepAtBottom: %s HWND=0x%X %q", verb, hwnd, getWindowTextFast(hwnd))
+}
+
+// toggleKeepAtBottomUnderCursor is the keep-at-bottom hotkey's (and remote
+// command's) action: toggle the mark of the top-level window under the
+// mouse cursor.
+func toggleKeepAtBottomUnderCursor() {
+	var pt wincoe.POINT
+	if res := wincoe.GetCursorPos(&pt); res.Failed() {
+		logf("toggleKeepAtBottomUnderCursor: GetCursorPos failed: %v", res.Err)
+		return
+	}
+	hwnd, res := wincoe.RootWindowFromPoint(pt)
+	if hwnd == 0 || !isManageableTopLevelWindow(hwnd) {
+		logf("toggleKeepAtBottomUnderCursor: no window under the cursor at (%d,%d) (HWND=0x%X, res: %v)", pt.X, pt.Y, hwnd, res)
+		return
+	}
+	setKeepAtBottom(hwnd, !isKeptAtBottom(hwnd))
+}
+
+// applyKeepAtBottomRules re-evaluates rule marks against every open window
+// and sinks the marked ones, as one batch that keeps them in the order
+// they were stacked in; run at startup and after a rules reload. Hotkey
+// marks and releases stay as they are. Main thread only.
+func applyKeepAtBottomRules() {
+	keptAtBottomMu.Lock()
+	for hwnd, mark := range keptAtBottom {
+		if mark == keepAtBottomByRule {
+			delete(keptAtBottom, hwnd)
+		}
+	}
+	keptAtBottomMu.Unlock()
+	if !activeRules.Load().Mentions(ruleKeepAtBottom) {
+		return
+	}
+	var kept []windows.Handle // top-down, as enumerated
+	forEachTopLevelWindow(func(hwnd windows.Handle) bool {
+		if isManageableTopLevelWindow(hwnd) && isKeptAtBottom(hwnd) {
+			journalStacking(hwnd)
+			kept = append(kept, hwnd)
+		}
+		return true
+	})
+	// The first one to HWND_BOTTOM, each next one beneath it.
+	restackWindows("applyKeepAtBottomRules", restackChain(wincoe.HWND_BOTTOM, kept))
+	logf("applyKeepAtBottomRules: %d open window(s) kept at bottom by rules", len(kept))
+}
+
+// keptAtBottomHwnds snapshots the marked windows for one tray menu popup,
+// like pinnedHwnds does.
+func keptAtBottomHwnds() []windows.Handle {
+	keptAtBottomMu.Lock()
+	defer keptAtBottomMu.Unlock()
+	out := make([]windows.Handle, 0, min(len(keptAtBottom), maxKeptAtBottomInTrayMenu))
+	for hwnd, mark := range keptAtBottom {
+		if len(out) == maxKeptAtBottomInTrayMenu {
+			break
+		}
+		if mark != keepAtBottomReleased && wincoe.IsWindow(hwnd) {
+			out = append(out, hwnd)
+		}
+	}
+	return out
+}
+
+// appendKeptAtBottomMenu appends the tray's "Kept at bottom" submenu, one
+// release item per marked window, or a grayed hint when none is marked.
+func appendKeptAtBottomMenu(hMenu windows.Handle, kept []windows.Handle) {
+	if len(kept) == 0 {
+		appendMenuChecked(hMenu, wincoe.MF_STRING|wincoe.MF_GRAYED, 0,
+			"Kept at bottom: none"+hotkeyLabel(hotkeyKeepAtBottom))
+		return
+	}
+	hSub, res := wincoe.CreatePopupMenu()
+	if res.Failed() {
+		logf("appendKeptAtBottomMenu: CreatePopupMenu failed: %v", res.Err)
+		return
+	}
+	for i, hwnd := range kept {
+		appendMenuChecked(hSub, wincoe.MF_STRING, uintptr(MENU_KEEP_AT_BOTTOM_RELEASE_BASE+i), "Release "+pinMenuTitle(hwnd))
+	}
+	appendMenuChecked(hMenu, wincoe.MF_STRING|MF_POPUP, uintptr(hSub), fmt.Sprintf("Kept at bottom (%d)", len(kept)))
+}
+
+// handleKeptAtBottomMenuCommand runs the tray command produced by
+// appendKeptAtBottomMenu, reporting whether cmd was one.
+func handleKeptAtBottomMenuCommand(cmd uint32, kept []windows.Handle) bool {
+	if cmd < MENU_KEEP_AT_BOTTOM_RELEASE_BASE || int(cmd) >= MENU_KEEP_AT_BOTTOM_RELEASE_BASE+len(kept) {
+		return false
+	}
+	setKeepAtBottom(kept[cmd-MENU_KEEP_AT_BOTTOM_RELEASE_BASE], false)
+	return true
+}
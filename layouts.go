@@ -0,0 +1,448 @@
+//go:build windows && amd64
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/workturnedplay/wincoe"
+
+	"github.com/workturnedplay/winbollocks/winlayout"
+)
+
+/* ---------------- Named window layouts ---------------- */
+
+// layoutsFilePath is where named window layouts live: a human-editable
+// text file next to settingsFilePath (same working-directory-relative
+// convention, see its doc comment), in the format documented by package
+// winlayout. Kept separate from the settings file because it's far larger,
+// changes for entirely different reasons, and is meant to be hand-tuned
+// (loosening title patterns, deleting windows you don't care about) in a
+// way the flat "name = value" settings format isn't.
+const layoutsFilePath = selfName + "_layouts.ini"
+
+// layoutsFileHeader is written (as comments) at the top of layoutsFilePath
+// on every save. Unlike the settings file, editing this one while
+// winbollocks is running is fine: it's re-read from disk on every save and
+// restore, never cached.
+const layoutsFileHeader = `winbollocks window layouts -- written by "Save layout", safe to hand-edit at any time.
+Each [name] is one layout; each "window =" line is one window, matched on restore by exe and class,
+with title as a wildcard pattern (* = anything, ? = one character, \ escapes). If no window matches
+all three, one matching only exe+class is used instead, then one matching only exe.
+rect is the window's restored (un-maximized) position in screen pixels; z=0 is the topmost window.`
+
+// maxLayoutsInTrayMenu bounds how many layouts the tray's layout submenus
+// list, which in turn bounds the MENU_LAYOUT_RESTORE_BASE/
+// MENU_LAYOUT_OVERWRITE_BASE ID ranges. Layouts past this are still
+// restorable by name via the "restore-layout" remote command.
+const maxLayoutsInTrayMenu = 50
+
+// readLayoutsFile loads every layout from path (layoutsFilePath, or
+// topologyLayoutsFilePath for the per-monitor-setup ones). A missing file
+// is simply "no layouts yet". Lines winlayout.Parse had to skip are logged
+// (with their line numbers) and otherwise ignored, so one typo never makes
+// every other layout unusable.
+func readLayoutsFile(path string) ([]winlayout.Layout, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // G304: path is always one of our fixed, hardcoded layout file constants, never derived from user/network input
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read %q: %w", path, err)
+	}
+	layouts, parseErrs := winlayout.Parse(data)
+	for _, e := range parseErrs {
+		logf("readLayoutsFile: %q: %v, skipping", path, e)
+	}
+	return layouts, nil
+}
+
+// writeLayoutsFile replaces path with layouts under header, via the same
+// crash-safe writer saveSettings uses (see settingsFileWriter).
+func writeLayoutsFile(path, header string, layouts []winlayout.Layout) error {
+	// #nosec G302 -- 0644 for the same reason as saveSettings' settings file.
+	if err := settingsFileWriter.SafeWriteFile(path, winlayout.Format(header, layouts), 0644); err != nil {
+		return fmt.Errorf("failed to write %q: %w", path, err)
+	}
+	return nil
+}
+
+// layoutNames returns the names of every layout in layoutsFilePath, in file
+// order, for the tray menu. Errors are logged and yield no names.
+func layoutNames() []string {
+	layouts, err := readLayoutsFile(layoutsFilePath)
+	if err != nil {
+		logf("layoutNames: %v", err)
+		return nil
+	}
+	names := make([]string, 0, len(layouts))
+	for _, l := range layouts {
+		names = append(names, l.Name)
+	}
+	return names
+}
+
+// nextFreeLayoutName returns the first of "Layout 1", "Layout 2", ... not
+// already taken in names (compared case-insensitively, like
+// winlayout.Upsert, so it never overwrites a "layout 1"), for the tray's "save as new layout" action (a
+// tray menu can't prompt for text; rename it in the file or save under a
+// chosen name via the "save-layout" remote command).
+func nextFreeLayoutName(names []string) string {
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("Layout %d", i)
+		taken := false
+		for _, n := range names {
+			if strings.EqualFold(n, candidate) {
+				taken = true
+				break
+			}
+		}
+		if !taken {
+			return candidate
+		}
+	}
+}
+
+// primaryWorkspaceOffset returns how far workspace coordinates (what
+// WINDOWPLACEMENT.RcNormalPosition uses for ordinary, non-tool windows) are
+// shifted from screen coordinates: by the primary monitor's work-area
+// origin relative to its monitor origin, i.e. nonzero exactly when the
+// taskbar is docked at the top or left of the primary monitor.
+func primaryWorkspaceOffset() (dx, dy int32) {
+	hMon := monitorFromPoint(wincoe.POINT{}, MONITOR_DEFAULTTOPRIMARY)
+	if hMon == 0 {
+		return 0, 0
+	}
+	var mi wincoe.MONITORINFO
+	if res := wincoe.GetMonitorInfo(hMon, &mi); res.Failed() {
+		logf("primaryWorkspaceOffset: GetMonitorInfo failed: %v; assuming no offset", res.Err)
+		return 0, 0
+	}
+	return mi.RcWork.Left - mi.RcMonitor.Left, mi.RcWork.Top - mi.RcMonitor.Top
+}
+
+// liveWindowState reads hwnd's show state and its restored rect in screen
+// coordinates: GetWindowRect for a normal window, the placement's normal
+// position (converted out of workspace coordinates, see
+// primaryWorkspaceOffset) for a maximized or minimized one.
+func liveWindowState(hwnd windows.Handle, wsDX, wsDY int32) (winlayout.State, winlayout.Rect, bool) {
+	var wp wincoe.WINDOWPLACEMENT
+	wp.Length = uint32(unsafe.Sizeof(wp))
+	if res := wincoe.GetWindowPlacement(hwnd, &wp); res.Failed() {
+		logf("liveWindowState: GetWindowPlacement failed for HWND=0x%X: %v", hwnd, res.Err)
+		return "", winlayout.Rect{}, false
+	}
+	state := winlayout.StateNormal
+	switch wp.ShowCmd {
+	case windows.SW_MAXIMIZE:
+		state = winlayout.StateMaximized
+	case windows.SW_SHOWMINIMIZED:
+		state = winlayout.StateMinimized
+	}
+	if state != winlayout.StateNormal {
+		n := wp.RcNormalPosition
+		return state, winlayout.Rect{Left: n.Left + wsDX, Top: n.Top + wsDY, Right: n.Right + wsDX, Bottom: n.Bottom + wsDY}, true
+	}
+	var r wincoe.RECT
+	if res := wincoe.GetWindowRect(hwnd, &r); res.Failed() {
+		logf("liveWindowState: GetWindowRect failed for HWND=0x%X: %v", hwnd, res.Err)
+		return "", winlayout.Rect{}, false
+	}
+	return state, winlayout.Rect{Left: r.Left, Top: r.Top, Right: r.Right, Bottom: r.Bottom}, true
+}
+
+// liveLayoutWindow is one currently open, layout-eligible window (see
+// isManageableTopLevelWindow) along with the keys a saved layout entry is
+// matched against.
+type liveLayoutWindow struct {
+	hwnd      windows.Handle
+	candidate winlayout.Candidate
+}
+
+// collectLiveLayoutWindows returns every layout-eligible window, topmost
+// first.
+func collectLiveLayoutWindows() []liveLayoutWindow {
+	var out []liveLayoutWindow
+	forEachTopLevelWindow(func(hwnd windows.Handle) bool {
+		if !isManageableTopLevelWindow(hwnd) {
+			return true
+		}
+		class, res := wincoe.GetClassName(hwnd)
+		if res.Failed() {
+			return true
+		}
+		out = append(out, liveLayoutWindow{
+			hwnd: hwnd,
+			candidate: winlayout.Candidate{
+				Exe:   getProcessNameFast(getWindowPID(hwnd)),
+				Class: class,
+				Title: getWindowTextFast(hwnd),
+			},
+		})
+		return true
+	})
+	return out
+}
+
+// captureLayout snapshots every layout-eligible window into a Layout named
+// name, with exact (escaped) titles as the title patterns.
+func captureLayout(name string) winlayout.Layout {
+	wsDX, wsDY := primaryWorkspaceOffset()
+	layout := winlayout.Layout{Name: name}
+	for _, w := range collectLiveLayoutWindows() {
+		state, rect, ok := liveWindowState(w.hwnd, wsDX, wsDY)
+		if !ok {
+			continue
+		}
+		layout.Windows = append(layout.Windows, winlayout.Window{
+			Z:     len(layout.Windows),
+			State: state,
+			Rect:  rect,
+			Exe:   w.candidate.Exe,
+			Class: w.candidate.Class,
+			Title: winlayout.EscapeTitle(w.candidate.Title),
+		})
+	}
+	return layout
+}
+
+// saveNamedLayout snapshots the current windows (see captureLayout) and
+// stores them in layoutsFilePath as name, replacing any existing layout of
+// that name. Returns how many windows were saved. Must run on the main
+// thread, like every other tray/remote-command action.
+func saveNamedLayout(name string) (int, error) {
+	if err := winlayout.ValidateName(name); err != nil {
+		return 0, err
+	}
+	layouts, err := readLayoutsFile(layoutsFilePath)
+	if err != nil {
+		return 0, err
+	}
+	layout := captureLayout(name)
+	if err := writeLayoutsFile(layoutsFilePath, layoutsFileHeader, winlayout.Upsert(layouts, layout)); err != nil {
+		return 0, err
+	}
+	logf("saveNamedLayout: saved %d window(s) as layout %q to %q", len(layout.Windows), name, layoutsFilePath)
+	return len(layout.Windows), nil
+}
+
+// errLayoutNotFound is returned by restoreNamedLayout for an unknown name.
+var errLayoutNotFound = errors.New("no such layout")
+
+// layoutRestoreResult summarizes a restoreNamedLayout run for the user.
+type layoutRestoreResult struct {
+	saved, placed, viaFallback int
+}
+
+// restoreNamedLayout puts every window of the layout called name back where
+// it was saved, matching saved entries to open windows via
+// winlayout.Assign (exact exe+class+title pattern first, then exe+class,
+// then exe alone), then restacks the matched windows in their saved
+// Z-order. Saved entries with no open window left to match are skipped.
+//
+// Placement is applied with ShowWindowAsync/SWP_ASYNCWINDOWPOS, so an
+// unresponsive window simply catches up later (or never) without stalling
+// the main thread, and without stalling the other windows' restores behind
+// it. Only the final restack is synchronous, one batch so the saved order
+// comes out exactly; unresponsive windows are left out of it (see
+// restackWindows).
+func restoreNamedLayout(name string) (layoutRestoreResult, error) {
+	layouts, err := readLayoutsFile(layoutsFilePath)
+	if err != nil {
+		return layoutRestoreResult{}, err
+	}
+	layout, ok := winlayout.Find(layouts, name)
+	if !ok {
+		return layoutRestoreResult{}, fmt.Errorf("%w %q in %q", errLayoutNotFound, name, layoutsFilePath)
+	}
+	return applyLayout(layout), nil
+}
+
+// applyLayout does restoreNamedLayout's actual matching and placement for
+// an already-loaded layout.
+func applyLayout(layout winlayout.Layout) layoutRestoreResult {
+	live := collectLiveLayoutWindows()
+	candidates := make([]winlayout.Candidate, len(live))
+	for i, w := range live {
+		candidates[i] = w.candidate
+	}
+	assignments := winlayout.Assign(layout.Windows, candidates)
+
+	wsDX, wsDY := primaryWorkspaceOffset()
+	result := layoutRestoreResult{saved: len(layout.Windows)}
+	var stack []windows.Handle // matched, non-minimized windows in saved Z-order (Assign returns them sorted by Saved)
+	for _, a := range assignments {
+		saved := layout.Windows[a.Saved]
+		hwnd := live[a.Live].hwnd
+		if !applySavedPlacement(hwnd, saved, wsDX, wsDY) {
+			continue
+		}
+		result.placed++
+		if a.Tier != winlayout.TierExact {
+			result.viaFallback++
+			logf("applyLayout(%q): %s %q (class %q) matched HWND=0x%X %q only by fallback tier %d", layout.Name, saved.Exe, saved.Title, saved.Class, hwnd, live[a.Live].candidate.Title, a.Tier)
+		}
+		if saved.State != winlayout.StateMinimized {
+			stack = append(stack, hwnd)
+		}
+	}
+
+	// Restack top-down, as one batch: the topmost saved window goes to
+	// HWND_TOP, each next one directly beneath the previous. Windows not in
+	// the layout end up below all of them.
+	restackWindows(fmt.Sprintf("applyLayout(%q)", layout.Name), restackChain(wincoe.HWND_TOP, stack))
+	logf("applyLayout(%q): placed %d of %d saved window(s) (%d via fallback match)", layout.Name, result.placed, result.saved, result.viaFallback)
+	return result
+}
+
+// applySavedPlacement moves hwnd to saved's rect and show state. A window
+// that is currently maximized or minimized is first restored (without
+// activation) so the rect lands on its restored geometry, then re-maximized
+// or re-minimized if that's what was saved -- re-maximizing after the move
+// is also what makes it maximize on the saved rect's monitor. A window
+// already exactly in the saved state and rect is left alone, avoiding a
+// pointless restore/maximize flicker.
+//
+// Note: there is no "maximize without activating" show command, so a
+// window saved maximized does get activated by its restore; the final
+// restack in applyLayout puts the Z-order right regardless.
+//
+// The geometry it replaces goes into hwnd's undo history (see
+// pushGeometryHistory).
+func applySavedPlacement(hwnd windows.Handle, saved winlayout.Window, wsDX, wsDY int32) bool {
+	curState, curRect, ok := liveWindowState(hwnd, wsDX, wsDY)
+	if !ok {
+		return false
+	}
+	if curState == saved.State && curRect == saved.Rect {
+		return true
+	}
+	pushGeometryHistory(hwnd, windowGeometry{curState, curRect})
+	return placeWindow(hwnd, curState, saved.State, saved.Rect)
+}
+
+// placeWindow is applySavedPlacement's placement half: move hwnd, currently
+// in state curState, to rect r in state state.
+func placeWindow(hwnd windows.Handle, curState, state winlayout.State, r winlayout.Rect) bool {
+	if curState != winlayout.StateNormal {
+		showWindowAsync(hwnd, windows.SW_SHOWNOACTIVATE)
+	}
+	if res := wincoe.SetWindowPos(hwnd, 0, r.Left, r.Top, r.Right-r.Left, r.Bottom-r.Top,
+		wincoe.SWP_NOZORDER|wincoe.SWP_NOACTIVATE|wincoe.SWP_ASYNCWINDOWPOS); res.Failed() {
+		logf("placeWindow: SetWindowPos failed for HWND=0x%X: %v", hwnd, res.Err)
+		if res.ErrIs(windows.ERROR_ACCESS_DENIED) {
+			logf("placeWindow: HWND=0x%X is likely elevated; run winbollocks as admin to move it", hwnd)
+		}
+		return false
+	}
+	switch state {
+	case winlayout.StateMaximized:
+		showWindowAsync(hwnd, windows.SW_SHOWMAXIMIZED)
+	case winlayout.StateMinimized:
+		showWindowAsync(hwnd, windows.SW_SHOWMINNOACTIVE)
+	}
+	return true
+}
+
+// saveLayoutAndNotify/restoreLayoutAndNotify are the user-facing wrappers
+// the tray menu and remote commands call: they do the work and report the
+// outcome as a tray balloon (see showTrayInfo), since neither caller has
+// anywhere else to show it.
+func saveLayoutAndNotify(name string) error {
+	n, err := saveNamedLayout(name)
+	if err != nil {
+		logf("saveLayoutAndNotify(%q): %v", name, err)
+		showTrayInfo(selfName, fmt.Sprintf("Failed to save layout %q: %v", name, err))
+		return err
+	}
+	showTrayInfo(selfName, fmt.Sprintf("Saved %d window(s) as layout %q.", n, name))
+	return nil
+}
+
+func restoreLayoutAndNotify(name string) error {
+	res, err := restoreNamedLayout(name)
+	if err != nil {
+		logf("restoreLayoutAndNotify(%q): %v", name, err)
+		showTrayInfo(selfName, fmt.Sprintf("Failed to restore layout %q: %v", name, err))
+		return err
+	}
+	msg := fmt.Sprintf("Restored layout %q: placed %d of %d window(s)", name, res.placed, res.saved)
+	if res.viaFallback > 0 {
+		msg += fmt.Sprintf(" (%d matched loosely)", res.viaFallback)
+	}
+	showTrayInfo(selfName, msg+".")
+	return nil
+}
+
+// appendLayoutsSubmenu appends the tray's "Window layouts" submenu to
+// hMenu: save the current windows as a new layout, then one restore entry
+// and one overwrite entry per existing layout in names (at most
+// maxLayoutsInTrayMenu of each), then the per-monitor-setup toggle (see
+// perTopologyLayoutsEnabled). names must be the same slice later passed
+// to handleLayoutsMenuCommand, so IDs map back to the names that were
+// actually shown.
+func appendLayoutsSubmenu(hMenu windows.Handle, names []string) {
+	hSub, res := wincoe.CreatePopupMenu()
+	if res.Failed() {
+		logf("WM_MYSYSTRAY: CreatePopupMenu failed for the layouts submenu, err=%v", res.Err)
+		return
+	}
+	appendMenuChecked(hSub, wincoe.MF_STRING, MENU_LAYOUT_SAVE_NEW,
+		fmt.Sprintf("Save current windows as new layout %q (rename it in %s)", nextFreeLayoutName(names), layoutsFilePath))
+	if len(names) > 0 {
+		appendMenuChecked(hSub, MF_SEPARATOR, 0, "")
+		for i, name := range names {
+			if i >= maxLayoutsInTrayMenu {
+				break
+			}
+			appendMenuChecked(hSub, wincoe.MF_STRING, uintptr(MENU_LAYOUT_RESTORE_BASE+i), "Restore "+name)
+		}
+
+		hOverwrite, res2 := wincoe.CreatePopupMenu()
+		if res2.Failed() {
+			logf("WM_MYSYSTRAY: CreatePopupMenu failed for the layout-overwrite submenu, err=%v", res2.Err)
+		} else {
+			for i, name := range names {
+				if i >= maxLayoutsInTrayMenu {
+					break
+				}
+				appendMenuChecked(hOverwrite, wincoe.MF_STRING, uintptr(MENU_LAYOUT_OVERWRITE_BASE+i), name)
+			}
+			appendMenuChecked(hSub, MF_SEPARATOR, 0, "")
+			appendMenuChecked(hSub, wincoe.MF_STRING|MF_POPUP, uintptr(hOverwrite), "Overwrite layout with current windows")
+		}
+	}
+
+	appendMenuChecked(hSub, MF_SEPARATOR, 0, "")
+	var perTopologyFlags uint32 = wincoe.MF_STRING
+	if perTopologyLayoutsEnabled.Load() {
+		perTopologyFlags |= wincoe.MF_CHECKED
+	}
+	appendMenuChecked(hSub, perTopologyFlags, MENU_TOGGLE_PER_TOPOLOGY_LAYOUTS,
+		fmt.Sprintf("Remember windows per monitor setup and put them back when it returns (docking; kept in %s)", topologyLayoutsFilePath))
+	appendMenuChecked(hMenu, wincoe.MF_STRING|MF_POPUP, uintptr(hSub), "Window layouts")
+}
+
+// handleLayoutsMenuCommand runs the layout action for a tray command ID
+// produced by appendLayoutsSubmenu, reporting whether cmd was one.
+func handleLayoutsMenuCommand(cmd uint32, names []string) bool {
+	switch {
+	case cmd == MENU_TOGGLE_PER_TOPOLOGY_LAYOUTS:
+		toggleAndPersist(&perTopologyLayoutsEnabled)
+	case cmd == MENU_LAYOUT_SAVE_NEW:
+		_ = saveLayoutAndNotify(nextFreeLayoutName(names)) //nolint:errcheck // already logged and shown as a tray balloon
+	case cmd >= MENU_LAYOUT_RESTORE_BASE && int(cmd) < MENU_LAYOUT_RESTORE_BASE+min(len(names), maxLayoutsInTrayMenu):
+		_ = restoreLayoutAndNotify(names[cmd-MENU_LAYOUT_RESTORE_BASE]) //nolint:errcheck // see above
+	case cmd >= MENU_LAYOUT_OVERWRITE_BASE && int(cmd) < MENU_LAYOUT_OVERWRITE_BASE+min(len(names), maxLayoutsInTrayMenu):
+		_ = saveLayoutAndNotify(names[cmd-MENU_LAYOUT_OVERWRITE_BASE]) //nolint:errcheck // see above
+	default:
+		return false
+	}
+	return true
+}
@@ -29,6 +29,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"math"
@@ -49,6 +50,9 @@ import (
 	"golang.org/x/sys/windows/registry"
 
 	"github.com/workturnedplay/wincoe"
+
+	"github.com/workturnedplay/winbollocks/snapengine"
+	"github.com/workturnedplay/winbollocks/winrules"
 )
 
 // this init() must be first, order of it in source code matters as they're executed in order of seen.
@@ -137,6 +141,211 @@ func init() {
 
 /* ---------------- DLLs & Procs ---------------- */
 
+// Win32 entry points wincoe doesn't (yet) wrap, bound lazily here instead:
+// a missing export then surfaces as a failed WinResult at the one call site
+// that needs it, never as a startup crash. Pointer arguments must still be
+// converted with uintptr(unsafe.Pointer(...)) right at the .Call site (see
+// wincoe.BoundProcN.Call's doc comment).
+var (
+	// procShowWindowAsync posts the show-state change to the target
+	// window's own thread instead of waiting for it, so restoring a layout
+	// can never hang the main thread on an unresponsive window -- the same
+	// reason handleActualMoveOrResize prefers SWP_ASYNCWINDOWPOS.
+	procShowWindowAsync = wincoe.NewLazyBoundProc2(wincoe.User32, "ShowWindowAsync", wincoe.CheckNone)
+	// procBeginDeferWindowPos, procDeferWindowPos and procEndDeferWindowPos
+	// restack several windows as one batch: the new Z-order is applied in a
+	// single pass, so windows owned by different threads can't end up
+	// interleaved the way chained SWP_ASYNCWINDOWPOS calls can.
+	procBeginDeferWindowPos = wincoe.NewLazyBoundProc1(wincoe.User32, "BeginDeferWindowPos", wincoe.CheckNull)
+	procDeferWindowPos      = wincoe.NewLazyBoundProc8(wincoe.User32, "DeferWindowPos", wincoe.CheckNull)
+	procEndDeferWindowPos   = wincoe.NewLazyBoundProc1(wincoe.User32, "EndDeferWindowPos", wincoe.CheckBool)
+	// procIsHungAppWindow reports whether a window has stopped pumping
+	// messages, so a synchronous batch can leave it out instead of waiting
+	// on it.
+	procIsHungAppWindow = wincoe.NewLazyBoundProc1(wincoe.User32, "IsHungAppWindow", wincoe.CheckNone)
+	// procMonitorFromPoint returns the HMONITOR containing a point. The
+	// POINT is passed BY VALUE, which on amd64 means packed into a single
+	// register: uintptr(uint32(x)) | uintptr(uint32(y))<<32.
+	procMonitorFromPoint = wincoe.NewLazyBoundProc2(wincoe.User32, "MonitorFromPoint", wincoe.CheckNone)
+	// procDwmGetWindowAttribute is wincoe's own (unexported) binding
+	// duplicated for attributes other than DWMWA_EXTENDED_FRAME_BOUNDS,
+	// e.g. DWMWA_CLOAKED in isWindowCloaked.
+	procDwmGetWindowAttribute = wincoe.NewLazyBoundProc4(wincoe.Dwmapi, "DwmGetWindowAttribute", wincoe.CheckHRESULT)
+	// procFindWindowW locates an already-running instance's hidden main
+	// message window by class name -- see forwardRemoteCommandIfRequested.
+	procFindWindowW = wincoe.NewLazyBoundProc2(wincoe.User32, "FindWindowW", wincoe.CheckNull)
+	// procChangeWindowMessageFilterEx lets a lower-integrity sender's
+	// WM_COPYDATA through UIPI to our (possibly elevated) main message
+	// window -- see allowRemoteCommandsThroughUIPI.
+	procChangeWindowMessageFilterEx = wincoe.NewLazyBoundProc4(wincoe.User32, "ChangeWindowMessageFilterEx", wincoe.CheckBool)
+	// procEnumDisplayMonitors walks every monitor of the desktop -- see
+	// enumMonitors.
+	procEnumDisplayMonitors = wincoe.NewLazyBoundProc4(wincoe.User32, "EnumDisplayMonitors", wincoe.CheckBool)
+	// procRegisterDeviceNotificationW/procUnregisterDeviceNotification
+	// subscribe the main message window to monitor arrival/removal
+	// WM_DEVICECHANGEs -- see registerMonitorDeviceNotifications.
+	procRegisterDeviceNotificationW  = wincoe.NewLazyBoundProc3(wincoe.User32, "RegisterDeviceNotificationW", wincoe.CheckNull)
+	procUnregisterDeviceNotification = wincoe.NewLazyBoundProc1(wincoe.User32, "UnregisterDeviceNotification", wincoe.CheckBool)
+	// procRegisterHotKey/procUnregisterHotKey back globalHotkeys.
+	procRegisterHotKey   = wincoe.NewLazyBoundProc4(wincoe.User32, "RegisterHotKey", wincoe.CheckBool)
+	procUnregisterHotKey = wincoe.NewLazyBoundProc2(wincoe.User32, "UnregisterHotKey", wincoe.CheckBool)
+	// procSetWindowPlacement moves a minimized window's restore position
+	// without un-minimizing it -- see rescueWindow.
+	procSetWindowPlacement = wincoe.NewLazyBoundProc2(wincoe.User32, "SetWindowPlacement", wincoe.CheckBool)
+	// procGetClassLongPtrW/procDestroyIcon fetch and free the icons of
+	// windows hidden to the tray -- see windowIconCopy.
+	procGetClassLongPtrW = wincoe.NewLazyBoundProc2(wincoe.User32, "GetClassLongPtrW", wincoe.CheckNone)
+	procDestroyIcon      = wincoe.NewLazyBoundProc1(wincoe.User32, "DestroyIcon", wincoe.CheckBool)
+	// procRegisterWindowMessageW gets Explorer's "TaskbarCreated" message
+	// -- see registerTaskbarCreated.
+	procRegisterWindowMessageW = wincoe.NewLazyBoundProc1(wincoe.User32, "RegisterWindowMessageW", wincoe.CheckNull)
+	// procGetGUIThreadInfo tells whether the foreground thread is busy with
+	// a menu or a drag -- see foregroundThreadBusy.
+	procGetGUIThreadInfo = wincoe.NewLazyBoundProc2(wincoe.User32, "GetGUIThreadInfo", wincoe.CheckBool)
+	// The GDI and menu calls that put window icons on tray menu items --
+	// see windowMenuBitmap.
+	procCreateCompatibleDC = wincoe.NewLazyBoundProc1(wincoe.Gdi32, "CreateCompatibleDC", wincoe.CheckNull)
+	procDeleteDC           = wincoe.NewLazyBoundProc1(wincoe.Gdi32, "DeleteDC", wincoe.CheckBool)
+	procCreateDIBSection   = wincoe.NewLazyBoundProc6(wincoe.Gdi32, "CreateDIBSection", wincoe.CheckNull)
+	procSelectObject       = wincoe.NewLazyBoundProc2(wincoe.Gdi32, "SelectObject", wincoe.CheckNull)
+	procDrawIconEx         = wincoe.NewLazyBoundProc9(wincoe.User32, "DrawIconEx", wincoe.CheckBool)
+	procSetMenuItemInfoW   = wincoe.NewLazyBoundProc4(wincoe.User32, "SetMenuItemInfoW", wincoe.CheckBool)
+	// procSHGetPropertyStoreForWindow reads a window's AppUserModelID --
+	// see windowAppUserModelID.
+	procSHGetPropertyStoreForWindow = wincoe.NewLazyBoundProc3(wincoe.Shell32, "SHGetPropertyStoreForWindow", wincoe.CheckHRESULT)
+)
+
+// MONITOR_DEFAULTTOPRIMARY/MONITOR_DEFAULTTONULL complement wincoe's
+// MONITOR_DEFAULTTONEAREST for MonitorFromWindow/MonitorFromPoint.
+const (
+	MONITOR_DEFAULTTONULL    = 0
+	MONITOR_DEFAULTTOPRIMARY = 1
+)
+
+// monitorFromPoint wraps procMonitorFromPoint's by-value POINT packing.
+func monitorFromPoint(pt wincoe.POINT, flags uint32) windows.Handle {
+	res := procMonitorFromPoint.Call(uintptr(uint32(pt.X))|uintptr(uint32(pt.Y))<<32, uintptr(flags))
+	return windows.Handle(res.R1)
+}
+
+// DWMWA_CLOAKED is the DwmGetWindowAttribute attribute reporting whether a
+// window is cloaked: "visible" as far as IsWindowVisible is concerned but
+// not actually drawn (suspended UWP apps, windows on other virtual
+// desktops, some shell surfaces).
+const DWMWA_CLOAKED = 14
+
+// isWindowCloaked reports whether DWM is currently cloaking hwnd. A failed
+// query is treated as "not cloaked" -- DWM composition is always on since
+// Windows 8, so a failure here means a weird window, not a weird system.
+func isWindowCloaked(hwnd windows.Handle) bool {
+	var cloaked uint32
+	res := procDwmGetWindowAttribute.Call(uintptr(hwnd), DWMWA_CLOAKED, uintptr(unsafe.Pointer(&cloaked)), unsafe.Sizeof(cloaked))
+	return res.Succeeded() && cloaked != 0
+}
+
+// showWindowAsync wraps procShowWindowAsync; cmd is one of windows.SW_*.
+func showWindowAsync(hwnd windows.Handle, cmd int32) {
+	_ = procShowWindowAsync.Call(uintptr(hwnd), uintptr(cmd)) // CheckNone: returns whether the window was previously visible, not success
+}
+
+// restackStep is one window's move in a restackWindows batch: hwnd goes
+// directly beneath insertAfter, or to one of the HWND_TOP/HWND_BOTTOM/
+// HWND_TOPMOST/HWND_NOTOPMOST positions.
+type restackStep struct {
+	hwnd, insertAfter windows.Handle
+}
+
+// restackChain returns the steps putting hwnds[0] after first and each
+// next window directly beneath the previous one.
+func restackChain(first windows.Handle, hwnds []windows.Handle) []restackStep {
+	steps := make([]restackStep, len(hwnds))
+	for i, hwnd := range hwnds {
+		steps[i] = restackStep{hwnd: hwnd, insertAfter: first}
+		first = hwnd
+	}
+	return steps
+}
+
+// restackWindows applies steps, in order, as one DeferWindowPos batch, so
+// the resulting Z-order is exactly the one asked for: one
+// SWP_ASYNCWINDOWPOS call per window is posted to each window's own
+// thread, and those can run in any order. The batch is synchronous, so
+// windows that are gone or not responding are left out rather than waited
+// on, as is one DeferWindowPos refuses (an elevated window, say) -- a step
+// anchored on a left-out window takes that window's anchor instead.
+// Returns the windows restacked. context3 is only used in logs. Main
+// thread only.
+func restackWindows(context3 string, steps []restackStep) []windows.Handle {
+	leftOut := make(map[windows.Handle]windows.Handle) // left-out window -> its insertAfter
+	for _, st := range steps {
+		switch {
+		case !wincoe.IsWindow(st.hwnd):
+			leftOut[st.hwnd] = st.insertAfter
+		case procIsHungAppWindow.Call(uintptr(st.hwnd)).R1 != 0:
+			logf("%s: HWND=0x%X is not responding, not restacking it", context3, st.hwnd)
+			leftOut[st.hwnd] = st.insertAfter
+		}
+	}
+	for {
+		batch := make([]restackStep, 0, len(steps))
+		for _, st := range steps {
+			if _, out := leftOut[st.hwnd]; out {
+				continue
+			}
+			after := st.insertAfter
+			for range len(leftOut) { // bounded: recorded anchors may form a cycle
+				a, out := leftOut[after]
+				if !out {
+					break
+				}
+				after = a
+			}
+			batch = append(batch, restackStep{hwnd: st.hwnd, insertAfter: after})
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		refused, ok := deferRestack(context3, batch)
+		if ok {
+			restacked := make([]windows.Handle, len(batch))
+			for i, st := range batch {
+				restacked[i] = st.hwnd
+			}
+			return restacked
+		}
+		if refused.hwnd == 0 {
+			return nil
+		}
+		leftOut[refused.hwnd] = refused.insertAfter
+	}
+}
+
+// deferRestack is one restackWindows attempt. On failure it returns the
+// step DeferWindowPos refused, or a zero step if the batch itself failed.
+func deferRestack(context3 string, batch []restackStep) (refused restackStep, ok bool) {
+	res := procBeginDeferWindowPos.Call(uintptr(len(batch)))
+	if res.Failed() {
+		logf("%s: BeginDeferWindowPos failed: %v", context3, res.Err)
+		return restackStep{}, false
+	}
+	hdwp := res.R1
+	for _, st := range batch {
+		res = procDeferWindowPos.Call(hdwp, uintptr(st.hwnd), uintptr(st.insertAfter), 0, 0, 0, 0,
+			uintptr(wincoe.SWP_NOMOVE|wincoe.SWP_NOSIZE|wincoe.SWP_NOACTIVATE))
+		if res.Failed() {
+			// DeferWindowPos has already freed the batch.
+			logf("%s: DeferWindowPos failed for HWND=0x%X: %v; restacking the others without it", context3, st.hwnd, res.Err)
+			return st, false
+		}
+		hdwp = res.R1
+	}
+	if res = procEndDeferWindowPos.Call(hdwp); res.Failed() {
+		logf("%s: EndDeferWindowPos failed: %v", context3, res.Err)
+		return restackStep{}, false
+	}
+	return restackStep{}, true
+}
+
 // var shellHook windows.Handle
 var (
 	// The Data Pipe (2048 is plenty for lag spikes)
@@ -201,9 +410,11 @@ var (
 	lastPostedX, lastPostedY atomic.Int32
 )
 
-// MIN_MOVE_INTERVAL the minimum amount of time between window moves, ie. throttle anything faster than this!
-// XXX: yes this works too, here: //revive:disable:var-naming
-const MIN_MOVE_INTERVAL = 33 * time.Millisecond // ~30 fps – very pleasant
+// moveRateLimitInterval is the minimum amount of time between window moves
+// posted by mouseProc while ratelimitOnMove is on, ie. throttle anything
+// faster than this! Nanoseconds; 33ms (~30 fps, very pleasant) by default.
+// Persisted (see persistedSettings), so it can be tuned without a rebuild.
+var moveRateLimitInterval atomic.Int64
 
 /* ---------------- Constants ---------------- */
 
@@ -241,14 +452,66 @@ const (
 	WM_CANCEL_GESTURE       = wincoe.WM_USER + 220
 	WM_APPLY_SHIFT_MIRROR   = wincoe.WM_USER + 225
 	WM_APPLY_GESTURE_CURSOR = wincoe.WM_USER + 230
+	// WM_TRAY_WINDOW_ICON is the callback message of the icons of windows
+	// hidden to the tray, WM_TRAY_WINDOW_DESTROYED winEventProc's notice
+	// that one of those windows is gone -- see traywindows.go.
+	WM_TRAY_WINDOW_ICON      = wincoe.WM_USER + 235
+	WM_TRAY_WINDOW_DESTROYED = wincoe.WM_USER + 240
+	// WM_HOVER_MOVED tells the main thread the cursor moved outside a
+	// gesture -- see noteHoverMove.
+	WM_HOVER_MOVED = wincoe.WM_USER + 245
+	// WM_MRU_FOREGROUND_LOST tells the main thread the foreground window
+	// went away -- see noteWindowGoneForMRU.
+	WM_MRU_FOREGROUND_LOST = wincoe.WM_USER + 250
+	// WM_SELECTION_BAND tells the main thread the selection band changed --
+	// see postSelectionBand.
+	WM_SELECTION_BAND = wincoe.WM_USER + 255
+	// WM_SWAP_TARGET tells the main thread a swap-by-drop was armed,
+	// followed or dropped -- see postSwapTarget.
+	WM_SWAP_TARGET = wincoe.WM_USER + 260
+	// WM_HOT_CORNER tells the main thread the cursor entered or left a
+	// screen corner -- see noteHotCornerMove.
+	WM_HOT_CORNER = wincoe.WM_USER + 265
+	// WM_PROFILE_FOREGROUND tells the main thread the foreground window
+	// changed while some profile switches on it -- see
+	// noteForegroundForProfiles.
+	WM_PROFILE_FOREGROUND = wincoe.WM_USER + 270
 
 	// gestureCursorTimerID is the SetTimer nIDEvent used to reassert SetCursor
 	// while a move/resize is active (fights apps that force a private cursor
 	// every mouse message — Electron/VS Code, mintty/git-bash, etc.).
 	gestureCursorTimerID = 1
-	// gestureCursorTimerMs is the reassert interval. 16ms ≈ 60Hz is enough to
-	// win the race against most targets without measurable CPU cost.
-	gestureCursorTimerMs = 16
+	// topologySettleTimerID/topologySnapshotTimerID drive per-monitor-setup
+	// layouts -- see scheduleTopologySettle and snapshotTopologyLayout.
+	topologySettleTimerID   = 2
+	topologySnapshotTimerID = 3
+	// pinBadgeTimerID keeps pin-on-top badges on their windows -- see
+	// updatePinBadges.
+	pinBadgeTimerID = 4
+	// focusFollowsMouseTimerID fires once the cursor has rested for
+	// focusFollowsMouseDelayMs -- see handleHoverMoved.
+	focusFollowsMouseTimerID = 5
+	// autoRaiseTimerID fires once the cursor has rested for
+	// autoRaiseDelayMs -- see handleHoverMoved.
+	autoRaiseTimerID = 6
+	// mruRestoreTimerID fires mruRestoreSettleMs after the foreground window
+	// went away -- see handleMRUForegroundLost.
+	mruRestoreTimerID = 7
+	// selectionTimerID keeps selection highlights on their windows -- see
+	// updateSelectionHighlights.
+	selectionTimerID = 8
+	// hotCornerTimerID fires once the cursor has stayed in a corner for
+	// hotCornerDelayMs -- see handleHotCorner.
+	hotCornerTimerID = 9
+	// settingsWatchTimerID polls settingsFilePath for edits made while
+	// running -- see checkSettingsFile.
+	settingsWatchTimerID = 10
+	// profileSwitchTimerID polls for the end of the gesture a profile
+	// switch is waiting on -- see requestProfileSwitch.
+	profileSwitchTimerID = 11
+	// tilingRestoreTimerID places the windows tiling restored from
+	// maximized -- see placeTiles.
+	tilingRestoreTimerID = 12
 )
 const (
 	MENU_EXIT                                      = 1
@@ -276,6 +539,66 @@ const (
 	MENU_TOGGLE_VIRTUALIZATION_DETECTION           = 23
 	MENU_TOGGLE_SNAP_TO_EDGES                      = 24
 	MENU_TOGGLE_DISABLE_FILE_LOGGING               = 25
+	MENU_TOGGLE_SNAP_TO_CENTER_LINES               = 26
+	MENU_TOGGLE_SNAP_TO_THIRDS                     = 27
+
+	// MENU_SNAP_THRESHOLD_BASE+i / MENU_SNAP_GAP_BASE+i select
+	// snapThresholdPxPresets[i] / snapOuterGapPxPresets[i] from their tray
+	// submenus. Kept well clear of the single-item IDs above so neither
+	// range can ever collide with a newly added toggle.
+	MENU_SNAP_THRESHOLD_BASE = 100
+	MENU_SNAP_GAP_BASE       = 120
+
+	// Window layouts submenu (see appendLayoutsSubmenu). The two bases are
+	// maxLayoutsInTrayMenu apart.
+	MENU_LAYOUT_SAVE_NEW             = 28
+	MENU_TOGGLE_PER_TOPOLOGY_LAYOUTS = 29
+
+	MENU_RESCUE_OFFSCREEN_WINDOWS           = 30
+	MENU_TOGGLE_RESCUE_AFTER_DISPLAY_CHANGE = 31
+	MENU_RELOAD_RULES                       = 32
+	MENU_TILE_WINDOWS                       = 33
+	MENU_TOGGLE_FOCUS_FOLLOWS_MOUSE         = 34
+	MENU_TOGGLE_FOCUS_FOLLOWS_MOUSE_RAISES  = 35
+	MENU_TOGGLE_AUTO_RAISE                  = 36
+	MENU_TOGGLE_MRU_FOCUS_RESTORE           = 37
+	MENU_TOGGLE_KEEP_SENT_TO_BACK_STACK     = 38
+	MENU_TOGGLE_RAISE_APP_UNMINIMIZES       = 39
+	MENU_TOGGLE_MOVE_OWNED_WINDOWS          = 40
+	MENU_SELECTION_TILE                     = 41
+	MENU_SELECTION_MINIMIZE                 = 42
+	MENU_SELECTION_SEND_TO_BACK             = 43
+	MENU_SELECTION_CLEAR                    = 44
+	MENU_TOGGLE_SESSION_JOURNAL             = 45
+	MENU_TOGGLE_REVERT_JOURNAL_ON_EXIT      = 46
+	MENU_REVERT_JOURNAL                     = 47
+	MENU_TOGGLE_AUTO_SWITCH_PROFILES        = 48
+	MENU_TILING_INNER_GAP_BASE              = 140 // + index into tilingGapPxPresets
+	MENU_TILING_OUTER_GAP_BASE              = 160 // + index into tilingGapPxPresets
+	MENU_LAYOUT_RESTORE_BASE                = 200
+	MENU_LAYOUT_OVERWRITE_BASE              = 250
+	MENU_UNPIN_BASE                         = 300 // + index into the tray's pinnedHwnds snapshot
+	MENU_FOCUS_FOLLOWS_MOUSE_DELAY_BASE     = 320 // + index into focusFollowsMouseDelayMsPresets
+	MENU_AUTO_RAISE_DELAY_BASE              = 340 // + index into autoRaiseDelayMsPresets
+	MENU_SENT_TO_BACK_RESTORE_BASE          = 360 // + index into the tray's sentToBackMenuEntries snapshot
+	MENU_KEEP_AT_BOTTOM_RELEASE_BASE        = 380 // + index into the tray's keptAtBottomHwnds snapshot
+	MENU_HOT_CORNER_ACTION_BASE             = 400 // + corner index * hotCornerActionCount + hotCornerAction
+	MENU_HOT_CORNER_DELAY_BASE              = 420 // + index into hotCornerDelayMsPresets
+	MENU_PROFILE_BASE                       = 440 // no profile; + 1 + index into the tray's profileNames snapshot
+)
+
+// maxPresetsInTrayMenu bounds the tray's preset submenus (see
+// appendInt32PresetSubmenu), whose lists are persisted and can be edited
+// (see int32ListSetting); it is the width of each MENU_*_BASE range they
+// use.
+const maxPresetsInTrayMenu = 20
+
+// MF_POPUP/MF_SEPARATOR are AppendMenuW flags wincoe doesn't export:
+// MF_POPUP makes uIDNewItem an HMENU to attach as a submenu (DestroyMenu on
+// the parent then destroys it too), MF_SEPARATOR draws a divider line.
+const (
+	MF_POPUP     = 0x00000010
+	MF_SEPARATOR = 0x00000800
 )
 
 const (
@@ -313,6 +636,11 @@ const (
 	// the foreground-retaining window used while
 	// unfocusSentToBackWindow was disabled.
 	zOrderActionRestoreFocused
+
+	// zOrderActionPin/zOrderActionUnpin identify a pin-on-top toggle's
+	// HWND_TOPMOST/HWND_NOTOPMOST (see togglePinUnderCursor).
+	zOrderActionPin
+	zOrderActionUnpin
 )
 
 type WindowMoveData struct {
@@ -343,6 +671,12 @@ type WindowMoveData struct {
 	// SentToBackRestoreID identifies the reserved sentToBackStack entry
 	// associated with zOrderActionRestoreStackEntry.
 	SentToBackRestoreID uint64
+
+	// Followers are the windows that follow Hwnd when this is a Win+LMB
+	// move (see owned.go and selection.go); the session's list, carried
+	// here because the final coalesced move can run after the session has
+	// ended.
+	Followers []follower
 }
 
 type dragState struct {
@@ -558,6 +892,19 @@ type dragSession struct {
 	// frame styling doesn't change mid-gesture, so these are safe to
 	// compute exactly once and reuse for the gesture's entire duration.
 	visualInsetLeft, visualInsetTop, visualInsetRight, visualInsetBottom int32
+
+	// rules is targetWnd's per-application rule overrides (see
+	// windowRulesFor), evaluated once when this gesture began and carried
+	// through unchanged across any Shift-mirror toggle, like the visual
+	// insets above -- the main-thread snap code reads it on every move
+	// instead of re-matching the window each time.
+	rules winrules.Overrides
+
+	// followers is the windows that follow targetWnd in a ModeMove gesture:
+	// its owned windows (see owned.go) and the rest of the selection if it
+	// is selected (see selection.go), collected once when the gesture
+	// began. nil for ModeResize, or when nothing follows.
+	followers []follower
 }
 
 // A single atomic pointer handles the entire active state machine.
@@ -678,7 +1025,9 @@ var (
 	focusedSentToBackHwnd atomic.Uintptr
 )
 
-const maxSentToBackStackDepth = 64
+// sentToBackStackDepth caps the sent-to-back stack; the oldest entries
+// fall off. Persisted (see persistedSettings), 64 by default.
+var sentToBackStackDepth atomic.Int32
 
 // bypassGesturesWhenFullscreen, when true, suppresses winkey+mouse gestures
 // whose resolved target window is fullscreen (exclusive or
@@ -764,7 +1113,8 @@ var virtualizationDetectionEnabled atomic.Bool
 // snapToEdgesEnabled gates whether an in-progress winkey+LMB move or
 // winkey+RMB resize snaps whichever window edges the current gesture is
 // actively touching flush against the target window's monitor work-area
-// edges, once within snapToEdgesThresholdPx of them (see
+// edges (plus, optionally, its center/thirds lines), once within
+// snapThresholdPx of them (see
 // applySnapToEdgesForMove/applySnapToEdgesForResize). Off by default:
 // besides being a new behavior change to how the mouse tracks during a
 // drag, checking this also adds a MonitorFromWindow + GetMonitorInfo call
@@ -779,6 +1129,62 @@ var virtualizationDetectionEnabled atomic.Bool
 // toggle (see persistedSettings).
 var snapToEdgesEnabled atomic.Bool
 
+// snapToCenterLinesEnabled adds the vertical and horizontal center lines of
+// the monitor's work area (after snapOuterGapPx) to the set of lines a
+// move/resize snaps to, and additionally lets a moved window's own
+// midpoint snap onto them -- dragging a window "to the middle" then lands
+// it exactly centered. Only consulted while snapToEdgesEnabled is on.
+// Defaults to false (pre-existing edges-only behavior). Toggleable via
+// systray; persisted like every other systray toggle (see
+// persistedSettings).
+var snapToCenterLinesEnabled atomic.Bool
+
+// snapToThirdsEnabled adds the lines at 1/3 and 2/3 of the monitor's work
+// area (after snapOuterGapPx) to the set of snap lines, for the common
+// "two-thirds + one-third" side-by-side arrangement. Only consulted while
+// snapToEdgesEnabled is on. Defaults to false. Toggleable via systray;
+// persisted like every other systray toggle (see persistedSettings).
+var snapToThirdsEnabled atomic.Bool
+
+// snapThresholdPx is how close (in screen pixels, inclusive) a window edge
+// must come to a snap line before it gets pulled onto it -- see
+// snapengine.Config.ThresholdPx. Defaults to 12: generous enough to catch
+// typical mouse overshoot/undershoot at normal drag speeds without being so
+// wide that it snaps when the user clearly isn't aiming for the line.
+// Measured in physical pixels, which is consistent with how every other
+// coordinate in this file is already treated -- see todo.txt's note that
+// Per-Monitor-V2 DPI awareness gives this process unvirtualized
+// physical-pixel coordinates uniformly across monitors of differing scale
+// factors. Settable via a systray submenu of presets, or any value within
+// [snapThresholdPxMin, snapThresholdPxMax] by hand-editing
+// settingsFilePath; persisted (see persistedSettings).
+var snapThresholdPx atomic.Int32
+
+// snapOuterGapPx insets every snap line derived from a monitor's work
+// area by this many pixels from each work-area edge, so windows snapped to
+// the screen border keep a visible margin instead of sitting flush against
+// it -- see snapengine.Config.OuterGapPx. Defaults to 0 (flush, the
+// pre-existing behavior). Settable the same way as snapThresholdPx;
+// persisted (see persistedSettings).
+var snapOuterGapPx atomic.Int32
+
+// Allowed ranges for snapThresholdPx/snapOuterGapPx, enforced when loading
+// a (possibly hand-edited) settings file -- see atomicInt32Setting.
+const (
+	snapThresholdPxMin int32 = 1
+	snapThresholdPxMax int32 = 64
+	snapOuterGapPxMin  int32 = 0
+	snapOuterGapPxMax  int32 = 200
+)
+
+// snapThresholdPxPresets/snapOuterGapPxPresets are the values offered by
+// the systray's snap submenus (see MENU_SNAP_THRESHOLD_BASE/
+// MENU_SNAP_GAP_BASE). Each must lie within its setting's allowed range.
+var (
+	snapThresholdPxPresets = []int32{4, 8, 12, 16, 24, 32}
+	snapOuterGapPxPresets  = []int32{0, 4, 8, 12, 16, 24}
+)
+
 // disableFileLogging, when true, suppresses internalLogger's log-FILE write
 // path entirely -- see internalLogger's own doc comment for exactly what
 // this does and does not affect (console/devbuild output is untouched).
@@ -847,28 +1253,17 @@ func oppositeResizeZone(zone int) int {
 	}
 }
 
-// snapToEdgesThresholdPx is how close (in screen pixels) a window edge must
-// come to its monitor's work-area edge before applySnapToEdgesForMove/
-// applySnapToEdgesForResize pull it flush against that edge. Kept generous
-// enough to catch typical mouse overshoot/undershoot at normal drag speeds
-// without being so wide that it snaps when the user clearly isn't aiming
-// for the edge. Measured in physical pixels, which is consistent with how
-// every other coordinate in this file is already treated -- see
-// todo.txt's note that Per-Monitor-V2 DPI awareness gives this process
-// unvirtualized physical-pixel coordinates uniformly across monitors of
-// differing scale factors.
-const snapToEdgesThresholdPx int32 = 12
-
 // edgeSnapMask flags which rectangle edges are eligible to be snapped to
-// the nearest monitor work-area edge by applySnapToEdgesForResize. Bits
-// combine for resize zones that move more than one edge (e.g. a corner).
-type edgeSnapMask uint8
+// the nearest snap line by applySnapToEdgesForResize. Bits combine for
+// resize zones that move more than one edge (e.g. a corner). An alias of
+// snapengine.Edge so a mask can be handed straight to the engine.
+type edgeSnapMask = snapengine.Edge
 
 const (
-	snapEdgeLeft edgeSnapMask = 1 << iota
-	snapEdgeTop
-	snapEdgeRight
-	snapEdgeBottom
+	snapEdgeLeft   = snapengine.EdgeLeft
+	snapEdgeTop    = snapengine.EdgeTop
+	snapEdgeRight  = snapengine.EdgeRight
+	snapEdgeBottom = snapengine.EdgeBottom
 )
 
 // snapEdgeMaskForResizeZone reports which rectangle edges the given resize
@@ -903,17 +1298,6 @@ func snapEdgeMaskForResizeZone(zone int) edgeSnapMask {
 	}
 }
 
-// absInt32 returns the absolute value of v. math.Abs operates on float64
-// and would round-trip through a lossy conversion for the plain pixel-delta
-// integers used throughout the snap-to-edges logic, so this tiny int32
-// helper avoids that entirely.
-func absInt32(v int32) int32 {
-	if v < 0 {
-		return -v
-	}
-	return v
-}
-
 // monitorWorkAreaFor returns the work-area rect (RcWork -- excludes the
 // taskbar and any other reserved screen real estate, unlike RcMonitor) of
 // the monitor nearest hwnd, and false if hwnd has no associated monitor (a
@@ -931,15 +1315,6 @@ func monitorWorkAreaFor(hwnd windows.Handle) (wincoe.RECT, bool) {
 	return mi.RcWork, true
 }
 
-// snappedEdgeOrOriginal returns workEdge if target is already within
-// snapToEdgesThresholdPx of it, or target unchanged otherwise.
-func snappedEdgeOrOriginal(target, workEdge int32) int32 {
-	if absInt32(target-workEdge) <= snapToEdgesThresholdPx {
-		return workEdge
-	}
-	return target
-}
-
 // windowVisualEdgeInsets returns how much GetWindowRect's rect extends
 // beyond hwnd's actual visible bounds on each side, via
 // wincoe.DwmGetExtendedFrameBounds. On Windows 10+, resizable top-level
@@ -986,60 +1361,69 @@ func windowVisualEdgeInsets(hwnd windows.Handle) (left, top, right, bottom int32
 	return left, top, right, bottom
 }
 
-// applySnapToEdgesForMove nudges the whole window flush against whichever
-// monitor work-area edge(s) it's already within snapToEdgesThresholdPx of,
-// preserving w/h exactly -- a move never resizes. No-op (returns x, y
-// unchanged) if snapToEdgesEnabled is off or hwnd's monitor can't be
-// determined.
-//
-// Left takes priority over right (and top over bottom) if a window is
-// somehow within threshold of both opposite edges at once -- only possible
-// for a window narrower/shorter than 2*snapToEdgesThresholdPx, in which
-// case either choice is equally reasonable.
+// currentSnapConfig snapshots the live snap settings, as overridden for
+// the target window by ov (see dragSession.rules), into the pure engine's
+// Config. Each field is loaded independently, so a tray change landing
+// mid-drag may mix old and new values for one mouse move -- harmless, the
+// very next move picks up the full new set.
+func currentSnapConfig(ov winrules.Overrides) snapengine.Config {
+	return snapengine.Config{
+		ThresholdPx: snapThresholdPx.Load(),
+		OuterGapPx:  snapOuterGapPx.Load(),
+		CenterLines: ruleSnapToCenterLines.in(ov),
+		Thirds:      ruleSnapToThirds.in(ov),
+	}
+}
+
+// toSnapRect converts a wincoe.RECT into the engine's own identical-layout
+// Rect (the engine deliberately doesn't import wincoe -- see its package
+// doc).
+func toSnapRect(r wincoe.RECT) snapengine.Rect {
+	return snapengine.Rect{Left: r.Left, Top: r.Top, Right: r.Right, Bottom: r.Bottom}
+}
+
+// applySnapToEdgesForMove nudges the whole window so its visible edges (or,
+// with snapToCenterLinesEnabled, its midpoint) land on whichever snap line
+// of its monitor's work area they're already within snapThresholdPx of,
+// preserving w/h exactly -- a move never resizes. The actual decision is
+// snapengine.SnapMove's; this only translates between GetWindowRect space
+// and the window's visible rect. No-op (returns x, y unchanged) if
+// snapToEdgesEnabled is off (globally, or for this window by a rule) or
+// hwnd's monitor can't be determined.
 func applySnapToEdgesForMove(session *dragSession, x, y, w, h int32) (int32, int32) {
-	if !snapToEdgesEnabled.Load() {
+	if !ruleSnapToEdges.in(session.rules) {
 		return x, y
 	}
 	work, ok := monitorWorkAreaFor(session.targetWnd)
 	if !ok {
 		return x, y
 	}
-	insetLeft, insetTop, insetRight, insetBottom := session.visualInsetLeft, session.visualInsetTop, session.visualInsetRight, session.visualInsetBottom
 
-	// Snap against the window's VISIBLE edge (x+insetLeft, etc.), not the
-	// raw GetWindowRect edge -- see windowVisualEdgeInsets's doc comment.
-	switch {
-	case absInt32((x+insetLeft)-work.Left) <= snapToEdgesThresholdPx:
-		x = work.Left - insetLeft
-	case absInt32((x+w-insetRight)-work.Right) <= snapToEdgesThresholdPx:
-		x = work.Right + insetRight - w
+	// Snap the window's VISIBLE rect, not the raw GetWindowRect one -- see
+	// windowVisualEdgeInsets's doc comment. A translation is the same in
+	// either space, so dx/dy apply to x/y directly.
+	visible := snapengine.Rect{
+		Left:   x + session.visualInsetLeft,
+		Top:    y + session.visualInsetTop,
+		Right:  x + w - session.visualInsetRight,
+		Bottom: y + h - session.visualInsetBottom,
 	}
-
-	switch {
-	case absInt32((y+insetTop)-work.Top) <= snapToEdgesThresholdPx:
-		y = work.Top - insetTop
-	case absInt32((y+h-insetBottom)-work.Bottom) <= snapToEdgesThresholdPx:
-		y = work.Bottom + insetBottom - h
-	}
-
-	return x, y
+	dx, dy := snapengine.SnapMove(visible, toSnapRect(work), currentSnapConfig(session.rules))
+	return x + dx, y + dy
 }
 
-// applySnapToEdgesForResize snaps whichever of l/t/r/b are set in mask
-// flush against hwnd's monitor work-area edge, if already within
-// snapToEdgesThresholdPx of it. Edges not set in mask are returned
-// completely unchanged -- see snapEdgeMaskForResizeZone's doc comment for
-// why only the edges the active resize zone actually moves are ever
-// eligible.
+// applySnapToEdgesForResize snaps whichever of l/t/r/b are set in mask onto
+// the nearest snap line of hwnd's monitor work area, if already within
+// snapThresholdPx of it. Edges not set in mask are returned completely
+// unchanged -- see snapEdgeMaskForResizeZone's doc comment for why only the
+// edges the active resize zone actually moves are ever eligible.
 //
-// No-op (returns l, t, r, b unchanged) if snapToEdgesEnabled is off, hwnd's
+// No-op (returns l, t, r, b unchanged) if snapToEdgesEnabled is off (see
+// applySnapToEdgesForMove), hwnd's
 // monitor can't be determined, mask is 0, or the snap would invert the
-// rect (a defensive floor that should never actually trigger in practice,
-// since snapToEdgesThresholdPx is far smaller than any realistic minimum
-// window size -- but a snapped-into-degenerate rect is worth refusing
-// outright rather than silently handing the caller a nonsensical result).
+// rect (snapengine.SnapResize refuses that itself).
 func applySnapToEdgesForResize(session *dragSession, l, t, r, b int32, mask edgeSnapMask) (int32, int32, int32, int32) {
-	if !snapToEdgesEnabled.Load() || mask == 0 {
+	if !ruleSnapToEdges.in(session.rules) || mask == 0 {
 		return l, t, r, b
 	}
 	work, ok := monitorWorkAreaFor(session.targetWnd)
@@ -1048,35 +1432,12 @@ func applySnapToEdgesForResize(session *dragSession, l, t, r, b int32, mask edge
 	}
 	insetLeft, insetTop, insetRight, insetBottom := session.visualInsetLeft, session.visualInsetTop, session.visualInsetRight, session.visualInsetBottom
 
-	// snappedEdgeOrOriginal is evaluated against the VISIBLE edge
-	// (l+insetLeft, r-insetRight, etc.), and the result converted back into
+	// Snapping is evaluated against the VISIBLE edges (l+insetLeft,
+	// r-insetRight, etc.), and the result converted back into
 	// GetWindowRect space -- see windowVisualEdgeInsets's doc comment.
-	snappedL, snappedT, snappedR, snappedB := l, t, r, b
-	if mask&snapEdgeLeft != 0 {
-		if visual := snappedEdgeOrOriginal(l+insetLeft, work.Left); visual != l+insetLeft {
-			snappedL = visual - insetLeft
-		}
-	}
-	if mask&snapEdgeTop != 0 {
-		if visual := snappedEdgeOrOriginal(t+insetTop, work.Top); visual != t+insetTop {
-			snappedT = visual - insetTop
-		}
-	}
-	if mask&snapEdgeRight != 0 {
-		if visual := snappedEdgeOrOriginal(r-insetRight, work.Right); visual != r-insetRight {
-			snappedR = visual + insetRight
-		}
-	}
-	if mask&snapEdgeBottom != 0 {
-		if visual := snappedEdgeOrOriginal(b-insetBottom, work.Bottom); visual != b-insetBottom {
-			snappedB = visual + insetBottom
-		}
-	}
-
-	if snappedL >= snappedR || snappedT >= snappedB {
-		return l, t, r, b
-	}
-	return snappedL, snappedT, snappedR, snappedB
+	visible := snapengine.Rect{Left: l + insetLeft, Top: t + insetTop, Right: r - insetRight, Bottom: b - insetBottom}
+	snapped := snapengine.SnapResize(visible, toSnapRect(work), mask, currentSnapConfig(session.rules))
+	return snapped.Left - insetLeft, snapped.Top - insetTop, snapped.Right + insetRight, snapped.Bottom + insetBottom
 }
 
 // mirrorPointInRect reflects pt through the center of r -- a combined
@@ -1183,6 +1544,7 @@ func handleShiftMirrorToggle(session *dragSession, cursorPt wincoe.POINT, shiftD
 		visualInsetTop:           session.visualInsetTop,
 		visualInsetRight:         session.visualInsetRight,
 		visualInsetBottom:        session.visualInsetBottom,
+		rules:                    session.rules,
 	}
 
 	if shiftDown {
@@ -1297,6 +1659,11 @@ func postShiftMirrorToggleIfNeeded(intendedShiftDown bool) {
 	}
 }
 
+// minResizeWindowPx is the smallest width and height a resize gesture will
+// shrink a window to (see calculateResize's safety floor). Persisted (see
+// persistedSettings), 32 by default.
+var minResizeWindowPx atomic.Int32
+
 // calculateResize computes the window rect for a resize gesture.
 // shiftDown is the authoritative Shift state for this sample: callers that
 // already know it from a key-transition event (WM_APPLY_SHIFT_MIRROR) must
@@ -1420,9 +1787,9 @@ func calculateResize(session *dragSession, currentPt wincoe.POINT, zone int, shi
 	}
 
 	// --- ANCHOR-AWARE HARD SAFETY FLOOR ---
-	// Enforce a safe minimum size (e.g., 32x32) while locking down the correct coordinates
+	// Enforce a safe minimum size (32x32 by default, see minResizeWindowPx) while locking down the correct coordinates
 	// so the window never slides when it hits this boundary floor.
-	const safeMin = 32
+	safeMin := minResizeWindowPx.Load()
 
 	if zone == ZONE_CENTER {
 		if w < safeMin {
@@ -2317,6 +2684,28 @@ func appendMenuChecked(hMenu windows.Handle, flags uint32, id uintptr, textStr s
 	}
 }
 
+// appendInt32PresetSubmenu appends a submenu titled textStr to hMenu,
+// listing each of presets (formatted via format) as an item with ID
+// baseID+index, checking whichever preset equals current. A failure to
+// create the submenu is logged and the entry simply omitted -- the rest of
+// the tray menu still works. Ownership of the submenu passes to hMenu, so
+// the caller's existing DestroyMenu(hMenu) frees it as well.
+func appendInt32PresetSubmenu(hMenu windows.Handle, textStr string, presets []int32, current int32, baseID int, format func(int32) string) {
+	hSub, res := wincoe.CreatePopupMenu()
+	if res.Failed() {
+		logf("WM_MYSYSTRAY: CreatePopupMenu failed for submenu %q, err=%v", textStr, res.Err)
+		return
+	}
+	for i, v := range presets {
+		var flags uint32 = wincoe.MF_STRING
+		if v == current {
+			flags |= wincoe.MF_CHECKED
+		}
+		appendMenuChecked(hSub, flags, uintptr(baseID+i), format(v))
+	}
+	appendMenuChecked(hMenu, wincoe.MF_STRING|MF_POPUP, uintptr(hSub), textStr)
+}
+
 // copyUTF16Truncated copies s (as UTF-16) into dst, guaranteeing dst ends up
 // null-terminated even when s must be truncated to fit. A bare
 // copy(dst, windows.StringToUTF16(s)) can silently drop the terminator
@@ -2609,16 +2998,27 @@ func updateTrayTooltipInputStateIfChanged() {
 
 /* ---------------- Settings persistence ---------------- */
 
-// persistedSetting describes one boolean systray toggle that's saved to
+// persistedSetting describes one systray setting that's saved to
 // settingsFilePath and restored at startup, keyed by name so the on-disk
-// format stays a simple, human-readable and human-editable "name =
-// true/false" list (matching this project's existing readcfg.env
-// convention) rather than a positional or binary format that would
-// silently corrupt if fields were ever reordered.
+// format stays a simple, human-readable and human-editable "name = value"
+// list (matching this project's existing readcfg.env convention) rather
+// than a positional or binary format that would silently corrupt if fields
+// were ever reordered.
+//
+// format/parse are the value's text codec: format renders the current
+// value exactly as saveSettings writes it, and parse validates a
+// hand-edited/persisted value and applies it ONLY if valid, returning an
+// error (and leaving the current value untouched) otherwise. Most entries
+// are plain booleans (see atomicBoolSetting); the rest are bounded integers
+// (atomicInt32Setting), durations (atomicDurationSetting), named choices
+// (atomicEnumSetting), strings (atomicStringSetting) and integer lists
+// (int32ListSetting). Each setting's default is whatever init() stores
+// before loadSettings runs, and stays in force if the file's value is
+// rejected.
 type persistedSetting struct {
-	name string
-	get  func() bool
-	set  func(bool)
+	name   string
+	format func() string
+	parse  func(string) error
 
 	// skip, if non-nil and returns true, makes loadSettings ignore this
 	// setting's persisted value entirely for this run (keeping whatever
@@ -2628,16 +3028,159 @@ type persistedSetting struct {
 	// an earlier session -- see disableFileLoggingForcedByCmdline. Every
 	// other entry leaves this nil.
 	skip func() bool
+
+	// onChange, if non-nil, runs after a change made while running (see
+	// applySettingsText) for settings that are more than a value read
+	// live, e.g. rulesFile, whose rules have to be reloaded. Not run at
+	// startup, nor for tray changes, whose handlers do the same work.
+	onChange func()
 }
 
-// atomicBoolSetting constructs the get/set closures for the overwhelmingly
-// common case (a plain *atomic.Bool toggle with no skip condition), so each
-// ordinary entry in persistedSettings below is a single line.
+// atomicBoolSetting constructs the format/parse closures for the
+// overwhelmingly common case (a plain *atomic.Bool toggle with no skip
+// condition), so each ordinary entry in persistedSettings below is a single
+// line.
 func atomicBoolSetting(name string, v *atomic.Bool) persistedSetting {
+	return persistedSetting{
+		name:   name,
+		format: func() string { return strconv.FormatBool(v.Load()) },
+		parse: func(text string) error {
+			parsed, err := strconv.ParseBool(text)
+			if err != nil {
+				return err
+			}
+			v.Store(parsed)
+			return nil
+		},
+	}
+}
+
+// atomicInt32Setting is atomicBoolSetting's counterpart for a bounded
+// integer setting (e.g. snapThresholdPx). A persisted value outside
+// [minVal, maxVal] is rejected exactly like an unparsable one -- a
+// hand-edited "snapThresholdPx = 100000" must not silently turn every drag
+// into a teleport to the nearest screen edge.
+func atomicInt32Setting(name string, v *atomic.Int32, minVal, maxVal int32) persistedSetting {
+	return persistedSetting{
+		name:   name,
+		format: func() string { return strconv.FormatInt(int64(v.Load()), 10) },
+		parse: func(text string) error {
+			parsed, err := strconv.ParseInt(text, 10, 32)
+			if err != nil {
+				return err
+			}
+			if int32(parsed) < minVal || int32(parsed) > maxVal {
+				return fmt.Errorf("value %d is outside the allowed range [%d, %d]", parsed, minVal, maxVal)
+			}
+			v.Store(int32(parsed))
+			return nil
+		},
+	}
+}
+
+// atomicDurationSetting is atomicInt32Setting's counterpart for a bounded
+// duration, kept in v as nanoseconds (time.Duration's own unit) so hot
+// paths can compare against it without conversion. Written and read the
+// way Go writes durations ("33ms", "1.5s"); a bare number is rejected
+// rather than guessed at, since "16" could mean milliseconds or seconds.
+func atomicDurationSetting(name string, v *atomic.Int64, minVal, maxVal time.Duration) persistedSetting {
+	return persistedSetting{
+		name:   name,
+		format: func() string { return time.Duration(v.Load()).String() },
+		parse: func(text string) error {
+			parsed, err := time.ParseDuration(text)
+			if err != nil {
+				return err
+			}
+			if parsed < minVal || parsed > maxVal {
+				return fmt.Errorf("value %v is outside the allowed range [%v, %v]", parsed, minVal, maxVal)
+			}
+			v.Store(int64(parsed))
+			return nil
+		},
+	}
+}
+
+// atomicEnumSetting is for a setting with a fixed set of choices: v holds
+// an index into names, and the file holds the name (matched
+// case-insensitively on the way in), so the settings file stays readable
+// and a reordering of the choices can't silently change what a saved
+// value means.
+func atomicEnumSetting(name string, v *atomic.Int32, names []string) persistedSetting {
+	return persistedSetting{
+		name:   name,
+		format: func() string { return names[v.Load()] },
+		parse: func(text string) error {
+			for i, n := range names {
+				if strings.EqualFold(text, n) {
+					v.Store(int32(i)) // #nosec G115 -- tiny index
+					return nil
+				}
+			}
+			return fmt.Errorf("unknown value %q (want one of %s)", text, strings.Join(names, ", "))
+		},
+	}
+}
+
+// atomicStringSetting is for a free-form string setting; validate, if
+// non-nil, rejects values that make no sense for it. A value can't span
+// lines (the file is line-based) and leading/trailing blanks don't
+// survive loadSettings' trimming, so neither is representable.
+func atomicStringSetting(name string, v *atomic.Pointer[string], validate func(string) error) persistedSetting {
+	return persistedSetting{
+		name:   name,
+		format: func() string { return *v.Load() },
+		parse: func(text string) error {
+			if validate != nil {
+				if err := validate(text); err != nil {
+					return err
+				}
+			}
+			v.Store(&text)
+			return nil
+		},
+	}
+}
+
+// int32ListSetting is for a comma-separated list of bounded integers,
+// e.g. the values a tray preset submenu offers. A list must have between
+// 1 and maxLen entries, each within [minVal, maxVal]; one bad entry
+// rejects the whole list. v is a plain slice, not an atomic: every list
+// setting is only read on the main thread (the tray), which is also
+// where loadSettings runs.
+func int32ListSetting(name string, v *[]int32, minVal, maxVal int32, maxLen int) persistedSetting {
 	return persistedSetting{
 		name: name,
-		get:  v.Load,
-		set:  v.Store,
+		format: func() string {
+			texts := make([]string, len(*v))
+			for i, n := range *v {
+				texts[i] = strconv.FormatInt(int64(n), 10)
+			}
+			return strings.Join(texts, ", ")
+		},
+		parse: func(text string) error {
+			fields := strings.Split(text, ",")
+			if len(fields) > maxLen {
+				return fmt.Errorf("%d values, at most %d allowed", len(fields), maxLen)
+			}
+			parsed := make([]int32, 0, len(fields))
+			for _, f := range fields {
+				f = strings.TrimSpace(f)
+				if f == "" {
+					return errors.New("empty value in list")
+				}
+				n, err := strconv.ParseInt(f, 10, 32)
+				if err != nil {
+					return err
+				}
+				if int32(n) < minVal || int32(n) > maxVal {
+					return fmt.Errorf("value %d is outside the allowed range [%d, %d]", n, minVal, maxVal)
+				}
+				parsed = append(parsed, int32(n))
+			}
+			*v = parsed
+			return nil
+		},
 	}
 }
 
@@ -2686,11 +3229,12 @@ var settingsFileWriter = wincoe.NewWin11SafeFileWriter(true, 3, 50, &fileWriterL
 // identical set-once-early/read-later-without-a-race pattern.
 var disableFileLoggingForcedByCmdline bool
 
-// persistedSettings is the single source of truth for which boolean
-// systray toggles survive a restart, and under what on-disk key name.
-// Adding a new persisted toggle means adding exactly one line here (via
-// atomicBoolSetting) -- saveSettings/loadSettings both iterate this table
-// generically instead of hand-rolling per-field (de)serialization code.
+// persistedSettings is the single source of truth for which systray
+// settings survive a restart, and under what on-disk key name. Adding a new
+// persisted setting means adding exactly one line here (via
+// atomicBoolSetting/atomicInt32Setting and friends) -- saveSettings and
+// loadSettings both iterate this table generically instead of hand-rolling
+// per-field (de)serialization code.
 var persistedSettings = []persistedSetting{
 	atomicBoolSetting("focusOnDrag", &focusOnDrag),
 	atomicBoolSetting("doLMBClick2FocusAsFallback", &doLMBClick2FocusAsFallback),
@@ -2714,12 +3258,75 @@ var persistedSettings = []persistedSetting{
 	atomicBoolSetting("useThreadAttachInputForFocus", &useThreadAttachInputForFocus),
 	atomicBoolSetting("virtualizationDetectionEnabled", &virtualizationDetectionEnabled),
 	atomicBoolSetting("snapToEdgesEnabled", &snapToEdgesEnabled),
-	{
-		name: "disableFileLogging",
-		get:  disableFileLogging.Load,
-		set:  disableFileLogging.Store,
-		skip: func() bool { return disableFileLoggingForcedByCmdline },
-	},
+	atomicBoolSetting("snapToCenterLinesEnabled", &snapToCenterLinesEnabled),
+	atomicBoolSetting("snapToThirdsEnabled", &snapToThirdsEnabled),
+	atomicInt32Setting("snapThresholdPx", &snapThresholdPx, snapThresholdPxMin, snapThresholdPxMax),
+	atomicInt32Setting("snapOuterGapPx", &snapOuterGapPx, snapOuterGapPxMin, snapOuterGapPxMax),
+	atomicBoolSetting("perTopologyLayoutsEnabled", &perTopologyLayoutsEnabled),
+	atomicBoolSetting("rescueWindowsAfterDisplayChange", &rescueWindowsAfterDisplayChange),
+	atomicInt32Setting("tilingInnerGapPx", &tilingInnerGapPx, tilingGapPxMin, tilingGapPxMax),
+	atomicInt32Setting("tilingOuterGapPx", &tilingOuterGapPx, tilingGapPxMin, tilingGapPxMax),
+	atomicBoolSetting("focusFollowsMouse", &focusFollowsMouse),
+	atomicBoolSetting("focusFollowsMouseRaises", &focusFollowsMouseRaises),
+	atomicInt32Setting("focusFollowsMouseDelayMs", &focusFollowsMouseDelayMs, focusFollowsMouseDelayMsMin, focusFollowsMouseDelayMsMax),
+	atomicBoolSetting("autoRaiseOnHover", &autoRaiseOnHover),
+	atomicInt32Setting("autoRaiseDelayMs", &autoRaiseDelayMs, autoRaiseDelayMsMin, autoRaiseDelayMsMax),
+	func() persistedSetting {
+		s := atomicBoolSetting("restoreFocusToMRUOnClose", &restoreFocusToMRUOnClose)
+		s.onChange = mruFocusToggled
+		return s
+	}(),
+	atomicBoolSetting("keepSentToBackStack", &keepSentToBackStack),
+	atomicBoolSetting("raiseAppRestoresMinimized", &raiseAppRestoresMinimized),
+	atomicBoolSetting("moveOwnedWindowsWithOwner", &moveOwnedWindowsWithOwner),
+	atomicEnumSetting("hotCornerTopLeft", &hotCornerActions[0], hotCornerActionNames),
+	atomicEnumSetting("hotCornerTopRight", &hotCornerActions[1], hotCornerActionNames),
+	atomicEnumSetting("hotCornerBottomLeft", &hotCornerActions[2], hotCornerActionNames),
+	atomicEnumSetting("hotCornerBottomRight", &hotCornerActions[3], hotCornerActionNames),
+	atomicInt32Setting("hotCornerDelayMs", &hotCornerDelayMs, hotCornerDelayMsMin, hotCornerDelayMsMax),
+	func() persistedSetting {
+		s := atomicBoolSetting("sessionJournalEnabled", &sessionJournalEnabled)
+		s.onChange = sessionJournalToggled
+		return s
+	}(),
+	atomicBoolSetting("revertJournalOnExit", &revertJournalOnExit),
+	func() persistedSetting {
+		s := atomicStringSetting("rulesFile", &rulesFile, validateRulesFile)
+		s.onChange = func() { reloadRules() }
+		return s
+	}(),
+	func() persistedSetting {
+		s := atomicBoolSetting("autoSwitchProfiles", &autoSwitchProfiles)
+		s.onChange = autoSwitchProfilesToggled
+		return s
+	}(),
+	profileSetting(),
+
+	// Tuning values; no tray items, edit the file (see the README).
+	atomicDurationSetting("moveRateLimitInterval", &moveRateLimitInterval, time.Millisecond, time.Second),
+	atomicDurationSetting("moveOrResizeApplyInterval", &moveOrResizeApplyInterval, time.Millisecond, time.Second),
+	atomicDurationSetting("gestureCursorInterval", &gestureCursorInterval, 10*time.Millisecond, time.Second), // USER_TIMER_MINIMUM is 10ms
+	atomicInt32Setting("minResizeWindowPx", &minResizeWindowPx, 1, 1024),
+	atomicInt32Setting("sentToBackStackDepth", &sentToBackStackDepth, 1, 1024),
+	int32ListSetting("snapThresholdPxPresets", &snapThresholdPxPresets, snapThresholdPxMin, snapThresholdPxMax, maxPresetsInTrayMenu),
+	int32ListSetting("snapOuterGapPxPresets", &snapOuterGapPxPresets, snapOuterGapPxMin, snapOuterGapPxMax, maxPresetsInTrayMenu),
+	int32ListSetting("tilingGapPxPresets", &tilingGapPxPresets, tilingGapPxMin, tilingGapPxMax, maxPresetsInTrayMenu),
+	int32ListSetting("focusFollowsMouseDelayMsPresets", &focusFollowsMouseDelayMsPresets, focusFollowsMouseDelayMsMin, focusFollowsMouseDelayMsMax, maxPresetsInTrayMenu),
+	int32ListSetting("autoRaiseDelayMsPresets", &autoRaiseDelayMsPresets, autoRaiseDelayMsMin, autoRaiseDelayMsMax, maxPresetsInTrayMenu),
+	int32ListSetting("hotCornerDelayMsPresets", &hotCornerDelayMsPresets, hotCornerDelayMsMin, hotCornerDelayMsMax, maxPresetsInTrayMenu),
+	func() persistedSetting {
+		s := atomicBoolSetting("disableFileLogging", &disableFileLogging)
+		s.skip = func() bool { return disableFileLoggingForcedByCmdline }
+		return s
+	}(),
+}
+
+// setInt32AndPersist stores val into v and immediately persists all current
+// settings, the integer counterpart of toggleAndPersist used by the tray's
+// preset-value submenus (see e.g. MENU_SNAP_THRESHOLD_BASE).
+func setInt32AndPersist(v *atomic.Int32, val int32) {
+	v.Store(val)
+	saveSettings()
 }
 
 // toggleAndPersist flips v and immediately persists all current settings to
@@ -2732,11 +3339,10 @@ func toggleAndPersist(v *atomic.Bool) {
 }
 
 // saveSettings serializes every entry in persistedSettings to
-// settingsFilePath as simple "name = true"/"name = false" lines (one per
-// line, matching this project's existing readcfg.env key=value
-// convention), written via wincoe's crash-safe FileWriter so a mid-write
-// crash or power loss can never leave a truncated, unparsable settings
-// file behind.
+// settingsFilePath as simple "name = value" lines (one per line, matching
+// this project's existing readcfg.env key=value convention), written via
+// wincoe's crash-safe FileWriter so a mid-write crash or power loss can
+// never leave a truncated, unparsable settings file behind.
 //
 // Called synchronously from the main thread every time a systray toggle
 // changes (see toggleAndPersist) -- a human clicking a tray menu item is
@@ -2751,9 +3357,13 @@ func toggleAndPersist(v *atomic.Bool) {
 // locked/read-only file.
 func saveSettings() {
 	var b strings.Builder
-	b.WriteString("# winbollocks systray settings -- auto-generated, edit while winbollocks is NOT running or your edits will be overwritten/lost!\n")
+	b.WriteString("# winbollocks systray settings -- auto-generated. Edits saved while winbollocks runs are applied within a second (see the log); tray changes rewrite this file, dropping comments.\n")
 	for _, s := range persistedSettings {
-		fmt.Fprintf(&b, "%s = %t\n", s.name, s.get())
+		val, overlaid := profileBase[s.name] // the file keeps values without the active profile; see profiles.go
+		if !overlaid {
+			val = s.format()
+		}
+		fmt.Fprintf(&b, "%s = %s\n", s.name, val)
 	}
 
 	// #nosec G302 -- 0644 not 0600: winbollocks often runs elevated (see
@@ -2762,11 +3372,13 @@ func saveSettings() {
 	// needing to become admin to do so.
 	if err := settingsFileWriter.SafeWriteFile(settingsFilePath, []byte(b.String()), 0644); err != nil {
 		logf("saveSettings: failed to write %q, err: %v", settingsFilePath, err)
+		return
 	}
+	noteSettingsFileSeen(b.String()) // our own write, not an external edit; see settingswatch.go
 }
 
 // loadSettings reads settingsFilePath (if present) and applies any
-// recognized "name = true"/"name = false" lines onto the matching entry in
+// recognized "name = value" lines onto the matching entry in
 // persistedSettings, overriding whatever default that toggle's own init()
 // already set. Must run after every init() function that seeds a default
 // for one of these toggles (e.g. shiftMirrorResizeEnabled's
@@ -2779,10 +3391,10 @@ func saveSettings() {
 // ignored, NOT logged as an error. An unrecognized key (e.g. a setting that
 // existed in an older version and was since removed) is skipped with a log
 // line rather than treated as fatal, so a settings file written by a newer
-// or older build of winbollocks never prevents startup. A malformed value
-// (anything strconv.ParseBool can't parse) for a recognized key is
-// likewise skipped with a log line, leaving that one toggle at whatever its
-// own init()-computed default already was.
+// or older build of winbollocks never prevents startup. A malformed or
+// out-of-range value (anything that entry's parse rejects) for a
+// recognized key is likewise skipped with a log line, leaving that one
+// setting at whatever its own init()-computed default already was.
 func loadSettings() {
 	data, err := os.ReadFile(settingsFilePath) //nolint:gosec // G304: settingsFilePath is a fixed, hardcoded constant, never derived from user/network input
 	if err != nil {
@@ -2791,13 +3403,25 @@ func loadSettings() {
 		}
 		return
 	}
+	noteSettingsFileSeen(string(data))
+	applySettingsText("loadSettings", settingsFilePath, string(data), false)
+}
 
-	byName := make(map[string]*persistedSetting, len(persistedSettings))
-	for i := range persistedSettings {
-		byName[persistedSettings[i].name] = &persistedSettings[i]
+// applySettingsText applies text, in settingsFilePath's format, onto
+// persistedSettings under loadSettings' rules: unknown keys, bad lines and
+// rejected values are logged (as caller, naming source) and skipped, and
+// settings text doesn't mention keep their current value. With live set
+// (anything after startup), only values that actually differ are applied,
+// each change is logged and runs its setting's onChange; reports how many
+// changed. Each setting changes atomically on its own (see its parse).
+func applySettingsText(caller, source, text string, live bool) (changed int) {
+	keeping := "keeping computed default"
+	if live {
+		keeping = "keeping current value"
 	}
+	byName := settingsByName()
 
-	lines := strings.Split(string(data), "\n")
+	lines := strings.Split(text, "\n")
 	for lineNum, rawLine := range lines {
 		line := strings.TrimSpace(rawLine)
 		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
@@ -2805,7 +3429,7 @@ func loadSettings() {
 		}
 		key, val, found := strings.Cut(line, "=")
 		if !found {
-			logf("loadSettings: %q line %d: missing '=', skipping: %q", settingsFilePath, lineNum+1, rawLine)
+			logf("%s: %q line %d: missing '=', skipping: %q", caller, source, lineNum+1, rawLine)
 			continue
 		}
 		key = strings.TrimSpace(key)
@@ -2813,21 +3437,47 @@ func loadSettings() {
 
 		setting, ok := byName[key]
 		if !ok {
-			logf("loadSettings: %q line %d: unrecognized setting %q, skipping", settingsFilePath, lineNum+1, key)
+			logf("%s: %q line %d: unrecognized setting %q, skipping", caller, source, lineNum+1, key)
 			continue
 		}
 		if setting.skip != nil && setting.skip() {
-			logf("loadSettings: %q line %d: skipping persisted %q for this run (overridden by an explicit command-line flag)", settingsFilePath, lineNum+1, key)
+			logf("%s: %q line %d: skipping persisted %q for this run (overridden by an explicit command-line flag)", caller, source, lineNum+1, key)
 			continue
 		}
 
-		parsed, err := strconv.ParseBool(val)
-		if err != nil {
-			logf("loadSettings: %q line %d: setting %q has unparsable value %q, skipping (keeping computed default), err: %v", settingsFilePath, lineNum+1, key, val, err)
+		if base, overlaid := profileBase[key]; live && overlaid {
+			if val != base && rebaseProfileSetting(caller, source, setting, val) {
+				changed++
+			}
+			continue
+		}
+		before := setting.format()
+		if live && val == before {
 			continue
 		}
-		setting.set(parsed)
+		if err := setting.parse(val); err != nil {
+			logf("%s: %q line %d: setting %q has unparsable value %q, skipping (%s), err: %v", caller, source, lineNum+1, key, val, keeping, err)
+			continue
+		}
+		if after := setting.format(); live && after != before {
+			logf("%s: %q: %s changed from %s to %s", caller, source, key, before, after)
+			if setting.onChange != nil {
+				setting.onChange()
+			}
+			changed++
+		}
 	}
+	settingsTextApplied()
+	return changed
+}
+
+// settingsByName indexes persistedSettings by name.
+func settingsByName() map[string]*persistedSetting {
+	byName := make(map[string]*persistedSetting, len(persistedSettings))
+	for i := range persistedSettings {
+		byName[persistedSettings[i].name] = &persistedSettings[i]
+	}
+	return byName
 }
 
 // parseDisableFileLoggingCmdlineFlag scans os.Args for "-nolog" or
@@ -3152,15 +3802,22 @@ func reassertGestureCursorSetCursor(h windows.Handle) {
 // spam "KillTimer failed" for an already-dead timer.
 var gestureCursorTimerArmed atomic.Bool
 
-// startGestureCursorTimer arms a 16ms WM_TIMER on mainMsgHwnd so SetCursor is
-// reasserted even when the mouse is still (or the target outpaces our move drain).
-// Idempotent: SetTimer with the same id resets the interval; armed stays true.
+// gestureCursorInterval is how often startGestureCursorTimer's timer
+// reasserts the gesture cursor. Nanoseconds; 16ms (≈ 60Hz) by default,
+// enough to win the race against most targets without measurable CPU
+// cost. Persisted (see persistedSettings); read when a gesture starts.
+var gestureCursorInterval atomic.Int64
+
+// startGestureCursorTimer arms a WM_TIMER (every gestureCursorInterval) on
+// mainMsgHwnd so SetCursor is reasserted even when the mouse is still (or
+// the target outpaces our move drain). Idempotent: SetTimer with the same
+// id resets the interval; armed stays true.
 func startGestureCursorTimer() {
 	msgHwnd := loadMainMsgHwnd()
 	if msgHwnd == 0 {
 		return
 	}
-	if _, res := wincoe.SetTimer(msgHwnd, gestureCursorTimerID, gestureCursorTimerMs, 0); res.Failed() {
+	if _, res := wincoe.SetTimer(msgHwnd, gestureCursorTimerID, uint32(time.Duration(gestureCursorInterval.Load()).Milliseconds()), 0); res.Failed() {
 		logf("startGestureCursorTimer: SetTimer failed: %v", res.Err)
 		return
 	}
@@ -3259,6 +3916,12 @@ func startManualDrag(hwnd windows.Handle, pt wincoe.POINT, viaMissedGestureRecov
 	}
 
 	insetL, insetT, insetR, insetB := windowVisualEdgeInsets(hwnd)
+	rules := windowRulesFor(hwnd)
+	var followers []follower
+	if ruleMoveOwnedWindows.in(rules) {
+		followers = ownedWindowsOf(hwnd, r)
+	}
+	followers = appendSelectionFollowers(followers, hwnd, r)
 	sess := &dragSession{
 		targetWnd:                hwnd,
 		mode:                     ModeMove,
@@ -3271,8 +3934,12 @@ func startManualDrag(hwnd windows.Handle, pt wincoe.POINT, viaMissedGestureRecov
 		visualInsetTop:           insetT,
 		visualInsetRight:         insetR,
 		visualInsetBottom:        insetB,
+		rules:                    rules,
+		followers:                followers,
+	}
+	if !beginGestureSession(sess) {
+		return false
 	}
-	activeSession.Store(sess)
 	// Apply the gesture cursor from the main thread, not here: this
 	// function runs on the hook thread (called from mouseProc's
 	// WM_LBUTTONDOWN case). See postApplyGestureCursorStart's doc comment.
@@ -3315,18 +3982,21 @@ func startDrag(hwnd windows.Handle, pt wincoe.POINT, viaMissedGestureRecovery bo
 // front of the Z-order and/or focuses it, right after a move or resize
 // gesture has successfully started. bringToFront and focus are the
 // systray-toggleable settings for whichever gesture mode just started
-// (ModeMove passes &bringToFrontOnDrag/&focusOnDrag; ModeResize passes its
-// own independent &bringToFrontOnResize/&focusOnResize), so the two modes
-// remain fully independently configurable rather than sharing state.
+// (ModeMove passes ruleBringToFrontOnDrag/ruleFocusOnDrag; ModeResize passes
+// its own independent ruleBringToFrontOnResize/ruleFocusOnResize), so the
+// two modes remain fully independently configurable rather than sharing
+// state, and each resolves against targetWnd's per-application rules (see
+// windowRulesFor) before falling back to the global toggle.
 // callerName is only used to identify the caller in the WM_BRING_TO_FRONT
 // failure log.
-func applyFocusAndBringToFrontOnGestureStart(targetWnd windows.Handle, pt wincoe.POINT, bringToFront, focus *atomic.Bool, callerName string) {
+func applyFocusAndBringToFrontOnGestureStart(targetWnd windows.Handle, pt wincoe.POINT, bringToFront, focus overridableSetting, callerName string) {
 	msgHwnd := loadMainMsgHwnd()
 	if msgHwnd == 0 {
 		logf("%s: applyFocusAndBringToFrontOnGestureStart failed due to mainMsgHwnd is 0", callerName)
 		return
 	}
-	if bringToFront.Load() {
+	ov := windowRulesFor(targetWnd)
+	if bringToFront.in(ov) {
 		// Post a dedicated bring-to-front message rather than routing through
 		// the move channel, which would be coalesced away by move events for
 		// the same HWND.
@@ -3335,7 +4005,7 @@ func applyFocusAndBringToFrontOnGestureStart(targetWnd windows.Handle, pt wincoe
 			logf("%s: PostMessage WM_BRING_TO_FRONT for HWND=0x%X failed: %v", callerName, targetWnd, res.Err)
 		}
 	}
-	if focus.Load() && !isWindowForeground(targetWnd) { //TODO: should I move this in startDrag?
+	if focus.in(ov) && !isWindowForeground(targetWnd) { //TODO: should I move this in startDrag?
 		//doneFIXME: should probably embed the targetWnd into the message instead of using whichever the current dragged window is, otherwise it might miss focusing the clicked window due to delays in processing if a new window was quick-engouh clicked since!
 
 		if res := wincoe.PostMessage(
@@ -3439,7 +4109,7 @@ func tryBeginMoveGestureAt(pt wincoe.POINT, viaMissedGestureRecovery bool) (star
 	// if session == nil {
 	// 	panic("bad coding: nil session after startDrag returned true")
 	// }
-	applyFocusAndBringToFrontOnGestureStart(wantTargetWnd, pt, &bringToFrontOnDrag, &focusOnDrag, "tryBeginMoveGestureAt")
+	applyFocusAndBringToFrontOnGestureStart(wantTargetWnd, pt, ruleBringToFrontOnDrag, ruleFocusOnDrag, "tryBeginMoveGestureAt")
 	return true, false
 }
 
@@ -3571,8 +4241,11 @@ func tryBeginResizeGestureAt(pt wincoe.POINT, viaMissedGestureRecovery, shiftDow
 		visualInsetTop:           insetT,
 		visualInsetRight:         insetR,
 		visualInsetBottom:        insetB,
+		rules:                    windowRulesFor(wantTargetWnd),
+	}
+	if !beginGestureSession(sess) {
+		return false, false
 	}
-	activeSession.Store(sess)
 	// See the identical comment (and full rationale) in startManualDrag's
 	// own analogous call site.
 	postApplyGestureCursorStart(sess.targetWnd)
@@ -3580,7 +4253,7 @@ func tryBeginResizeGestureAt(pt wincoe.POINT, viaMissedGestureRecovery, shiftDow
 	// if session == nil {
 	// 	panic("bad coding: nil session after storing new resize session")
 	// }
-	applyFocusAndBringToFrontOnGestureStart(wantTargetWnd, pt, &bringToFrontOnResize, &focusOnResize, "tryBeginResizeGestureAt")
+	applyFocusAndBringToFrontOnGestureStart(wantTargetWnd, pt, ruleBringToFrontOnResize, ruleFocusOnResize, "tryBeginResizeGestureAt")
 	return true, false
 }
 
@@ -3836,7 +4509,11 @@ The compiler instantly recognizes it, creates the variadic backing array right o
 
 func softReset(releaseCapture bool) { //nevermindTODO: use hardReset instead(well no, because it also resets winGestureUsed!) because it now handles the case when Shift tap needs to be inserted if winGestureUsed !
 	//do this first
-	activeSession.Store(nil) //XXX: don't set the innards to nil like state and targetWnd ! because old pointer's contents may still be used by other threads; this is Lock-Free Snapshot or Read-Copy-Update (RCU) pattern.
+	ended := activeSession.Swap(nil) //XXX: don't set the innards to nil like state and targetWnd ! because old pointer's contents may still be used by other threads; this is Lock-Free Snapshot or Read-Copy-Update (RCU) pattern.
+	if ended != nil {
+		recordGestureGeometry(ended) // so the finished gesture can be undone later, see stepGeometryHistory
+	}
+	disarmSwap() // hides the swap target highlight, if any (see swap.go)
 	captureHeldForSession.Store(nil)
 	msgHwnd := loadMainMsgHwnd()
 	/*
@@ -3981,6 +4658,9 @@ func cancelActiveGesture(session *dragSession) {
 	); res.Failed() {
 		logf("cancelActiveGesture: SetWindowPos (restore original rect) on HWND=0x%X failed: %v", target, res.Err)
 	}
+	// Windows that followed a ModeMove drag go back with it (followers is
+	// nil otherwise).
+	moveFollowers(session.followers, r.Left, r.Top)
 
 	if session.wasMaximizedAtStart {
 		// See startDrag/tryBeginResizeGestureAt's identical
@@ -4017,7 +4697,9 @@ func cancelActiveGesture(session *dragSession) {
 func tryCancelActiveGestureViaEsc() bool {
 	session := activeSession.Load()
 	if session == nil {
-		return false // no active drag/resize gesture to cancel; let ESC through
+		// No drag/resize gesture to cancel; ESC may still be dropping a
+		// selection band (see selection.go), or else passes through.
+		return cancelSelectionBand()
 	}
 
 	msgHwnd := loadMainMsgHwnd()
@@ -4299,7 +4981,9 @@ func hideOverlay() {
 	}
 }
 
-// shouldBypassGestureNow returns true when gesture processing for hwnd (the
+// shouldBypassGestureNow returns true when a per-application rule says to
+// ignore hwnd entirely (see windowRulesFor), or when gesture processing for
+// hwnd (the
 // window the gesture would actually target) should be skipped because it's
 // fullscreen (exclusive or borderless) on its monitor and the bypass feature
 // is enabled. The check is done live via isWindowFullscreenOnMonitor against
@@ -4314,7 +4998,16 @@ func hideOverlay() {
 // any other failure still swallows it, matching each gesture's prior
 // behavior before this bypass feature existed.
 func shouldBypassGestureNow(hwnd windows.Handle) bool {
-	if !bypassGesturesWhenFullscreen.Load() {
+	ov := windowRulesFor(hwnd)
+	if ov.Ignored() {
+		now := time.Now().UnixNano()
+		if last := lastIgnoredByRuleLogTime.Load(); now-last > int64(time.Second) {
+			lastIgnoredByRuleLogTime.Store(now)
+			logf("Target window HWND=0x%X is ignored by a rule in %q, letting the gesture through. (this logline is rate-limited to 1 per second)", hwnd, rulesFilePath())
+		}
+		return true
+	}
+	if !ruleBypassGesturesWhenFullscreen.in(ov) {
 		return false
 	}
 	should := isWindowFullscreenOnMonitor(hwnd)
@@ -4333,6 +5026,7 @@ func shouldBypassGestureNow(hwnd windows.Handle) bool {
 }
 
 var lastFullscreenLogTime atomic.Int64 // Add this with your other globals
+var lastIgnoredByRuleLogTime atomic.Int64
 
 // alignRestoredWindowToCursor repositions the restored-window rect so the
 // cursor sits at the same proportional position it held within the maximized
@@ -4731,6 +5425,63 @@ func findNewForegroundCandidateAfterSendToBack(excludeHwnd windows.Handle) windo
 	return 0
 }
 
+// forEachTopLevelWindow calls fn for every top-level window in current
+// Z-order, topmost first -- the same GetTopWindow(0)/GW_HWNDNEXT walk
+// findNewForegroundCandidateAfterSendToBack does, minus its candidate
+// filtering -- until fn returns false or the walk ends. Bounded by
+// maxWalkSteps for the same defensive reason. A GetWindow failure
+// mid-walk (the window we were standing on got destroyed) just ends the
+// walk early: every caller is a best-effort, user-triggered bulk action
+// for which "most windows" is an acceptable outcome.
+func forEachTopLevelWindow(fn func(hwnd windows.Handle) bool) {
+	const maxWalkSteps = 5000 // desktop-wide, so far more generous than the refocus walk's bound
+
+	hwnd, res1 := wincoe.GetTopWindow(0)
+	if res1.Failed() {
+		logf("forEachTopLevelWindow: GetTopWindow failed, res:%v", res1)
+		return
+	}
+	for i := 0; hwnd != 0 && i < maxWalkSteps; i++ {
+		if !fn(hwnd) {
+			return
+		}
+		res2 := wincoe.GetWindow(hwnd, wincoe.GW_HWNDNEXT)
+		if res2.Failed() {
+			return // end of the Z-order (NULL) or a handle that died mid-walk
+		}
+		hwnd = windows.Handle(res2.R1)
+	}
+}
+
+// isManageableTopLevelWindow reports whether hwnd is an ordinary,
+// user-facing application window that bulk window-management actions
+// (saving/restoring layouts, and the like) should touch: visible and not
+// DWM-cloaked (see isWindowCloaked), not one of ours, not something
+// shouldSkipFocusingIt flags (child/tool/no-activate windows), not owned
+// by another window (dialogs and owned popups follow their owner instead),
+// not one of the shell's own desktop/taskbar surfaces, and not ignored by
+// a rule (see rules.go) -- "ignore" means every feature leaves it alone.
+func isManageableTopLevelWindow(hwnd windows.Handle) bool {
+	if !wincoe.IsWindowVisible(hwnd) || isWindowCloaked(hwnd) || isOwnWindow(hwnd) {
+		return false
+	}
+	if skip, _ := shouldSkipFocusingIt(hwnd); skip {
+		return false
+	}
+	if owner := wincoe.GetWindow(hwnd, wincoe.GW_OWNER); owner.R1 != 0 {
+		return false
+	}
+	class, res := wincoe.GetClassName(hwnd)
+	if res.Failed() {
+		return false
+	}
+	switch class {
+	case "Progman", "WorkerW", "Shell_TrayWnd", "Shell_SecondaryTrayWnd":
+		return false
+	}
+	return !windowRulesFor(hwnd).Ignored()
+}
+
 // aka focus(activate) the window, works by attaching to target window's thread, so Windows won't do its focus stealing prevention thing!
 // also, this way I don't have to inject LMB down then LMB up aka a LMB click event to focus it, risking pressing Exit button on total commander for example.
 // however, doneTODO: now i do have to make sure hooks are running on a separate thread (than main msg. loop) because this is potentially blocking and can deadlock, depending on target app.
@@ -4931,8 +5682,16 @@ func mouseProc(nCode int32, wParam uintptr, lParam unsafe.Pointer) uintptr {
 		return res2.R1
 	}
 
+	switch wParam {
+	case wincoe.WM_LBUTTONDOWN, wincoe.WM_RBUTTONDOWN, wincoe.WM_MBUTTONDOWN:
+		noteUserPressForMRU()
+	}
+
 	switch wParam {
 	case wincoe.WM_LBUTTONDOWN: //LMB pressed aka LMBDown or LMB DOWN
+		// A selection band still open here lost its LMB-up (e.g. to a
+		// Winkey+L lock); drop it rather than let it follow the cursor.
+		cancelSelectionBand()
 		// we don't want to trigger our drag gesture if shift/alt/ctrl was held before winkey, because it might have different meaning to other apps.
 		winDown, shiftDown, ctrlDown, altDown := modifierKeyState()
 		// var winDown bool = keyDown(VK_LWIN) || keyDown(VK_RWIN)
@@ -4956,13 +5715,25 @@ func mouseProc(nCode int32, wParam uintptr, lParam unsafe.Pointer) uintptr {
 
 			lmbDownSwallowed.Store(true) // we're about to eat this down; the matching up must be eaten too, regardless of what happens to activeSession in between.
 			return 1                     // swallow LMB
+		} else if winDown && ctrlDown && !altDown {
+			// winkey+Ctrl+LMB (plus Shift to add): rubber-band selection,
+			// see selection.go.
+			beginSelectionBand(info.Pt, shiftDown)
+			markGestureUsedOnce()
+			lmbDownSwallowed.Store(true) // see above
+			return 1
 		} else if !winDown {
 			tryBringForegroundToFrontAt(info.Pt)
 		} // the 'if' in LMB
 
 	case wincoe.WM_MOUSEMOVE:
+		if updateSelectionBand(info.Pt) {
+			break // drawing a selection band; nothing else happens meanwhile
+		}
 		session := activeSession.Load()
 		if session == nil {
+			noteHoverMove()
+			noteHotCornerMove(info.Pt)
 			// See if we might have missed the LMB/RMB-down that would normally have
 			// started a gesture, because our low-level hooks were blind while a
 			// higher-integrity window (e.g. Task Manager, while we're not elevated)
@@ -5116,6 +5887,9 @@ func mouseProc(nCode int32, wParam uintptr, lParam unsafe.Pointer) uintptr {
 			// 	break
 			// }
 
+			_, shiftDown, _, _ := modifierKeyState()
+			noteSwapModifier(shiftDown) // arms or follows a swap-by-drop, see swap.go
+
 			if !ShouldThrottle() {
 				// At the very beginning of the drag/move logic (e.g., right after checking if dragging is active)
 				var now time.Time
@@ -5153,7 +5927,7 @@ func mouseProc(nCode int32, wParam uintptr, lParam unsafe.Pointer) uintptr {
 				// )
 
 				//THISIGNORESALLfrom_staticcheck//nolint:staticcheck,QF1011: could omit type bool from declaration; it will be inferred from the right-hand side (staticcheck)go-golangci-lint-v2
-				var willPostMessage bool = !ratelimitOnMove.Load() || (newX != lastPostedX.Load() || newY != lastPostedY.Load()) && (nowOffset-time.Duration(lastMovePostedTime.Load())) >= MIN_MOVE_INTERVAL
+				var willPostMessage bool = !ratelimitOnMove.Load() || (newX != lastPostedX.Load() || newY != lastPostedY.Load()) && (nowOffset-time.Duration(lastMovePostedTime.Load())) >= time.Duration(moveRateLimitInterval.Load())
 				// Optional: Also count only the ones that would have posted (uncomment if you want both stats)
 				if ratelimitOnMove.Load() && shouldLogDragRate.Load() && willPostMessage {
 					//actualPostCounter++
@@ -5198,6 +5972,7 @@ func mouseProc(nCode int32, wParam uintptr, lParam unsafe.Pointer) uintptr {
 						X:           newX,
 						Y:           newY,
 						InsertAfter: 0, // this is the value for HWND_TOP but SWP_NOZORDER below makes it unused, supposedly!
+						Followers:   session.followers,
 
 						Flags: wincoe.SWP_NOSIZE | wincoe.SWP_NOACTIVATE | wincoe.SWP_NOZORDER | wincoe.SWP_ASYNCWINDOWPOS, // for ModeMove
 					}
@@ -5356,6 +6131,7 @@ func mouseProc(nCode int32, wParam uintptr, lParam unsafe.Pointer) uintptr {
 		// }
 
 	case wincoe.WM_LBUTTONUP: //LMB released aka LMBUP aka LMB UP
+		endSelectionBand(info.Pt) // no-op unless a selection band is being drawn
 		if session := activeSession.Load(); session != nil && session.mode == ModeMove {
 			// End the drag regardless of whether we owe a swallow below (see
 			// lmbDownSwallowed's doc comment): a real LMB-up always ends an
@@ -5364,6 +6140,7 @@ func mouseProc(nCode int32, wParam uintptr, lParam unsafe.Pointer) uintptr {
 			// the target normally, but this real up still ends OUR side of
 			// the drag). This also means when winkey goes UP it will make
 			// sure from keyboardProc that start menu doesn't pop up!
+			noteSwapDrop(session, info.Pt) // Shift held: swap with the window under the cursor, see swap.go
 			softReset(true)
 		}
 		if !lmbDownSwallowed.CompareAndSwap(true, false) {
@@ -5838,15 +6615,12 @@ func mustUTF16(s string) *uint16 {
 var lastResizeUnixNano atomic.Int64
 
 // ShouldThrottle returns true if the last action happened too recently.
-// Uses the constant directly for a zero-allocation, fast check.
+// A single atomic load of the interval keeps it a zero-allocation, fast check.
 func ShouldThrottle() bool {
 	var now int64 = time.Now().UnixNano()
 	var last int64 = lastResizeUnixNano.Load()
 
-	// thresholdNanos is calculated at compile-time/startup
-	const thresholdNanos int64 = int64(forceMoveOrResizeActionsToBeThisManyMSApart) * int64(time.Millisecond)
-
-	return (now - last) < thresholdNanos
+	return (now - last) < moveOrResizeApplyInterval.Load()
 }
 
 // MarkAsResizedNow marks it as "just started processing" — so, called early.
@@ -5854,7 +6628,11 @@ func MarkAsResizedNow() {
 	lastResizeUnixNano.Store(time.Now().UnixNano())
 }
 
-const forceMoveOrResizeActionsToBeThisManyMSApart = 16 // 16ms is 60fps, 10ms is 100fps
+// moveOrResizeApplyInterval forces move/resize actions to be at least
+// this far apart on the main thread (see ShouldThrottle). Nanoseconds;
+// 16ms (60fps) by default, 10ms would be 100fps. Persisted (see
+// persistedSettings).
+var moveOrResizeApplyInterval atomic.Int64
 
 func handleActualMoveOrResize(data WindowMoveData, bypassThrottle bool) {
 	//Top of handleActualMoveOrResize, before the rate-limit check (capture should be set even if we throttle the actual SetWindowPos):
@@ -5907,6 +6685,10 @@ func handleActualMoveOrResize(data WindowMoveData, bypassThrottle bool) {
 	// }()
 
 	target := data.Hwnd
+	if data.Flags&wincoe.SWP_NOZORDER == 0 {
+		// Send to back, its restore, pin/unpin, keep at bottom: see journal.go.
+		journalStacking(target)
+	}
 	// if resizing {
 	// 	//actually we could be done resizing and still get resize things or move things from the queue due to delays.
 	// 	//so this is no good to check.
@@ -6125,6 +6907,11 @@ func handleActualMoveOrResize(data WindowMoveData, bypassThrottle bool) {
 			abandonPendingZOrderAction(data)
 			return
 		}
+		if len(data.Followers) > 0 {
+			// Right behind the dragged window's own (likewise posted)
+			// move, so they land together; see owned.go.
+			moveFollowers(data.Followers, data.X, data.Y)
+		}
 		switch data.ZOrderAction {
 		case zOrderActionNone:
 			// Ordinary move or asynchronous resize.
@@ -6195,6 +6982,12 @@ func handleActualMoveOrResize(data WindowMoveData, bypassThrottle bool) {
 				)
 			}
 
+		case zOrderActionPin:
+			setPinned(target, true)
+
+		case zOrderActionUnpin:
+			setPinned(target, false)
+
 		default:
 			badprogramming(fmt.Sprintf(
 				"unknown WindowMoveData.ZOrderAction %d",
@@ -6204,6 +6997,47 @@ func handleActualMoveOrResize(data WindowMoveData, bypassThrottle bool) {
 	} //else
 } //func
 
+// applyZOrderChangeNow applies data, a one-shot Z-order change (pinning,
+// sinking, restoring a sent-to-back window), right away. It's the main
+// thread's counterpart of enqueueMoveOrResize, which stays the hook
+// thread's alone (see ringDoorbell): whatever the hook thread already
+// queued is applied first, drained the same way WM_DO_SETWINDOWPOS would
+// (see drainMoveChannelAsConfigured), so the change stays ordered after
+// any move of the same window still in flight, and it's never throttled
+// away. Main thread only.
+func applyZOrderChangeNow(data WindowMoveData) {
+	drainMoveChannelAsConfigured()
+	handleActualMoveOrResize(data, true)
+}
+
+// drainMoveChannelAsConfigured drains moveDataChan with
+// drainMoveChannelCoalesced or drainMoveChannel, per
+// coalesceMoveResizeEvents. Main thread only.
+func drainMoveChannelAsConfigured() {
+	if coalesceMoveResizeEvents.Load() {
+		drainMoveChannelCoalesced() // ← new coalescing version
+	} else {
+		drainMoveChannel() // Pull everything from the channel, sequentially
+	}
+}
+
+// bringToFrontWithoutActivating promotes target to HWND_TOP without
+// activating it: WM_BRING_TO_FRONT's work, also used directly by auto-raise
+// (see onAutoRaiseTimer), which already runs on the main thread. context is
+// only used in the failure log. A window kept at bottom (see
+// isKeptAtBottom) stays where it is.
+func bringToFrontWithoutActivating(target windows.Handle, context3 string) {
+	if isKeptAtBottom(target) {
+		return
+	}
+	journalStacking(target)
+	if res := wincoe.SetWindowPos(target, wincoe.HWND_TOP, 0, 0, 0, 0,
+		wincoe.SWP_NOMOVE|wincoe.SWP_NOSIZE|wincoe.SWP_NOACTIVATE,
+	); res.Failed() {
+		logf("%s: SetWindowPos(HWND_TOP) on HWND=0x%X failed: %v", context3, target, res.Err)
+	}
+}
+
 // makeLParam packs signed 16-bit x,y coordinates into a Win32 LPARAM (uintptr).
 // This ensures proper sign-extension to 64 bits on x64, matching MAKELPARAM / LPARAM semantics.
 // Handles negative coordinates (multi-monitor setups where monitors are left/above primary).
@@ -6266,17 +7100,17 @@ func wtsSessionChangeName(code uintptr) string {
 }
 
 var wndProc = windows.NewCallback(func(hwnd windows.Handle, msg uint32, wParam, lParam uintptr) uintptr {
+	if msg != 0 && msg == wmTaskbarCreated.Load() {
+		handleTaskbarCreated()
+		return 0
+	}
 	switch msg {
 	case WM_DO_SETWINDOWPOS:
 		// Reset the doorbell immediately so new incoming mouse events
 		// can queue a fresh wakeup call if they arrive while we are draining.
 		doorbellPending.Store(false)
 
-		if coalesceMoveResizeEvents.Load() {
-			drainMoveChannelCoalesced() // ← new coalescing version
-		} else {
-			drainMoveChannel() // Pull everything from the channel, sequentially
-		}
+		drainMoveChannelAsConfigured()
 		return 0 // Handled
 
 	case wincoe.WM_SETCURSOR:
@@ -6306,8 +7140,59 @@ var wndProc = windows.NewCallback(func(hwnd windows.Handle, msg uint32, wParam,
 			}
 			return 0
 		}
+		if handleTopologyTimer(wParam) {
+			return 0
+		}
+		if wParam == pinBadgeTimerID {
+			updatePinBadges()
+			return 0
+		}
+		if wParam == focusFollowsMouseTimerID {
+			onFocusFollowsMouseTimer(hwnd)
+			return 0
+		}
+		if wParam == autoRaiseTimerID {
+			onAutoRaiseTimer(hwnd)
+			return 0
+		}
+		if wParam == mruRestoreTimerID {
+			onMRURestoreTimer(hwnd)
+			return 0
+		}
+		if wParam == selectionTimerID {
+			updateSelectionHighlights()
+			return 0
+		}
+		if wParam == hotCornerTimerID {
+			onHotCornerTimer(hwnd)
+			return 0
+		}
+		if wParam == settingsWatchTimerID {
+			checkSettingsFile()
+			return 0
+		}
+		if wParam == profileSwitchTimerID {
+			onProfileSwitchTimer(hwnd)
+			return 0
+		}
+		if wParam == tilingRestoreTimerID {
+			onTilingRestoreTimer(hwnd)
+			return 0
+		}
 		return wincoe.DefWindowProc(hwnd, msg, wParam, lParam).R1
 
+	case WM_DISPLAYCHANGE:
+		handleDisplayChange()
+		return wincoe.DefWindowProc(hwnd, msg, wParam, lParam).R1
+
+	case WM_DEVICECHANGE:
+		handleMonitorDeviceChange(wParam, lParam)
+		return wincoe.DefWindowProc(hwnd, msg, wParam, lParam).R1
+
+	case WM_HOTKEY:
+		handleHotkey(wParam)
+		return 0
+
 	case WM_HIDE_OVERLAY:
 		hideOverlay()
 		return 0
@@ -6320,11 +7205,7 @@ var wndProc = windows.NewCallback(func(hwnd windows.Handle, msg uint32, wParam,
 			return 0
 		}
 
-		if res := wincoe.SetWindowPos(target, wincoe.HWND_TOP, 0, 0, 0, 0,
-			wincoe.SWP_NOMOVE|wincoe.SWP_NOSIZE|wincoe.SWP_NOACTIVATE,
-		); res.Failed() {
-			logf("WM_BRING_TO_FRONT: SetWindowPos(HWND_TOP) on HWND=0x%X failed: %v", target, res.Err)
-		}
+		bringToFrontWithoutActivating(target, "WM_BRING_TO_FRONT")
 		return 0
 
 	// case WM_DO_SET_CAPTURE:
@@ -6379,6 +7260,38 @@ var wndProc = windows.NewCallback(func(hwnd windows.Handle, msg uint32, wParam,
 		cancelActiveGesture(session)
 		return 0
 
+	case WM_HOVER_MOVED:
+		handleHoverMoved(hwnd)
+		return 0
+
+	case WM_MRU_FOREGROUND_LOST:
+		handleMRUForegroundLost(hwnd)
+		return 0
+
+	case WM_SELECTION_BAND:
+		handleSelectionBand()
+		return 0
+
+	case WM_SWAP_TARGET:
+		handleSwapTarget()
+		return 0
+
+	case WM_HOT_CORNER:
+		handleHotCorner(hwnd)
+		return 0
+
+	case WM_PROFILE_FOREGROUND:
+		handleProfileForeground()
+		return 0
+
+	case WM_TRAY_WINDOW_ICON:
+		handleTrayWindowIconMessage(wParam, lParam)
+		return 0
+
+	case WM_TRAY_WINDOW_DESTROYED:
+		handleTrayWindowDestroyed(windows.Handle(wParam))
+		return 0
+
 	case WM_APPLY_GESTURE_CURSOR:
 		// Posted by postApplyGestureCursorStart -- the actual
 		// SetSystemCursor/CopyIcon/SetCursor/SetTimer work must happen
@@ -6495,7 +7408,7 @@ var wndProc = windows.NewCallback(func(hwnd windows.Handle, msg uint32, wParam,
 			// resetStaleGestureFlags's doc comment (shared with the
 			// higher-integrity-foreground case in winEventProc).
 			resetStaleGestureFlags()
-			clearSentToBackStack()
+			sentToBackStackWinkeyReleased()
 			if session := activeSession.Load(); session != nil {
 				logf("WTS session %s detected mid-%v; discarding stale drag/resize session for HWND=0x%X", wtsSessionChangeName(wParam), session.mode, session.targetWnd)
 				softReset(true)
@@ -6686,6 +7599,9 @@ var wndProc = windows.NewCallback(func(hwnd windows.Handle, msg uint32, wParam,
 					MENU_TOGGLE_UNFOCUS_SENT_TO_BACK, unfocusSentToBackText)
 			}
 
+			traySentToBack := sentToBackMenuEntries()
+			defer deleteMenuBitmaps(appendSentToBackMenuItems(hMenu, traySentToBack))
+
 			{
 				var useThreadAttachInputForFocusFlags uint32 = wincoe.MF_STRING
 				if useThreadAttachInputForFocus.Load() {
@@ -6768,11 +7684,45 @@ var wndProc = windows.NewCallback(func(hwnd windows.Handle, msg uint32, wParam,
 				if snapToEdgesEnabled.Load() {
 					snapFlags |= wincoe.MF_CHECKED
 				}
-				snapText := fmt.Sprintf("Snap window edges to the monitor's work-area edges (within %dpx) while moving/resizing", snapToEdgesThresholdPx)
+				snapText := fmt.Sprintf("Snap window edges to the monitor's work-area edges (within %dpx) while moving/resizing", snapThresholdPx.Load())
 				appendMenuChecked(hMenu, snapFlags,
 					MENU_TOGGLE_SNAP_TO_EDGES, snapText)
 			}
 
+			{
+				// The snap refinements below only matter while snapping
+				// itself is on, so they're grayed out (but keep showing
+				// their state) otherwise.
+				var snapDependentFlags uint32
+				if !snapToEdgesEnabled.Load() {
+					snapDependentFlags = wincoe.MF_DISABLED | wincoe.MF_GRAYED
+				}
+
+				var centerFlags uint32 = wincoe.MF_STRING | snapDependentFlags
+				if snapToCenterLinesEnabled.Load() {
+					centerFlags |= wincoe.MF_CHECKED
+				}
+				appendMenuChecked(hMenu, centerFlags,
+					MENU_TOGGLE_SNAP_TO_CENTER_LINES, "    Also snap to the work area's center lines (and a moved window's own center onto them)")
+
+				var thirdsFlags uint32 = wincoe.MF_STRING | snapDependentFlags
+				if snapToThirdsEnabled.Load() {
+					thirdsFlags |= wincoe.MF_CHECKED
+				}
+				appendMenuChecked(hMenu, thirdsFlags,
+					MENU_TOGGLE_SNAP_TO_THIRDS, "    Also snap to the work area's 1/3 and 2/3 lines")
+
+				appendInt32PresetSubmenu(hMenu, "    Snap threshold", snapThresholdPxPresets, snapThresholdPx.Load(),
+					MENU_SNAP_THRESHOLD_BASE, func(v int32) string { return fmt.Sprintf("%dpx", v) })
+				appendInt32PresetSubmenu(hMenu, "    Snap outer gap (margin kept from the work-area edges)", snapOuterGapPxPresets, snapOuterGapPx.Load(),
+					MENU_SNAP_GAP_BASE, func(v int32) string {
+						if v == 0 {
+							return "none (flush)"
+						}
+						return fmt.Sprintf("%dpx", v)
+					})
+			}
+
 			{
 				var immediateOverlayRepaintFlags uint32 = wincoe.MF_STRING
 				if immediateOverlayRepaint.Load() {
@@ -6886,6 +7836,29 @@ var wndProc = windows.NewCallback(func(hwnd windows.Handle, msg uint32, wParam,
 					MENU_TOGGLE_DISABLE_FILE_LOGGING, noFileLogText)
 			}
 
+			// Read once per menu popup, and reused by the command switch
+			// below so layout/unpin IDs map back to exactly what was shown.
+			trayLayoutNames := layoutNames()
+			trayPinned := pinnedHwnds()
+			trayProfiles := profileNames()
+			trayKeptAtBottom := keptAtBottomHwnds()
+			appendLayoutsSubmenu(hMenu, trayLayoutNames)
+			appendRescueMenuItems(hMenu)
+			appendTilingMenuItems(hMenu)
+			appendSelectionMenu(hMenu)
+			appendPinnedMenu(hMenu, trayPinned)
+			appendKeptAtBottomMenu(hMenu, trayKeptAtBottom)
+			appendFocusFollowsMouseMenuItems(hMenu)
+			appendAutoRaiseMenuItems(hMenu)
+			appendHotCornersMenu(hMenu)
+			appendMRUFocusMenuItems(hMenu)
+			appendRaiseAppMenuItems(hMenu)
+			appendOwnedWindowsMenuItems(hMenu)
+			appendJournalMenuItems(hMenu)
+			appendProfilesMenu(hMenu, trayProfiles)
+			appendMenuChecked(hMenu, wincoe.MF_STRING, MENU_RELOAD_RULES,
+				fmt.Sprintf("Reload per-application rules from %s (%d in force)", rulesFilePath(), activeRules.Load().Len()))
+
 			{
 				// Read-only diagnostic row, grayed/disabled so it can never
 				// be "selected" -- it's informational only. Recomputed
@@ -7086,13 +8059,50 @@ var wndProc = windows.NewCallback(func(hwnd windows.Handle, msg uint32, wParam,
 			case MENU_TOGGLE_DISABLE_FILE_LOGGING:
 				toggleAndPersist(&disableFileLogging)
 
+			case MENU_TOGGLE_SNAP_TO_CENTER_LINES:
+				toggleAndPersist(&snapToCenterLinesEnabled)
+
+			case MENU_TOGGLE_SNAP_TO_THIRDS:
+				toggleAndPersist(&snapToThirdsEnabled)
+
+			case MENU_RELOAD_RULES:
+				reloadRulesAndNotify()
+
 			case MENU_EXIT:
 				//procUnhookWindowsHookEx.Call(uintptr(mouseHook))
 				exit(0)
+
+			default:
+				switch {
+				case handleLayoutsMenuCommand(cmd, trayLayoutNames):
+				case handleRescueMenuCommand(cmd):
+				case handleTilingMenuCommand(cmd):
+				case handleSelectionMenuCommand(cmd):
+				case handlePinMenuCommand(cmd, trayPinned):
+				case handleKeptAtBottomMenuCommand(cmd, trayKeptAtBottom):
+				case handleFocusFollowsMouseMenuCommand(cmd):
+				case handleAutoRaiseMenuCommand(cmd):
+				case handleHotCornersMenuCommand(cmd):
+				case handleMRUFocusMenuCommand(cmd):
+				case handleSentToBackMenuCommand(cmd, traySentToBack):
+				case handleRaiseAppMenuCommand(cmd):
+				case handleOwnedWindowsMenuCommand(cmd):
+				case handleJournalMenuCommand(cmd):
+				case handleProfilesMenuCommand(cmd, trayProfiles):
+				case cmd >= MENU_SNAP_THRESHOLD_BASE && int(cmd) < MENU_SNAP_THRESHOLD_BASE+len(snapThresholdPxPresets):
+					setInt32AndPersist(&snapThresholdPx, snapThresholdPxPresets[cmd-MENU_SNAP_THRESHOLD_BASE])
+				case cmd >= MENU_SNAP_GAP_BASE && int(cmd) < MENU_SNAP_GAP_BASE+len(snapOuterGapPxPresets):
+					setInt32AndPersist(&snapOuterGapPx, snapOuterGapPxPresets[cmd-MENU_SNAP_GAP_BASE])
+				}
 			}
 		} // fi RMB context menu
 		return 0
 
+	case WM_COPYDATA:
+		// A remote command forwarded by a second invocation of the exe
+		// (see forwardRemoteCommandIfRequested).
+		return handleRemoteCommandCopyData(lParam)
+
 	case wincoe.WM_CLOSE:
 		//exit(0)
 		//WM_CLOSE → DestroyWindow() → WM_DESTROY → PostQuitMessage() -> getmessage() -> break loop -> outside of loop continuation...
@@ -7161,12 +8171,18 @@ func deinit() {
 		}
 	}
 
+	deinitTrayWindows()
+	deinitJournal() // after the hidden-to-tray windows are back, before the pin badges and shades go
 	cleanupTray()
 
 	//yeah this has to be after NIM_DELETE aka cleanupTray(), according to Gemini 3 Thinking
 	deinitMainMsgHwnd()
 
 	deinitOverlayClass()
+	deinitPinBadges()
+	deinitSwap()
+	deinitSelection()
+	deinitShading()
 
 	// NOTE: deinit() runs from primary_defer(), which executes AFTER
 	// runApplication()'s own `for { GetMessage(); ... }` loop has already
@@ -7779,6 +8795,7 @@ func keyboardProc(nCode int32, wParam uintptr, lParam unsafe.Pointer) uintptr {
 
 	// Key DOWN
 	if wParam == wincoe.WM_KEYDOWN || wParam == wincoe.WM_SYSKEYDOWN {
+		noteUserPressForMRU()
 		if vk == wincoe.VK_ESCAPE && tryCancelActiveGestureViaEsc() {
 			// Swallow ESC entirely: the target window under an in-progress
 			// winkey+LMB/RMB gesture never saw the original button-down (it
@@ -7796,6 +8813,7 @@ func keyboardProc(nCode int32, wParam uintptr, lParam unsafe.Pointer) uintptr {
 			// generic VK_SHIFT for a physical Shift press -- react to
 			// whichever one actually arrives rather than assuming one.
 			postShiftMirrorToggleIfNeeded(true)
+			noteSwapModifier(true)
 		}
 	}
 
@@ -7803,10 +8821,11 @@ func keyboardProc(nCode int32, wParam uintptr, lParam unsafe.Pointer) uintptr {
 	if wParam == wincoe.WM_KEYUP || wParam == wincoe.WM_SYSKEYUP {
 		if vk == wincoe.VK_SHIFT || vk == wincoe.VK_LSHIFT || vk == wincoe.VK_RSHIFT {
 			postShiftMirrorToggleIfNeeded(false)
+			noteSwapModifier(false)
 		}
 		switch vk {
 		case wincoe.VK_LWIN, wincoe.VK_RWIN:
-			clearSentToBackStack()
+			sentToBackStackWinkeyReleased()
 			//Do not clear focusedSentToBackHwnd here. That marker must survive Win-key release because ordinary-click restoration occurs later, after the user releases Win and clicks the backgrounded window.
 
 			//logf("winUP")
@@ -8164,9 +9183,38 @@ func init() {
 
 	injectButtonUpOnMissedGestureRecovery.Store(false) // default off, see doc comment on the var
 
-	bypassGesturesWhenFullscreen.Store(false) // default off; opt-in
-	snapToEdgesEnabled.Store(true)            // default on actually
-	disableFileLogging.Store(false)           // default off; file logging stays on unless explicitly disabled via -nolog/--nolog or systray
+	bypassGesturesWhenFullscreen.Store(false)    // default off; opt-in
+	snapToEdgesEnabled.Store(true)               // default on actually
+	snapThresholdPx.Store(12)                    // see doc comment on the var
+	snapOuterGapPx.Store(0)                      // default flush against the work-area edges
+	snapToCenterLinesEnabled.Store(false)        // default off; opt-in
+	snapToThirdsEnabled.Store(false)             // default off; opt-in
+	perTopologyLayoutsEnabled.Store(false)       // default off; opt-in, see its doc comment
+	rescueWindowsAfterDisplayChange.Store(false) // default off; opt-in
+	tilingInnerGapPx.Store(0)                    // default tiles flush against each other
+	tilingOuterGapPx.Store(0)                    // default tiles flush against the work-area edges
+	focusFollowsMouse.Store(false)               // default off; opt-in
+	focusFollowsMouseRaises.Store(true)          // default on, like a click
+	focusFollowsMouseDelayMs.Store(400)          // long enough to cross a window on the way somewhere else
+	autoRaiseOnHover.Store(false)                // default off; opt-in
+	autoRaiseDelayMs.Store(500)                  // see autoRaiseDelayMsPresets
+	restoreFocusToMRUOnClose.Store(false)        // default off; opt-in
+	keepSentToBackStack.Store(false)             // default off; the stack lasts while the winkey is held
+	raiseAppRestoresMinimized.Store(false)       // default off; minimized windows stay minimized
+	moveOwnedWindowsWithOwner.Store(false)       // default off; opt-in
+	hotCornerDelayMs.Store(250)                  // hot corners themselves default to no action
+	sessionJournalEnabled.Store(false)           // default off; opt-in
+	revertJournalOnExit.Store(false)             // default off; reverting is a tray action unless asked
+	autoSwitchProfiles.Store(true)               // default on; only matters once a profile lists executables
+	disableFileLogging.Store(false)              // default off; file logging stays on unless explicitly disabled via -nolog/--nolog or systray
+
+	// Tuning values; see their doc comments.
+	rulesFile.Store(new(selfName + "_rules.ini"))
+	moveRateLimitInterval.Store(int64(33 * time.Millisecond))
+	moveOrResizeApplyInterval.Store(int64(16 * time.Millisecond))
+	gestureCursorInterval.Store(int64(16 * time.Millisecond))
+	minResizeWindowPx.Store(32)
+	sentToBackStackDepth.Store(64)
 
 	shiftMirrorResizeEnabled.Store(!isEffectivelyVirtualized()) // default off under a detected (and detection-enabled) hypervisor guest; see its own doc comment
 	allowShiftHeldBeforeResizeGesture.Store(true)               // default on; shift+winkey+RMB starts resize with Shift effect applied
@@ -8436,8 +9484,8 @@ loggingLoop:
 	}
 	maxMoveEvents := maxChannelFillForMoveEvents.Load()
 	if maxMoveEvents > 1 {
-		directLoggerf("Most move/resize events queued: %s (Dropped: %s which were <%dms apart, to prevent mouse stuttering)",
-			withCommas(maxMoveEvents), withCommas(droppedMoveOrResizeEvents.Load()), forceMoveOrResizeActionsToBeThisManyMSApart)
+		directLoggerf("Most move/resize events queued: %s (Dropped: %s which were <%v apart, to prevent mouse stuttering)",
+			withCommas(maxMoveEvents), withCommas(droppedMoveOrResizeEvents.Load()), time.Duration(moveOrResizeApplyInterval.Load()))
 		//logf("for testing when a panic in logWorker happens after main's keypress, right before main's os.Exit!")
 	}
 	if moveCasFailures := moveChannelCASFailures.Load(); moveCasFailures > 0 {
@@ -8676,6 +9724,11 @@ func main() {
 	runtime.LockOSThread() // first! in main() not in init() ! That runtime.LockOSThread() call in main is there because of a specific Windows requirement: Hooks and Message Loops are thread-bound.
 	token := theILockedMainThreadToken{}
 
+	// A "-cmd ..." invocation only forwards a command to the already
+	// running instance and exits; it must not log, take the
+	// single-instance mutex, or do anything else below.
+	forwardRemoteCommandIfRequested()
+
 	// Must run before any logf()/directLoggerf() call can possibly reach
 	// internalLogger's lazy initLogFile() fallback -- see
 	// parseDisableFileLoggingCmdlineFlag's own doc comment.
@@ -8816,7 +9869,9 @@ func runApplication(_token theILockedMainThreadToken) error { //XXX: must be cal
 	initWincoeLogging() // ← must be before any wincoe calls
 
 	settingsFileWriter.CheckPowerLossFile(settingsFilePath)
+	loadProfiles() // before loadSettings, which validates its "profile" against them
 	loadSettings()
+	loadRules()
 
 	// Capture the actual terminal/console window that launched us
 	//resFg := procGetForegroundWindow.Call()
@@ -8867,10 +9922,15 @@ func runApplication(_token theILockedMainThreadToken) error { //XXX: must be cal
 		return fmt.Errorf("failed to create message window: %w", err3)
 	}
 	storeMainMsgHwnd(hwnd)
+	allowRemoteCommandsThroughUIPI(hwnd)
+	registerTaskbarCreated(hwnd)
 
 	if err4 := initTray(); err4 != nil {
 		return fmt.Errorf("failed to init tray: %w", err4)
 	}
+	restoreOrphanedTrayWindows()
+	applyKeepAtBottomRules()
+	refreshHotCornerMonitors()
 
 	// if res := procWTSRegisterSessionNotification.Call(uintptr(mainMsgHwnd), NOTIFY_FOR_THIS_SESSION); res.Failed() {
 	if res := wincoe.WTSRegisterSessionNotification(hwnd, wincoe.NOTIFY_FOR_THIS_SESSION); res.Failed() {
@@ -8884,6 +9944,11 @@ func runApplication(_token theILockedMainThreadToken) error { //XXX: must be cal
 		}()
 	}
 
+	defer registerMonitorDeviceNotifications(hwnd)()
+	defer registerGlobalHotkeys(hwnd)()
+	startTopologyTracking(hwnd)
+	startSettingsWatch(hwnd)
+
 	go hookWorker()
 
 	// shellH, _, err := procSetWindowsHookEx.Call(
@@ -9556,6 +10621,9 @@ func winEventProc(hWinEventHook windows.Handle, event uint32, hwnd windows.Handl
 	switch event {
 	case wincoe.EVENT_SYSTEM_FOREGROUND: //0x0003:
 		eventName = "EVENT_SYSTEM_FOREGROUND"
+		noteForegroundForMRU(hwnd)
+		noteKeepAtBottomPromoted(hwnd)
+		noteForegroundForProfiles(hwnd)
 	case wincoe.EVENT_SYSTEM_CAPTURESTART: //0x0008:
 		eventName = "EVENT_SYSTEM_CAPTURESTART"
 		// fg := getForegroundWindow()
@@ -9585,10 +10653,25 @@ func winEventProc(hWinEventHook windows.Handle, event uint32, hwnd windows.Handl
 	case wincoe.EVENT_OBJECT_DESTROY: //0x8001:
 		eventName = "EVENT_OBJECT_DESTROY"
 		untrackedEvent = true
+		if idChild == 0 { // CHILDID_SELF: the window itself, not one of its accessible children
+			forgetGeometryHistory(hwnd) // a recycled HWND value must not inherit this window's undo history
+			forgetShade(hwnd)
+			noteTrayWindowDestroyed(hwnd)
+			noteWindowGoneForMRU(hwnd)
+			forgetKeepAtBottom(hwnd)
+			forgetPlacementToggle(hwnd)
+			forgetJournal(hwnd)
+		}
 	case wincoe.EVENT_OBJECT_SHOW: //0x8002:
 		eventName = "EVENT_OBJECT_SHOW"
+		if idChild == 0 {
+			noteKeepAtBottomPromoted(hwnd)
+		}
 	case wincoe.EVENT_OBJECT_HIDE: // 0x8003:
 		eventName = "EVENT_OBJECT_HIDE"
+		if idChild == 0 {
+			noteWindowGoneForMRU(hwnd)
+		}
 	case wincoe.EVENT_OBJECT_REORDER: //0x8004:
 		eventName = "EVENT_OBJECT_REORDER"
 	case wincoe.EVENT_OBJECT_FOCUS: // 0x8005:
@@ -9748,7 +10831,7 @@ func winEventProc(hWinEventHook windows.Handle, event uint32, hwnd windows.Handl
 				// very foreground shift was itself caused by an in-flight
 				// gesture. See resetStaleGestureFlags's doc comment.
 				resetStaleGestureFlags()
-				clearSentToBackStack()
+				sentToBackStackWinkeyReleased()
 			}
 		} else {
 			if shouldLogFocusChanges {
@@ -10228,10 +11311,6 @@ func GetVersion() string {
 //
 // Returns true only when a remembered window was successfully restored.
 func tryBringForegroundToFrontAt(pt wincoe.POINT) bool {
-	if !bringToFrontOnBackgroundClick.Load() {
-		return false
-	}
-
 	target := windows.Handle(focusedSentToBackHwnd.Load())
 	if target == 0 {
 		return false
@@ -10242,6 +11321,12 @@ func tryBringForegroundToFrontAt(pt wincoe.POINT) bool {
 		return false
 	}
 
+	// Checked per target rather than up front so a rule can turn this on
+	// (or off) for one application regardless of the global toggle.
+	if ov := windowRulesFor(target); ov.Ignored() || !ruleBringToFrontOnBackgroundClick.in(ov) {
+		return false
+	}
+
 	fg := getForegroundWindow()
 	if fg != target {
 		// The exceptional focused-but-backgrounded state ended naturally.
@@ -10308,13 +11393,14 @@ func pushSentToBackWindow(hwnd windows.Handle) {
 	sentToBackStackMu.Lock()
 	defer sentToBackStackMu.Unlock()
 
-	if len(sentToBackStack) >= maxSentToBackStackDepth {
+	// A loop, not an if: the cap may have been lowered since the last push.
+	for depth := int(sentToBackStackDepth.Load()); len(sentToBackStack) >= depth; {
 		copy(sentToBackStack, sentToBackStack[1:])
 		sentToBackStack = sentToBackStack[:len(sentToBackStack)-1]
 
 		logf(
 			"pushSentToBackWindow: stack reached cap %d; dropped oldest entry",
-			maxSentToBackStackDepth,
+			depth,
 		)
 	}
 
@@ -10357,6 +11443,35 @@ func reserveSentToBackWindow() (id uint64, hwnd windows.Handle, ok bool) {
 	return 0, 0, false
 }
 
+// reserveSentToBackEntry reserves the entry with the given id, like
+// reserveSentToBackWindow does for the newest one, for a restore picked
+// from the tray's "Sent to back" submenu.
+func reserveSentToBackEntry(id uint64) (hwnd windows.Handle, ok bool) {
+	sentToBackStackMu.Lock()
+	defer sentToBackStackMu.Unlock()
+
+	for i := range sentToBackStack {
+		entry := &sentToBackStack[i]
+		if entry.id != id {
+			continue
+		}
+		if entry.restoring {
+			return 0, false
+		}
+		if !wincoe.IsWindow(entry.hwnd) {
+			sentToBackStack = append(
+				sentToBackStack[:i],
+				sentToBackStack[i+1:]...,
+			)
+			return 0, false
+		}
+		entry.restoring = true
+		return entry.hwnd, true
+	}
+
+	return 0, false
+}
+
 // releaseSentToBackReservation makes an unsuccessful reserved restore
 // available for a later Win+Shift+MMB attempt.
 func releaseSentToBackReservation(id uint64) {
@@ -0,0 +1,281 @@
+//go:build windows && amd64
+
+package main
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/workturnedplay/wincoe"
+)
+
+/* ---------------- MRU focus restoration ---------------- */
+
+// When the foreground window closes (or hides itself, e.g. to the tray),
+// Windows is supposed to hand focus back to the window that had it before.
+// Windows 11 regularly doesn't: it picks some other, older window instead
+// (see todo.txt -- reproducible without winbollocks running at all). So
+// winbollocks keeps its own most-recently-used order of foreground windows,
+// from the EVENT_SYSTEM_FOREGROUND WinEvents winEventProc already receives,
+// and when the foreground window goes away it activates the most recent
+// window that's still around and focusable, overriding Windows' pick if
+// that disagrees -- unless the user activated Windows' pick themselves
+// after the window went away (a click or key press since then).
+//
+// winEventProc is an out-of-context WinEvent hook, so it runs on the main
+// thread; it only records, and the decision is made on a timer
+// (WM_MRU_FOREGROUND_LOST, then mruRestoreTimerID once Windows has had
+// mruRestoreSettleMs to make its own pick). The mouse and keyboard hooks
+// only note when the user last pressed something (noteUserPressForMRU).
+
+// restoreFocusToMRUOnClose turns the feature on. Off by default: it
+// overrides a decision Windows makes, which some users may prefer left
+// alone. The MRU order is only tracked while it's on, so it starts empty
+// when turned on. Persisted (see persistedSettings).
+var restoreFocusToMRUOnClose atomic.Bool
+
+// maxMRUWindows bounds mruWindows; the least recently used fall off.
+const maxMRUWindows = 64
+
+// mruRestoreSettleMs is how long after the foreground window went away the
+// MRU pick is made: long enough for Windows to have activated whatever it
+// chose, so that can be compared (and overridden) rather than raced.
+const mruRestoreSettleMs = 100
+
+// mruHandoffWindow is how recently the MRU head must have become foreground
+// for the window behind it going away to still count as "the foreground
+// window went away": the WinEvent for Windows' replacement pick can arrive
+// before the one for the window's hide/destroy.
+const mruHandoffWindow = 250 * time.Millisecond
+
+// mruEntry is one mruWindows entry.
+type mruEntry struct {
+	hwnd      windows.Handle
+	activated time.Time
+}
+
+// mruRestore is a pending restoration: the window that went away, when,
+// and the windows to try instead, most recent first.
+type mruRestore struct {
+	lost       windows.Handle
+	lostAt     time.Time
+	candidates []windows.Handle
+}
+
+// mruWindows (most recent first) and mruPending are only touched on the
+// main thread (winEventProc, the restore timer and the tray). The mutex is
+// kept anyway, the same defensive habit as winEventProc's atomic counters.
+var (
+	mruMu      sync.Mutex
+	mruWindows []mruEntry
+	mruPending *mruRestore
+)
+
+// lastUserPressUnixNano is when the user last pressed a mouse button or a
+// key, from the hook thread; 0 until then. Only kept while the feature is
+// on.
+var lastUserPressUnixNano atomic.Int64
+
+// noteUserPressForMRU is the mouse and keyboard hooks' button- and
+// key-down hook. Hook thread.
+func noteUserPressForMRU() {
+	if restoreFocusToMRUOnClose.Load() {
+		lastUserPressUnixNano.Store(time.Now().UnixNano())
+	}
+}
+
+// noteForegroundForMRU is winEventProc's EVENT_SYSTEM_FOREGROUND hook:
+// move hwnd to the front of the MRU order.
+func noteForegroundForMRU(hwnd windows.Handle) {
+	if !restoreFocusToMRUOnClose.Load() || hwnd == 0 || isOwnWindow(hwnd) {
+		return
+	}
+	e := mruEntry{hwnd: hwnd, activated: time.Now()}
+	mruMu.Lock()
+	defer mruMu.Unlock()
+	if i := mruIndexLocked(hwnd); i >= 0 {
+		mruWindows = slices.Delete(mruWindows, i, i+1)
+	}
+	if len(mruWindows) >= maxMRUWindows {
+		mruWindows = mruWindows[:maxMRUWindows-1]
+	}
+	mruWindows = slices.Insert(mruWindows, 0, e)
+}
+
+// mruIndexLocked returns hwnd's index in mruWindows, or -1. mruMu held.
+func mruIndexLocked(hwnd windows.Handle) int {
+	return slices.IndexFunc(mruWindows, func(e mruEntry) bool { return e.hwnd == hwnd })
+}
+
+// noteWindowGoneForMRU is winEventProc's EVENT_OBJECT_HIDE and
+// EVENT_OBJECT_DESTROY hook: drop hwnd from the MRU order, and if it was
+// the foreground window, have the main thread restore focus to the MRU
+// window behind it.
+func noteWindowGoneForMRU(hwnd windows.Handle) {
+	if !restoreFocusToMRUOnClose.Load() {
+		return
+	}
+	mruMu.Lock()
+	i := mruIndexLocked(hwnd)
+	if i < 0 {
+		mruMu.Unlock()
+		return
+	}
+	wasForeground := i == 0 || (i == 1 && time.Since(mruWindows[0].activated) < mruHandoffWindow)
+	var candidates []windows.Handle
+	if wasForeground {
+		candidates = mruCandidatesLocked(i)
+	}
+	mruWindows = slices.Delete(mruWindows, i, i+1)
+	if wasForeground {
+		mruPending = &mruRestore{lost: hwnd, lostAt: time.Now(), candidates: candidates}
+	}
+	mruMu.Unlock()
+	if !wasForeground {
+		return
+	}
+	main := loadMainMsgHwnd()
+	if main == 0 {
+		return
+	}
+	if res := wincoe.PostMessage(main, WM_MRU_FOREGROUND_LOST, uintptr(hwnd), 0); res.Failed() {
+		logf("noteWindowGoneForMRU: PostMessage failed for HWND=0x%X: %v; leaving focus to Windows", hwnd, res.Err)
+	}
+}
+
+// mruCandidatesLocked returns the MRU order without mruWindows[lost]. If
+// lost is 1, the head is whatever became foreground just before the window
+// went away; it's left out too, being foreground already. mruMu held.
+func mruCandidatesLocked(lost int) []windows.Handle {
+	candidates := make([]windows.Handle, 0, len(mruWindows))
+	for i, e := range mruWindows {
+		if i != lost && !(lost == 1 && i == 0) {
+			candidates = append(candidates, e.hwnd)
+		}
+	}
+	return candidates
+}
+
+// handleMRUForegroundLost is wndProc's WM_MRU_FOREGROUND_LOST handler: give
+// Windows mruRestoreSettleMs to make its pick before onMRURestoreTimer
+// checks it.
+func handleMRUForegroundLost(hwnd windows.Handle) {
+	if _, res := wincoe.SetTimer(hwnd, mruRestoreTimerID, mruRestoreSettleMs, 0); res.Failed() {
+		logf("handleMRUForegroundLost: SetTimer failed: %v", res.Err)
+	}
+}
+
+// mruEligible reports whether hwnd can take focus back: still a window,
+// shown, not minimized, and nothing shouldSkipFocusingIt or a rule rejects.
+func mruEligible(hwnd windows.Handle) bool {
+	if !isWindowValid(hwnd) || !wincoe.IsWindowVisible(hwnd) || isWindowCloaked(hwnd) || isMinimized(hwnd) {
+		return false
+	}
+	if skip, _ := shouldSkipFocusingIt(hwnd); skip {
+		return false
+	}
+	return !windowRulesFor(hwnd).Ignored()
+}
+
+// mruRestorePick returns the first eligible candidate, and whether to
+// activate it instead of fg, the window Windows picked: whenever they
+// differ, unless userActivated (the user brought fg up themselves after
+// the window went away).
+func mruRestorePick(candidates []windows.Handle, eligible func(windows.Handle) bool, fg windows.Handle, userActivated bool) (want windows.Handle, override bool) {
+	for _, c := range candidates {
+		if eligible(c) {
+			want = c
+			break
+		}
+	}
+	return want, want != 0 && want != fg && !userActivated
+}
+
+// onMRURestoreTimer makes the pending restoration's pick and activates it
+// if Windows picked something else: nothing, the desktop or taskbar, or
+// an older window (see mruRestorePick).
+func onMRURestoreTimer(hwnd windows.Handle) {
+	if res := wincoe.KillTimer(hwnd, mruRestoreTimerID); res.Failed() {
+		logf("onMRURestoreTimer: KillTimer failed: %v", res.Err)
+	}
+	mruMu.Lock()
+	pending := mruPending
+	mruPending = nil
+	mruMu.Unlock()
+	if pending == nil || !restoreFocusToMRUOnClose.Load() || activeSession.Load() != nil {
+		return
+	}
+
+	fg := getForegroundWindow()
+	userActivated := fg != 0 && lastUserPressUnixNano.Load() > pending.lostAt.UnixNano()
+	want, override := mruRestorePick(pending.candidates, mruEligible, fg, userActivated)
+	switch {
+	case override:
+	case want == 0:
+		logf("onMRURestoreTimer: HWND=0x%X went away; no eligible MRU window left, leaving Windows' pick HWND=0x%X", pending.lost, fg)
+		return
+	case want == fg:
+		logf("onMRURestoreTimer: HWND=0x%X went away; Windows picked the MRU window HWND=0x%X %q too", pending.lost, fg, getWindowTextFast(fg))
+		return
+	default:
+		logf("onMRURestoreTimer: HWND=0x%X went away; the user activated HWND=0x%X %q since, leaving it", pending.lost, fg, getWindowTextFast(fg))
+		return
+	}
+	logf("onMRURestoreTimer: HWND=0x%X went away; Windows picked HWND=0x%X %q, restoring MRU window HWND=0x%X %q instead",
+		pending.lost, fg, getWindowTextFast(fg), want, getWindowTextFast(want))
+	if !forceForeground(want) {
+		logf("onMRURestoreTimer: couldn't activate HWND=0x%X", want)
+	}
+}
+
+// isShellBackground reports whether hwnd is the desktop or a taskbar, which
+// Windows falls back to activating when it finds nothing better.
+func isShellBackground(hwnd windows.Handle) bool {
+	class, res := wincoe.GetClassName(hwnd)
+	if res.Failed() {
+		return false
+	}
+	switch class {
+	case "Progman", "WorkerW", "Shell_TrayWnd", "Shell_SecondaryTrayWnd":
+		return true
+	}
+	return false
+}
+
+// appendMRUFocusMenuItems appends the tray's MRU focus restoration toggle
+// to hMenu.
+func appendMRUFocusMenuItems(hMenu windows.Handle) {
+	var flags uint32 = wincoe.MF_STRING
+	if restoreFocusToMRUOnClose.Load() {
+		flags |= wincoe.MF_CHECKED
+	}
+	appendMenuChecked(hMenu, flags, MENU_TOGGLE_MRU_FOCUS_RESTORE,
+		"When the focused window closes, focus the window that had focus before it")
+}
+
+// mruFocusToggled follows restoreFocusToMRUOnClose being turned on or
+// off, from the tray or the settings file. Turning the feature off drops
+// the MRU order, so turning it back on never acts on a stale one.
+func mruFocusToggled() {
+	if !restoreFocusToMRUOnClose.Load() {
+		mruMu.Lock()
+		mruWindows = nil
+		mruPending = nil
+		mruMu.Unlock()
+	}
+}
+
+// handleMRUFocusMenuCommand runs the tray command produced by
+// appendMRUFocusMenuItems, reporting whether cmd was one.
+func handleMRUFocusMenuCommand(cmd uint32) bool {
+	if cmd != MENU_TOGGLE_MRU_FOCUS_RESTORE {
+		return false
+	}
+	toggleAndPersist(&restoreFocusToMRUOnClose)
+	mruFocusToggled()
+	return true
+}
@@ -0,0 +1,40 @@
+//go:build windows && amd64
+
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestMRURestorePick(t *testing.T) {
+	const a, b, c, desktop windows.Handle = 0xA, 0xB, 0xC, 0xD
+	gone := map[windows.Handle]bool{b: true}
+	eligible := func(w windows.Handle) bool { return !gone[w] }
+	tests := []struct {
+		name          string
+		candidates    []windows.Handle
+		fg            windows.Handle
+		userActivated bool
+		want          windows.Handle
+		override      bool
+	}{
+		{"Windows picked an older window", []windows.Handle{a, c}, c, false, a, true},
+		{"Windows picked the MRU window", []windows.Handle{a, c}, a, false, a, false},
+		{"Windows picked the desktop", []windows.Handle{a, c}, desktop, false, a, true},
+		{"Windows picked nothing", []windows.Handle{a, c}, 0, false, a, true},
+		{"user activated an older window", []windows.Handle{a, c}, c, true, a, false},
+		{"ineligible head is skipped", []windows.Handle{b, a, c}, c, false, a, true},
+		{"no eligible candidate", []windows.Handle{b}, c, false, 0, false},
+		{"no candidates", nil, desktop, false, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, override := mruRestorePick(tt.candidates, eligible, tt.fg, tt.userActivated)
+			if want != tt.want || override != tt.override {
+				t.Errorf("mruRestorePick() = (0x%X, %v), want (0x%X, %v)", want, override, tt.want, tt.override)
+			}
+		})
+	}
+}
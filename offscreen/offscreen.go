@@ -0,0 +1,134 @@
+// Package offscreen decides which windows have become unreachable -- left
+// (mostly) outside every monitor, typically by a monitor that has since
+// been disconnected -- and where to put them back. Like snapengine it is
+// pure geometry, no Win32, so the decision logic builds and behaves the
+// same on any OS; the main package (see rescueOffscreenWindows) supplies
+// the window frames and work areas and applies the results.
+//
+// Rectangles follow Win32 RECT conventions (exclusive Right/Bottom, screen
+// pixels). Frames are expected to be a window's VISIBLE bounds (what
+// DwmGetExtendedFrameBounds reports), not GetWindowRect, whose invisible
+// resize borders would otherwise count as "on screen".
+package offscreen
+
+// Rect is a screen-space rectangle with exclusive Right/Bottom.
+type Rect struct {
+	Left, Top, Right, Bottom int32
+}
+
+// Width returns Right-Left.
+func (r Rect) Width() int32 { return r.Right - r.Left }
+
+// Height returns Bottom-Top.
+func (r Rect) Height() int32 { return r.Bottom - r.Top }
+
+func (r Rect) empty() bool { return r.Left >= r.Right || r.Top >= r.Bottom }
+
+func (r Rect) area() int64 {
+	if r.empty() {
+		return 0
+	}
+	return int64(r.Width()) * int64(r.Height())
+}
+
+func intersect(a, b Rect) Rect {
+	return Rect{max(a.Left, b.Left), max(a.Top, b.Top), min(a.Right, b.Right), min(a.Bottom, b.Bottom)}
+}
+
+// Config holds the thresholds NeedsRescue judges a frame by.
+//
+// MinVisiblePercent: a window with less than this percentage of its frame
+// inside the work areas is "mostly off-screen".
+//
+// TitleBarPx is the height of the strip at the top of the frame treated as
+// its title bar, and MinGrabPx how wide a piece of that strip must lie
+// inside a single work area for the user to still be able to grab it.
+type Config struct {
+	MinVisiblePercent int
+	TitleBarPx        int32
+	MinGrabPx         int32
+}
+
+// NeedsRescue reports whether a window with the given frame is unreachable
+// on a desktop whose monitors have the given work areas: either mostly
+// outside all of them, or with no grabbable part of its title bar on any
+// of them (e.g. a tall window whose title bar is above the top of the
+// screen, which no amount of ordinary dragging can fix). Work areas of
+// different monitors never overlap, so their intersections with the frame
+// are simply summed. An empty frame never needs rescuing.
+func NeedsRescue(frame Rect, works []Rect, cfg Config) bool {
+	if frame.empty() {
+		return false
+	}
+	var visible int64
+	for _, w := range works {
+		visible += intersect(frame, w).area()
+	}
+	if visible*100 < int64(cfg.MinVisiblePercent)*frame.area() {
+		return true
+	}
+	return !titleBarReachable(frame, works, cfg)
+}
+
+func titleBarReachable(frame Rect, works []Rect, cfg Config) bool {
+	bar := frame
+	bar.Bottom = min(frame.Bottom, frame.Top+max(cfg.TitleBarPx, 1))
+	minGrab := min(cfg.MinGrabPx, bar.Width())
+	for _, w := range works {
+		i := intersect(bar, w)
+		// At least half the bar's height must be on this monitor: a bar
+		// with only a sliver of pixels peeking out is not grabbable.
+		if !i.empty() && i.Width() >= minGrab && 2*i.Height() >= bar.Height() {
+			return true
+		}
+	}
+	return false
+}
+
+// Nearest returns the index of the work area a window with the given frame
+// belongs on: the one it overlaps most, or, if it overlaps none, the one
+// closest to the frame's center. Returns -1 only for an empty works.
+func Nearest(frame Rect, works []Rect) int {
+	best, bestArea := -1, int64(0)
+	for i, w := range works {
+		if a := intersect(frame, w).area(); a > bestArea {
+			best, bestArea = i, a
+		}
+	}
+	if best >= 0 {
+		return best
+	}
+	cx := int64(frame.Left) + int64(frame.Width())/2
+	cy := int64(frame.Top) + int64(frame.Height())/2
+	var bestDist int64
+	for i, w := range works {
+		dx := axisDistance(cx, int64(w.Left), int64(w.Right))
+		dy := axisDistance(cy, int64(w.Top), int64(w.Bottom))
+		if d := dx*dx + dy*dy; best < 0 || d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+// axisDistance is how far v is outside [lo, hi), 0 if inside.
+func axisDistance(v, lo, hi int64) int64 {
+	switch {
+	case v < lo:
+		return lo - v
+	case v >= hi:
+		return v - hi + 1
+	}
+	return 0
+}
+
+// Rescue returns frame moved (and, only if it's larger than work, shrunk)
+// so it lies entirely inside work. Position is changed as little as
+// possible: a frame already inside work is returned unchanged.
+func Rescue(frame, work Rect) Rect {
+	w := min(frame.Width(), work.Width())
+	h := min(frame.Height(), work.Height())
+	left := min(max(frame.Left, work.Left), work.Right-w)
+	top := min(max(frame.Top, work.Top), work.Bottom-h)
+	return Rect{left, top, left + w, top + h}
+}
@@ -0,0 +1,83 @@
+package offscreen
+
+import "testing"
+
+// works is a 1920x1040 primary work area (taskbar at the bottom) with a
+// 1280x984 one to its right, top-aligned.
+var works = []Rect{
+	{0, 0, 1920, 1040},
+	{1920, 0, 3200, 984},
+}
+
+var cfg = Config{MinVisiblePercent: 25, TitleBarPx: 30, MinGrabPx: 40}
+
+func TestNeedsRescue(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame Rect
+		want  bool
+	}{
+		{"fully on the primary", Rect{100, 100, 900, 700}, false},
+		{"spanning both monitors", Rect{1500, 100, 2300, 700}, false},
+		{"on a disconnected monitor to the left", Rect{-1500, 100, -700, 700}, true},
+		{"on a disconnected monitor below the right one", Rect{2000, 1100, 2800, 1500}, true},
+		{"title bar above every work area", Rect{100, -40, 900, 900}, true},
+		{"title bar half above the top is still grabbable", Rect{100, -15, 900, 900}, false},
+		{"title bar below every work area", Rect{100, 1030, 900, 1600}, true},
+		{"in the gap below the shorter right monitor", Rect{2000, 990, 2800, 1030}, true},
+		{"partial overlap, enough visible", Rect{-400, 100, 400, 700}, false},
+		{"partial overlap, too little visible", Rect{-700, 100, 100, 700}, true},
+		{"a quarter visible, but too narrow a bit of title bar to grab", Rect{-90, 100, 30, 200}, true},
+		{"empty frame", Rect{100, 100, 100, 700}, false},
+	}
+	for _, tt := range tests {
+		if got := NeedsRescue(tt.frame, works, cfg); got != tt.want {
+			t.Errorf("%s: NeedsRescue(%+v) = %v, want %v", tt.name, tt.frame, got, tt.want)
+		}
+	}
+}
+
+func TestNearest(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame Rect
+		works []Rect
+		want  int
+	}{
+		{"overlaps the primary most", Rect{1700, 100, 2000, 700}, works, 0},
+		{"overlaps the right one most", Rect{1800, 100, 2400, 700}, works, 1},
+		{"off to the left: closest is the primary", Rect{-1500, 100, -700, 700}, works, 0},
+		{"off to the right: closest is the right one", Rect{3500, 100, 4000, 700}, works, 1},
+		{"below the right one", Rect{2400, 1100, 2800, 1500}, works, 1},
+		{"no monitors", Rect{0, 0, 10, 10}, nil, -1},
+	}
+	for _, tt := range tests {
+		if got := Nearest(tt.frame, tt.works); got != tt.want {
+			t.Errorf("%s: Nearest(%+v) = %d, want %d", tt.name, tt.frame, got, tt.want)
+		}
+	}
+}
+
+func TestRescue(t *testing.T) {
+	work := works[0]
+	tests := []struct {
+		name  string
+		frame Rect
+		want  Rect
+	}{
+		{"already inside is unchanged", Rect{100, 100, 900, 700}, Rect{100, 100, 900, 700}},
+		{"off the left edge slides in", Rect{-500, 100, 300, 700}, Rect{0, 100, 800, 700}},
+		{"above the top slides down", Rect{100, -200, 900, 400}, Rect{100, 0, 900, 600}},
+		{"past the bottom right corner", Rect{1800, 1000, 2200, 1300}, Rect{1520, 740, 1920, 1040}},
+		{"larger than the work area shrinks to it", Rect{-100, -100, 2500, 1500}, work},
+	}
+	for _, tt := range tests {
+		got := Rescue(tt.frame, work)
+		if got != tt.want {
+			t.Errorf("%s: Rescue(%+v) = %+v, want %+v", tt.name, tt.frame, got, tt.want)
+		}
+		if intersect(got, work) != got {
+			t.Errorf("%s: Rescue result %+v isn't inside the work area", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+//go:build windows && amd64
+
+package main
+
+import (
+	"sync/atomic"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/workturnedplay/wincoe"
+)
+
+/* ---------------- Owned windows follow their owner ---------------- */
+
+// A Win+LMB drag moves one window; its owned top-level windows (floating
+// toolbars, Find dialogs, palettes -- whatever has it as GW_OWNER, directly
+// or through another owned window) stay where they were. With
+// moveOwnedWindowsWithOwner on, they're collected when the drag starts
+// (startManualDrag, along with their offsets from the owner) and each move
+// of the owner moves them too, by the same amount, in the same
+// handleActualMoveOrResize call, so the group moves as one rigid piece.
+
+// moveOwnedWindowsWithOwner turns this on. Off by default; a rule can turn
+// it off (or on) for one application -- see ruleMoveOwnedWindows.
+// Persisted (see persistedSettings).
+var moveOwnedWindowsWithOwner atomic.Bool
+
+// follower is one window that follows a dragged window, at a fixed offset
+// from its top-left corner: one of its owned windows, or another window of
+// the selection (see selection.go).
+type follower struct {
+	hwnd   windows.Handle
+	dx, dy int32
+}
+
+// maxOwnerChainDepth bounds the GW_OWNER walk in isOwnedBy; real owner
+// chains are one or two deep.
+const maxOwnerChainDepth = 8
+
+// isOwnedBy reports whether owner is hwnd's owner, or its owner's owner,
+// and so on.
+func isOwnedBy(hwnd, owner windows.Handle) bool {
+	for range maxOwnerChainDepth {
+		next := windows.Handle(wincoe.GetWindow(hwnd, wincoe.GW_OWNER).R1)
+		if next == 0 {
+			return false
+		}
+		if next == owner {
+			return true
+		}
+		hwnd = next
+	}
+	return false
+}
+
+// ownedWindowsOf collects the visible, non-minimized windows owned by
+// owner, with their offsets from ownerRect. Runs at gesture start on the
+// hook thread; it only reads window state, like the rest of
+// startManualDrag.
+func ownedWindowsOf(owner windows.Handle, ownerRect wincoe.RECT) []follower {
+	var out []follower
+	forEachTopLevelWindow(func(hwnd windows.Handle) bool {
+		if hwnd == owner || !wincoe.IsWindowVisible(hwnd) || isOwnWindow(hwnd) || !isOwnedBy(hwnd, owner) || isMinimized(hwnd) {
+			return true
+		}
+		var r wincoe.RECT
+		if res := wincoe.GetWindowRect(hwnd, &r); res.Failed() {
+			return true
+		}
+		out = append(out, follower{hwnd: hwnd, dx: r.Left - ownerRect.Left, dy: r.Top - ownerRect.Top})
+		return true
+	})
+	return out
+}
+
+// moveFollowers puts each of followers at its offset from the dragged
+// window's new top-left (x, y). Posted (SWP_ASYNCWINDOWPOS) like the dragged
+// window's own move, so the group's moves are all issued back to back.
+// Main thread only.
+func moveFollowers(followers []follower, x, y int32) {
+	for _, o := range followers {
+		journalLiveGeometry(o.hwnd)
+		if res := wincoe.SetWindowPos(o.hwnd, 0, x+o.dx, y+o.dy, 0, 0,
+			wincoe.SWP_NOSIZE|wincoe.SWP_NOZORDER|wincoe.SWP_NOACTIVATE|wincoe.SWP_ASYNCWINDOWPOS); res.Failed() {
+			logf("moveFollowers: SetWindowPos failed for HWND=0x%X: %v", o.hwnd, res.Err)
+		}
+	}
+}
+
+// appendOwnedWindowsMenuItems appends the tray's move-owned-windows toggle
+// to hMenu.
+func appendOwnedWindowsMenuItems(hMenu windows.Handle) {
+	var flags uint32 = wincoe.MF_STRING
+	if moveOwnedWindowsWithOwner.Load() {
+		flags |= wincoe.MF_CHECKED
+	}
+	appendMenuChecked(hMenu, flags, MENU_TOGGLE_MOVE_OWNED_WINDOWS,
+		"Move a window's toolbars, palettes and dialogs along with it (winkey+LMB drag)")
+}
+
+// handleOwnedWindowsMenuCommand runs the tray command produced by
+// appendOwnedWindowsMenuItems, reporting whether cmd was one.
+func handleOwnedWindowsMenuCommand(cmd uint32) bool {
+	if cmd != MENU_TOGGLE_MOVE_OWNED_WINDOWS {
+		return false
+	}
+	toggleAndPersist(&moveOwnedWindowsWithOwner)
+	return true
+}
@@ -0,0 +1,354 @@
+//go:build windows && amd64
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/workturnedplay/wincoe"
+)
+
+/* ---------------- Pin on top ---------------- */
+
+// Pinning toggles WS_EX_TOPMOST on the window under the cursor. The
+// SetWindowPos(HWND_TOPMOST/HWND_NOTOPMOST) itself is applied like every
+// gesture's window change, after any still queued (see requestPin,
+// applyZOrderChangeNow and handleActualMoveOrResize's zOrderActionPin /
+// zOrderActionUnpin cases), so it's ordered with any move of the same
+// window still in flight, and the pinned list below is only updated once
+// the change actually succeeded.
+//
+// Every window pinned this way gets a small badge near its caption
+// buttons, and is listed in the tray so it can be unpinned from there. A
+// window that loses topmost some other way (the app itself, another tool,
+// or winkey+MMB send-to-back, which Windows implements by dropping
+// topmost) drops off the list on the next badge refresh.
+
+// pinBadgeTimerMs is how often pinned windows' badges follow their
+// windows. Only runs while something is pinned.
+const pinBadgeTimerMs = 200
+
+// winbollocksPinBadgeClassName is the window class of the badges.
+const winbollocksPinBadgeClassName = selfName + "PinBadgeClass"
+
+// pinBadgeColor is the badge fill, as a COLORREF (0x00BBGGRR): orange.
+const pinBadgeColor uint32 = 0x0000A5FF
+
+// SM_CXSIZE is the GetSystemMetrics index of a caption button's width;
+// badges sit just left of the (usually three) caption buttons.
+const SM_CXSIZE = 30
+
+// maxPinnedInTrayMenu bounds the tray's "Pinned on top" submenu, and is
+// the width of the MENU_UNPIN_BASE range.
+const maxPinnedInTrayMenu = 20
+
+// pinnedWindow is one window pinned via togglePinUnderCursor.
+type pinnedWindow struct {
+	hwnd  windows.Handle
+	badge windows.Handle // 0 if the badge couldn't be created; the pin itself still works
+	at    wincoe.RECT    // where the badge was last put, to skip redundant SetWindowPos
+	shown bool
+}
+
+// Pin state. Main thread only: it's touched by handleActualMoveOrResize,
+// the badge timer, the tray and deinit, all of which run there.
+var (
+	pinnedWindows           []pinnedWindow
+	pinBadgeClassRegistered bool
+	pinBadgeBrush           windows.Handle
+)
+
+// isWindowTopmost reports whether hwnd currently has WS_EX_TOPMOST.
+func isWindowTopmost(hwnd windows.Handle) bool {
+	exStyle, err := getWindowLongPtr(hwnd, wincoe.GWL_EXSTYLE)
+	// #nosec G115 -- safe: Win32 extended window styles are 32-bit bitmasks
+	return err == nil && uint32(exStyle)&wincoe.WS_EX_TOPMOST != 0
+}
+
+// togglePinUnderCursor pins the top-level window under the mouse cursor,
+// or unpins it if it's already topmost (whoever made it so).
+func togglePinUnderCursor() {
+	var pt wincoe.POINT
+	if res := wincoe.GetCursorPos(&pt); res.Failed() {
+		logf("togglePinUnderCursor: GetCursorPos failed: %v", res.Err)
+		return
+	}
+	hwnd, res := wincoe.RootWindowFromPoint(pt)
+	if hwnd == 0 || !isManageableTopLevelWindow(hwnd) {
+		logf("togglePinUnderCursor: no pinnable window under the cursor at (%d,%d) (HWND=0x%X, res: %v)", pt.X, pt.Y, hwnd, res)
+		return
+	}
+	requestPin(hwnd, !isWindowTopmost(hwnd))
+}
+
+// requestPin applies the SetWindowPos that pins (or unpins) hwnd; see the
+// top of this file. Main thread only.
+func requestPin(hwnd windows.Handle, pin bool) {
+	data := WindowMoveData{
+		Hwnd:         hwnd,
+		InsertAfter:  wincoe.HWND_NOTOPMOST,
+		Flags:        wincoe.SWP_NOMOVE | wincoe.SWP_NOSIZE | wincoe.SWP_NOACTIVATE,
+		ZOrderAction: zOrderActionUnpin,
+	}
+	if pin {
+		data.InsertAfter, data.ZOrderAction = wincoe.HWND_TOPMOST, zOrderActionPin
+	}
+	applyZOrderChangeNow(data)
+}
+
+// setPinned records that hwnd's pin change succeeded, adding or removing
+// its badge. Called from handleActualMoveOrResize.
+func setPinned(hwnd windows.Handle, pinned bool) {
+	for i, p := range pinnedWindows {
+		if p.hwnd != hwnd {
+			continue
+		}
+		if !pinned {
+			removePinned(i)
+			logf("setPinned: unpinned HWND=0x%X %q", hwnd, getWindowTextFast(hwnd))
+		}
+		return
+	}
+	if !pinned {
+		return // unpinned a window someone else had made topmost
+	}
+	pinnedWindows = append(pinnedWindows, pinnedWindow{hwnd: hwnd, badge: createPinBadge()})
+	logf("setPinned: pinned HWND=0x%X %q on top", hwnd, getWindowTextFast(hwnd))
+	if len(pinnedWindows) == 1 {
+		if _, res := wincoe.SetTimer(loadMainMsgHwnd(), pinBadgeTimerID, pinBadgeTimerMs, 0); res.Failed() {
+			logf("setPinned: SetTimer failed: %v; pin badges won't follow their windows", res.Err)
+		}
+	}
+	updatePinBadges()
+}
+
+// removePinned drops pinnedWindows[i] and its badge, stopping the badge
+// timer when nothing is left pinned.
+func removePinned(i int) {
+	if b := pinnedWindows[i].badge; b != 0 {
+		if res := wincoe.DestroyWindow(b); res.Failed() {
+			logf("removePinned: DestroyWindow failed for badge HWND=0x%X: %v", b, res.Err)
+		}
+	}
+	pinnedWindows = append(pinnedWindows[:i], pinnedWindows[i+1:]...)
+	if len(pinnedWindows) == 0 {
+		if res := wincoe.KillTimer(loadMainMsgHwnd(), pinBadgeTimerID); res.Failed() {
+			logf("removePinned: KillTimer failed: %v", res.Err)
+		}
+	}
+}
+
+// updatePinBadges drops pins whose window is gone or no longer topmost and
+// moves every remaining badge to its window, hiding it while the window
+// is minimized, hidden or cloaked.
+func updatePinBadges() {
+	for i := len(pinnedWindows) - 1; i >= 0; i-- {
+		p := &pinnedWindows[i]
+		if !wincoe.IsWindow(p.hwnd) || !isWindowTopmost(p.hwnd) {
+			logf("updatePinBadges: HWND=0x%X is gone or no longer topmost; dropping its pin", p.hwnd)
+			removePinned(i)
+			continue
+		}
+		if p.badge == 0 {
+			continue
+		}
+		visible := wincoe.IsWindowVisible(p.hwnd) && !isWindowCloaked(p.hwnd) && !isMinimized(p.hwnd)
+		if !visible {
+			if p.shown {
+				_ = wincoe.SetWindowPos(p.badge, 0, 0, 0, 0, 0,
+					wincoe.SWP_NOMOVE|wincoe.SWP_NOSIZE|wincoe.SWP_NOZORDER|wincoe.SWP_NOACTIVATE|wincoe.SWP_HIDEWINDOW)
+				p.shown = false
+			}
+			continue
+		}
+		at, ok := pinBadgeRect(p.hwnd)
+		if !ok {
+			continue
+		}
+		if p.shown && at == p.at {
+			// Clicking a pinned window raises it above its own badge (both
+			// are topmost), so put the badge back on top of it.
+			if wincoe.GetForegroundWindow() == p.hwnd {
+				_ = wincoe.SetWindowPos(p.badge, wincoe.HWND_TOPMOST, 0, 0, 0, 0,
+					wincoe.SWP_NOMOVE|wincoe.SWP_NOSIZE|wincoe.SWP_NOACTIVATE)
+			}
+			continue
+		}
+		if res := wincoe.SetWindowPos(p.badge, wincoe.HWND_TOPMOST, at.Left, at.Top, at.Right-at.Left, at.Bottom-at.Top,
+			wincoe.SWP_NOACTIVATE|wincoe.SWP_SHOWWINDOW); res.Failed() {
+			logf("updatePinBadges: SetWindowPos failed for badge HWND=0x%X: %v", p.badge, res.Err)
+			continue
+		}
+		p.at, p.shown = at, true
+	}
+}
+
+// isMinimized reports whether hwnd is minimized.
+func isMinimized(hwnd windows.Handle) bool {
+	var wp wincoe.WINDOWPLACEMENT
+	wp.Length = uint32(unsafe.Sizeof(wp))
+	return wincoe.GetWindowPlacement(hwnd, &wp).Succeeded() && wp.ShowCmd == windows.SW_SHOWMINIMIZED
+}
+
+// pinBadgeRect is where hwnd's badge goes: a square about half a caption
+// high, on the caption just left of the caption buttons.
+func pinBadgeRect(hwnd windows.Handle) (wincoe.RECT, bool) {
+	frame, err := wincoe.DwmGetExtendedFrameBounds(hwnd)
+	if err != nil {
+		if res := wincoe.GetWindowRect(hwnd, &frame); res.Failed() {
+			return wincoe.RECT{}, false
+		}
+	}
+	caption := max(wincoe.GetSystemMetrics(SM_CYCAPTION), 16)
+	size := max(caption*2/3, 10)
+	buttons := 3 * max(wincoe.GetSystemMetrics(SM_CXSIZE), 0)
+	right := max(frame.Right-buttons-size/2, frame.Left+size)
+	top := frame.Top + (caption-size)/2
+	return wincoe.RECT{Left: right - size, Top: top, Right: right, Bottom: top + size}, true
+}
+
+// createPinBadge creates one (hidden) badge window, registering the class
+// and creating the brush on first use. Returns 0 on failure, logged.
+func createPinBadge() windows.Handle {
+	className := mustUTF16(winbollocksPinBadgeClassName)
+	if !pinBadgeClassRegistered {
+		var wc wincoe.WNDCLASSEX
+		wc.CbSize = uint32(unsafe.Sizeof(wc))
+		wc.LpfnWndProc = windows.NewCallback(pinBadgeWndProc) // once per process: registration happens at most once
+		wc.LpszClassName = className
+		wc.HInstance = selfHInstance
+		if res := wincoe.RegisterClassEx(&wc); res.Failed() {
+			logf("createPinBadge: RegisterClassEx failed: %v; pinned windows won't get a badge", res.Err)
+			return 0
+		}
+		pinBadgeClassRegistered = true
+	}
+	if pinBadgeBrush == 0 {
+		brush, res := wincoe.GdiCreateSolidBrush(pinBadgeColor)
+		if res.Failed() {
+			logf("createPinBadge: CreateSolidBrush failed: %v; pinned windows won't get a badge", res.Err)
+			return 0
+		}
+		pinBadgeBrush = brush
+	}
+	res := wincoe.CreateWindowEx(
+		wincoe.WS_EX_LAYERED|wincoe.WS_EX_TRANSPARENT|wincoe.WS_EX_TOOLWINDOW|wincoe.WS_EX_TOPMOST|wincoe.WS_EX_NOACTIVATE,
+		className, nil, wincoe.WS_POPUP,
+		0, 0, 1, 1, // positioned by updatePinBadges
+		0, 0, selfHInstance, nil,
+	)
+	if res.Failed() {
+		logf("createPinBadge: CreateWindowEx failed: %v; this pinned window won't get a badge", res.Err)
+		return 0
+	}
+	badge := windows.Handle(res.R1)
+	const pinBadgeAlpha = 230
+	if r := wincoe.SetLayeredWindowAttributes(badge, 0, pinBadgeAlpha, wincoe.LWA_ALPHA); r.Failed() {
+		logf("createPinBadge: SetLayeredWindowAttributes failed for HWND=0x%X: %v; the badge may stay invisible", badge, r.Err)
+	}
+	return badge
+}
+
+// pinBadgeWndProc paints a badge: a filled orange square. Clicks go
+// straight through it (WS_EX_TRANSPARENT) to the caption beneath.
+func pinBadgeWndProc(hwnd windows.Handle, msg uint32, wParam, lParam uintptr) uintptr {
+	if msg == wincoe.WM_PAINT {
+		var ps wincoe.PAINTSTRUCT
+		hdc, res := wincoe.BeginPaint(hwnd, &ps)
+		if res.Failed() {
+			return 0
+		}
+		defer wincoe.EndPaint(hwnd, &ps)
+		var rect wincoe.RECT
+		if res := wincoe.GetClientRect(hwnd, &rect); res.Succeeded() {
+			_ = wincoe.FillRect(hdc, &rect, pinBadgeBrush)
+		}
+		return 0
+	}
+	return wincoe.DefWindowProc(hwnd, msg, wParam, lParam).R1
+}
+
+// pinnedHwnds snapshots the pinned windows for one tray menu popup (see
+// appendPinnedMenu/handlePinMenuCommand), the same way trayLayoutNames
+// does for layouts: the list may change while the menu is open.
+func pinnedHwnds() []windows.Handle {
+	out := make([]windows.Handle, 0, min(len(pinnedWindows), maxPinnedInTrayMenu))
+	for _, p := range pinnedWindows {
+		if len(out) == maxPinnedInTrayMenu {
+			break
+		}
+		out = append(out, p.hwnd)
+	}
+	return out
+}
+
+// appendPinnedMenu appends the tray's "Pinned on top" submenu, one unpin
+// item per pinned window, or a grayed hint when nothing is pinned.
+func appendPinnedMenu(hMenu windows.Handle, pinned []windows.Handle) {
+	if len(pinned) == 0 {
+		appendMenuChecked(hMenu, wincoe.MF_STRING|wincoe.MF_GRAYED, 0,
+			"Pinned on top: none"+hotkeyLabel(hotkeyTogglePin))
+		return
+	}
+	hSub, res := wincoe.CreatePopupMenu()
+	if res.Failed() {
+		logf("appendPinnedMenu: CreatePopupMenu failed: %v", res.Err)
+		return
+	}
+	for i, hwnd := range pinned {
+		appendMenuChecked(hSub, wincoe.MF_STRING, uintptr(MENU_UNPIN_BASE+i), "Unpin "+pinMenuTitle(hwnd))
+	}
+	appendMenuChecked(hMenu, wincoe.MF_STRING|MF_POPUP, uintptr(hSub), fmt.Sprintf("Pinned on top (%d)", len(pinned)))
+}
+
+// pinMenuTitle is hwnd's title as a menu item shows it: shortened, with
+// '&' doubled so it isn't taken for an accelerator prefix.
+func pinMenuTitle(hwnd windows.Handle) string {
+	const maxRunes = 60
+	title := []rune(getWindowTextFast(hwnd))
+	if len(title) == 0 {
+		return fmt.Sprintf("HWND 0x%X", hwnd)
+	}
+	if len(title) > maxRunes {
+		title = append(title[:maxRunes-1], '…')
+	}
+	return strings.ReplaceAll(string(title), "&", "&&")
+}
+
+// handlePinMenuCommand runs the tray command produced by appendPinnedMenu,
+// reporting whether cmd was one.
+func handlePinMenuCommand(cmd uint32, pinned []windows.Handle) bool {
+	if cmd < MENU_UNPIN_BASE || int(cmd) >= MENU_UNPIN_BASE+len(pinned) {
+		return false
+	}
+	requestPin(pinned[cmd-MENU_UNPIN_BASE], false)
+	return true
+}
+
+// deinitPinBadges destroys every badge and frees the badge class and
+// brush. Windows stay pinned: topmost is the window's own state, and the
+// hotkey unpins it again on the next run.
+func deinitPinBadges() {
+	for _, p := range pinnedWindows {
+		if p.badge != 0 {
+			_ = wincoe.DestroyWindow(p.badge)
+		}
+	}
+	pinnedWindows = nil
+	if pinBadgeBrush != 0 {
+		if res := wincoe.GdiDeleteObject(pinBadgeBrush); res.Failed() {
+			logf("deinitPinBadges: DeleteObject failed: %v", res.Err)
+		}
+		pinBadgeBrush = 0
+	}
+	if pinBadgeClassRegistered {
+		if res := wincoe.UnregisterClassW(mustUTF16(winbollocksPinBadgeClassName), selfHInstance); res.Failed() {
+			logf("deinitPinBadges: UnregisterClassW failed: %v", res)
+		}
+		pinBadgeClassRegistered = false
+	}
+}
@@ -0,0 +1,133 @@
+//go:build windows && amd64
+
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/workturnedplay/wincoe"
+
+	"github.com/workturnedplay/winbollocks/snapengine"
+	"github.com/workturnedplay/winbollocks/winlayout"
+)
+
+/* ---------------- Center / maximize vertically / horizontally ---------------- */
+
+// One-shot placements for the window under the cursor: center it in its
+// monitor's work area, or stretch it to the work area's full height (or
+// width) while keeping the other axis. The geometry is snapengine.Place's,
+// done on the window's visible frame (see windowVisualEdgeInsets) and
+// within the snap outer gap, so the result lines up exactly with what
+// snapping would produce. Running the same placement again on a window
+// still sitting where it put it restores the geometry from before, like
+// Windows' own Win+Shift+Up; every placement also goes into the undo
+// history (see pushGeometryHistory).
+
+// placementToggle remembers the last placement applied to one window:
+// which one, the geometry it replaced, and the rect it left the window at.
+type placementToggle struct {
+	placement snapengine.Placement
+	before    windowGeometry
+	applied   winlayout.Rect
+}
+
+// placementToggles is written on the main thread and pruned from the hook
+// thread (winEventProc's EVENT_OBJECT_DESTROY case), hence the mutex.
+var (
+	placementTogglesMu sync.Mutex
+	placementToggles   = map[windows.Handle]placementToggle{}
+)
+
+// placementNames is how each placement appears in the log.
+var placementNames = map[snapengine.Placement]string{
+	snapengine.PlaceCenter:     "center",
+	snapengine.PlaceFullHeight: "maximize vertically",
+	snapengine.PlaceFullWidth:  "maximize horizontally",
+}
+
+// forgetPlacementToggle drops hwnd's toggle state; called when it's
+// destroyed.
+func forgetPlacementToggle(hwnd windows.Handle) {
+	placementTogglesMu.Lock()
+	delete(placementToggles, hwnd)
+	placementTogglesMu.Unlock()
+}
+
+// togglePlacement applies p to hwnd, or undoes it if p is what last put
+// hwnd where it is now. A maximized window is placed from its restored
+// rect and comes out restored. Main thread only.
+func togglePlacement(hwnd windows.Handle, p snapengine.Placement) bool {
+	wsDX, wsDY := primaryWorkspaceOffset()
+	state, rect, ok := liveWindowState(hwnd, wsDX, wsDY)
+	if !ok || state == winlayout.StateMinimized {
+		return false
+	}
+	cur := windowGeometry{state, rect}
+
+	placementTogglesMu.Lock()
+	last, had := placementToggles[hwnd]
+	placementTogglesMu.Unlock()
+	if had && last.placement == p && state == winlayout.StateNormal && rect == last.applied {
+		forgetPlacementToggle(hwnd)
+		pushGeometryHistory(hwnd, cur)
+		logf("togglePlacement(%s): restoring HWND=0x%X %q", placementNames[p], hwnd, getWindowTextFast(hwnd))
+		return placeWindow(hwnd, state, last.before.state, last.before.rect)
+	}
+
+	var mi wincoe.MONITORINFO
+	hMon := wincoe.MonitorFromWindow(hwnd, wincoe.MONITOR_DEFAULTTONEAREST)
+	if res := wincoe.GetMonitorInfo(hMon, &mi); res.Failed() {
+		logf("togglePlacement(%s): GetMonitorInfo failed for HWND=0x%X: %v", placementNames[p], hwnd, res.Err)
+		return false
+	}
+	insL, insT, insR, insB := windowVisualEdgeInsets(hwnd)
+	visible := snapengine.Rect{Left: rect.Left + insL, Top: rect.Top + insT, Right: rect.Right - insR, Bottom: rect.Bottom - insB}
+	v := snapengine.Place(visible, toSnapRect(mi.RcWork), p, snapOuterGapPx.Load())
+	target := winlayout.Rect{Left: v.Left - insL, Top: v.Top - insT, Right: v.Right + insR, Bottom: v.Bottom + insB}
+
+	pushGeometryHistory(hwnd, cur)
+	if !placeWindow(hwnd, state, winlayout.StateNormal, target) {
+		return false
+	}
+	placementTogglesMu.Lock()
+	placementToggles[hwnd] = placementToggle{placement: p, before: cur, applied: target}
+	placementTogglesMu.Unlock()
+	logf("togglePlacement(%s): placed HWND=0x%X %q at (%d,%d)-(%d,%d)", placementNames[p], hwnd, getWindowTextFast(hwnd),
+		target.Left, target.Top, target.Right, target.Bottom)
+	return true
+}
+
+// togglePlacementUnderCursor is the placement hotkeys' (and remote
+// commands') action: toggle p on the top-level window under the mouse
+// cursor.
+func togglePlacementUnderCursor(p snapengine.Placement) {
+	var pt wincoe.POINT
+	if res := wincoe.GetCursorPos(&pt); res.Failed() {
+		logf("togglePlacementUnderCursor(%s): GetCursorPos failed: %v", placementNames[p], res.Err)
+		return
+	}
+	hwnd, res := wincoe.RootWindowFromPoint(pt)
+	if hwnd == 0 || !isManageableTopLevelWindow(hwnd) {
+		logf("togglePlacementUnderCursor(%s): no window under the cursor at (%d,%d) (HWND=0x%X, res: %v)", placementNames[p], pt.X, pt.Y, hwnd, res)
+		return
+	}
+	togglePlacement(hwnd, p)
+}
+
+// placementRemoteCommand is the remote command name toggling p on the
+// window under the cursor; it takes no arguments.
+func placementRemoteCommand(name string, p snapengine.Placement) remoteCommand {
+	return remoteCommand{
+		usage: name,
+		run: func(arg string) error {
+			if arg != "" {
+				return fmt.Errorf("%s takes no arguments, got %q", name, arg)
+			}
+			togglePlacementUnderCursor(p)
+			return nil
+		},
+	}
+}
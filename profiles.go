@@ -0,0 +1,489 @@
+//go:build windows && amd64
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/workturnedplay/wincoe"
+
+	"github.com/workturnedplay/winbollocks/settingsprofile"
+)
+
+/* ---------------- Settings profiles ---------------- */
+
+// A profile is a named set of settings (see package settingsprofile for
+// the file format) laid over the settings file's own values while it's
+// active: "gaming" turning hot corners and focus-follows-mouse off,
+// "presentation" widening the snap gap. One is picked in the tray, with
+// "-cmd profile <name>", or in the settings file's "profile" line, and
+// persisted there. A profile listing executables under
+// switchOnForeground also takes over, for as long as one of those apps is
+// in the foreground, when automatic switching is on; the picked profile
+// comes back afterwards.
+//
+// Switching first puts back the values the previous profile replaced, then
+// applies the new profile's, each through its setting's own parse (so a
+// profile can't set anything the settings file couldn't) and logged like
+// a settings file edit. The settings file always keeps the values without
+// any profile: saveSettings writes profileBase's values for the settings
+// the active profile replaced, so a tray change to one of those lasts only
+// until the next switch, and an edit to one in the settings file is
+// remembered for when the profile goes away (see applySettingsText).
+//
+// The profiles file is re-read whenever the tray menu opens and on the
+// "profile" command; an edit to the active profile is applied right away.
+// A switch requested during a gesture waits for the gesture to end
+// (polled by profileSwitchTimerID), and a gesture can't start during a
+// switch (see profileSwitchMu), so no gesture ever sees half of one
+// profile and half of another.
+
+// profilesFilePath is the hand-written profiles file, next to
+// settingsFilePath. winbollocks never writes it.
+const profilesFilePath = selfName + "_profiles.ini"
+
+// maxProfilesInTrayMenu bounds the tray's profile submenu; with its "no
+// profile" item it fills the MENU_PROFILE_BASE range.
+const maxProfilesInTrayMenu = 19
+
+// profileSwitchRetryMs is how often a switch deferred by a gesture checks
+// whether the gesture is over.
+const profileSwitchRetryMs = 50
+
+// autoSwitchProfiles enables switchOnForeground. On by default: it only
+// does anything for profiles that list executables. Persisted (see
+// persistedSettings).
+var autoSwitchProfiles atomic.Bool
+
+// Profile state. Main thread only: the tray, remote commands, the settings
+// file loaders and the WM_PROFILE_FOREGROUND handler all run there.
+var (
+	profiles      []settingsprofile.Profile
+	profilesText  string // what profiles was parsed from, to skip re-parsing (and re-logging) an unchanged file
+	manualProfile string // picked by the user; "" for none
+	autoProfile   string // picked by the foreground application; "" for none
+	activeProfile string // the one in force
+	// profileBase holds, for each setting the active profile replaced, its
+	// value without the profile, as format renders it.
+	profileBase = map[string]string{}
+	// activeProfileEdited is set when the profiles file changed under the
+	// active profile, which then gets applied again.
+	activeProfileEdited bool
+	// profileSwitchDeferred is set while a switch waits for a gesture to end.
+	profileSwitchDeferred bool
+	// settingsFileProfile is the settings file's "profile" value, held
+	// until the whole file has been applied (see settingsTextApplied).
+	settingsFileProfile *string
+)
+
+// profileSwitchMu is held by the main thread for a whole profile switch,
+// and by the mouse hook's thread (hookWorker) while it starts a gesture
+// (see beginGestureSession).
+var profileSwitchMu sync.Mutex
+
+// Foreground changes, from winEventProc. That runs on the main thread
+// already, but in the middle of whatever the main thread was doing, so the
+// switch is posted and made from wndProc. Only posted while
+// profilesWantForeground, i.e. automatic switching is on and some profile
+// lists executables.
+var (
+	profilesWantForeground   atomic.Bool
+	profileForegroundHwnd    atomic.Uintptr
+	profileForegroundPending atomic.Bool
+)
+
+// loadProfiles (re)reads profilesFilePath if it changed. A missing file
+// just means no profiles.
+func loadProfiles() {
+	data, err := os.ReadFile(profilesFilePath) //nolint:gosec // G304: profilesFilePath is a fixed, hardcoded constant, never derived from user/network input
+	if err != nil && !os.IsNotExist(err) {
+		logf("loadProfiles: failed to read %q: %v; keeping the previous profiles", profilesFilePath, err)
+		return
+	}
+	if string(data) == profilesText {
+		return
+	}
+	profilesText = string(data)
+	parsed, errs := settingsprofile.Parse(data)
+	for _, e := range errs {
+		logf("loadProfiles: %q: %v", profilesFilePath, e)
+	}
+	if len(parsed) > maxProfilesInTrayMenu {
+		logf("loadProfiles: %q has %d profiles; only the first %d are offered in the tray", profilesFilePath, len(parsed), maxProfilesInTrayMenu)
+	}
+	profiles = parsed
+	updateProfilesWantForeground()
+	logf("loadProfiles: %d profile(s) in %q", len(profiles), profilesFilePath)
+	if activeProfile != "" {
+		activeProfileEdited = true
+		requestProfileSwitch()
+	}
+}
+
+// updateProfilesWantForeground tells winEventProc whether foreground
+// changes matter.
+func updateProfilesWantForeground() {
+	want := false
+	if autoSwitchProfiles.Load() {
+		for _, p := range profiles {
+			want = want || len(p.Exes) > 0
+		}
+	}
+	profilesWantForeground.Store(want)
+}
+
+// profileNames returns the profiles' names, as offered in the tray.
+func profileNames() []string {
+	loadProfiles()
+	names := make([]string, 0, len(profiles))
+	for _, p := range profiles {
+		if len(names) == maxProfilesInTrayMenu {
+			break
+		}
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+// profileSetting is the settings file's "profile" entry: the picked
+// profile, "" (or "none") for none.
+func profileSetting() persistedSetting {
+	return persistedSetting{
+		name:   "profile",
+		format: func() string { return manualProfile },
+		parse: func(text string) error {
+			name, err := canonicalProfileName(text)
+			if err != nil {
+				return err
+			}
+			settingsFileProfile = &name
+			return nil
+		},
+	}
+}
+
+// canonicalProfileName returns name as the profiles file spells it, ""
+// for none.
+func canonicalProfileName(name string) (string, error) {
+	if name == "" || strings.EqualFold(name, settingsprofile.None) {
+		return "", nil
+	}
+	p, ok := settingsprofile.Find(profiles, name)
+	if !ok {
+		return "", fmt.Errorf("no profile named %q in %s", name, profilesFilePath)
+	}
+	return p.Name, nil
+}
+
+// settingsTextApplied is applySettingsText's last step: it puts the
+// settings file's "profile" value in force once the rest of the file is
+// applied, so the profile lands on top of the file's values rather than
+// under the ones after its line, and catches up with autoSwitchProfiles.
+// (The settings' own onChange can't switch profiles: switching goes
+// through persistedSettings, which their initializers can't refer to.)
+func settingsTextApplied() {
+	if settingsFileProfile != nil {
+		manualProfile = *settingsFileProfile
+		settingsFileProfile = nil
+	}
+	requestProfileSwitch()
+}
+
+// setManualProfile makes name (canonical, "" for none) the picked profile
+// and persists that.
+func setManualProfile(name string) {
+	manualProfile = name
+	requestProfileSwitch()
+	saveSettings()
+}
+
+// switchProfileAndNotify is the "profile" remote command: pick name, or
+// no profile for "none".
+func switchProfileAndNotify(name string) error {
+	if name == "" {
+		return errors.New("profile needs a profile name, or none")
+	}
+	loadProfiles()
+	canonical, err := canonicalProfileName(name)
+	if err != nil {
+		showTrayInfo(selfName, err.Error())
+		return err
+	}
+	setManualProfile(canonical)
+	return nil
+}
+
+// requestProfileSwitch puts the wanted profile -- the foreground app's,
+// else the picked one -- in force, now or, during a gesture, once it ends.
+// The switch holds profileSwitchMu throughout, so no gesture can start
+// halfway through it (see beginGestureSession).
+func requestProfileSwitch() {
+	want := autoProfile
+	if want == "" {
+		want = manualProfile
+	}
+	if want == activeProfile && !activeProfileEdited {
+		return
+	}
+	profileSwitchMu.Lock()
+	defer profileSwitchMu.Unlock()
+	if activeSession.Load() != nil {
+		if profileSwitchDeferred {
+			return // onProfileSwitchTimer asks again
+		}
+		if _, res := wincoe.SetTimer(loadMainMsgHwnd(), profileSwitchTimerID, profileSwitchRetryMs, 0); res.Failed() {
+			logf("requestProfileSwitch: SetTimer failed: %v; not switching to %q until the next request", res.Err, want)
+			return
+		}
+		profileSwitchDeferred = true
+		return
+	}
+	activeProfileEdited = false
+	switchProfile(want)
+}
+
+// beginGestureSession makes sess the active gesture unless a profile
+// switch is in progress, so a gesture starts with either the old
+// profile's settings or the new one's, never a mix. The mouse hook can't
+// wait out a switch (onChange may read files, and a slow low-level hook
+// gets unhooked by Windows), so it gives up on the gesture instead; that
+// only happens in the few milliseconds a switch takes. Mouse hook's
+// thread (hookWorker), via startManualDrag and the resize start.
+func beginGestureSession(sess *dragSession) bool {
+	if !profileSwitchMu.TryLock() {
+		logf("beginGestureSession: a settings profile switch is in progress; not starting a gesture on HWND=0x%X", sess.targetWnd)
+		return false
+	}
+	activeSession.Store(sess)
+	profileSwitchMu.Unlock()
+	return true
+}
+
+// onProfileSwitchTimer is profileSwitchTimerID's handler: do the deferred
+// switch once no gesture is in flight.
+func onProfileSwitchTimer(hwnd windows.Handle) {
+	if activeSession.Load() != nil {
+		return
+	}
+	if res := wincoe.KillTimer(hwnd, profileSwitchTimerID); res.Failed() {
+		logf("onProfileSwitchTimer: KillTimer failed: %v", res.Err)
+	}
+	profileSwitchDeferred = false
+	requestProfileSwitch()
+}
+
+// switchProfile takes activeProfile out of force and puts name ("" for
+// none) in. A profile that has since vanished from the file switches to
+// none.
+func switchProfile(name string) {
+	byName := settingsByName()
+	restored := 0
+	for key, base := range profileBase {
+		if changeSetting("switchProfile", settingsFilePath, byName[key], base) {
+			restored++
+		}
+	}
+	clear(profileBase)
+	from := activeProfile
+	activeProfile = ""
+
+	applied := 0
+	if name != "" {
+		p, ok := settingsprofile.Find(profiles, name)
+		if !ok {
+			logf("switchProfile: profile %q is gone from %q; no profile in force", name, profilesFilePath)
+		}
+		for _, e := range p.Entries {
+			setting, known := byName[e.Key]
+			switch {
+			case !known:
+				logf("switchProfile: %q line %d: unrecognized setting %q, skipping", profilesFilePath, e.Line, e.Key)
+				continue
+			case setting.name == "profile" || setting.name == "autoSwitchProfiles":
+				logf("switchProfile: %q line %d: a profile can't change %s, skipping", profilesFilePath, e.Line, e.Key)
+				continue
+			case setting.skip != nil && setting.skip():
+				continue
+			}
+			before := setting.format()
+			if changeSetting("switchProfile", profilesFilePath, setting, e.Value) {
+				if _, recorded := profileBase[setting.name]; !recorded {
+					profileBase[setting.name] = before
+				}
+				applied++
+			}
+		}
+		if ok {
+			activeProfile = p.Name
+		}
+	}
+	logf("switchProfile: from %q to %q: %d setting(s) put back, %d set by the profile", from, activeProfile, restored, applied)
+}
+
+// changeSetting parses val into setting, logging (as caller, naming
+// source) a rejected value or an actual change, and running onChange for
+// the latter. Reports whether the value changed.
+func changeSetting(caller, source string, setting *persistedSetting, val string) bool {
+	before := setting.format()
+	if val == before {
+		return false
+	}
+	if err := setting.parse(val); err != nil {
+		logf("%s: %q: setting %q has unparsable value %q, skipping (keeping current value), err: %v", caller, source, setting.name, val, err)
+		return false
+	}
+	after := setting.format()
+	if after == before {
+		return false
+	}
+	logf("%s: %q: %s changed from %s to %s", caller, source, setting.name, before, after)
+	if setting.onChange != nil {
+		setting.onChange()
+	}
+	return true
+}
+
+// rebaseProfileSetting is applySettingsText's handling of a settings file
+// edit to a setting the active profile replaced: val becomes the value
+// that comes back when the profile goes away, and the profile's stays in
+// force. Reports whether the remembered value changed.
+func rebaseProfileSetting(caller, source string, setting *persistedSetting, val string) bool {
+	current := setting.format()
+	if err := setting.parse(val); err != nil {
+		logf("%s: %q: setting %q has unparsable value %q, skipping (keeping current value), err: %v", caller, source, setting.name, val, err)
+		return false
+	}
+	base := setting.format()
+	if err := setting.parse(current); err != nil { // can't happen: current came from format
+		logf("%s: %q: failed to put %s back to %s, err: %v", caller, source, setting.name, current, err)
+	}
+	if base == profileBase[setting.name] {
+		return false
+	}
+	logf("%s: %q: %s changed from %s to %s (profile %q still sets it to %s)", caller, source, setting.name, profileBase[setting.name], base, activeProfile, current)
+	profileBase[setting.name] = base
+	return true
+}
+
+// noteForegroundForProfiles is winEventProc's EVENT_SYSTEM_FOREGROUND
+// hook: have wndProc check the new foreground application. Only the
+// latest window matters, so one wakeup in flight is enough.
+func noteForegroundForProfiles(hwnd windows.Handle) {
+	if !profilesWantForeground.Load() || hwnd == 0 {
+		return
+	}
+	profileForegroundHwnd.Store(uintptr(hwnd))
+	if !profileForegroundPending.CompareAndSwap(false, true) {
+		return
+	}
+	main := loadMainMsgHwnd()
+	if main == 0 {
+		profileForegroundPending.Store(false)
+		return
+	}
+	if res := wincoe.PostMessage(main, WM_PROFILE_FOREGROUND, 0, 0); res.Failed() {
+		profileForegroundPending.Store(false) // the next foreground change retries
+		logf("noteForegroundForProfiles: PostMessage failed: %v", res.Err)
+	}
+}
+
+// handleProfileForeground is wndProc's WM_PROFILE_FOREGROUND handler:
+// switch to the profile the foreground application wants, or back to the
+// picked one. Our own windows (the tray menu, overlays) don't count.
+func handleProfileForeground() {
+	profileForegroundPending.Store(false)
+	hwnd := windows.Handle(profileForegroundHwnd.Load())
+	if !profilesWantForeground.Load() || hwnd == 0 || isOwnWindow(hwnd) {
+		return
+	}
+	exe := getProcessNameFast(getWindowPID(hwnd))
+	want := ""
+	if p, ok := settingsprofile.Match(profiles, exe); ok {
+		want = p.Name
+	}
+	if want == autoProfile {
+		return
+	}
+	logf("handleProfileForeground: %s is in the foreground; automatic profile %q -> %q", exe, autoProfile, want)
+	autoProfile = want
+	requestProfileSwitch()
+}
+
+// autoSwitchProfilesToggled follows autoSwitchProfiles being turned on or
+// off, from the tray or the settings file; turning it off drops the
+// foreground application's profile at the next requestProfileSwitch.
+func autoSwitchProfilesToggled() {
+	updateProfilesWantForeground()
+	if !autoSwitchProfiles.Load() {
+		autoProfile = ""
+	}
+}
+
+// appendProfilesMenu appends the tray's "Profile" submenu: no profile,
+// then names (see profileNames), then the automatic switching toggle.
+func appendProfilesMenu(hMenu windows.Handle, names []string) {
+	hSub, res := wincoe.CreatePopupMenu()
+	if res.Failed() {
+		logf("appendProfilesMenu: CreatePopupMenu failed: %v", res.Err)
+		return
+	}
+	var flags uint32 = wincoe.MF_STRING
+	if manualProfile == "" {
+		flags |= wincoe.MF_CHECKED
+	}
+	appendMenuChecked(hSub, flags, MENU_PROFILE_BASE, "No profile")
+	for i, name := range names {
+		flags = wincoe.MF_STRING
+		if name == manualProfile {
+			flags |= wincoe.MF_CHECKED
+		}
+		text := name
+		if p, _ := settingsprofile.Find(profiles, name); len(p.Exes) > 0 {
+			text += " (also while " + strings.Join(p.Exes, ", ") + " is in front)"
+		}
+		appendMenuChecked(hSub, flags, uintptr(MENU_PROFILE_BASE+1+i), text)
+	}
+	if len(names) == 0 {
+		appendMenuChecked(hSub, wincoe.MF_STRING|wincoe.MF_GRAYED, 0, "(add profiles to "+profilesFilePath+")")
+	}
+	appendMenuChecked(hSub, MF_SEPARATOR, 0, "")
+	flags = wincoe.MF_STRING
+	if autoSwitchProfiles.Load() {
+		flags |= wincoe.MF_CHECKED
+	}
+	appendMenuChecked(hSub, flags, MENU_TOGGLE_AUTO_SWITCH_PROFILES, "Switch automatically while a listed application is in front")
+
+	label := "Profile: none"
+	if activeProfile != "" {
+		label = "Profile: " + activeProfile
+	}
+	if autoProfile != "" && autoProfile == activeProfile {
+		label += " (automatic)"
+	}
+	appendMenuChecked(hMenu, wincoe.MF_STRING|MF_POPUP, uintptr(hSub), label)
+}
+
+// handleProfilesMenuCommand runs the tray command produced by
+// appendProfilesMenu, reporting whether cmd was one.
+func handleProfilesMenuCommand(cmd uint32, names []string) bool {
+	switch {
+	case cmd == MENU_TOGGLE_AUTO_SWITCH_PROFILES:
+		toggleAndPersist(&autoSwitchProfiles)
+		autoSwitchProfilesToggled()
+		requestProfileSwitch()
+	case cmd == MENU_PROFILE_BASE:
+		setManualProfile("")
+	case cmd > MENU_PROFILE_BASE && int(cmd) <= MENU_PROFILE_BASE+len(names):
+		setManualProfile(names[cmd-MENU_PROFILE_BASE-1])
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,185 @@
+//go:build windows && amd64
+
+package main
+
+import (
+	"slices"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/workturnedplay/wincoe"
+)
+
+/* ---------------- Raise every window of an application ---------------- */
+
+// Multi-window applications (GIMP's docks, an IDE's floating tool windows,
+// a mail client's message windows) otherwise have to be brought forward
+// one window at a time. Raising the application takes the window under
+// the cursor and brings every visible top-level window of the same
+// application to the front with it, keeping their Z-order relative to
+// each other, with the window under the cursor on top and activated.
+//
+// "The same application" is the same process, or the same explicit
+// AppUserModelID -- the ID the taskbar groups buttons by, which apps made
+// of several processes set so their windows group together.
+
+// raiseAppRestoresMinimized makes raising an application also un-minimize
+// its minimized windows. Off by default: minimized windows are left alone.
+// Persisted (see persistedSettings).
+var raiseAppRestoresMinimized atomic.Bool
+
+// PROPERTYKEY identifies a property in an IPropertyStore.
+type PROPERTYKEY struct {
+	Fmtid windows.GUID
+	Pid   uint32
+}
+
+// PROPVARIANT is IPropertyStore::GetValue's output, as far as it matters
+// here: the type tag and, for VT_LPWSTR, the string pointer in the union.
+type PROPVARIANT struct {
+	Vt         uint16
+	reserved1  uint16
+	reserved2  uint16
+	reserved3  uint16
+	Val        *uint16 // as VT_LPWSTR
+	valPadding uintptr
+}
+
+// VT_LPWSTR is the PROPVARIANT type of a CoTaskMemAlloc'd UTF-16 string.
+const VT_LPWSTR = 31
+
+// PKEY_AppUserModel_ID is the window property holding an explicit
+// AppUserModelID.
+var PKEY_AppUserModel_ID = PROPERTYKEY{
+	Fmtid: windows.GUID{Data1: 0x9F4C2855, Data2: 0x9F79, Data3: 0x4B39, Data4: [8]byte{0xA8, 0xD0, 0xE1, 0xD4, 0x2D, 0xE1, 0xD5, 0xF3}},
+	Pid:   5,
+}
+
+// IID_IPropertyStore is the interface SHGetPropertyStoreForWindow returns.
+var IID_IPropertyStore = windows.GUID{Data1: 0x886D8EEB, Data2: 0x8CF2, Data3: 0x4446, Data4: [8]byte{0x8D, 0x02, 0xCD, 0xBA, 0x1D, 0xBD, 0xCF, 0x99}}
+
+// iPropertyStore is an IPropertyStore COM object: a pointer to its vtable.
+type iPropertyStore struct {
+	vtbl *[8]uintptr
+}
+
+// IPropertyStore vtable slots used here (after IUnknown's three).
+const (
+	iPropertyStoreRelease  = 2
+	iPropertyStoreGetValue = 5
+)
+
+// windowAppUserModelID returns hwnd's explicit AppUserModelID, or "" if it
+// has none (most windows don't; the taskbar then groups by executable).
+func windowAppUserModelID(hwnd windows.Handle) string {
+	var store *iPropertyStore
+	if res := procSHGetPropertyStoreForWindow.Call(uintptr(hwnd), uintptr(unsafe.Pointer(&IID_IPropertyStore)), uintptr(unsafe.Pointer(&store))); res.Failed() || store == nil {
+		return ""
+	}
+	vtbl := store.vtbl
+	defer syscall.SyscallN(vtbl[iPropertyStoreRelease], uintptr(unsafe.Pointer(store)))
+
+	var pv PROPVARIANT
+	hr, _, _ := syscall.SyscallN(vtbl[iPropertyStoreGetValue], uintptr(unsafe.Pointer(store)),
+		uintptr(unsafe.Pointer(&PKEY_AppUserModel_ID)), uintptr(unsafe.Pointer(&pv)))
+	if int32(hr) < 0 || pv.Vt != VT_LPWSTR || pv.Val == nil {
+		return ""
+	}
+	id := windows.UTF16PtrToString(pv.Val)
+	windows.CoTaskMemFree(unsafe.Pointer(pv.Val)) // ours to free, as PropVariantClear would
+	return id
+}
+
+// appWindows returns the visible top-level windows of hwnd's application,
+// topmost first, hwnd included. Owned windows and tool windows count too:
+// they're exactly the docks and palettes this is for. Minimized windows
+// are included only if includeMinimized.
+func appWindows(hwnd windows.Handle, includeMinimized bool) []windows.Handle {
+	pid := getWindowPID(hwnd)
+	aumid := windowAppUserModelID(hwnd)
+	var out []windows.Handle
+	forEachTopLevelWindow(func(w windows.Handle) bool {
+		if w != hwnd {
+			if !wincoe.IsWindowVisible(w) || isWindowCloaked(w) || isOwnWindow(w) || isShellBackground(w) || windowRulesFor(w).Ignored() {
+				return true
+			}
+			if !includeMinimized && isMinimized(w) {
+				return true
+			}
+			if getWindowPID(w) != pid && (aumid == "" || windowAppUserModelID(w) != aumid) {
+				return true
+			}
+		}
+		out = append(out, w)
+		return true
+	})
+	return out
+}
+
+// raiseApp brings hwnd's application to the front (see the top of this
+// file) and activates hwnd. Main thread only.
+func raiseApp(hwnd windows.Handle) {
+	restore := raiseAppRestoresMinimized.Load()
+	stack := appWindows(hwnd, restore)
+	if i := slices.Index(stack, hwnd); i > 0 {
+		stack = slices.Insert(slices.Delete(stack, i, i+1), 0, hwnd)
+	}
+
+	restored := 0
+	for _, w := range stack {
+		if restore && isMinimized(w) {
+			showWindowAsync(w, windows.SW_SHOWNOACTIVATE)
+			restored++
+		}
+	}
+	for _, w := range stack {
+		journalStacking(w)
+	}
+	restackWindows("raiseApp", restackChain(wincoe.HWND_TOP, stack))
+	if !forceForeground(hwnd) {
+		logf("raiseApp: couldn't activate HWND=0x%X", hwnd)
+	}
+	logf("raiseApp: raised %d window(s) of HWND=0x%X %q (%s), un-minimized %d", len(stack), hwnd, getWindowTextFast(hwnd), getProcessNameFast(getWindowPID(hwnd)), restored)
+}
+
+// raiseAppUnderCursor is the raise-application hotkey's (and remote
+// command's) action: raise the application of the top-level window under
+// the mouse cursor.
+func raiseAppUnderCursor() {
+	var pt wincoe.POINT
+	if res := wincoe.GetCursorPos(&pt); res.Failed() {
+		logf("raiseAppUnderCursor: GetCursorPos failed: %v", res.Err)
+		return
+	}
+	hwnd, res := wincoe.RootWindowFromPoint(pt)
+	if hwnd == 0 || !isManageableTopLevelWindow(hwnd) {
+		logf("raiseAppUnderCursor: no application window under the cursor at (%d,%d) (HWND=0x%X, res: %v)", pt.X, pt.Y, hwnd, res)
+		return
+	}
+	raiseApp(hwnd)
+}
+
+// appendRaiseAppMenuItems appends the tray's raise-application option to
+// hMenu. The action itself is the hotkey's: from the tray there's no
+// window under the cursor to pick the application by.
+func appendRaiseAppMenuItems(hMenu windows.Handle) {
+	var flags uint32 = wincoe.MF_STRING
+	if raiseAppRestoresMinimized.Load() {
+		flags |= wincoe.MF_CHECKED
+	}
+	appendMenuChecked(hMenu, flags, MENU_TOGGLE_RAISE_APP_UNMINIMIZES,
+		"Raising all windows of an application also un-minimizes them"+hotkeyLabel(hotkeyRaiseApp))
+}
+
+// handleRaiseAppMenuCommand runs the tray command produced by
+// appendRaiseAppMenuItems, reporting whether cmd was one.
+func handleRaiseAppMenuCommand(cmd uint32) bool {
+	if cmd != MENU_TOGGLE_RAISE_APP_UNMINIMIZES {
+		return false
+	}
+	toggleAndPersist(&raiseAppRestoresMinimized)
+	return true
+}
@@ -0,0 +1,334 @@
+//go:build windows && amd64
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/workturnedplay/wincoe"
+
+	"github.com/workturnedplay/winbollocks/snapengine"
+)
+
+/* ---------------- Remote commands ---------------- */
+
+// Remote commands let a second invocation of the exe drive the
+// already-running instance instead of failing ensureSingleInstance:
+//
+//	winbollocks.exe -cmd save-layout Work
+//	winbollocks.exe -cmd restore-layout "Deep focus"
+//	winbollocks.exe -cmd rescue-windows
+//	winbollocks.exe -cmd tile master-stack
+//	winbollocks.exe -cmd pin
+//	winbollocks.exe -cmd raise-app
+//	winbollocks.exe -cmd center
+//	winbollocks.exe -cmd selection tile
+//	winbollocks.exe -cmd revert-all
+//	winbollocks.exe -cmd profile gaming
+//
+// The second process finds the running instance's hidden main message
+// window by class (see forwardRemoteCommandIfRequested), hands it the
+// command line via WM_COPYDATA, and exits with remoteCmdExit* telling
+// whether it was accepted. This is what makes actions like layouts
+// scriptable and bindable to whatever launcher/hotkey tool people already
+// use, without winbollocks growing a hotkey editor of its own.
+
+// WM_COPYDATA is the one cross-process message Windows marshals a payload
+// for; wincoe doesn't export it.
+const WM_COPYDATA = 0x004A
+
+// COPYDATASTRUCT is WM_COPYDATA's lParam.
+type COPYDATASTRUCT struct {
+	DwData uintptr
+	CbData uint32
+	LpData unsafe.Pointer
+}
+
+// remoteCommandCopyDataTag is COPYDATASTRUCT.DwData for our commands, so a
+// stray WM_COPYDATA from anything else (some accessibility tools broadcast
+// them) is rejected instead of being parsed as a command.
+const remoteCommandCopyDataTag uintptr = 0x77624D44 // "wbMD"
+
+// remoteCommandFlag introduces a remote command on the command line; every
+// argument after it belongs to the command.
+const remoteCommandFlag = "-cmd"
+
+// maxRemoteCommandBytes bounds the accepted WM_COPYDATA payload; real
+// commands are a few dozen bytes.
+const maxRemoteCommandBytes = 4096
+
+// remoteCommandTimeoutMs bounds how long the sending process waits for the
+// running instance to handle a command.
+const remoteCommandTimeoutMs = 10000
+
+// Exit codes of a command-forwarding invocation. Distinct from the main
+// app's own exitf codes (5 = "already running" etc.) so scripts can tell a
+// rejected command apart from a startup failure.
+const (
+	remoteCmdExitOK          = 0
+	remoteCmdExitNotRunning  = 20
+	remoteCmdExitSendFailed  = 21
+	remoteCmdExitRejected    = 22
+	remoteCmdExitBadCmdline  = 23
+	remoteCmdResultAccepted  = 1 // WM_COPYDATA reply: handled successfully
+	remoteCmdResultFailed    = 2 // WM_COPYDATA reply: known command, but it failed (details shown as a tray balloon)
+	remoteCmdResultUnknown   = 0 // WM_COPYDATA reply: not a command we know (also DefWindowProc's reply)
+	remoteCmdResultMalformed = 3 // WM_COPYDATA reply: payload wasn't ours or wasn't valid UTF-8
+)
+
+// remoteCommand is one entry of remoteCommands.
+type remoteCommand struct {
+	usage string
+	// run executes the command on the main thread with everything after
+	// the command name joined back into one space-separated string (so
+	// layout names with spaces work quoted or not).
+	run func(arg string) error
+}
+
+// remoteCommands maps a command name to its handler. Handlers run on the
+// main thread inside wndProc (see handleRemoteCommandCopyData), exactly
+// like tray menu actions, so they may do anything a tray action may.
+var remoteCommands = map[string]remoteCommand{
+	"save-layout": {
+		usage: "save-layout <name>",
+		run:   func(arg string) error { return saveLayoutAndNotify(arg) },
+	},
+	"restore-layout": {
+		usage: "restore-layout <name>",
+		run:   func(arg string) error { return restoreLayoutAndNotify(arg) },
+	},
+	"rescue-windows": {
+		usage: "rescue-windows",
+		run: func(arg string) error {
+			if arg != "" {
+				return fmt.Errorf("rescue-windows takes no arguments, got %q", arg)
+			}
+			rescueOffscreenWindowsAndNotify()
+			return nil
+		},
+	},
+	"tile": {
+		usage: "tile [columns|rows|master-stack|bsp]",
+		run:   func(arg string) error { return tileWindowsAndNotify(arg) },
+	},
+	"pin": {
+		usage: "pin",
+		run: func(arg string) error {
+			if arg != "" {
+				return fmt.Errorf("pin takes no arguments, got %q", arg)
+			}
+			togglePinUnderCursor()
+			return nil
+		},
+	},
+	"shade": {
+		usage: "shade",
+		run: func(arg string) error {
+			if arg != "" {
+				return fmt.Errorf("shade takes no arguments, got %q", arg)
+			}
+			toggleShadeUnderCursor()
+			return nil
+		},
+	},
+	"hide-to-tray": {
+		usage: "hide-to-tray",
+		run: func(arg string) error {
+			if arg != "" {
+				return fmt.Errorf("hide-to-tray takes no arguments, got %q", arg)
+			}
+			hideWindowUnderCursorToTray()
+			return nil
+		},
+	},
+	"raise-app": {
+		usage: "raise-app",
+		run: func(arg string) error {
+			if arg != "" {
+				return fmt.Errorf("raise-app takes no arguments, got %q", arg)
+			}
+			raiseAppUnderCursor()
+			return nil
+		},
+	},
+	"keep-at-bottom": {
+		usage: "keep-at-bottom",
+		run: func(arg string) error {
+			if arg != "" {
+				return fmt.Errorf("keep-at-bottom takes no arguments, got %q", arg)
+			}
+			toggleKeepAtBottomUnderCursor()
+			return nil
+		},
+	},
+	"center":                placementRemoteCommand("center", snapengine.PlaceCenter),
+	"maximize-vertically":   placementRemoteCommand("maximize-vertically", snapengine.PlaceFullHeight),
+	"maximize-horizontally": placementRemoteCommand("maximize-horizontally", snapengine.PlaceFullWidth),
+	"revert-all": {
+		usage: "revert-all",
+		run: func(arg string) error {
+			if arg != "" {
+				return fmt.Errorf("revert-all takes no arguments, got %q", arg)
+			}
+			revertJournalAndNotify()
+			return nil
+		},
+	},
+	"profile": {
+		usage: "profile <name>|none",
+		run:   func(arg string) error { return switchProfileAndNotify(arg) },
+	},
+	"selection": {
+		usage: "selection tile|minimize|send-to-back|clear",
+		run:   runSelectionAction,
+	},
+	"reload-rules": {
+		usage: "reload-rules",
+		run: func(arg string) error {
+			if arg != "" {
+				return fmt.Errorf("reload-rules takes no arguments, got %q", arg)
+			}
+			reloadRulesAndNotify()
+			return nil
+		},
+	},
+}
+
+// remoteCommandUsage lists every command, for error output.
+func remoteCommandUsage() string {
+	usages := make([]string, 0, len(remoteCommands))
+	for _, c := range remoteCommands {
+		usages = append(usages, "  "+selfName+".exe "+remoteCommandFlag+" "+c.usage)
+	}
+	sort.Strings(usages)
+	return strings.Join(usages, "\n")
+}
+
+// forwardRemoteCommandIfRequested checks os.Args for remoteCommandFlag and,
+// if present, forwards the command to the running instance and exits the
+// process -- it never returns in that case. Must run at the very top of
+// main(), before logging, the single-instance mutex or anything else that
+// only makes sense for the real app instance; for the same reason it
+// reports to stderr (visible when run from a console build) rather than
+// through logf, and must not touch the log file or the settings file.
+func forwardRemoteCommandIfRequested() {
+	idx := -1
+	for i, arg := range os.Args[1:] {
+		if arg == remoteCommandFlag || arg == "-"+remoteCommandFlag {
+			idx = i + 1
+			break
+		}
+	}
+	if idx < 0 {
+		return
+	}
+	words := os.Args[idx+1:]
+	if len(words) == 0 {
+		fmt.Fprintf(os.Stderr, "%s: %s needs a command, one of:\n%s\n", selfName, remoteCommandFlag, remoteCommandUsage())
+		os.Exit(remoteCmdExitBadCmdline)
+	}
+	if _, ok := remoteCommands[words[0]]; !ok {
+		fmt.Fprintf(os.Stderr, "%s: unknown command %q, expected one of:\n%s\n", selfName, words[0], remoteCommandUsage())
+		os.Exit(remoteCmdExitBadCmdline)
+	}
+	payload := []byte(strings.Join(words, " "))
+	if len(payload) > maxRemoteCommandBytes {
+		fmt.Fprintf(os.Stderr, "%s: command too long (%d bytes, max %d)\n", selfName, len(payload), maxRemoteCommandBytes)
+		os.Exit(remoteCmdExitBadCmdline)
+	}
+
+	classNameUTF16, err := windows.UTF16PtrFromString(winbollocksHiddenClassName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: UTF16PtrFromString failed: %v\n", selfName, err)
+		os.Exit(remoteCmdExitSendFailed)
+	}
+	res := procFindWindowW.Call(uintptr(unsafe.Pointer(classNameUTF16)), 0)
+	if res.Failed() {
+		fmt.Fprintf(os.Stderr, "%s: no running instance found to send %q to\n", selfName, words[0])
+		os.Exit(remoteCmdExitNotRunning)
+	}
+	target := windows.Handle(res.R1)
+
+	cds := COPYDATASTRUCT{
+		DwData: remoteCommandCopyDataTag,
+		CbData: uint32(len(payload)), // #nosec G115 -- bounded by maxRemoteCommandBytes above
+		LpData: unsafe.Pointer(&payload[0]),
+	}
+	var reply uintptr
+	if sendRes := wincoe.SendMessageTimeout(target, WM_COPYDATA, 0, uintptr(unsafe.Pointer(&cds)),
+		wincoe.SMTO_ABORTIFHUNG, remoteCommandTimeoutMs, &reply); sendRes.Failed() {
+		fmt.Fprintf(os.Stderr, "%s: sending %q to the running instance failed: %v\n", selfName, words[0], sendRes.Err)
+		os.Exit(remoteCmdExitSendFailed)
+	}
+	switch reply {
+	case remoteCmdResultAccepted:
+		os.Exit(remoteCmdExitOK)
+	case remoteCmdResultFailed:
+		fmt.Fprintf(os.Stderr, "%s: the running instance could not complete %q (see its tray notification/log)\n", selfName, words[0])
+	default:
+		fmt.Fprintf(os.Stderr, "%s: the running instance rejected %q (reply %d); is it an older version?\n", selfName, words[0], reply)
+	}
+	os.Exit(remoteCmdExitRejected)
+}
+
+// allowRemoteCommandsThroughUIPI lets WM_COPYDATA from lower-integrity
+// processes reach hwnd. Without it, a remote command launched from an
+// ordinary (medium-IL) shortcut is silently dropped by UIPI whenever
+// winbollocks itself runs elevated (see runasadmin.bat). Only WM_COPYDATA
+// is opened up, and handleRemoteCommandCopyData only ever accepts our own
+// tagged, size-bounded commands from it. Failure is logged and otherwise
+// harmless: commands still work between same-integrity processes.
+func allowRemoteCommandsThroughUIPI(hwnd windows.Handle) {
+	const MSGFLT_ALLOW = 1
+	if res := procChangeWindowMessageFilterEx.Call(uintptr(hwnd), WM_COPYDATA, MSGFLT_ALLOW, 0); res.Failed() {
+		logf("allowRemoteCommandsThroughUIPI: ChangeWindowMessageFilterEx failed: %v; remote commands from non-elevated processes won't reach an elevated instance", res.Err)
+	}
+}
+
+// handleRemoteCommandCopyData is wndProc's WM_COPYDATA handler: validates
+// the payload, runs the named command synchronously (the sender is blocked
+// in SendMessageTimeout for at most remoteCommandTimeoutMs meanwhile) and
+// returns one of the remoteCmdResult* replies.
+//
+// The payload is copied out of the COPYDATASTRUCT before use: its memory
+// is only valid for the duration of this message.
+func handleRemoteCommandCopyData(lParam uintptr) uintptr {
+	if lParam == 0 {
+		return remoteCmdResultMalformed
+	}
+	// Reinterpret the uintptr's own storage as a pointer rather than
+	// converting it with unsafe.Pointer(lParam), which vet's unsafeptr
+	// check rightly flags in general -- here lParam genuinely is a pointer
+	// USER32 owns and keeps valid for the duration of this message, the
+	// same situation as mouseProc's lParam.
+	cds := *(**COPYDATASTRUCT)(unsafe.Pointer(&lParam))
+	if cds.DwData != remoteCommandCopyDataTag || cds.CbData == 0 || cds.CbData > maxRemoteCommandBytes || cds.LpData == nil {
+		logf("handleRemoteCommandCopyData: ignoring WM_COPYDATA that isn't a remote command (tag=0x%X, %d bytes)", cds.DwData, cds.CbData)
+		return remoteCmdResultMalformed
+	}
+	text := string(unsafe.Slice((*byte)(cds.LpData), cds.CbData))
+	if !utf8.ValidString(text) {
+		logf("handleRemoteCommandCopyData: ignoring remote command that isn't valid UTF-8")
+		return remoteCmdResultMalformed
+	}
+
+	name, arg, _ := strings.Cut(text, " ")
+	cmd, ok := remoteCommands[name]
+	if !ok {
+		logf("handleRemoteCommandCopyData: unknown remote command %q", name)
+		return remoteCmdResultUnknown
+	}
+	logf("handleRemoteCommandCopyData: running remote command %q", text)
+	if err := cmd.run(strings.TrimSpace(arg)); err != nil {
+		logf("handleRemoteCommandCopyData: remote command %q failed: %v", text, err)
+		return remoteCmdResultFailed
+	}
+	return remoteCmdResultAccepted
+}
@@ -0,0 +1,197 @@
+//go:build windows && amd64
+
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/workturnedplay/wincoe"
+
+	"github.com/workturnedplay/winbollocks/offscreen"
+)
+
+/* ---------------- Off-screen window rescue ---------------- */
+
+// Windows restored onto a monitor that has since been disconnected (or
+// left half-way off the top of the screen after a resolution change) can't
+// be reached with the mouse at all. rescueOffscreenWindows finds every such
+// window and pulls it fully back onto the nearest monitor; the decision of
+// what counts as unreachable and where it goes lives in package offscreen.
+
+// rescueMinVisiblePercent and rescueMinGrabPx are offscreen.Config's
+// thresholds: less than half a window's frame on-screen, or less than
+// rescueMinGrabPx of its title bar, and it gets rescued.
+const (
+	rescueMinVisiblePercent = 50
+	rescueMinGrabPx         = 48
+)
+
+// SM_CYCAPTION is the GetSystemMetrics index of the standard caption
+// height, used as the title-bar strip height offscreen.NeedsRescue checks.
+const SM_CYCAPTION = 4
+
+// WPF_ASYNCWINDOWPLACEMENT makes SetWindowPlacement post rather than wait,
+// for the same reason the rest of this file uses SWP_ASYNCWINDOWPOS.
+const WPF_ASYNCWINDOWPLACEMENT = 0x0004
+
+// rescueWindowsAfterDisplayChange also runs rescueOffscreenWindows every
+// time a display change settles (see onTopologySettled), before any
+// per-monitor-setup layout is restored. Off by default: rescuing moves
+// windows the user didn't ask to move, and the hotkey/tray action covers
+// the occasional case.
+var rescueWindowsAfterDisplayChange atomic.Bool
+
+func rescueConfig() offscreen.Config {
+	bar := wincoe.GetSystemMetrics(SM_CYCAPTION)
+	if bar <= 0 {
+		bar = 23 // the 96-DPI default
+	}
+	return offscreen.Config{MinVisiblePercent: rescueMinVisiblePercent, TitleBarPx: bar, MinGrabPx: rescueMinGrabPx}
+}
+
+func toOffscreenRect(r wincoe.RECT) offscreen.Rect {
+	return offscreen.Rect{Left: r.Left, Top: r.Top, Right: r.Right, Bottom: r.Bottom}
+}
+
+// rescueOffscreenWindows moves every unreachable layout-eligible window
+// (see isManageableTopLevelWindow; owned dialogs follow their owner) back
+// onto the monitor whose work area it's nearest to, and returns how many
+// it moved. Main thread only.
+func rescueOffscreenWindows() int {
+	mons := enumMonitors()
+	if len(mons) == 0 {
+		logf("rescueOffscreenWindows: no monitors found; nothing to rescue onto")
+		return 0
+	}
+	works := make([]offscreen.Rect, len(mons))
+	for i, m := range mons {
+		works[i] = toOffscreenRect(m.rcWork)
+	}
+	cfg := rescueConfig()
+	wsDX, wsDY := primaryWorkspaceOffset()
+	moved := 0
+	forEachTopLevelWindow(func(hwnd windows.Handle) bool {
+		if isManageableTopLevelWindow(hwnd) && rescueWindow(hwnd, works, cfg, wsDX, wsDY) {
+			moved++
+		}
+		return true
+	})
+	logf("rescueOffscreenWindows: moved %d window(s)", moved)
+	return moved
+}
+
+// rescueWindow rescues hwnd if it needs it, reporting whether it moved it.
+//
+// A maximized window is left alone: Windows always keeps it filling some
+// current monitor. A minimized one has its restore position fixed in place
+// via SetWindowPlacement, so it comes back on-screen when un-minimized
+// instead of being un-minimized now. A normal one is judged by its visible
+// DWM frame and moved so that frame lands on-screen, keeping its invisible
+// resize borders around it.
+func rescueWindow(hwnd windows.Handle, works []offscreen.Rect, cfg offscreen.Config, wsDX, wsDY int32) bool {
+	var wp wincoe.WINDOWPLACEMENT
+	wp.Length = uint32(unsafe.Sizeof(wp))
+	if res := wincoe.GetWindowPlacement(hwnd, &wp); res.Failed() {
+		logf("rescueWindow: GetWindowPlacement failed for HWND=0x%X: %v", hwnd, res.Err)
+		return false
+	}
+	switch wp.ShowCmd {
+	case windows.SW_MAXIMIZE:
+		return false
+	case windows.SW_SHOWMINIMIZED:
+		n := wp.RcNormalPosition
+		from := offscreen.Rect{Left: n.Left + wsDX, Top: n.Top + wsDY, Right: n.Right + wsDX, Bottom: n.Bottom + wsDY}
+		if !offscreen.NeedsRescue(from, works, cfg) {
+			return false
+		}
+		to := offscreen.Rescue(from, works[offscreen.Nearest(from, works)])
+		wp.RcNormalPosition = wincoe.RECT{Left: to.Left - wsDX, Top: to.Top - wsDY, Right: to.Right - wsDX, Bottom: to.Bottom - wsDY}
+		wp.ShowCmd = windows.SW_SHOWMINNOACTIVE
+		wp.Flags = WPF_ASYNCWINDOWPLACEMENT
+		if res := procSetWindowPlacement.Call(uintptr(hwnd), uintptr(unsafe.Pointer(&wp))); res.Failed() {
+			logf("rescueWindow: SetWindowPlacement failed for minimized HWND=0x%X: %v", hwnd, res.Err)
+			return false
+		}
+		logf("rescueWindow: minimized HWND=0x%X %q will now restore at %+v instead of %+v", hwnd, getWindowTextFast(hwnd), to, from)
+		return true
+	}
+
+	var wr wincoe.RECT
+	if res := wincoe.GetWindowRect(hwnd, &wr); res.Failed() {
+		logf("rescueWindow: GetWindowRect failed for HWND=0x%X: %v", hwnd, res.Err)
+		return false
+	}
+	frame, err := wincoe.DwmGetExtendedFrameBounds(hwnd)
+	if err != nil {
+		frame = wr // no DWM frame (e.g. a borderless window): the window rect is what's visible
+	}
+	from := toOffscreenRect(frame)
+	if !offscreen.NeedsRescue(from, works, cfg) {
+		return false
+	}
+	to := offscreen.Rescue(from, works[offscreen.Nearest(from, works)])
+
+	recordWindowGeometry(hwnd, wsDX, wsDY)
+	// Put the invisible borders back around the new visible frame.
+	insetL, insetT := frame.Left-wr.Left, frame.Top-wr.Top
+	insetR, insetB := wr.Right-frame.Right, wr.Bottom-frame.Bottom
+	flags := uint32(wincoe.SWP_NOZORDER | wincoe.SWP_NOACTIVATE | wincoe.SWP_ASYNCWINDOWPOS)
+	if to.Width() == from.Width() && to.Height() == from.Height() {
+		flags |= wincoe.SWP_NOSIZE
+	}
+	if res := wincoe.SetWindowPos(hwnd, 0, to.Left-insetL, to.Top-insetT,
+		to.Width()+insetL+insetR, to.Height()+insetT+insetB, flags); res.Failed() {
+		logf("rescueWindow: SetWindowPos failed for HWND=0x%X: %v", hwnd, res.Err)
+		if res.ErrIs(windows.ERROR_ACCESS_DENIED) {
+			logf("rescueWindow: HWND=0x%X is likely elevated; run winbollocks as admin to rescue it", hwnd)
+		}
+		return false
+	}
+	logf("rescueWindow: moved HWND=0x%X %q from %+v to %+v", hwnd, getWindowTextFast(hwnd), from, to)
+	return true
+}
+
+// rescuedWindowsMessage is the tray balloon text for n rescued windows.
+func rescuedWindowsMessage(n int) string {
+	if n == 0 {
+		return "No off-screen windows found."
+	}
+	return fmt.Sprintf("Moved %d off-screen window(s) back onto a monitor.", n)
+}
+
+// rescueOffscreenWindowsAndNotify is the hotkey/tray/remote-command entry
+// point: rescue, then say how it went.
+func rescueOffscreenWindowsAndNotify() {
+	showTrayInfo(selfName, rescuedWindowsMessage(rescueOffscreenWindows()))
+}
+
+// appendRescueMenuItems appends the tray's rescue action and its
+// after-display-change toggle to hMenu.
+func appendRescueMenuItems(hMenu windows.Handle) {
+	appendMenuChecked(hMenu, wincoe.MF_STRING, MENU_RESCUE_OFFSCREEN_WINDOWS,
+		"Rescue off-screen windows"+hotkeyLabel(hotkeyRescueWindows))
+	var autoFlags uint32 = wincoe.MF_STRING
+	if rescueWindowsAfterDisplayChange.Load() {
+		autoFlags |= wincoe.MF_CHECKED
+	}
+	appendMenuChecked(hMenu, autoFlags, MENU_TOGGLE_RESCUE_AFTER_DISPLAY_CHANGE,
+		"    Also rescue them automatically whenever the monitor setup or resolution changes")
+}
+
+// handleRescueMenuCommand runs the tray command produced by
+// appendRescueMenuItems, reporting whether cmd was one.
+func handleRescueMenuCommand(cmd uint32) bool {
+	switch cmd {
+	case MENU_RESCUE_OFFSCREEN_WINDOWS:
+		rescueOffscreenWindowsAndNotify()
+	case MENU_TOGGLE_RESCUE_AFTER_DISPLAY_CHANGE:
+		toggleAndPersist(&rescueWindowsAfterDisplayChange)
+	default:
+		return false
+	}
+	return true
+}
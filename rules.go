@@ -0,0 +1,193 @@
+//go:build windows && amd64
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/workturnedplay/wincoe"
+
+	"github.com/workturnedplay/winbollocks/winrules"
+)
+
+/* ---------------- Per-application rules ---------------- */
+
+// rulesFile is the hand-written per-application rules file, in the
+// format documented by package winrules. Same working-directory-relative
+// convention as settingsFilePath, unless the "rulesFile" setting points
+// elsewhere (e.g. a file shared by a team). winbollocks never writes it.
+var rulesFile atomic.Pointer[string]
+
+// rulesFilePath returns the current rulesFile.
+func rulesFilePath() string { return *rulesFile.Load() }
+
+// validateRulesFile is the "rulesFile" setting's validation: anything
+// os.ReadFile could open, which rules out the empty name and the settings
+// file itself.
+func validateRulesFile(path string) error {
+	switch {
+	case path == "":
+		return errors.New("empty file name")
+	case strings.EqualFold(filepath.Clean(path), settingsFilePath):
+		return fmt.Errorf("%q is the settings file", path)
+	}
+	return nil
+}
+
+// overridableSetting pairs a global toggle with the rules-file key that
+// overrides it per window; the key is the setting's own name in the
+// settings file, so a rule reads exactly like the global line it refines.
+type overridableSetting struct {
+	key    string
+	global *atomic.Bool
+}
+
+// in returns the setting's effective value for a window with overrides ov.
+func (s overridableSetting) in(ov winrules.Overrides) bool {
+	return ov.Bool(s.key, s.global.Load())
+}
+
+// The settings a rule may override (besides winrules.Ignore): the ones that
+// are about how a particular window is treated, as opposed to how
+// winbollocks itself behaves.
+var (
+	ruleBypassGesturesWhenFullscreen  = overridableSetting{"bypassGesturesWhenFullscreen", &bypassGesturesWhenFullscreen}
+	ruleFocusOnDrag                   = overridableSetting{"focusOnDrag", &focusOnDrag}
+	ruleBringToFrontOnDrag            = overridableSetting{"bringToFrontOnDrag", &bringToFrontOnDrag}
+	ruleFocusOnResize                 = overridableSetting{"focusOnResize", &focusOnResize}
+	ruleBringToFrontOnResize          = overridableSetting{"bringToFrontOnResize", &bringToFrontOnResize}
+	ruleBringToFrontOnBackgroundClick = overridableSetting{"bringToFrontOnBackgroundClick", &bringToFrontOnBackgroundClick}
+	ruleSnapToEdges                   = overridableSetting{"snapToEdgesEnabled", &snapToEdgesEnabled}
+	ruleSnapToCenterLines             = overridableSetting{"snapToCenterLinesEnabled", &snapToCenterLinesEnabled}
+	ruleSnapToThirds                  = overridableSetting{"snapToThirdsEnabled", &snapToThirdsEnabled}
+	ruleAutoRaiseOnHover              = overridableSetting{"autoRaiseOnHover", &autoRaiseOnHover}
+	ruleMoveOwnedWindows              = overridableSetting{"moveOwnedWindowsWithOwner", &moveOwnedWindowsWithOwner}
+
+	overridableSettings = []overridableSetting{
+		ruleBypassGesturesWhenFullscreen, ruleFocusOnDrag, ruleBringToFrontOnDrag, ruleFocusOnResize,
+		ruleBringToFrontOnResize, ruleBringToFrontOnBackgroundClick, ruleSnapToEdges, ruleSnapToCenterLines, ruleSnapToThirds,
+		ruleAutoRaiseOnHover, ruleMoveOwnedWindows,
+	}
+)
+
+// ruleKeepAtBottom is a rule-only key, with no global setting behind it:
+// keepAtBottom = true marks the windows a rule matches (see keepbottom.go).
+const ruleKeepAtBottom = "keepAtBottom"
+
+// activeRules is the rule set currently in force, swapped wholesale by
+// loadRules; nil (no rules) until the first load. Read from the hook
+// thread at gesture start and from the main thread.
+var activeRules atomic.Pointer[winrules.Engine]
+
+// loadRules (re)reads rulesFilePath and puts it in force, returning the
+// number of rules loaded. A missing file means no rules; problems in the
+// file are logged line by line (see winrules.Parse) and cost only the
+// affected rule or line.
+func loadRules() int {
+	data, err := os.ReadFile(rulesFilePath()) //nolint:gosec // G304: rulesFilePath() comes from our own settings file, never from network input
+	if err != nil && !os.IsNotExist(err) {
+		logf("loadRules: failed to read %q: %v; keeping the previous rules", rulesFilePath(), err)
+		return activeRules.Load().Len()
+	}
+	keys := make([]string, len(overridableSettings), len(overridableSettings)+1)
+	for i, s := range overridableSettings {
+		keys[i] = s.key
+	}
+	keys = append(keys, ruleKeepAtBottom)
+	rules, errs := winrules.Parse(data, keys)
+	for _, e := range errs {
+		logf("loadRules: %q: %v", rulesFilePath(), e)
+	}
+	activeRules.Store(winrules.NewEngine(rules, winrules.DefaultCacheSize))
+	ruleWindowInfoMu.Lock()
+	clear(ruleWindowInfoCache)
+	ruleWindowInfoMu.Unlock()
+	if len(rules) > 0 || len(errs) > 0 {
+		logf("loadRules: %d rule(s) in force from %q", len(rules), rulesFilePath())
+	}
+	return len(rules)
+}
+
+// ruleWindowInfo is the part of a winrules.Window that can't change over a
+// window's lifetime, cached per (HWND, PID) so a gesture start costs no
+// OpenProcess/token query for a window seen before. Keyed on the pair
+// rather than the HWND alone so a recycled HWND value in another process
+// can never inherit the old window's exe.
+type (
+	ruleWindowKey struct {
+		hwnd windows.Handle
+		pid  uint32
+	}
+	ruleWindowInfo struct {
+		exe, class string
+		integrity  winrules.Integrity
+	}
+)
+
+// maxRuleWindowInfoCache bounds ruleWindowInfoCache; it's emptied when full.
+const maxRuleWindowInfoCache = 512
+
+var (
+	ruleWindowInfoMu    sync.Mutex
+	ruleWindowInfoCache = map[ruleWindowKey]ruleWindowInfo{}
+)
+
+// ruleWindowFor gathers what rules match hwnd on. The title is always read
+// fresh (it's the one attribute that changes, and InternalGetWindowText is
+// a cheap kernel read, see getWindowTextFast).
+func ruleWindowFor(hwnd windows.Handle) winrules.Window {
+	pid := getWindowPID(hwnd)
+	key := ruleWindowKey{hwnd, pid}
+	ruleWindowInfoMu.Lock()
+	info, ok := ruleWindowInfoCache[key]
+	ruleWindowInfoMu.Unlock()
+	if !ok {
+		info.exe = getProcessNameFast(pid)
+		info.class, _ = wincoe.GetClassName(hwnd)
+		if rid, err := processIntegrityLevel(pid); err == nil {
+			info.integrity = winrules.IntegrityFromRID(rid)
+		}
+		ruleWindowInfoMu.Lock()
+		if len(ruleWindowInfoCache) >= maxRuleWindowInfoCache {
+			clear(ruleWindowInfoCache)
+		}
+		ruleWindowInfoCache[key] = info
+		ruleWindowInfoMu.Unlock()
+	}
+	return winrules.Window{Exe: info.exe, Class: info.class, Title: getWindowTextFast(hwnd), Integrity: info.integrity}
+}
+
+// windowRulesFor returns the rule overrides for top-level window hwnd.
+// With no rules loaded (the common case) it returns immediately without
+// touching hwnd at all, so the gesture-start paths on the hook thread pay
+// nothing for this feature unless it's used.
+func windowRulesFor(hwnd windows.Handle) winrules.Overrides {
+	engine := activeRules.Load()
+	if engine.Len() == 0 || hwnd == 0 {
+		return winrules.Overrides{}
+	}
+	return engine.Evaluate(ruleWindowFor(hwnd))
+}
+
+// reloadRules reloads the rules and re-evaluates the keep-at-bottom marks
+// they make; returns how many rules are in force. Also rulesFile's
+// onChange, so editing the settings file does what the tray does.
+func reloadRules() int {
+	n := loadRules()
+	applyKeepAtBottomRules()
+	return n
+}
+
+// reloadRulesAndNotify is the tray/remote-command entry point.
+func reloadRulesAndNotify() {
+	n := reloadRules()
+	showTrayInfo(selfName, fmt.Sprintf("Loaded %d rule(s) from %s (problems, if any, are in the log).", n, rulesFilePath()))
+}
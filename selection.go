@@ -0,0 +1,631 @@
+//go:build windows && amd64
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/workturnedplay/wincoe"
+
+	"github.com/workturnedplay/winbollocks/winselect"
+)
+
+/* ---------------- Rubber-band selection ---------------- */
+
+// Win+Ctrl+LMB dragged from anywhere draws a selection band, and every
+// window it touches becomes selected (with Shift held too, added to the
+// selection); a click without dragging selects just the window under it.
+// Selected windows get a highlight frame. A Win+LMB drag on any selected
+// window then moves the whole selection along with it (as followers, like
+// owned windows -- see owned.go), and the tray's Selection submenu, or
+// -cmd selection, tiles, minimizes or sends to back all of it at once. A
+// band that touches nothing, or Clear, ends the selection; windows that
+// close drop out of it.
+//
+// The geometry and the set itself are package winselect's. mouseProc only
+// records the band (beginSelectionBand and friends) and posts
+// WM_SELECTION_BAND, at most one in flight, like noteHoverMove does; the
+// main thread draws the band and makes the selection.
+
+// maxSelectedWindows bounds the selection; every member has a highlight
+// window of its own.
+const maxSelectedWindows = 64
+
+// selectionTimerMs is how often highlights follow their windows. Only runs
+// while something is selected.
+const selectionTimerMs = 100
+
+// winbollocksSelectionClassName is the window class of the band and the
+// highlights.
+const winbollocksSelectionClassName = selfName + "SelectionClass"
+
+// Band and highlight looks. Colors are COLORREFs (0x00BBGGRR).
+const (
+	selectionColor             uint32 = 0x00D77800 // the usual Windows accent blue
+	selectionKeyColor          uint32 = 0x00FF00FF // magenta; LWA_COLORKEY makes a highlight's inside see-through
+	selectionBandAlpha                = 70
+	selectionHighlightAlpha           = 200
+	selectionHighlightBorderPx        = 3
+)
+
+// errEmptySelection is returned by selection actions when nothing is
+// selected (or nothing selected is shown).
+var errEmptySelection = errors.New("no windows are selected (select some with a winkey+Ctrl+LMB drag)")
+
+// selectionBand is the band being drawn: written by mouseProc on the hook
+// thread, read by the main thread, hence the mutex. finished means the
+// band was released and the main thread hasn't made its selection yet.
+var (
+	selectionBandMu sync.Mutex
+	selectionBand   struct {
+		active, finished, adding bool
+		anchor, cur              winselect.Point
+	}
+	selectionBandPending atomic.Bool
+)
+
+// selected is the selection: written on the main thread, read from the
+// hook thread when a drag starts (appendSelectionFollowers), hence the
+// mutex.
+var (
+	selectedMu sync.Mutex
+	selected   winselect.Set[windows.Handle]
+)
+
+// selectionHighlight is one selected window's highlight frame.
+type selectionHighlight struct {
+	hwnd  windows.Handle // 0 if it couldn't be created; the window is still selected
+	at    wincoe.RECT    // where it was last put, to skip redundant SetWindowPos
+	shown bool
+}
+
+// Band and highlight windows. Main thread only.
+var (
+	selectionHighlights       = map[windows.Handle]*selectionHighlight{} // by selected window
+	selectionBandHwnd         windows.Handle
+	selectionClassRegistered  bool
+	selectionBrush            windows.Handle
+	selectionKeyBrush         windows.Handle
+	selectionHighlightTimerOn bool
+)
+
+// beginSelectionBand starts a band at pt; mouseProc's Win+Ctrl+LMB down.
+func beginSelectionBand(pt wincoe.POINT, adding bool) {
+	p := winselect.Point{X: pt.X, Y: pt.Y}
+	selectionBandMu.Lock()
+	selectionBand.active, selectionBand.finished, selectionBand.adding = true, false, adding
+	selectionBand.anchor, selectionBand.cur = p, p
+	selectionBandMu.Unlock()
+	postSelectionBand()
+}
+
+// updateSelectionBand stretches the band to pt, reporting whether one is
+// being drawn; mouseProc's mouse move.
+func updateSelectionBand(pt wincoe.POINT) bool {
+	selectionBandMu.Lock()
+	active := selectionBand.active
+	if active {
+		selectionBand.cur = winselect.Point{X: pt.X, Y: pt.Y}
+	}
+	selectionBandMu.Unlock()
+	if active {
+		postSelectionBand()
+	}
+	return active
+}
+
+// endSelectionBand releases the band at pt for the main thread to select
+// with, reporting whether one was being drawn; mouseProc's LMB up.
+func endSelectionBand(pt wincoe.POINT) bool {
+	selectionBandMu.Lock()
+	active := selectionBand.active
+	if active {
+		selectionBand.active, selectionBand.finished = false, true
+		selectionBand.cur = winselect.Point{X: pt.X, Y: pt.Y}
+	}
+	selectionBandMu.Unlock()
+	if active {
+		postSelectionBand()
+	}
+	return active
+}
+
+// cancelSelectionBand drops the band without selecting anything,
+// reporting whether one was being drawn; ESC (see
+// tryCancelActiveGestureViaEsc).
+func cancelSelectionBand() bool {
+	selectionBandMu.Lock()
+	active := selectionBand.active
+	selectionBand.active, selectionBand.finished = false, false
+	selectionBandMu.Unlock()
+	if active {
+		postSelectionBand()
+	}
+	return active
+}
+
+// postSelectionBand wakes the main thread (handleSelectionBand) unless a
+// wakeup is already in flight.
+func postSelectionBand() {
+	if !selectionBandPending.CompareAndSwap(false, true) {
+		return
+	}
+	main := loadMainMsgHwnd()
+	if main == 0 {
+		selectionBandPending.Store(false)
+		return
+	}
+	if res := wincoe.PostMessage(main, WM_SELECTION_BAND, 0, 0); res.Failed() {
+		selectionBandPending.Store(false) // the next band update retries
+		logf("postSelectionBand: PostMessage failed: %v", res.Err)
+	}
+}
+
+// handleSelectionBand is wndProc's WM_SELECTION_BAND handler: draw the
+// band where it is now, or, once it's released, hide it and select.
+func handleSelectionBand() {
+	selectionBandPending.Store(false)
+	selectionBandMu.Lock()
+	band := selectionBand
+	selectionBand.finished = false
+	selectionBandMu.Unlock()
+
+	rect := winselect.Band(band.anchor, band.cur)
+	if band.active {
+		showSelectionBand(rect)
+		return
+	}
+	if selectionBandHwnd != 0 {
+		_ = wincoe.SetWindowPos(selectionBandHwnd, 0, 0, 0, 0, 0,
+			wincoe.SWP_NOMOVE|wincoe.SWP_NOSIZE|wincoe.SWP_NOZORDER|wincoe.SWP_NOACTIVATE|wincoe.SWP_HIDEWINDOW)
+	}
+	if band.finished {
+		selectWithBand(rect, band.adding)
+	}
+}
+
+// showSelectionBand puts the band window over rect, creating it first if
+// need be.
+func showSelectionBand(rect winselect.Rect) {
+	if selectionBandHwnd == 0 {
+		if selectionBandHwnd = createSelectionWindow(false); selectionBandHwnd == 0 {
+			return // logged; the selection still works, just unseen until released
+		}
+	}
+	if res := wincoe.SetWindowPos(selectionBandHwnd, wincoe.HWND_TOPMOST, rect.Left, rect.Top, rect.Right-rect.Left, rect.Bottom-rect.Top,
+		wincoe.SWP_NOACTIVATE|wincoe.SWP_SHOWWINDOW); res.Failed() {
+		logf("showSelectionBand: SetWindowPos failed: %v", res.Err)
+	}
+}
+
+// visibleFrame is hwnd's visible frame, or its window rect when it has no
+// DWM frame (e.g. a borderless window).
+func visibleFrame(hwnd windows.Handle) (wincoe.RECT, bool) {
+	frame, err := wincoe.DwmGetExtendedFrameBounds(hwnd)
+	if err != nil {
+		if res := wincoe.GetWindowRect(hwnd, &frame); res.Failed() {
+			return wincoe.RECT{}, false
+		}
+	}
+	return frame, true
+}
+
+// selectWithBand selects the windows band touches (see winselect.Touched),
+// replacing the selection, or adding to it if adding.
+func selectWithBand(band winselect.Rect, adding bool) {
+	var candidates []winselect.Window[windows.Handle]
+	forEachTopLevelWindow(func(hwnd windows.Handle) bool {
+		if !isManageableTopLevelWindow(hwnd) || isMinimized(hwnd) {
+			return true
+		}
+		if f, ok := visibleFrame(hwnd); ok {
+			candidates = append(candidates, winselect.Window[windows.Handle]{
+				ID: hwnd, Frame: winselect.Rect{Left: f.Left, Top: f.Top, Right: f.Right, Bottom: f.Bottom},
+			})
+		}
+		return true
+	})
+	touched := winselect.Touched(band, candidates)
+
+	selectedMu.Lock()
+	if adding {
+		selected.Add(touched)
+	} else {
+		selected.Replace(touched)
+	}
+	if selected.Len() > maxSelectedWindows {
+		selected.Replace(selected.IDs()[:maxSelectedWindows])
+		logf("selectWithBand: keeping only the first %d selected windows", maxSelectedWindows)
+	}
+	n := selected.Len()
+	selectedMu.Unlock()
+
+	logf("selectWithBand: band (%d,%d)-(%d,%d) touched %d window(s), adding=%v; %d selected",
+		band.Left, band.Top, band.Right, band.Bottom, len(touched), adding, n)
+	updateSelectionHighlights()
+}
+
+// clearSelection deselects everything.
+func clearSelection() {
+	selectedMu.Lock()
+	selected.Clear()
+	selectedMu.Unlock()
+	updateSelectionHighlights()
+}
+
+// appendSelectionFollowers adds the rest of the selection to followers
+// when hwnd, about to be dragged from rect r, is selected; see
+// startManualDrag. Runs on the hook thread, reading window state only.
+// Windows already following (as owned windows) aren't added twice.
+func appendSelectionFollowers(followers []follower, hwnd windows.Handle, r wincoe.RECT) []follower {
+	selectedMu.Lock()
+	var ids []windows.Handle
+	if selected.Contains(hwnd) {
+		ids = selected.IDs()
+	}
+	selectedMu.Unlock()
+	if len(ids) < 2 {
+		return followers
+	}
+	members := make([]winselect.Window[windows.Handle], 0, len(ids))
+	for _, id := range ids {
+		if id == hwnd || !wincoe.IsWindowVisible(id) || isMinimized(id) ||
+			slices.ContainsFunc(followers, func(f follower) bool { return f.hwnd == id }) {
+			continue
+		}
+		var wr wincoe.RECT
+		if res := wincoe.GetWindowRect(id, &wr); res.Failed() {
+			continue
+		}
+		members = append(members, winselect.Window[windows.Handle]{
+			ID: id, Frame: winselect.Rect{Left: wr.Left, Top: wr.Top, Right: wr.Right, Bottom: wr.Bottom},
+		})
+	}
+	anchor := winselect.Window[windows.Handle]{ID: hwnd, Frame: winselect.Rect{Left: r.Left, Top: r.Top, Right: r.Right, Bottom: r.Bottom}}
+	for _, o := range winselect.Offsets(anchor, members) {
+		followers = append(followers, follower{hwnd: o.ID, dx: o.DX, dy: o.DY})
+	}
+	return followers
+}
+
+// updateSelectionHighlights drops windows that are gone from the
+// selection and puts every remaining highlight on its window, directly
+// above it in the Z-order, hiding it while the window is minimized, hidden
+// or cloaked, and during gestures (a dragged selection would leave its
+// highlights trailing behind). Starts and stops the refresh timer as the
+// selection fills and empties. Main thread only.
+func updateSelectionHighlights() {
+	selectedMu.Lock()
+	gone := selected.Prune(func(hwnd windows.Handle) bool { return wincoe.IsWindow(hwnd) })
+	ids := selected.IDs()
+	selectedMu.Unlock()
+	for _, hwnd := range gone {
+		logf("updateSelectionHighlights: HWND=0x%X is gone; deselected", hwnd)
+	}
+	for hwnd, hl := range selectionHighlights {
+		if !slices.Contains(ids, hwnd) {
+			if hl.hwnd != 0 {
+				_ = wincoe.DestroyWindow(hl.hwnd)
+			}
+			delete(selectionHighlights, hwnd)
+		}
+	}
+	setSelectionHighlightTimer(len(ids) > 0)
+
+	gesture := activeSession.Load() != nil
+	for _, hwnd := range ids {
+		hl := selectionHighlights[hwnd]
+		if hl == nil {
+			hl = &selectionHighlight{hwnd: createSelectionWindow(true)}
+			selectionHighlights[hwnd] = hl
+		}
+		if hl.hwnd == 0 {
+			continue
+		}
+		if gesture || !wincoe.IsWindowVisible(hwnd) || isWindowCloaked(hwnd) || isMinimized(hwnd) {
+			if hl.shown {
+				_ = wincoe.SetWindowPos(hl.hwnd, 0, 0, 0, 0, 0,
+					wincoe.SWP_NOMOVE|wincoe.SWP_NOSIZE|wincoe.SWP_NOZORDER|wincoe.SWP_NOACTIVATE|wincoe.SWP_HIDEWINDOW)
+				hl.shown = false
+			}
+			continue
+		}
+		at, ok := visibleFrame(hwnd)
+		if !ok {
+			continue
+		}
+		var flags uint32 = wincoe.SWP_NOACTIVATE | wincoe.SWP_SHOWWINDOW
+		var insertAfter windows.Handle
+		prev := windows.Handle(wincoe.GetWindow(hwnd, wincoe.GW_HWNDPREV).R1)
+		switch {
+		case prev == hl.hwnd:
+			flags |= wincoe.SWP_NOZORDER // already directly above its window
+			if hl.shown && at == hl.at {
+				continue
+			}
+		case isWindowTopmost(hwnd):
+			insertAfter = wincoe.HWND_TOPMOST
+		case prev == 0 || isWindowTopmost(prev):
+			insertAfter = wincoe.HWND_NOTOPMOST // the top of the non-topmost windows
+		default:
+			insertAfter = prev
+		}
+		if res := wincoe.SetWindowPos(hl.hwnd, insertAfter, at.Left, at.Top, at.Right-at.Left, at.Bottom-at.Top, flags); res.Failed() {
+			logf("updateSelectionHighlights: SetWindowPos failed for highlight HWND=0x%X: %v", hl.hwnd, res.Err)
+			continue
+		}
+		if at.Right-at.Left != hl.at.Right-hl.at.Left || at.Bottom-at.Top != hl.at.Bottom-hl.at.Top {
+			_ = wincoe.InvalidateRect(hl.hwnd, nil, false) // the frame is drawn to size
+		}
+		hl.at, hl.shown = at, true
+	}
+}
+
+// setSelectionHighlightTimer starts or stops the highlight refresh timer.
+func setSelectionHighlightTimer(on bool) {
+	if on == selectionHighlightTimerOn {
+		return
+	}
+	if on {
+		if _, res := wincoe.SetTimer(loadMainMsgHwnd(), selectionTimerID, selectionTimerMs, 0); res.Failed() {
+			logf("setSelectionHighlightTimer: SetTimer failed: %v; highlights won't follow their windows", res.Err)
+			return
+		}
+	} else if res := wincoe.KillTimer(loadMainMsgHwnd(), selectionTimerID); res.Failed() {
+		logf("setSelectionHighlightTimer: KillTimer failed: %v", res.Err)
+	}
+	selectionHighlightTimerOn = on
+}
+
+// createSelectionWindow creates the (hidden) band window, or a highlight
+// if highlight, registering the class and creating the brushes on first
+// use. Returns 0 on failure, logged.
+func createSelectionWindow(highlight bool) windows.Handle {
+	className := mustUTF16(winbollocksSelectionClassName)
+	if !selectionClassRegistered {
+		var wc wincoe.WNDCLASSEX
+		wc.CbSize = uint32(unsafe.Sizeof(wc))
+		wc.LpfnWndProc = windows.NewCallback(selectionWndProc) // once per process: registration happens at most once
+		wc.LpszClassName = className
+		wc.HInstance = selfHInstance
+		if res := wincoe.RegisterClassEx(&wc); res.Failed() {
+			logf("createSelectionWindow: RegisterClassEx failed: %v; the selection won't be shown", res.Err)
+			return 0
+		}
+		selectionClassRegistered = true
+	}
+	for _, b := range []struct {
+		brush *windows.Handle
+		color uint32
+	}{{&selectionBrush, selectionColor}, {&selectionKeyBrush, selectionKeyColor}} {
+		if *b.brush != 0 {
+			continue
+		}
+		brush, res := wincoe.GdiCreateSolidBrush(b.color)
+		if res.Failed() {
+			logf("createSelectionWindow: CreateSolidBrush failed: %v; the selection won't be shown", res.Err)
+			return 0
+		}
+		*b.brush = brush
+	}
+	res := wincoe.CreateWindowEx(
+		wincoe.WS_EX_LAYERED|wincoe.WS_EX_TRANSPARENT|wincoe.WS_EX_TOOLWINDOW|wincoe.WS_EX_NOACTIVATE,
+		className, nil, wincoe.WS_POPUP,
+		0, 0, 1, 1, // positioned by showSelectionBand/updateSelectionHighlights
+		0, 0, selfHInstance, nil,
+	)
+	if res.Failed() {
+		logf("createSelectionWindow: CreateWindowEx failed: %v", res.Err)
+		return 0
+	}
+	hwnd := windows.Handle(res.R1)
+	r := wincoe.SetLayeredWindowAttributes(hwnd, 0, selectionBandAlpha, wincoe.LWA_ALPHA)
+	if highlight {
+		r = wincoe.SetLayeredWindowAttributes(hwnd, selectionKeyColor, selectionHighlightAlpha, wincoe.LWA_COLORKEY|wincoe.LWA_ALPHA)
+	}
+	if r.Failed() {
+		logf("createSelectionWindow: SetLayeredWindowAttributes failed for HWND=0x%X: %v; it may stay invisible", hwnd, r.Err)
+	}
+	return hwnd
+}
+
+// selectionWndProc paints the band (a translucent fill) and the highlights
+// (a frame around a see-through inside). Clicks go straight through both
+// (WS_EX_TRANSPARENT).
+func selectionWndProc(hwnd windows.Handle, msg uint32, wParam, lParam uintptr) uintptr {
+	if msg == wincoe.WM_PAINT {
+		var ps wincoe.PAINTSTRUCT
+		hdc, res := wincoe.BeginPaint(hwnd, &ps)
+		if res.Failed() {
+			return 0
+		}
+		defer wincoe.EndPaint(hwnd, &ps)
+		var rect wincoe.RECT
+		if res := wincoe.GetClientRect(hwnd, &rect); res.Succeeded() {
+			_ = wincoe.FillRect(hdc, &rect, selectionBrush)
+			if hwnd != selectionBandHwnd {
+				inside := wincoe.RECT{
+					Left: rect.Left + selectionHighlightBorderPx, Top: rect.Top + selectionHighlightBorderPx,
+					Right: rect.Right - selectionHighlightBorderPx, Bottom: rect.Bottom - selectionHighlightBorderPx,
+				}
+				if inside.Right > inside.Left && inside.Bottom > inside.Top {
+					_ = wincoe.FillRect(hdc, &inside, selectionKeyBrush)
+				}
+			}
+		}
+		return 0
+	}
+	return wincoe.DefWindowProc(hwnd, msg, wParam, lParam).R1
+}
+
+// selectedWindowsInZOrder returns the selected windows that are shown,
+// topmost first, leaving minimized ones out unless includeMinimized.
+func selectedWindowsInZOrder(includeMinimized bool) []windows.Handle {
+	selectedMu.Lock()
+	ids := selected.IDs()
+	selectedMu.Unlock()
+	var out []windows.Handle
+	forEachTopLevelWindow(func(hwnd windows.Handle) bool {
+		if slices.Contains(ids, hwnd) && wincoe.IsWindowVisible(hwnd) && (includeMinimized || !isMinimized(hwnd)) {
+			out = append(out, hwnd)
+		}
+		return len(out) < len(ids)
+	})
+	return out
+}
+
+// tileSelection tiles the selected windows on the monitor of the topmost
+// one, with that monitor's next algorithm (see nextTilingAlgorithm), and
+// returns how many it placed.
+func tileSelection() (int, error) {
+	hwnds := selectedWindowsInZOrder(false)
+	if len(hwnds) == 0 {
+		return 0, errEmptySelection
+	}
+	hMon := wincoe.MonitorFromWindow(hwnds[0], wincoe.MONITOR_DEFAULTTONEAREST)
+	mons := enumMonitors()
+	at := slices.IndexFunc(mons, func(m monitor) bool { return m.hMon == hMon })
+	if at < 0 {
+		return 0, fmt.Errorf("couldn't find the monitor of HWND=0x%X", hwnds[0])
+	}
+	maximized := make([]bool, len(hwnds))
+	for i, hwnd := range hwnds {
+		maximized[i] = isMaximized(hwnd)
+	}
+	return placeTiles(nextTilingAlgorithm(hMon), mons[at], hwnds, maximized, "tileSelection"), nil
+}
+
+// minimizeSelection minimizes every selected window, returning how many.
+func minimizeSelection() (int, error) {
+	hwnds := selectedWindowsInZOrder(false)
+	if len(hwnds) == 0 {
+		return 0, errEmptySelection
+	}
+	for _, hwnd := range hwnds {
+		showWindowAsync(hwnd, windows.SW_MINIMIZE)
+	}
+	return len(hwnds), nil
+}
+
+// sendSelectionToBack sends every selected window to HWND_BOTTOM, topmost
+// first, so they keep their order among themselves at the bottom. Like
+// keep-at-bottom's sink, it neither moves focus nor records
+// sentToBackStack entries. Returns how many were sent. Main thread only.
+func sendSelectionToBack() (int, error) {
+	hwnds := selectedWindowsInZOrder(false)
+	if len(hwnds) == 0 {
+		return 0, errEmptySelection
+	}
+	for _, hwnd := range hwnds {
+		applyZOrderChangeNow(WindowMoveData{
+			Hwnd:         hwnd,
+			InsertAfter:  wincoe.HWND_BOTTOM,
+			Flags:        wincoe.SWP_NOMOVE | wincoe.SWP_NOSIZE | wincoe.SWP_NOACTIVATE,
+			ZOrderAction: zOrderActionSendToBack,
+		})
+	}
+	return len(hwnds), nil
+}
+
+// runSelectionAction is the tray/remote-command entry point for the
+// selection-wide actions: action is tile, minimize, send-to-back or clear.
+func runSelectionAction(action string) error {
+	var (
+		n   int
+		err error
+	)
+	switch action {
+	case "tile":
+		n, err = tileSelection()
+	case "minimize":
+		n, err = minimizeSelection()
+	case "send-to-back":
+		n, err = sendSelectionToBack()
+	case "clear":
+		clearSelection()
+		logf("runSelectionAction: selection cleared")
+		return nil
+	default:
+		return fmt.Errorf("unknown selection action %q (want tile, minimize, send-to-back or clear)", action)
+	}
+	if err != nil {
+		logf("runSelectionAction: %s: %v", action, err)
+		showTrayInfo(selfName, fmt.Sprintf("Failed to %s the selection: %v", action, err))
+		return err
+	}
+	logf("runSelectionAction: %s: %d window(s)", action, n)
+	return nil
+}
+
+// appendSelectionMenu appends the tray's "Selection" submenu of
+// selection-wide actions, or a grayed hint when nothing is selected.
+func appendSelectionMenu(hMenu windows.Handle) {
+	selectedMu.Lock()
+	n := selected.Len()
+	selectedMu.Unlock()
+	if n == 0 {
+		appendMenuChecked(hMenu, wincoe.MF_STRING|wincoe.MF_GRAYED, 0, "Selection: none\tWin+Ctrl+LMB drag")
+		return
+	}
+	hSub, res := wincoe.CreatePopupMenu()
+	if res.Failed() {
+		logf("appendSelectionMenu: CreatePopupMenu failed: %v", res.Err)
+		return
+	}
+	appendMenuChecked(hSub, wincoe.MF_STRING, MENU_SELECTION_TILE, "Tile them on the topmost one's monitor")
+	appendMenuChecked(hSub, wincoe.MF_STRING, MENU_SELECTION_MINIMIZE, "Minimize them")
+	appendMenuChecked(hSub, wincoe.MF_STRING, MENU_SELECTION_SEND_TO_BACK, "Send them to the back")
+	appendMenuChecked(hSub, wincoe.MF_STRING, MENU_SELECTION_CLEAR, "Clear the selection")
+	appendMenuChecked(hMenu, wincoe.MF_STRING|MF_POPUP, uintptr(hSub), fmt.Sprintf("Selection (%d windows)", n))
+}
+
+// handleSelectionMenuCommand runs the tray command produced by
+// appendSelectionMenu, reporting whether cmd was one.
+func handleSelectionMenuCommand(cmd uint32) bool {
+	action, ok := map[uint32]string{
+		MENU_SELECTION_TILE:         "tile",
+		MENU_SELECTION_MINIMIZE:     "minimize",
+		MENU_SELECTION_SEND_TO_BACK: "send-to-back",
+		MENU_SELECTION_CLEAR:        "clear",
+	}[cmd]
+	if ok {
+		_ = runSelectionAction(action) // already logged and shown
+	}
+	return ok
+}
+
+// deinitSelection destroys the band and the highlights and frees their
+// class and brushes. The selection itself is forgotten.
+func deinitSelection() {
+	for _, hl := range selectionHighlights {
+		if hl.hwnd != 0 {
+			_ = wincoe.DestroyWindow(hl.hwnd)
+		}
+	}
+	clear(selectionHighlights)
+	if selectionBandHwnd != 0 {
+		_ = wincoe.DestroyWindow(selectionBandHwnd)
+		selectionBandHwnd = 0
+	}
+	for _, b := range []*windows.Handle{&selectionBrush, &selectionKeyBrush} {
+		if *b != 0 {
+			if res := wincoe.GdiDeleteObject(*b); res.Failed() {
+				logf("deinitSelection: DeleteObject failed: %v", res.Err)
+			}
+			*b = 0
+		}
+	}
+	if selectionClassRegistered {
+		if res := wincoe.UnregisterClassW(mustUTF16(winbollocksSelectionClassName), selfHInstance); res.Failed() {
+			logf("deinitSelection: UnregisterClassW failed: %v", res)
+		}
+		selectionClassRegistered = false
+	}
+}
@@ -0,0 +1,243 @@
+//go:build windows && amd64
+
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/workturnedplay/wincoe"
+)
+
+/* ---------------- Sent-to-back tray submenu ---------------- */
+
+// Win+Shift+MMB can only pop sentToBackStack blindly, newest first. The
+// tray's "Sent to back" submenu lists every entry with its window's icon,
+// title and process, newest first, and restores whichever one is clicked,
+// out of order if need be. A restore from the menu goes through the same
+// reservation and queued HWND_TOP as Win+Shift+MMB (see
+// zOrderActionRestoreStackEntry), so the two can't restore one entry twice.
+
+// keepSentToBackStack keeps sentToBackStack across winkey
+// releases, so windows buried a while ago can still be found in the tray
+// submenu (or popped by a later Win+Shift+MMB). Off by default: the stack
+// then only lasts as long as the winkey is held, as it always has.
+// Persisted (see persistedSettings).
+var keepSentToBackStack atomic.Bool
+
+// maxSentToBackInTrayMenu bounds the submenu to the newest entries;
+// MENU_SENT_TO_BACK_RESTORE_BASE reserves this many IDs.
+const maxSentToBackInTrayMenu = 20
+
+// sentToBackStackWinkeyReleased is called wherever the winkey was (or may
+// have been, unseen) released: it clears sentToBackStack unless
+// keepSentToBackStack says to keep it.
+func sentToBackStackWinkeyReleased() {
+	if keepSentToBackStack.Load() {
+		return
+	}
+	clearSentToBackStack()
+}
+
+// sentToBackMenuEntries snapshots sentToBackStack for one tray menu popup,
+// newest first, the same way pinnedHwnds does for pinned windows. Entries
+// whose window is gone are pruned; entries already being restored are
+// left out.
+func sentToBackMenuEntries() []sentToBackEntry {
+	sentToBackStackMu.Lock()
+	defer sentToBackStackMu.Unlock()
+
+	out := make([]sentToBackEntry, 0, min(len(sentToBackStack), maxSentToBackInTrayMenu))
+	for i := len(sentToBackStack) - 1; i >= 0 && len(out) < maxSentToBackInTrayMenu; i-- {
+		entry := sentToBackStack[i]
+		if !wincoe.IsWindow(entry.hwnd) {
+			sentToBackStack = append(sentToBackStack[:i], sentToBackStack[i+1:]...)
+			continue
+		}
+		if !entry.restoring {
+			out = append(out, entry)
+		}
+	}
+	return out
+}
+
+// appendSentToBackMenuItems appends the tray's "Sent to back" submenu (or
+// a grayed hint when the stack is empty) and the keep-after-release toggle
+// to hMenu. Returns the item bitmaps, which the caller frees with
+// deleteMenuBitmaps once the menu is closed.
+func appendSentToBackMenuItems(hMenu windows.Handle, entries []sentToBackEntry) (bitmaps []windows.Handle) {
+	if len(entries) == 0 {
+		appendMenuChecked(hMenu, wincoe.MF_STRING|wincoe.MF_GRAYED, 0, "Sent to back: none")
+	} else if hSub, res := wincoe.CreatePopupMenu(); res.Failed() {
+		logf("appendSentToBackMenuItems: CreatePopupMenu failed: %v", res.Err)
+	} else {
+		for i, entry := range entries {
+			id := uint32(MENU_SENT_TO_BACK_RESTORE_BASE + i)
+			appendMenuChecked(hSub, wincoe.MF_STRING, uintptr(id),
+				pinMenuTitle(entry.hwnd)+"\t"+getProcessNameFast(getWindowPID(entry.hwnd)))
+			if bmp := windowMenuBitmap(entry.hwnd); bmp != 0 {
+				setMenuItemBitmap(hSub, id, bmp)
+				bitmaps = append(bitmaps, bmp)
+			}
+		}
+		appendMenuChecked(hMenu, wincoe.MF_STRING|MF_POPUP, uintptr(hSub), fmt.Sprintf("Sent to back (%d)", len(entries)))
+	}
+
+	var flags uint32 = wincoe.MF_STRING
+	if keepSentToBackStack.Load() {
+		flags |= wincoe.MF_CHECKED
+	}
+	appendMenuChecked(hMenu, flags, MENU_TOGGLE_KEEP_SENT_TO_BACK_STACK,
+		"    Remember sent-to-back windows after the winkey is released")
+	return bitmaps
+}
+
+// handleSentToBackMenuCommand runs the tray command produced by
+// appendSentToBackMenuItems, reporting whether cmd was one.
+func handleSentToBackMenuCommand(cmd uint32, entries []sentToBackEntry) bool {
+	switch {
+	case cmd == MENU_TOGGLE_KEEP_SENT_TO_BACK_STACK:
+		toggleAndPersist(&keepSentToBackStack)
+	case cmd >= MENU_SENT_TO_BACK_RESTORE_BASE && int(cmd) < MENU_SENT_TO_BACK_RESTORE_BASE+len(entries):
+		restoreSentToBackEntry(entries[cmd-MENU_SENT_TO_BACK_RESTORE_BASE].id)
+	default:
+		return false
+	}
+	return true
+}
+
+// restoreSentToBackEntry restores one sentToBackStack entry, like
+// Win+Shift+MMB does for the newest one. Main thread only.
+func restoreSentToBackEntry(id uint64) {
+	hwnd, ok := reserveSentToBackEntry(id)
+	if !ok {
+		logf("restoreSentToBackEntry: entry ID=%d is gone or already being restored", id)
+		return
+	}
+	applyZOrderChangeNow(WindowMoveData{
+		Hwnd:                hwnd,
+		InsertAfter:         wincoe.HWND_TOP,
+		Flags:               wincoe.SWP_NOMOVE | wincoe.SWP_NOSIZE | wincoe.SWP_NOACTIVATE,
+		ZOrderAction:        zOrderActionRestoreStackEntry,
+		SentToBackRestoreID: id,
+	})
+}
+
+// BITMAPINFOHEADER is CreateDIBSection's bitmap description; wincoe
+// doesn't export it. No color table follows it for 32bpp BI_RGB bitmaps.
+type BITMAPINFOHEADER struct {
+	Size          uint32
+	Width         int32
+	Height        int32
+	Planes        uint16
+	BitCount      uint16
+	Compression   uint32
+	SizeImage     uint32
+	XPelsPerMeter int32
+	YPelsPerMeter int32
+	ClrUsed       uint32
+	ClrImportant  uint32
+}
+
+// MENUITEMINFOW is SetMenuItemInfoW's input; wincoe doesn't export it.
+type MENUITEMINFOW struct {
+	CbSize        uint32
+	FMask         uint32
+	FType         uint32
+	FState        uint32
+	WID           uint32
+	HSubMenu      windows.Handle
+	HbmpChecked   windows.Handle
+	HbmpUnchecked windows.Handle
+	DwItemData    uintptr
+	DwTypeData    *uint16
+	Cch           uint32
+	HbmpItem      windows.Handle
+}
+
+// GDI/menu constants wincoe doesn't export.
+const (
+	BI_RGB         = 0
+	DIB_RGB_COLORS = 0
+	DI_NORMAL      = 0x0003
+	MIIM_BITMAP    = 0x00000080
+)
+
+// windowMenuBitmap renders hwnd's small class icon into a 32bpp top-down
+// DIB section, the form menus draw with per-pixel alpha. Returns 0 on
+// failure (the item then simply has no icon); the caller owns the bitmap.
+//
+// Only the class icon: asking each window for its own (WM_GETICON) could
+// wait on every hung window in the list while the tray menu is being
+// built, and a window's class icon is almost always the same picture.
+func windowMenuBitmap(hwnd windows.Handle) windows.Handle {
+	icon := windowClassIconCopy(hwnd)
+	if icon == 0 {
+		return 0
+	}
+	defer destroyIcon(icon)
+
+	cx, cy := wincoe.GetSystemMetrics(wincoe.SM_CXSMICON), wincoe.GetSystemMetrics(wincoe.SM_CYSMICON)
+	res := procCreateCompatibleDC.Call(0)
+	if res.Failed() {
+		logf("windowMenuBitmap: CreateCompatibleDC failed: %v", res.Err)
+		return 0
+	}
+	hdc := res.R1
+	defer procDeleteDC.Call(hdc)
+
+	bih := BITMAPINFOHEADER{Width: cx, Height: -cy, Planes: 1, BitCount: 32, Compression: BI_RGB}
+	bih.Size = uint32(unsafe.Sizeof(bih))
+	var bits unsafe.Pointer
+	res = procCreateDIBSection.Call(hdc, uintptr(unsafe.Pointer(&bih)), DIB_RGB_COLORS, uintptr(unsafe.Pointer(&bits)), 0, 0)
+	if res.Failed() {
+		logf("windowMenuBitmap: CreateDIBSection failed: %v", res.Err)
+		return 0
+	}
+	bmp := windows.Handle(res.R1)
+	old := procSelectObject.Call(hdc, uintptr(bmp)).R1
+	drawn := procDrawIconEx.Call(hdc, 0, 0, uintptr(icon), uintptr(cx), uintptr(cy), 0, 0, DI_NORMAL)
+	procSelectObject.Call(hdc, old)
+	if drawn.Failed() {
+		logf("windowMenuBitmap: DrawIconEx failed for HWND=0x%X: %v", hwnd, drawn.Err)
+		wincoe.GdiDeleteObject(bmp)
+		return 0
+	}
+	// Icons without an alpha channel draw with every alpha byte left 0,
+	// which a menu shows as fully transparent; make their drawn pixels
+	// opaque. The masked-out ones stayed 0 and stay clear (and so, as a
+	// compromise, do any pure black pixels of the icon).
+	pixels := unsafe.Slice((*uint32)(bits), int(cx)*int(cy))
+	for _, p := range pixels {
+		if p&0xFF000000 != 0 {
+			return bmp
+		}
+	}
+	for i, p := range pixels {
+		if p != 0 {
+			pixels[i] = p | 0xFF000000
+		}
+	}
+	return bmp
+}
+
+// setMenuItemBitmap shows bmp next to menu item id of hMenu.
+func setMenuItemBitmap(hMenu windows.Handle, id uint32, bmp windows.Handle) {
+	mii := MENUITEMINFOW{FMask: MIIM_BITMAP, HbmpItem: bmp}
+	mii.CbSize = uint32(unsafe.Sizeof(mii))
+	if res := procSetMenuItemInfoW.Call(uintptr(hMenu), uintptr(id), 0, uintptr(unsafe.Pointer(&mii))); res.Failed() {
+		logf("setMenuItemBitmap: SetMenuItemInfoW failed for item %d: %v", id, res.Err)
+	}
+}
+
+// deleteMenuBitmaps frees the bitmaps appendSentToBackMenuItems returned.
+func deleteMenuBitmaps(bitmaps []windows.Handle) {
+	for _, bmp := range bitmaps {
+		if res := wincoe.GdiDeleteObject(bmp); res.Failed() {
+			logf("deleteMenuBitmaps: DeleteObject(0x%X) failed: %v", bmp, res.Err)
+		}
+	}
+}
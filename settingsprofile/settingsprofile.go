@@ -0,0 +1,155 @@
+// Package settingsprofile parses winbollocks' profiles file: named sets of
+// settings, each laid over the settings file's own values while the
+// profile is active. Like winrules it never touches Win32 -- the main
+// package (see profiles.go there) applies a profile's entries through the
+// same code that loads the settings file, and asks Match which profile, if
+// any, the foreground application wants -- so parsing and matching are
+// unit-testable on any OS.
+//
+// The profiles file is a hand-written text file of [sections], one
+// profile each, holding "name = value" lines exactly like the settings
+// file's:
+//
+//	# Nothing in the way while playing.
+//	[gaming]
+//	switchOnForeground = eldenring.exe, sekiro.exe
+//	focusFollowsMouse = false
+//	hotCornerTopLeft = none
+//
+//	[presentation]
+//	snapOuterGapPx = 24
+//
+// A profile only needs the settings it changes. switchOnForeground isn't a
+// setting: it lists executables whose windows switch to the profile when
+// they come to the foreground. Which setting names exist, and which values
+// they take, is the caller's business; Parse only checks the structure.
+package settingsprofile
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SwitchOnForeground is the key listing a profile's executables.
+const SwitchOnForeground = "switchOnForeground"
+
+// None is the reserved name meaning "no profile", for commands and the
+// tray; no profile may be called that.
+const None = "none"
+
+// Entry is one "name = value" line of a profile, with its line number for
+// error messages.
+type Entry struct {
+	Key, Value string
+	Line       int
+}
+
+// Profile is one [section] of the profiles file.
+type Profile struct {
+	Name    string
+	Line    int
+	Entries []Entry
+	// Exes are the executable names from SwitchOnForeground, lowercased.
+	Exes []string
+}
+
+// Parse reads a profiles file. It returns every profile it could make
+// sense of plus one error per problem. A profile with a bad header, or
+// named like an earlier one, is dropped entirely (switching to half of the
+// wrong profile would be worse than not switching); a bad line only loses
+// that line.
+func Parse(data []byte) ([]Profile, []error) {
+	var (
+		profiles []Profile
+		errs     []error
+		cur      *Profile
+		bad      bool // cur had an error that disqualifies the whole profile
+	)
+	seen := map[string]bool{}
+	flush := func() {
+		if cur != nil && !bad {
+			profiles = append(profiles, *cur)
+		}
+		cur, bad = nil, false
+	}
+
+	for i, raw := range strings.Split(string(data), "\n") {
+		lineNum := i + 1
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			flush()
+			cur = &Profile{Line: lineNum}
+			if !strings.HasSuffix(line, "]") {
+				errs = append(errs, fmt.Errorf("line %d: unterminated profile header %q", lineNum, line))
+				bad = true
+				continue
+			}
+			cur.Name = strings.TrimSpace(line[1 : len(line)-1])
+			lower := strings.ToLower(cur.Name)
+			switch {
+			case cur.Name == "":
+				errs = append(errs, fmt.Errorf("line %d: profile has no name", lineNum))
+				bad = true
+			case lower == None:
+				errs = append(errs, fmt.Errorf("line %d: %q is reserved, ignoring that profile", lineNum, cur.Name))
+				bad = true
+			case seen[lower]:
+				errs = append(errs, fmt.Errorf("line %d: a profile named %q already exists, ignoring this one", lineNum, cur.Name))
+				bad = true
+			}
+			seen[lower] = true
+			continue
+		}
+		key, val, found := strings.Cut(line, "=")
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		if !found || key == "" {
+			errs = append(errs, fmt.Errorf("line %d: expected \"key = value\", got %q", lineNum, line))
+			continue
+		}
+		if cur == nil {
+			errs = append(errs, fmt.Errorf("line %d: %q is outside any [profile] section", lineNum, key))
+			continue
+		}
+		if bad {
+			continue
+		}
+		if strings.EqualFold(key, SwitchOnForeground) {
+			for _, exe := range strings.Split(val, ",") {
+				if exe = strings.TrimSpace(exe); exe != "" {
+					cur.Exes = append(cur.Exes, strings.ToLower(exe))
+				}
+			}
+			continue
+		}
+		cur.Entries = append(cur.Entries, Entry{Key: key, Value: val, Line: lineNum})
+	}
+	flush()
+	return profiles, errs
+}
+
+// Find returns the profile called name, compared case-insensitively.
+func Find(profiles []Profile, name string) (Profile, bool) {
+	for _, p := range profiles {
+		if strings.EqualFold(p.Name, name) {
+			return p, true
+		}
+	}
+	return Profile{}, false
+}
+
+// Match returns the first profile whose SwitchOnForeground lists exe
+// (compared case-insensitively), if any.
+func Match(profiles []Profile, exe string) (Profile, bool) {
+	exe = strings.ToLower(exe)
+	for _, p := range profiles {
+		for _, e := range p.Exes {
+			if e == exe {
+				return p, true
+			}
+		}
+	}
+	return Profile{}, false
+}
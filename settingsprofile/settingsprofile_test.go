@@ -0,0 +1,87 @@
+package settingsprofile
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+const sample = `
+# comment
+; also a comment
+[gaming]
+switchOnForeground = EldenRing.exe, , sekiro.exe
+focusFollowsMouse = false
+hotCornerTopLeft = none
+
+[presentation]
+snapOuterGapPx = 24
+`
+
+func TestParse(t *testing.T) {
+	profiles, errs := Parse([]byte(sample))
+	if len(errs) != 0 {
+		t.Fatalf("Parse errors: %v", errs)
+	}
+	if len(profiles) != 2 {
+		t.Fatalf("got %d profiles, want 2", len(profiles))
+	}
+	g := profiles[0]
+	if g.Name != "gaming" || g.Line != 4 || !slices.Equal(g.Exes, []string{"eldenring.exe", "sekiro.exe"}) {
+		t.Errorf("gaming profile = %+v", g)
+	}
+	want := []Entry{{"focusFollowsMouse", "false", 6}, {"hotCornerTopLeft", "none", 7}}
+	if !slices.Equal(g.Entries, want) {
+		t.Errorf("gaming entries = %+v, want %+v (switchOnForeground is not an entry)", g.Entries, want)
+	}
+	if p := profiles[1]; p.Name != "presentation" || len(p.Entries) != 1 || p.Exes != nil {
+		t.Errorf("presentation profile = %+v", p)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         string
+		wantProfiles int
+		wantErr      string
+	}{
+		{"outside section", "focusOnDrag = true\n", 0, "outside any [profile]"},
+		{"unterminated header", "[oops\nfocusOnDrag = true\n", 0, "unterminated"},
+		{"empty name", "[ ]\nfocusOnDrag = true\n", 0, "has no name"},
+		{"reserved name", "[None]\nfocusOnDrag = true\n", 0, "reserved"},
+		{"duplicate drops the later one", "[a]\nfocusOnDrag = true\n[A]\nfocusOnDrag = false\n", 1, "already exists"},
+		{"not key value keeps profile", "[a]\njunk\nfocusOnDrag = true\n", 1, "expected \"key = value\""},
+	}
+	for _, tt := range tests {
+		profiles, errs := Parse([]byte(tt.data))
+		if len(profiles) != tt.wantProfiles {
+			t.Errorf("%s: got %d profiles, want %d", tt.name, len(profiles), tt.wantProfiles)
+		}
+		if len(errs) == 0 || !strings.Contains(errs[0].Error(), tt.wantErr) {
+			t.Errorf("%s: errors = %v, want one containing %q", tt.name, errs, tt.wantErr)
+		}
+	}
+}
+
+func TestFindAndMatch(t *testing.T) {
+	profiles, _ := Parse([]byte(sample + "[other]\nswitchOnForeground = sekiro.exe\n"))
+	tests := []struct {
+		name   string
+		lookup func([]Profile, string) (Profile, bool)
+		arg    string
+		want   string // "" for no profile
+	}{
+		{"find case-insensitive", Find, "PRESENTATION", "presentation"},
+		{"find missing", Find, "dev", ""},
+		{"match case-insensitive", Match, "eldenring.EXE", "gaming"},
+		{"first match wins", Match, "sekiro.exe", "gaming"},
+		{"no match", Match, "notepad.exe", ""},
+	}
+	for _, tt := range tests {
+		got, ok := tt.lookup(profiles, tt.arg)
+		if got.Name != tt.want || ok != (tt.want != "") {
+			t.Errorf("%s: got %q, %v; want %q", tt.name, got.Name, ok, tt.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+//go:build windows && amd64
+
+package main
+
+import (
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/workturnedplay/wincoe"
+)
+
+/* ---------------- Settings file hot reload ---------------- */
+
+// Editing settingsFilePath used to mean quitting winbollocks first, or the
+// next tray change would write over the edit. Instead the file is polled
+// (a cheap os.Stat every settingsWatchIntervalMs on the main thread, like
+// the topology snapshot timer) and, once its content differs from what we
+// last wrote or read, re-applied through applySettingsText -- loadSettings'
+// own rules. Only settings whose value actually changed are touched, each
+// change is logged, and a bad line or value is skipped like at startup,
+// so a botched edit can never reset anything else. Keys missing from the
+// file keep their current value; an editor that saves in two steps just
+// gets applied twice.
+//
+// Our own saves are told apart by content, not timing: saveSettings
+// records what it wrote (see noteSettingsFileSeen), and a file holding
+// exactly that is not an edit.
+
+// settingsWatchIntervalMs is how often the settings file is polled.
+const settingsWatchIntervalMs = 1000
+
+// settingsFileSeen is the settings file as of our last read or write:
+// its stat, to skip reading an unchanged file, and its content, to tell
+// our own writes from external ones. Main thread only.
+var settingsFileSeen struct {
+	modTime time.Time
+	size    int64
+	text    string
+}
+
+// noteSettingsFileSeen records text as the settings file's current
+// content; called right after reading or writing it.
+func noteSettingsFileSeen(text string) {
+	settingsFileSeen.text = text
+	if fi, err := os.Stat(settingsFilePath); err == nil {
+		settingsFileSeen.modTime, settingsFileSeen.size = fi.ModTime(), fi.Size()
+	}
+}
+
+// startSettingsWatch starts polling the settings file on hwnd.
+func startSettingsWatch(hwnd windows.Handle) {
+	if _, res := wincoe.SetTimer(hwnd, settingsWatchTimerID, settingsWatchIntervalMs, 0); res.Failed() {
+		logf("startSettingsWatch: SetTimer failed: %v; edits to %q won't be picked up until restart", res.Err, settingsFilePath)
+	}
+}
+
+// checkSettingsFile is settingsWatchTimerID's handler: apply the settings
+// file if it changed since we last saw it. A missing file is left alone
+// (the next tray change writes it again).
+func checkSettingsFile() {
+	fi, err := os.Stat(settingsFilePath)
+	if err != nil {
+		return
+	}
+	if fi.ModTime().Equal(settingsFileSeen.modTime) && fi.Size() == settingsFileSeen.size {
+		return
+	}
+	data, err := os.ReadFile(settingsFilePath) //nolint:gosec // G304: settingsFilePath is a fixed, hardcoded constant, never derived from user/network input
+	if err != nil {
+		logf("checkSettingsFile: failed to read %q: %v; will retry", settingsFilePath, err)
+		return
+	}
+	text := string(data)
+	settingsFileSeen.modTime, settingsFileSeen.size = fi.ModTime(), fi.Size()
+	if text == settingsFileSeen.text {
+		return // touched, or our own write
+	}
+	settingsFileSeen.text = text
+	n := applySettingsText("checkSettingsFile", settingsFilePath, text, true)
+	logf("checkSettingsFile: %q was edited; %d setting(s) changed", settingsFilePath, n)
+}
@@ -0,0 +1,169 @@
+//go:build windows && amd64
+
+package main
+
+import (
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/workturnedplay/wincoe"
+)
+
+/* ---------------- Window shading ---------------- */
+
+// Shading rolls a window up to just its caption, the old X11 window
+// manager trick for getting a window out of the way without losing track
+// of where it is: width and position stay, only the height collapses, and
+// shading it again rolls it back down to the height it had. A move gesture
+// on a shaded window moves it shaded, for free: moves never resize (they
+// use SWP_NOSIZE).
+//
+// The full height lives only in shadedWindows, so every shaded window is
+// unshaded again by deinitShading when winbollocks exits -- otherwise a
+// window shaded in one run would stay collapsed forever, with no record
+// of how tall it used to be.
+
+// shadedWindow is one shadedWindows entry: the outer (GetWindowRect)
+// height to unshade back to.
+type shadedWindow struct {
+	fullHeight int32
+}
+
+// shadedWindows is only used on the main thread: the shade action,
+// deinit, and winEventProc's EVENT_OBJECT_DESTROY case, which runs there
+// too (it is an out-of-context WinEvent hook). The mutex is defensive.
+var (
+	shadedWindowsMu sync.Mutex
+	shadedWindows   = map[windows.Handle]shadedWindow{}
+)
+
+// shadedHeight is the outer height hwnd collapses to: its non-client area
+// (frame plus caption) with an empty client area. Windows that draw their
+// own caption inside the client area report almost no non-client area, so
+// at least a standard caption's worth is kept.
+func shadedHeight(hwnd windows.Handle, outer wincoe.RECT) int32 {
+	var client wincoe.RECT
+	nonClient := int32(0)
+	if res := wincoe.GetClientRect(hwnd, &client); res.Succeeded() {
+		nonClient = (outer.Bottom - outer.Top) - (client.Bottom - client.Top)
+	}
+	_, top, _, bottom := windowVisualEdgeInsets(hwnd)
+	return max(nonClient, top+bottom+max(wincoe.GetSystemMetrics(SM_CYCAPTION), 16))
+}
+
+// toggleShade shades hwnd, or unshades it if it is shaded. A window that
+// was shaded but has since been resized to at least its full height (by
+// the user or the app) no longer counts as shaded and is shaded afresh.
+// Reports what happened, for the caller's log. Main thread only.
+func toggleShade(hwnd windows.Handle) (shaded bool, ok bool) {
+	var wp wincoe.WINDOWPLACEMENT
+	wp.Length = uint32(unsafe.Sizeof(wp))
+	if res := wincoe.GetWindowPlacement(hwnd, &wp); res.Failed() {
+		logf("toggleShade: GetWindowPlacement failed for HWND=0x%X: %v", hwnd, res.Err)
+		return false, false
+	}
+	if wp.ShowCmd == windows.SW_SHOWMINIMIZED || wp.ShowCmd == windows.SW_MAXIMIZE {
+		logf("toggleShade: HWND=0x%X is minimized or maximized (showCmd=%d); not shading it", hwnd, wp.ShowCmd)
+		return false, false
+	}
+	var r wincoe.RECT
+	if res := wincoe.GetWindowRect(hwnd, &r); res.Failed() {
+		logf("toggleShade: GetWindowRect failed for HWND=0x%X: %v", hwnd, res.Err)
+		return false, false
+	}
+	height := r.Bottom - r.Top
+
+	shadedWindowsMu.Lock()
+	entry, wasShaded := shadedWindows[hwnd]
+	shadedWindowsMu.Unlock()
+	if wasShaded && height < entry.fullHeight {
+		if !setWindowHeight(hwnd, r, entry.fullHeight) {
+			return true, false
+		}
+		forgetShade(hwnd)
+		return false, true
+	}
+
+	to := shadedHeight(hwnd, r)
+	if to >= height {
+		logf("toggleShade: HWND=0x%X is already no taller than its caption (%dpx)", hwnd, height)
+		return false, false
+	}
+	journalLiveGeometry(hwnd)
+	if !setWindowHeight(hwnd, r, to) {
+		return false, false
+	}
+	shadedWindowsMu.Lock()
+	shadedWindows[hwnd] = shadedWindow{fullHeight: height}
+	shadedWindowsMu.Unlock()
+	return true, true
+}
+
+// setWindowHeight resizes hwnd (currently at r) to height, keeping its
+// position and width. Posted (SWP_ASYNCWINDOWPOS) so a hung app can't
+// stall the caller. Apps that enforce a minimum height (WM_GETMINMAXINFO)
+// will stop short of a full shade; that's still recorded as shaded, since
+// unshading only needs the full height.
+func setWindowHeight(hwnd windows.Handle, r wincoe.RECT, height int32) bool {
+	if res := wincoe.SetWindowPos(hwnd, 0, 0, 0, r.Right-r.Left, height,
+		wincoe.SWP_NOMOVE|wincoe.SWP_NOZORDER|wincoe.SWP_NOACTIVATE|wincoe.SWP_ASYNCWINDOWPOS); res.Failed() {
+		logf("setWindowHeight: SetWindowPos failed for HWND=0x%X: %v", hwnd, res.Err)
+		if res.ErrIs(windows.ERROR_ACCESS_DENIED) {
+			showTrayInfo(selfName, "Cannot shade elevated window (access denied), you'd have to run as admin.")
+		}
+		return false
+	}
+	return true
+}
+
+// forgetShade drops hwnd's shadedWindows entry; also called when hwnd is
+// destroyed, so a recycled HWND value is never "unshaded" to a dead
+// window's height.
+func forgetShade(hwnd windows.Handle) {
+	shadedWindowsMu.Lock()
+	delete(shadedWindows, hwnd)
+	shadedWindowsMu.Unlock()
+}
+
+// toggleShadeUnderCursor is the shade hotkey's (and remote command's)
+// action: toggle the top-level window under the mouse cursor.
+func toggleShadeUnderCursor() {
+	var pt wincoe.POINT
+	if res := wincoe.GetCursorPos(&pt); res.Failed() {
+		logf("toggleShadeUnderCursor: GetCursorPos failed: %v", res.Err)
+		return
+	}
+	hwnd, res := wincoe.RootWindowFromPoint(pt)
+	if hwnd == 0 || !isManageableTopLevelWindow(hwnd) {
+		logf("toggleShadeUnderCursor: no shadeable window under the cursor at (%d,%d) (HWND=0x%X, res: %v)", pt.X, pt.Y, hwnd, res)
+		return
+	}
+	shaded, ok := toggleShade(hwnd)
+	if !ok {
+		return
+	}
+	verb := "unshaded"
+	if shaded {
+		verb = "shaded"
+	}
+	logf("toggleShadeUnderCursor: %s HWND=0x%X %q", verb, hwnd, getWindowTextFast(hwnd))
+}
+
+// deinitShading unshades every window still shaded, so none stays rolled
+// up after winbollocks exits. Main thread only (see deinit).
+func deinitShading() {
+	shadedWindowsMu.Lock()
+	defer shadedWindowsMu.Unlock()
+	for hwnd, entry := range shadedWindows {
+		var r wincoe.RECT
+		if !wincoe.IsWindow(hwnd) || wincoe.GetWindowRect(hwnd, &r).Failed() {
+			continue
+		}
+		if setWindowHeight(hwnd, r, entry.fullHeight) {
+			logf("deinitShading: unshaded HWND=0x%X back to %dpx", hwnd, entry.fullHeight)
+		}
+	}
+	clear(shadedWindows)
+}
@@ -0,0 +1,246 @@
+// Package snapengine computes snap-to-line adjustments for window move and
+// resize gestures. It is deliberately pure -- plain int32 geometry in, plain
+// int32 geometry out, no Win32 calls, no globals, no logging -- so that the
+// exact same decision logic is shared by winbollocks' move path
+// (applySnapToEdgesForMove) and resize path (applySnapToEdgesForResize), and
+// can be unit-tested on any OS without a real desktop.
+//
+// All coordinates are screen pixels. Rectangles follow Win32 RECT
+// conventions: Right/Bottom are exclusive, so a window's visible right edge
+// is at Right and its width is Right-Left. Callers are expected to pass the
+// window's VISIBLE rectangle (GetWindowRect minus the DWM invisible resize
+// borders -- see windowVisualEdgeInsets in the main package) and convert the
+// result back into GetWindowRect space themselves.
+package snapengine
+
+// Rect is a screen-space rectangle with exclusive Right/Bottom, mirroring
+// wincoe.RECT's layout without depending on it (this package must build and
+// be testable on non-Windows hosts).
+type Rect struct {
+	Left, Top, Right, Bottom int32
+}
+
+// Width returns Right-Left.
+func (r Rect) Width() int32 { return r.Right - r.Left }
+
+// Height returns Bottom-Top.
+func (r Rect) Height() int32 { return r.Bottom - r.Top }
+
+// Config describes which snap lines exist and how strongly they attract.
+//
+// ThresholdPx is how close (inclusive) an edge must come to a line before it
+// gets pulled onto it; 0 or negative disables snapping entirely.
+//
+// OuterGapPx shrinks the work area on all four sides before any line is
+// derived from it, so windows snapped to the screen edges keep that margin,
+// and the center/thirds lines are measured across the gapped area (keeping
+// e.g. two half-width windows snapped to the center line symmetric with the
+// gapped outer edges). A gap large enough to invert the work area is treated
+// as no gap.
+//
+// CenterLines adds the vertical and horizontal center lines of the (gapped)
+// work area. Thirds adds the lines at 1/3 and 2/3 of it.
+type Config struct {
+	ThresholdPx int32
+	OuterGapPx  int32
+	CenterLines bool
+	Thirds      bool
+}
+
+// Edge flags which rectangle edges a resize gesture is actually moving, so
+// SnapResize only ever relocates those -- an edge the gesture never touches
+// must never be silently snapped out from under the user.
+type Edge uint8
+
+const (
+	EdgeLeft Edge = 1 << iota
+	EdgeTop
+	EdgeRight
+	EdgeBottom
+)
+
+// Lines holds the snap lines for one work area: X are vertical lines
+// (candidate x coordinates for left/right edges), Y are horizontal lines.
+// The outer (gapped) edges always come first in each slice, in
+// left/right and top/bottom order, which SnapMove relies on for its
+// tie-breaking rule.
+type Lines struct {
+	X []int32
+	Y []int32
+}
+
+// LinesFor derives the snap lines for work under cfg. The result never
+// contains duplicates, even for tiny work areas where e.g. a third line
+// rounds onto the center line.
+func LinesFor(work Rect, cfg Config) Lines {
+	area := gapped(work, cfg.OuterGapPx)
+	return Lines{
+		X: axisLines(area.Left, area.Right, cfg),
+		Y: axisLines(area.Top, area.Bottom, cfg),
+	}
+}
+
+// gapped shrinks work by gap on every side, or returns work unchanged if the
+// gap is non-positive or would invert it.
+func gapped(work Rect, gap int32) Rect {
+	if gap <= 0 {
+		return work
+	}
+	r := Rect{work.Left + gap, work.Top + gap, work.Right - gap, work.Bottom - gap}
+	if r.Left >= r.Right || r.Top >= r.Bottom {
+		return work
+	}
+	return r
+}
+
+func axisLines(lo, hi int32, cfg Config) []int32 {
+	lines := make([]int32, 0, 5)
+	lines = appendUnique(lines, lo)
+	lines = appendUnique(lines, hi)
+	span := hi - lo
+	if cfg.CenterLines {
+		lines = appendUnique(lines, lo+span/2)
+	}
+	if cfg.Thirds {
+		lines = appendUnique(lines, lo+span/3)
+		lines = appendUnique(lines, lo+(2*span)/3)
+	}
+	return lines
+}
+
+func appendUnique(lines []int32, v int32) []int32 {
+	for _, l := range lines {
+		if l == v {
+			return lines
+		}
+	}
+	return append(lines, v)
+}
+
+func abs32(v int32) int32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// nearest returns the line closest to v within threshold (inclusive) and
+// true, or v and false if none is close enough. Ties go to whichever line
+// comes first in lines.
+func nearest(v int32, lines []int32, threshold int32) (int32, bool) {
+	best, bestDist, found := v, int32(0), false
+	for _, l := range lines {
+		d := abs32(v - l)
+		if d > threshold {
+			continue
+		}
+		if !found || d < bestDist {
+			best, bestDist, found = l, d, true
+		}
+	}
+	return best, found
+}
+
+// axisMoveOffset returns how far to shift a [lo,hi) span along one axis so
+// that its closest edge (or, with center lines on, its midpoint) lands on a
+// snap line, or 0 if nothing is within threshold. Edges are considered
+// before the midpoint and lo before hi, and only a strictly closer candidate
+// replaces an earlier one -- so on a tie the window's left/top edge wins,
+// matching the left-over-right and top-over-bottom priority the original
+// four-edge snapping had.
+func axisMoveOffset(lo, hi int32, lines []int32, center int32, useCenter bool, threshold int32) int32 {
+	var offset, bestDist int32
+	found := false
+	consider := func(v int32, candidates []int32) {
+		if l, ok := nearest(v, candidates, threshold); ok {
+			if d := abs32(l - v); !found || d < bestDist {
+				offset, bestDist, found = l-v, d, true
+			}
+		}
+	}
+	consider(lo, lines)
+	consider(hi, lines)
+	if useCenter {
+		consider(lo+(hi-lo)/2, []int32{center})
+	}
+	return offset
+}
+
+// SnapMove returns the (dx, dy) translation to apply to a window being moved
+// so its visible rect snaps to the nearest line of work under cfg. Size is
+// never changed -- a move never resizes. With CenterLines on, the window's
+// own midpoint is also attracted to the work area's center line, which is
+// what makes "drag a window to the middle of the screen" land exactly
+// centered.
+func SnapMove(visible, work Rect, cfg Config) (dx, dy int32) {
+	if cfg.ThresholdPx <= 0 {
+		return 0, 0
+	}
+	lines := LinesFor(work, cfg)
+	area := gapped(work, cfg.OuterGapPx)
+	centerX := area.Left + area.Width()/2
+	centerY := area.Top + area.Height()/2
+	dx = axisMoveOffset(visible.Left, visible.Right, lines.X, centerX, cfg.CenterLines, cfg.ThresholdPx)
+	dy = axisMoveOffset(visible.Top, visible.Bottom, lines.Y, centerY, cfg.CenterLines, cfg.ThresholdPx)
+	return dx, dy
+}
+
+// SnapResize snaps each edge of visible selected by edges to its nearest
+// line within threshold, independently, leaving every other edge exactly
+// as given. If snapping would invert or collapse the rect (only possible
+// with a threshold larger than the window itself) visible is returned
+// unchanged rather than handing the caller a degenerate rectangle.
+func SnapResize(visible, work Rect, edges Edge, cfg Config) Rect {
+	if cfg.ThresholdPx <= 0 || edges == 0 {
+		return visible
+	}
+	lines := LinesFor(work, cfg)
+	out := visible
+	if edges&EdgeLeft != 0 {
+		out.Left, _ = nearest(visible.Left, lines.X, cfg.ThresholdPx)
+	}
+	if edges&EdgeRight != 0 {
+		out.Right, _ = nearest(visible.Right, lines.X, cfg.ThresholdPx)
+	}
+	if edges&EdgeTop != 0 {
+		out.Top, _ = nearest(visible.Top, lines.Y, cfg.ThresholdPx)
+	}
+	if edges&EdgeBottom != 0 {
+		out.Bottom, _ = nearest(visible.Bottom, lines.Y, cfg.ThresholdPx)
+	}
+	if out.Left >= out.Right || out.Top >= out.Bottom {
+		return visible
+	}
+	return out
+}
+
+// Placement is one of the one-shot placements Place computes.
+type Placement uint8
+
+const (
+	PlaceCenter     Placement = iota + 1 // centered in the work area, size kept
+	PlaceFullHeight                      // full work-area height, width and x kept
+	PlaceFullWidth                       // full work-area width, height and y kept
+)
+
+// Place returns where visible goes under p within work, shrunk by gap on
+// every side like the snap lines (see Config.OuterGapPx), so a placed
+// window keeps the same margin a snapped one does. A window too big to be
+// centered along an axis is aligned with the area's left or top edge
+// instead, keeping its title bar reachable.
+func Place(visible, work Rect, p Placement, gap int32) Rect {
+	area := gapped(work, gap)
+	out := visible
+	switch p {
+	case PlaceCenter:
+		out.Left = area.Left + max(0, (area.Width()-visible.Width())/2)
+		out.Top = area.Top + max(0, (area.Height()-visible.Height())/2)
+		out.Right = out.Left + visible.Width()
+		out.Bottom = out.Top + visible.Height()
+	case PlaceFullHeight:
+		out.Top, out.Bottom = area.Top, area.Bottom
+	case PlaceFullWidth:
+		out.Left, out.Right = area.Left, area.Right
+	}
+	return out
+}
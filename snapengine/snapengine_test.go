@@ -0,0 +1,117 @@
+package snapengine
+
+import (
+	"reflect"
+	"testing"
+)
+
+// work is a 1920x1040 work area (a 1080p monitor minus a 40px taskbar).
+var work = Rect{Left: 0, Top: 0, Right: 1920, Bottom: 1040}
+
+func TestLinesFor(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   Config
+		wantX []int32
+		wantY []int32
+	}{
+		{"edges only", Config{}, []int32{0, 1920}, []int32{0, 1040}},
+		{"outer gap", Config{OuterGapPx: 10}, []int32{10, 1910}, []int32{10, 1030}},
+		{"center lines", Config{CenterLines: true}, []int32{0, 1920, 960}, []int32{0, 1040, 520}},
+		{"thirds", Config{Thirds: true}, []int32{0, 1920, 640, 1280}, []int32{0, 1040, 346, 693}},
+		{"center and thirds within gap", Config{OuterGapPx: 30, CenterLines: true, Thirds: true},
+			[]int32{30, 1890, 960, 650, 1270}, []int32{30, 1010, 520, 356, 683}},
+		{"inverting gap ignored", Config{OuterGapPx: 5000}, []int32{0, 1920}, []int32{0, 1040}},
+	}
+	for _, tt := range tests {
+		got := LinesFor(work, tt.cfg)
+		if !reflect.DeepEqual(got.X, tt.wantX) || !reflect.DeepEqual(got.Y, tt.wantY) {
+			t.Errorf("%s: LinesFor = X%v Y%v, want X%v Y%v", tt.name, got.X, got.Y, tt.wantX, tt.wantY)
+		}
+	}
+}
+
+func TestLinesForDeduplicates(t *testing.T) {
+	// A 2px-wide area: center and both thirds all round onto existing lines.
+	got := LinesFor(Rect{0, 0, 2, 2}, Config{CenterLines: true, Thirds: true})
+	if !reflect.DeepEqual(got.X, []int32{0, 2, 1}) {
+		t.Errorf("LinesFor X = %v, want [0 2 1]", got.X)
+	}
+}
+
+func TestSnapMove(t *testing.T) {
+	tests := []struct {
+		name           string
+		visible        Rect
+		cfg            Config
+		wantDX, wantDY int32
+	}{
+		{"disabled by zero threshold", Rect{5, 5, 505, 405}, Config{}, 0, 0},
+		{"left/top edges", Rect{5, 7, 505, 407}, Config{ThresholdPx: 12}, -5, -7},
+		{"right/bottom edges", Rect{1410, 630, 1915, 1030}, Config{ThresholdPx: 12}, 5, 10},
+		{"outside threshold", Rect{13, 13, 513, 413}, Config{ThresholdPx: 12}, 0, 0},
+		{"threshold inclusive", Rect{12, 12, 512, 412}, Config{ThresholdPx: 12}, -12, -12},
+		{"outer gap", Rect{5, 5, 505, 405}, Config{ThresholdPx: 12, OuterGapPx: 10}, 5, 5},
+		{"window edge onto center line", Rect{955, 300, 1455, 700}, Config{ThresholdPx: 12, CenterLines: true}, 5, 0},
+		{"window center onto center line", Rect{712, 322, 1212, 722}, Config{ThresholdPx: 12, CenterLines: true}, -2, -2},
+		{"center ignored without CenterLines", Rect{712, 322, 1212, 722}, Config{ThresholdPx: 12}, 0, 0},
+		{"thirds", Rect{636, 350, 1136, 750}, Config{ThresholdPx: 12, Thirds: true}, 4, -4},
+		{"nearest of several candidates wins", Rect{957, 100, 1285, 200}, Config{ThresholdPx: 12, CenterLines: true, Thirds: true}, 3, 0},
+		// Equidistant from both opposite edges: left/top wins, matching the
+		// pre-engine priority.
+		{"tie prefers left/top", Rect{6, 6, 1914, 1034}, Config{ThresholdPx: 12}, -6, -6},
+	}
+	for _, tt := range tests {
+		dx, dy := SnapMove(tt.visible, work, tt.cfg)
+		if dx != tt.wantDX || dy != tt.wantDY {
+			t.Errorf("%s: SnapMove = (%d, %d), want (%d, %d)", tt.name, dx, dy, tt.wantDX, tt.wantDY)
+		}
+	}
+}
+
+func TestSnapResize(t *testing.T) {
+	tests := []struct {
+		name    string
+		visible Rect
+		edges   Edge
+		cfg     Config
+		want    Rect
+	}{
+		{"no edges", Rect{5, 5, 505, 405}, 0, Config{ThresholdPx: 12}, Rect{5, 5, 505, 405}},
+		{"only masked edge snaps", Rect{5, 5, 1915, 405}, EdgeRight, Config{ThresholdPx: 12}, Rect{5, 5, 1920, 405}},
+		{"corner", Rect{5, 3, 505, 405}, EdgeLeft | EdgeTop, Config{ThresholdPx: 12}, Rect{0, 0, 505, 405}},
+		{"outer gap", Rect{100, 100, 1915, 1035}, EdgeRight | EdgeBottom, Config{ThresholdPx: 12, OuterGapPx: 8}, Rect{100, 100, 1912, 1032}},
+		{"right edge onto third", Rect{100, 100, 1285, 500}, EdgeRight, Config{ThresholdPx: 12, Thirds: true}, Rect{100, 100, 1280, 500}},
+		{"bottom edge onto center", Rect{100, 100, 500, 515}, EdgeBottom, Config{ThresholdPx: 12, CenterLines: true}, Rect{100, 100, 500, 520}},
+		{"refuses inversion", Rect{8, 100, 10, 500}, EdgeLeft | EdgeRight, Config{ThresholdPx: 12}, Rect{8, 100, 10, 500}},
+	}
+	for _, tt := range tests {
+		if got := SnapResize(tt.visible, work, tt.edges, tt.cfg); got != tt.want {
+			t.Errorf("%s: SnapResize = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPlace(t *testing.T) {
+	tests := []struct {
+		name    string
+		visible Rect
+		p       Placement
+		gap     int32
+		want    Rect
+	}{
+		{"center", Rect{10, 20, 810, 620}, PlaceCenter, 0, Rect{560, 220, 1360, 820}},
+		{"center odd leftover rounds toward top-left", Rect{0, 0, 801, 601}, PlaceCenter, 0, Rect{559, 219, 1360, 820}},
+		{"center within gap", Rect{10, 20, 810, 620}, PlaceCenter, 20, Rect{560, 220, 1360, 820}},
+		{"center too wide keeps left edge on screen", Rect{-100, 20, 2100, 620}, PlaceCenter, 0, Rect{0, 220, 2200, 820}},
+		{"full height", Rect{300, 200, 900, 500}, PlaceFullHeight, 0, Rect{300, 0, 900, 1040}},
+		{"full height within gap", Rect{300, 200, 900, 500}, PlaceFullHeight, 8, Rect{300, 8, 900, 1032}},
+		{"full width", Rect{300, 200, 900, 500}, PlaceFullWidth, 0, Rect{0, 200, 1920, 500}},
+		{"inverting gap ignored", Rect{300, 200, 900, 500}, PlaceFullWidth, 5000, Rect{0, 200, 1920, 500}},
+	}
+	for _, tt := range tests {
+		if got := Place(tt.visible, work, tt.p, tt.gap); got != tt.want {
+			t.Errorf("%s: Place = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
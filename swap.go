@@ -0,0 +1,257 @@
+//go:build windows && amd64
+
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/workturnedplay/wincoe"
+
+	"github.com/workturnedplay/winbollocks/winlayout"
+)
+
+/* ---------------- Swap by dropping onto another window ---------------- */
+
+// Rearranging two side-by-side windows normally takes two drags and some
+// fiddling. Pressing Shift during a Win+LMB drag arms a swap instead: the
+// window under the cursor (beneath the dragged one) gets a highlight frame,
+// and releasing LMB there swaps the two windows' places -- the dragged
+// window takes the target's rect and maximized state, the target takes
+// the ones the dragged window had before the drag. Visible frames are what
+// get swapped (see windowVisualEdgeInsets), so windows with and without
+// invisible resize borders still line up exactly. Released over no
+// eligible window, the drag ends like any other.
+//
+// Shift has to go down after the drag starts, since Shift+Win+LMB doesn't
+// start one. mouseProc and keyboardProc only record whether a swap is
+// armed and where the drop was, and post WM_SWAP_TARGET, at most one in
+// flight, like postSelectionBand does; the main thread finds the target,
+// draws the highlight and swaps.
+
+// swapDrop is a drop with Shift held, captured from the ended session by
+// noteSwapDrop.
+type swapDrop struct {
+	hwnd         windows.Handle
+	pt           wincoe.POINT
+	originalRect wincoe.RECT
+	wasMaximized bool
+	insets       [4]int32 // left, top, right, bottom; see dragSession.visualInsetLeft
+	followers    []follower
+}
+
+// swapState is written by the hook thread and read by the main thread,
+// hence the mutex. armed means a ModeMove gesture is in progress with
+// Shift held; drop is the drop the main thread hasn't swapped yet.
+var (
+	swapStateMu sync.Mutex
+	swapState   struct {
+		armed bool
+		drop  *swapDrop
+	}
+	swapPending atomic.Bool
+)
+
+// swapTargetHwnd is the target highlight window, swapTargetShown the
+// window it's shown over (0 if hidden). Main thread only.
+var (
+	swapTargetHwnd  windows.Handle
+	swapTargetShown windows.Handle
+)
+
+// noteSwapModifier records whether Shift is held during a ModeMove
+// gesture; mouseProc's mouse move and keyboardProc's Shift transitions.
+// While armed, every call wakes the main thread to follow the cursor.
+func noteSwapModifier(shiftDown bool) {
+	if session := activeSession.Load(); session == nil || session.mode != ModeMove {
+		return
+	}
+	swapStateMu.Lock()
+	changed := swapState.armed != shiftDown
+	swapState.armed = shiftDown
+	swapStateMu.Unlock()
+	if changed || shiftDown {
+		postSwapTarget()
+	}
+}
+
+// noteSwapDrop records session's drop at pt for the main thread to swap,
+// if Shift is held; mouseProc's LMB up, before the session ends.
+func noteSwapDrop(session *dragSession, pt wincoe.POINT) {
+	if session.mode != ModeMove {
+		return
+	}
+	if _, shiftDown, _, _ := modifierKeyState(); !shiftDown {
+		return
+	}
+	drop := &swapDrop{
+		hwnd:         session.targetWnd,
+		pt:           pt,
+		originalRect: session.originalRect,
+		wasMaximized: session.wasMaximizedAtStart,
+		insets:       [4]int32{session.visualInsetLeft, session.visualInsetTop, session.visualInsetRight, session.visualInsetBottom},
+		followers:    session.followers,
+	}
+	swapStateMu.Lock()
+	swapState.drop = drop
+	swapStateMu.Unlock()
+	postSwapTarget()
+}
+
+// disarmSwap is softReset's hook: the gesture is over, so the highlight
+// goes away.
+func disarmSwap() {
+	swapStateMu.Lock()
+	was := swapState.armed
+	swapState.armed = false
+	swapStateMu.Unlock()
+	if was {
+		postSwapTarget()
+	}
+}
+
+// postSwapTarget wakes the main thread (handleSwapTarget) unless a wakeup
+// is already in flight.
+func postSwapTarget() {
+	if !swapPending.CompareAndSwap(false, true) {
+		return
+	}
+	main := loadMainMsgHwnd()
+	if main == 0 {
+		swapPending.Store(false)
+		return
+	}
+	if res := wincoe.PostMessage(main, WM_SWAP_TARGET, 0, 0); res.Failed() {
+		swapPending.Store(false) // the next cursor move retries
+		logf("postSwapTarget: PostMessage failed: %v", res.Err)
+	}
+}
+
+// handleSwapTarget is wndProc's WM_SWAP_TARGET handler: swap a pending
+// drop, or else highlight the target under the cursor while armed.
+func handleSwapTarget() {
+	swapPending.Store(false)
+	swapStateMu.Lock()
+	armed, drop := swapState.armed, swapState.drop
+	swapState.drop = nil
+	swapStateMu.Unlock()
+
+	if drop != nil {
+		showSwapTarget(0)
+		swapWithDropTarget(drop)
+		return
+	}
+	session := activeSession.Load()
+	if !armed || session == nil || session.mode != ModeMove {
+		showSwapTarget(0)
+		return
+	}
+	var pt wincoe.POINT
+	if res := wincoe.GetCursorPos(&pt); res.Failed() {
+		return
+	}
+	showSwapTarget(swapTargetAt(pt, session.targetWnd, session.followers))
+}
+
+// swapTargetAt returns the topmost eligible window whose visible frame is
+// under pt, looking through dragged itself and its followers, or 0.
+func swapTargetAt(pt wincoe.POINT, dragged windows.Handle, followers []follower) windows.Handle {
+	var target windows.Handle
+	forEachTopLevelWindow(func(hwnd windows.Handle) bool {
+		if hwnd == dragged || !isManageableTopLevelWindow(hwnd) || isMinimized(hwnd) {
+			return true
+		}
+		for _, f := range followers {
+			if f.hwnd == hwnd {
+				return true
+			}
+		}
+		f, ok := visibleFrame(hwnd)
+		if !ok || pt.X < f.Left || pt.X >= f.Right || pt.Y < f.Top || pt.Y >= f.Bottom {
+			return true
+		}
+		target = hwnd
+		return false
+	})
+	return target
+}
+
+// showSwapTarget puts the highlight over target's visible frame, or hides
+// it if target is 0.
+func showSwapTarget(target windows.Handle) {
+	if target == 0 {
+		if swapTargetShown != 0 && swapTargetHwnd != 0 {
+			_ = wincoe.SetWindowPos(swapTargetHwnd, 0, 0, 0, 0, 0,
+				wincoe.SWP_NOMOVE|wincoe.SWP_NOSIZE|wincoe.SWP_NOZORDER|wincoe.SWP_NOACTIVATE|wincoe.SWP_HIDEWINDOW)
+		}
+		swapTargetShown = 0
+		return
+	}
+	if swapTargetHwnd == 0 {
+		if swapTargetHwnd = createSelectionWindow(true); swapTargetHwnd == 0 {
+			return // logged; the swap still works, just unseen
+		}
+	}
+	f, ok := visibleFrame(target)
+	if !ok {
+		return
+	}
+	if res := wincoe.SetWindowPos(swapTargetHwnd, wincoe.HWND_TOPMOST, f.Left, f.Top, f.Right-f.Left, f.Bottom-f.Top,
+		wincoe.SWP_NOACTIVATE|wincoe.SWP_SHOWWINDOW); res.Failed() {
+		logf("showSwapTarget: SetWindowPos failed: %v", res.Err)
+		return
+	}
+	if target != swapTargetShown {
+		_ = wincoe.InvalidateRect(swapTargetHwnd, nil, false) // the frame is drawn to size
+	}
+	swapTargetShown = target
+}
+
+// swapWithDropTarget swaps drop's window with the one it was dropped onto.
+// The dragged window's own entry in its undo history was already recorded
+// when the gesture ended (see softReset); the target's is recorded here.
+func swapWithDropTarget(drop *swapDrop) {
+	target := swapTargetAt(drop.pt, drop.hwnd, drop.followers)
+	if target == 0 {
+		logf("swapWithDropTarget: HWND=0x%X dropped with Shift over no eligible window at (%d,%d); left where dropped", drop.hwnd, drop.pt.X, drop.pt.Y)
+		return
+	}
+	wsDX, wsDY := primaryWorkspaceOffset()
+	tState, tRect, ok := liveWindowState(target, wsDX, wsDY)
+	if !ok {
+		return
+	}
+	dState := winlayout.StateNormal
+	if drop.wasMaximized {
+		dState = winlayout.StateMaximized
+	}
+
+	// Swap visible frames: strip each window's own insets, then add the
+	// other window's back.
+	tL, tT, tR, tB := windowVisualEdgeInsets(target)
+	d := drop.insets
+	o := drop.originalRect
+	toDragged := winlayout.Rect{Left: tRect.Left + tL - d[0], Top: tRect.Top + tT - d[1], Right: tRect.Right - tR + d[2], Bottom: tRect.Bottom - tB + d[3]}
+	toTarget := winlayout.Rect{Left: o.Left + d[0] - tL, Top: o.Top + d[1] - tT, Right: o.Right - d[2] + tR, Bottom: o.Bottom - d[3] + tB}
+
+	pushGeometryHistory(target, windowGeometry{tState, tRect})
+	if !placeWindow(drop.hwnd, winlayout.StateNormal, tState, toDragged) {
+		return
+	}
+	moveFollowers(drop.followers, toDragged.Left, toDragged.Top)
+	if !placeWindow(target, tState, dState, toTarget) {
+		return
+	}
+	logf("swapWithDropTarget: swapped HWND=0x%X %q with HWND=0x%X %q", drop.hwnd, getWindowTextFast(drop.hwnd), target, getWindowTextFast(target))
+}
+
+// deinitSwap destroys the target highlight; before deinitSelection, which
+// unregisters its class.
+func deinitSwap() {
+	if swapTargetHwnd != 0 {
+		_ = wincoe.DestroyWindow(swapTargetHwnd)
+		swapTargetHwnd, swapTargetShown = 0, 0
+	}
+}
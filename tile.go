@@ -0,0 +1,304 @@
+//go:build windows && amd64
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/workturnedplay/wincoe"
+
+	"github.com/workturnedplay/winbollocks/tiling"
+)
+
+/* ---------------- On-demand tiling ---------------- */
+
+// Tiling arranges every eligible window on the cursor's monitor side by
+// side, once, when asked (hotkey, tray or remote command) -- winbollocks
+// is not a tiling window manager and nothing stays tiled afterwards. The
+// arrangements themselves live in package tiling; this file only gathers
+// the windows and applies the rects.
+//
+// Tiling again on the same monitor within tilingCycleWindow moves on to
+// the next algorithm, so one hotkey steps through columns, rows,
+// master-stack and BSP until the user likes what they see. After a pause
+// it starts from whichever algorithm that monitor was last tiled with.
+
+// tilingMasterPercent is tiling.Config.MasterPercent: the master window
+// keeps a bit over half the width, the usual editor-plus-helpers split.
+const tilingMasterPercent = 60
+
+// tilingCycleWindow is how soon a repeated tile counts as "try the next
+// algorithm" rather than "tile again the way I had it".
+const tilingCycleWindow = 5 * time.Second
+
+// tilingInnerGapPx/tilingOuterGapPx are tiling.Config's gaps: between
+// adjacent tiles, and between the tiles and the work-area edges. Both
+// default to 0 (tiles flush against each other and the screen edges).
+// Settable from tray submenus of presets, or any value within their
+// allowed range by hand-editing settingsFilePath; persisted (see
+// persistedSettings).
+var (
+	tilingInnerGapPx atomic.Int32
+	tilingOuterGapPx atomic.Int32
+)
+
+// Allowed ranges for tilingInnerGapPx/tilingOuterGapPx, enforced when
+// loading a (possibly hand-edited) settings file -- see atomicInt32Setting.
+const (
+	tilingGapPxMin int32 = 0
+	tilingGapPxMax int32 = 100
+)
+
+// tilingGapPxPresets are the values offered by both tiling gap submenus
+// (see MENU_TILING_INNER_GAP_BASE/MENU_TILING_OUTER_GAP_BASE). Each must
+// lie within [tilingGapPxMin, tilingGapPxMax].
+var tilingGapPxPresets = []int32{0, 4, 8, 12, 16, 24}
+
+// Cycling state. Main thread only, like every tiling entry point.
+var (
+	// tilingAlgorithmByMonitor is the algorithm each monitor was last
+	// tiled with. Keyed by HMONITOR, which stays stable while the monitor
+	// stays connected; a stale key after a display change is harmless (at
+	// worst a reconnected monitor starts from Columns again).
+	tilingAlgorithmByMonitor = map[windows.Handle]tiling.Algorithm{}
+	lastTiledMonitor         windows.Handle
+	lastTiledAt              time.Time
+)
+
+// errNoMonitorUnderCursor is returned when the cursor's monitor can't be
+// determined; tiling "the monitor you're on" has no sensible fallback.
+var errNoMonitorUnderCursor = errors.New("couldn't determine the monitor under the mouse cursor")
+
+// cursorMonitor returns the monitor the mouse cursor is on.
+func cursorMonitor() (monitor, error) {
+	var pt wincoe.POINT
+	if res := wincoe.GetCursorPos(&pt); res.Failed() {
+		return monitor{}, fmt.Errorf("GetCursorPos failed: %w", res.Err)
+	}
+	hMon := monitorFromPoint(pt, wincoe.MONITOR_DEFAULTTONEAREST)
+	for _, m := range enumMonitors() {
+		if m.hMon == hMon {
+			return m, nil
+		}
+	}
+	return monitor{}, errNoMonitorUnderCursor
+}
+
+// tilingCandidates returns the windows to tile on hMon, topmost first (so
+// the window the user is working in becomes the master / the largest BSP
+// tile): every layout-eligible window (see isManageableTopLevelWindow)
+// that isn't minimized and that Windows considers to be on hMon. Each
+// comes with whether it is currently maximized.
+func tilingCandidates(hMon windows.Handle) (hwnds []windows.Handle, maximized []bool) {
+	forEachTopLevelWindow(func(hwnd windows.Handle) bool {
+		if !isManageableTopLevelWindow(hwnd) || wincoe.MonitorFromWindow(hwnd, wincoe.MONITOR_DEFAULTTONEAREST) != hMon {
+			return true
+		}
+		var wp wincoe.WINDOWPLACEMENT
+		wp.Length = uint32(unsafe.Sizeof(wp))
+		if res := wincoe.GetWindowPlacement(hwnd, &wp); res.Failed() {
+			logf("tilingCandidates: GetWindowPlacement failed for HWND=0x%X: %v; leaving it out", hwnd, res.Err)
+			return true
+		}
+		if wp.ShowCmd == windows.SW_SHOWMINIMIZED {
+			return true
+		}
+		hwnds = append(hwnds, hwnd)
+		maximized = append(maximized, wp.ShowCmd == windows.SW_MAXIMIZE)
+		return true
+	})
+	return hwnds, maximized
+}
+
+// nextTilingAlgorithm picks the algorithm for an un-specified tile of
+// hMon, per the cycling rule described at the top of this file.
+func nextTilingAlgorithm(hMon windows.Handle) tiling.Algorithm {
+	alg := tilingAlgorithmByMonitor[hMon] // Columns if never tiled
+	if hMon == lastTiledMonitor && time.Since(lastTiledAt) < tilingCycleWindow {
+		alg = alg.Next()
+	}
+	return alg
+}
+
+// tileWindowsOnCursorMonitor tiles the cursor monitor's windows with alg,
+// or with nextTilingAlgorithm's pick if alg is nil, returning the
+// algorithm used and how many windows it placed. Main thread only.
+func tileWindowsOnCursorMonitor(alg *tiling.Algorithm) (tiling.Algorithm, int, error) {
+	mon, err := cursorMonitor()
+	if err != nil {
+		return 0, 0, err
+	}
+	use := nextTilingAlgorithm(mon.hMon)
+	if alg != nil {
+		use = *alg
+	}
+	hwnds, maximized := tilingCandidates(mon.hMon)
+	placed := placeTiles(use, mon, hwnds, maximized, "tileWindowsOnCursorMonitor")
+	return use, placed, nil
+}
+
+// tilingRestoreSettleMs is how long placeTiles gives a maximized window's
+// (posted) restore to take effect before placing it: its frame insets are
+// only the restored window's once it is restored.
+const tilingRestoreSettleMs = 100
+
+// pendingTile is a window placeTiles restored from maximized, still to be
+// placed in tile by onTilingRestoreTimer.
+type pendingTile struct {
+	hwnd     windows.Handle
+	tile     tiling.Rect
+	context3 string
+}
+
+// pendingTiles waits for tilingRestoreTimerID. Main thread only.
+var pendingTiles []pendingTile
+
+// placeTiles tiles hwnds (topmost first, with whether each is maximized)
+// on mon's work area with alg, recording alg as mon's last algorithm, and
+// returns how many windows it placed. Maximized windows are restored
+// first, since a maximized window ignores SetWindowPos, and placed
+// tilingRestoreSettleMs later (see onTilingRestoreTimer), once they have
+// their restored frame. Every call is posted (SWP_ASYNCWINDOWPOS), so a
+// hung app can't stall the main thread. Main thread only.
+func placeTiles(alg tiling.Algorithm, mon monitor, hwnds []windows.Handle, maximized []bool, context3 string) int {
+	tilingAlgorithmByMonitor[mon.hMon] = alg
+	lastTiledMonitor, lastTiledAt = mon.hMon, time.Now()
+
+	work := tiling.Rect{Left: mon.rcWork.Left, Top: mon.rcWork.Top, Right: mon.rcWork.Right, Bottom: mon.rcWork.Bottom}
+	tiles := tiling.Layout(alg, work, len(hwnds), tiling.Config{
+		OuterGapPx:    tilingOuterGapPx.Load(),
+		InnerGapPx:    tilingInnerGapPx.Load(),
+		MasterPercent: tilingMasterPercent,
+	})
+	wsDX, wsDY := primaryWorkspaceOffset()
+	placed, restoring := 0, 0
+	for i, hwnd := range hwnds {
+		recordWindowGeometry(hwnd, wsDX, wsDY)
+		if maximized[i] {
+			showWindowAsync(hwnd, windows.SW_SHOWNOACTIVATE)
+			pendingTiles = append(pendingTiles, pendingTile{hwnd: hwnd, tile: tiles[i], context3: context3})
+			restoring++
+			continue
+		}
+		if placeTile(hwnd, tiles[i], context3) {
+			placed++
+		}
+	}
+	if restoring > 0 {
+		if _, res := wincoe.SetTimer(loadMainMsgHwnd(), tilingRestoreTimerID, tilingRestoreSettleMs, 0); res.Failed() {
+			logf("%s: SetTimer failed: %v; placing the %d restored window(s) right away", context3, res.Err, restoring)
+			placePendingTiles()
+		}
+		placed += restoring
+	}
+	logf("%s: %s, placed %d of %d window(s) on HMONITOR=0x%X (%d once restored from maximized)", context3, alg, placed, len(hwnds), mon.hMon, restoring)
+	return placed
+}
+
+// placeTile moves hwnd into tile. The tiles are visible frames, so the
+// invisible resize borders are added back and the visible edges are what
+// line up.
+func placeTile(hwnd windows.Handle, tile tiling.Rect, context3 string) bool {
+	l, t, r, b := windowVisualEdgeInsets(hwnd)
+	if res := wincoe.SetWindowPos(hwnd, 0, tile.Left-l, tile.Top-t, tile.Width()+l+r, tile.Height()+t+b,
+		wincoe.SWP_NOZORDER|wincoe.SWP_NOACTIVATE|wincoe.SWP_ASYNCWINDOWPOS); res.Failed() {
+		logf("%s: SetWindowPos failed for HWND=0x%X: %v", context3, hwnd, res.Err)
+		if res.ErrIs(windows.ERROR_ACCESS_DENIED) {
+			logf("%s: HWND=0x%X is likely elevated; run winbollocks as admin to tile it", context3, hwnd)
+		}
+		return false
+	}
+	return true
+}
+
+// onTilingRestoreTimer is tilingRestoreTimerID's handler: place the
+// windows placeTiles restored from maximized.
+func onTilingRestoreTimer(hwnd windows.Handle) {
+	if res := wincoe.KillTimer(hwnd, tilingRestoreTimerID); res.Failed() {
+		logf("onTilingRestoreTimer: KillTimer failed: %v", res.Err)
+	}
+	placePendingTiles()
+}
+
+// placePendingTiles places and empties pendingTiles. A window still
+// maximized by now hasn't processed its restore (it's busy or hung) and
+// is left as it is rather than tiled with a maximized window's frame.
+func placePendingTiles() {
+	pending := pendingTiles
+	pendingTiles = nil
+	for _, p := range pending {
+		if !wincoe.IsWindow(p.hwnd) {
+			continue
+		}
+		if isMaximized(p.hwnd) {
+			logf("%s: HWND=0x%X is still maximized, not tiling it", p.context3, p.hwnd)
+			continue
+		}
+		placeTile(p.hwnd, p.tile, p.context3)
+	}
+}
+
+// tileWindowsAndNotify is the hotkey/tray/remote-command entry point:
+// requested is an algorithm name (see tiling.ParseAlgorithm), or "" to
+// cycle.
+func tileWindowsAndNotify(requested string) error {
+	var alg *tiling.Algorithm
+	if requested != "" {
+		a, err := tiling.ParseAlgorithm(requested)
+		if err != nil {
+			return err
+		}
+		alg = &a
+	}
+	used, n, err := tileWindowsOnCursorMonitor(alg)
+	if err != nil {
+		logf("tileWindowsAndNotify: %v", err)
+		showTrayInfo(selfName, fmt.Sprintf("Failed to tile windows: %v", err))
+		return err
+	}
+	if n == 0 {
+		showTrayInfo(selfName, "No windows to tile on this monitor.")
+		return nil
+	}
+	showTrayInfo(selfName, fmt.Sprintf("Tiled %d window(s) as %s.", n, used))
+	return nil
+}
+
+// appendTilingMenuItems appends the tray's tiling action and its gap
+// submenus to hMenu.
+func appendTilingMenuItems(hMenu windows.Handle) {
+	appendMenuChecked(hMenu, wincoe.MF_STRING, MENU_TILE_WINDOWS,
+		"Tile windows on the mouse's monitor (repeat to cycle layouts)"+hotkeyLabel(hotkeyTileWindows))
+	gapText := func(v int32) string {
+		if v == 0 {
+			return "none"
+		}
+		return fmt.Sprintf("%dpx", v)
+	}
+	appendInt32PresetSubmenu(hMenu, "    Tiling gap between windows", tilingGapPxPresets, tilingInnerGapPx.Load(),
+		MENU_TILING_INNER_GAP_BASE, gapText)
+	appendInt32PresetSubmenu(hMenu, "    Tiling gap at the work-area edges", tilingGapPxPresets, tilingOuterGapPx.Load(),
+		MENU_TILING_OUTER_GAP_BASE, gapText)
+}
+
+// handleTilingMenuCommand runs the tray command produced by
+// appendTilingMenuItems, reporting whether cmd was one.
+func handleTilingMenuCommand(cmd uint32) bool {
+	switch {
+	case cmd == MENU_TILE_WINDOWS:
+		_ = tileWindowsAndNotify("") // already logged and shown
+	case cmd >= MENU_TILING_INNER_GAP_BASE && int(cmd) < MENU_TILING_INNER_GAP_BASE+len(tilingGapPxPresets):
+		setInt32AndPersist(&tilingInnerGapPx, tilingGapPxPresets[cmd-MENU_TILING_INNER_GAP_BASE])
+	case cmd >= MENU_TILING_OUTER_GAP_BASE && int(cmd) < MENU_TILING_OUTER_GAP_BASE+len(tilingGapPxPresets):
+		setInt32AndPersist(&tilingOuterGapPx, tilingGapPxPresets[cmd-MENU_TILING_OUTER_GAP_BASE])
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,193 @@
+// Package tiling computes tiled arrangements of windows within one
+// monitor's work area: columns, rows, master-stack and binary space
+// partition. Like snapengine it is pure geometry -- no Win32, no globals --
+// so the arrangements are golden-tested on synthetic monitors and window
+// lists (see testdata/) and the main package (see tileWindowsOnCursorMonitor)
+// only gathers windows and applies the rects.
+//
+// Rectangles follow Win32 RECT conventions (exclusive Right/Bottom, screen
+// pixels) and describe each window's VISIBLE frame; the caller accounts for
+// invisible resize borders itself.
+package tiling
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Rect is a screen-space rectangle with exclusive Right/Bottom.
+type Rect struct {
+	Left, Top, Right, Bottom int32
+}
+
+// Width returns Right-Left.
+func (r Rect) Width() int32 { return r.Right - r.Left }
+
+// Height returns Bottom-Top.
+func (r Rect) Height() int32 { return r.Bottom - r.Top }
+
+// Algorithm selects an arrangement.
+type Algorithm int
+
+const (
+	// Columns gives every window an equal-width, full-height column, first
+	// window leftmost.
+	Columns Algorithm = iota
+	// Rows gives every window an equal-height, full-width row, first window
+	// on top.
+	Rows
+	// MasterStack gives the first window a full-height master column on the
+	// left (Config.MasterPercent of the width) and stacks the rest as
+	// equal-height rows on the right.
+	MasterStack
+	// BSP splits the area in two along its longer side, gives the first
+	// window one half and recursively partitions the other half among the
+	// rest (a "dwindle" layout): every window gets half the space of the one
+	// before it, so the first few stay usefully large however many there
+	// are.
+	BSP
+
+	numAlgorithms = iota
+)
+
+var algorithmNames = [numAlgorithms]string{"columns", "rows", "master-stack", "bsp"}
+
+// String returns the algorithm's name as ParseAlgorithm accepts it.
+func (a Algorithm) String() string {
+	if a < 0 || a >= numAlgorithms {
+		return fmt.Sprintf("Algorithm(%d)", int(a))
+	}
+	return algorithmNames[a]
+}
+
+// Next returns the algorithm after a, wrapping around, for cycling through
+// them on repeated tiling.
+func (a Algorithm) Next() Algorithm {
+	return (a + 1) % numAlgorithms
+}
+
+// ParseAlgorithm is the inverse of Algorithm.String, case-insensitive.
+func ParseAlgorithm(s string) (Algorithm, error) {
+	for i, n := range algorithmNames {
+		if strings.EqualFold(s, n) {
+			return Algorithm(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown tiling layout %q, expected one of %s", s, strings.Join(algorithmNames[:], ", "))
+}
+
+// Config holds the tunables shared by every algorithm.
+//
+// OuterGapPx is kept free between the tiles and the work-area edges,
+// InnerGapPx between adjacent tiles. Gaps that would leave a tile less than
+// MinTilePx wide or high are dropped for that split (a cramped tile is
+// better than one with no room at all). MasterPercent is MasterStack's
+// master column width; values outside 10..90 are clamped.
+type Config struct {
+	OuterGapPx    int32
+	InnerGapPx    int32
+	MasterPercent int32
+}
+
+// MinTilePx is the smallest tile side gaps are allowed to squeeze down to.
+const MinTilePx = 32
+
+// Layout returns n tiles for work under alg and cfg, one per window, in the
+// same order as the windows (callers pass them topmost first, so the window
+// the user is working in becomes the master / the largest BSP tile).
+// Returns nil for n <= 0.
+func Layout(alg Algorithm, work Rect, n int, cfg Config) []Rect {
+	if n <= 0 {
+		return nil
+	}
+	area := inset(work, cfg.OuterGapPx)
+	switch alg {
+	case Rows:
+		return splitRows(area, n, cfg.InnerGapPx)
+	case MasterStack:
+		return masterStack(area, n, cfg)
+	case BSP:
+		return bsp(area, n, cfg.InnerGapPx)
+	default:
+		return splitColumns(area, n, cfg.InnerGapPx)
+	}
+}
+
+// inset shrinks r by gap on every side, unless that would leave less than
+// MinTilePx either way.
+func inset(r Rect, gap int32) Rect {
+	if gap <= 0 || r.Width()-2*gap < MinTilePx || r.Height()-2*gap < MinTilePx {
+		return r
+	}
+	return Rect{r.Left + gap, r.Top + gap, r.Right - gap, r.Bottom - gap}
+}
+
+// split divides [lo, hi) into n spans separated by gap, as equal as
+// integer pixels allow: the first (total % n) spans are one pixel larger,
+// so the spans always exactly fill [lo, hi) with nothing lost to rounding.
+func split(lo, hi int32, n int, gap int32) [][2]int32 {
+	count := int32(n) // #nosec G115 -- n is a window count, far below int32 range
+	if gap < 0 || (hi-lo)-gap*(count-1) < MinTilePx*count {
+		gap = 0
+	}
+	total := (hi - lo) - gap*(count-1)
+	size, extra := total/count, total%count
+	spans := make([][2]int32, n)
+	pos := lo
+	for i := range spans {
+		s := size
+		if int32(i) < extra { // #nosec G115 -- see count above
+			s++
+		}
+		spans[i] = [2]int32{pos, pos + s}
+		pos += s + gap
+	}
+	return spans
+}
+
+func splitColumns(area Rect, n int, gap int32) []Rect {
+	out := make([]Rect, 0, n)
+	for _, s := range split(area.Left, area.Right, n, gap) {
+		out = append(out, Rect{s[0], area.Top, s[1], area.Bottom})
+	}
+	return out
+}
+
+func splitRows(area Rect, n int, gap int32) []Rect {
+	out := make([]Rect, 0, n)
+	for _, s := range split(area.Top, area.Bottom, n, gap) {
+		out = append(out, Rect{area.Left, s[0], area.Right, s[1]})
+	}
+	return out
+}
+
+func masterStack(area Rect, n int, cfg Config) []Rect {
+	if n == 1 {
+		return []Rect{area}
+	}
+	pct := min(max(cfg.MasterPercent, 10), 90)
+	gap := cfg.InnerGapPx
+	if gap < 0 || area.Width()-gap < 2*MinTilePx {
+		gap = 0
+	}
+	// Keep both columns at least MinTilePx wide whatever the percentage.
+	masterW := min(max((area.Width()-gap)*pct/100, MinTilePx), area.Width()-gap-MinTilePx)
+	master := Rect{area.Left, area.Top, area.Left + masterW, area.Bottom}
+	stack := Rect{master.Right + gap, area.Top, area.Right, area.Bottom}
+	return append([]Rect{master}, splitRows(stack, n-1, cfg.InnerGapPx)...)
+}
+
+func bsp(area Rect, n int, gap int32) []Rect {
+	out := make([]Rect, 0, n)
+	for i := 0; i < n-1; i++ {
+		var halves []Rect
+		if area.Width() >= area.Height() {
+			halves = splitColumns(area, 2, gap)
+		} else {
+			halves = splitRows(area, 2, gap)
+		}
+		out = append(out, halves[0])
+		area = halves[1]
+	}
+	return append(out, area)
+}
@@ -0,0 +1,126 @@
+package tiling
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Regenerate the golden files after an intentional layout change with:
+//
+//	go test ./tiling/ -update
+//
+// and review the diff of testdata/ like any other code change.
+var update = flag.Bool("update", false, "rewrite testdata/*.golden from the current output")
+
+// scenario is one synthetic monitor plus the window counts to tile on it.
+type scenario struct {
+	name   string
+	work   Rect
+	cfg    Config
+	counts []int
+}
+
+var scenarios = []scenario{
+	{"1080p_taskbar_bottom_no_gaps", Rect{0, 0, 1920, 1040}, Config{MasterPercent: 60}, []int{1, 2, 3, 4, 5}},
+	{"1080p_taskbar_bottom_gaps", Rect{0, 0, 1920, 1040}, Config{OuterGapPx: 8, InnerGapPx: 8, MasterPercent: 60}, []int{1, 2, 3, 4, 5}},
+	// A portrait monitor to the right of, and partly above, the primary.
+	{"portrait_secondary_negative_top", Rect{1920, -300, 3000, 1620}, Config{OuterGapPx: 10, InnerGapPx: 4, MasterPercent: 50}, []int{2, 3, 7}},
+	// 1366 doesn't divide evenly by 3 or 7: the remainder pixels must go to
+	// the first tiles, never be lost.
+	{"1366_uneven_division", Rect{0, 0, 1366, 728}, Config{InnerGapPx: 5, MasterPercent: 60}, []int{3, 7}},
+	// Far too small for the requested gaps: gaps are dropped instead of
+	// producing empty tiles.
+	{"tiny_area_gaps_dropped", Rect{0, 0, 100, 90}, Config{OuterGapPx: 40, InnerGapPx: 30, MasterPercent: 200}, []int{2, 3}},
+}
+
+func render(sc scenario) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# work=%d,%d,%d,%d outer=%d inner=%d master=%d\n",
+		sc.work.Left, sc.work.Top, sc.work.Right, sc.work.Bottom, sc.cfg.OuterGapPx, sc.cfg.InnerGapPx, sc.cfg.MasterPercent)
+	for alg := Algorithm(0); alg < numAlgorithms; alg++ {
+		for _, n := range sc.counts {
+			fmt.Fprintf(&b, "\n== %s n=%d\n", alg, n)
+			for i, r := range Layout(alg, sc.work, n, sc.cfg) {
+				fmt.Fprintf(&b, "%d: %d,%d %dx%d\n", i, r.Left, r.Top, r.Width(), r.Height())
+			}
+		}
+	}
+	return b.String()
+}
+
+func TestLayoutGolden(t *testing.T) {
+	for _, sc := range scenarios {
+		got := render(sc)
+		path := filepath.Join("testdata", sc.name+".golden")
+		if *update {
+			if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("%s: %v (run with -update to create it)", sc.name, err)
+		}
+		if got != string(want) {
+			t.Errorf("%s: layout differs from %s (run with -update and review the diff if intended)\ngot:\n%s", sc.name, path, got)
+		}
+	}
+}
+
+// TestLayoutInvariants checks what must hold for every algorithm whatever
+// the golden files say: one tile per window, every tile non-empty and
+// inside the work area, and no two tiles overlapping.
+func TestLayoutInvariants(t *testing.T) {
+	for _, sc := range scenarios {
+		for alg := Algorithm(0); alg < numAlgorithms; alg++ {
+			for n := 1; n <= 9; n++ {
+				tiles := Layout(alg, sc.work, n, sc.cfg)
+				if len(tiles) != n {
+					t.Fatalf("%s/%s/n=%d: got %d tiles", sc.name, alg, n, len(tiles))
+				}
+				for i, r := range tiles {
+					if r.Width() <= 0 || r.Height() <= 0 {
+						t.Errorf("%s/%s/n=%d: tile %d is empty: %+v", sc.name, alg, n, i, r)
+					}
+					if r.Left < sc.work.Left || r.Top < sc.work.Top || r.Right > sc.work.Right || r.Bottom > sc.work.Bottom {
+						t.Errorf("%s/%s/n=%d: tile %d %+v outside work area %+v", sc.name, alg, n, i, r, sc.work)
+					}
+					for j := i + 1; j < n; j++ {
+						o := tiles[j]
+						if r.Left < o.Right && o.Left < r.Right && r.Top < o.Bottom && o.Top < r.Bottom {
+							t.Errorf("%s/%s/n=%d: tiles %d %+v and %d %+v overlap", sc.name, alg, n, i, r, j, o)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestLayoutNoWindows(t *testing.T) {
+	if got := Layout(Columns, Rect{0, 0, 100, 100}, 0, Config{}); got != nil {
+		t.Errorf("Layout with n=0 = %v, want nil", got)
+	}
+}
+
+func TestAlgorithmCycleAndParse(t *testing.T) {
+	a := Columns
+	for i := 0; i < int(numAlgorithms); i++ {
+		parsed, err := ParseAlgorithm(strings.ToUpper(a.String()))
+		if err != nil || parsed != a {
+			t.Errorf("ParseAlgorithm(%q) = %v, %v", a.String(), parsed, err)
+		}
+		a = a.Next()
+	}
+	if a != Columns {
+		t.Errorf("Next didn't wrap around to Columns after %d steps, got %v", numAlgorithms, a)
+	}
+	if _, err := ParseAlgorithm("spiral"); err == nil {
+		t.Error("ParseAlgorithm accepted an unknown name")
+	}
+}
@@ -0,0 +1,398 @@
+//go:build windows && amd64
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/workturnedplay/wincoe"
+
+	"github.com/workturnedplay/winbollocks/winlayout"
+)
+
+/* ---------------- Monitors ---------------- */
+
+// monitor is one display monitor as enumMonitors reports it.
+type monitor struct {
+	hMon      windows.Handle
+	rcMonitor wincoe.RECT
+	rcWork    wincoe.RECT
+}
+
+// enumMonitorsScratch is where enumMonitorsCallback collects results for
+// the enumMonitors call currently on the stack. EnumDisplayMonitors calls
+// its callback synchronously on the calling thread, and enumMonitors only
+// ever runs on the main thread, so a plain package-level slice is enough
+// (and saves smuggling a Go pointer through the callback's LPARAM).
+var enumMonitorsScratch []monitor
+
+// enumMonitorsCallback is created once: windows.NewCallback slots are a
+// finite, never-freed resource, so it must not be called per enumeration.
+var enumMonitorsCallback = windows.NewCallback(func(hMon, _ windows.Handle, _, _ uintptr) uintptr {
+	var mi wincoe.MONITORINFO
+	if res := wincoe.GetMonitorInfo(hMon, &mi); res.Failed() {
+		logf("enumMonitors: GetMonitorInfo failed for HMONITOR=0x%X: %v; leaving it out", hMon, res.Err)
+		return 1 // keep enumerating
+	}
+	enumMonitorsScratch = append(enumMonitorsScratch, monitor{hMon: hMon, rcMonitor: mi.RcMonitor, rcWork: mi.RcWork})
+	return 1
+})
+
+// enumMonitors returns every monitor of the desktop, left to right (then
+// top to bottom), or nil if enumeration failed. Main thread only (see
+// enumMonitorsScratch).
+func enumMonitors() []monitor {
+	enumMonitorsScratch = nil
+	res := procEnumDisplayMonitors.Call(0, 0, enumMonitorsCallback, 0)
+	mons := enumMonitorsScratch
+	enumMonitorsScratch = nil
+	if res.Failed() {
+		logf("enumMonitors: EnumDisplayMonitors failed: %v", res.Err)
+		return nil
+	}
+	sort.Slice(mons, func(i, j int) bool {
+		if mons[i].rcMonitor.Left != mons[j].rcMonitor.Left {
+			return mons[i].rcMonitor.Left < mons[j].rcMonitor.Left
+		}
+		return mons[i].rcMonitor.Top < mons[j].rcMonitor.Top
+	})
+	return mons
+}
+
+/* ---------------- Per-topology layouts ---------------- */
+
+// Docking or undocking a laptop makes Windows shove every window onto
+// whatever monitors are left, and it never puts them back. To undo that,
+// winbollocks keeps one layout per monitor topology (see
+// winlayout.TopologyName for what distinguishes one topology from another):
+// while a topology is current, its layout is re-snapshotted every
+// topologySnapshotIntervalMs; when the topology changes (WM_DISPLAYCHANGE,
+// or a monitor device arriving/leaving -- see handleDisplayChange and
+// handleMonitorDeviceChange) and the new one has a snapshot from last time,
+// that snapshot is restored exactly like a named layout (see applyLayout).
+
+// topologyLayoutsFilePath holds the per-topology layouts, one [section]
+// per topology, in the same format as layoutsFilePath. It's a separate
+// file so these automatic snapshots neither clutter the tray's named-layout
+// list nor ever overwrite a layout the user saved on purpose.
+const topologyLayoutsFilePath = selfName + "_topologies.ini"
+
+const topologyLayoutsFileHeader = `winbollocks per-monitor-setup layouts -- rewritten automatically (see "Window layouts" in the tray).
+Each [section] is named after a monitor setup and holds where every window was the last time that setup was in use;
+it is restored when that setup comes back. Same format as the named layouts file; deleting a section just forgets it.`
+
+// topologySettleMs is how long display-change notifications must stay
+// quiet before the new topology is acted on. A single dock/undock produces
+// a burst of them (one WM_DISPLAYCHANGE per mode switch, one device
+// notification per monitor) spread over a second or so, and Windows itself
+// is still shuffling windows around during that burst; restoring in the
+// middle of it would just get undone.
+const topologySettleMs = 2000
+
+// topologySnapshotIntervalMs is how often the current topology's layout is
+// re-captured. The file is only rewritten when a window actually moved,
+// resized, changed state or restacked, or came or went (see
+// snapshotTopologyLayout), so an idle desktop costs one window enumeration
+// per interval and no disk writes -- even with a clock or a progress
+// percentage in some window's title.
+const topologySnapshotIntervalMs = 30000
+
+// perTopologyLayoutsEnabled turns per-topology snapshots and their
+// automatic restore on or off. Off by default: it writes a file in the
+// background and moves windows on its own when the monitors change, which
+// should be asked for. Topology changes are still tracked while it's off,
+// so turning it on never "restores" into a topology that changed while it
+// was off.
+var perTopologyLayoutsEnabled atomic.Bool
+
+// Topology tracking state. Main thread only: every reader and writer runs
+// from wndProc (timers, display/device notifications, tray) or from
+// runApplication before the message loop starts.
+var (
+	// currentTopology is the topology whose layout snapshots are being
+	// taken, "" until startTopologyTracking has run.
+	currentTopology string
+	// topologySettlePending is true while topologySettleTimerID is armed:
+	// a change was notified but not yet acted on. Snapshots are suspended
+	// meanwhile, since the windows are mid-scramble.
+	topologySettlePending bool
+	// lastTopologySnapshot is topologySnapshotKey of the last snapshot
+	// written for currentTopology, to skip rewriting an unchanged one.
+	lastTopologySnapshot string
+)
+
+// currentTopologyName enumerates the monitors and names their topology,
+// reporting false if there currently are none (mid-reconfiguration, or
+// enumeration failed) -- never a topology worth remembering.
+func currentTopologyName() (string, bool) {
+	mons := enumMonitors()
+	if len(mons) == 0 {
+		return "", false
+	}
+	rects := make([]winlayout.Rect, len(mons))
+	for i, m := range mons {
+		rects[i] = winlayout.Rect{Left: m.rcMonitor.Left, Top: m.rcMonitor.Top, Right: m.rcMonitor.Right, Bottom: m.rcMonitor.Bottom}
+	}
+	return winlayout.TopologyName(rects), true
+}
+
+// startTopologyTracking records the startup topology (without restoring
+// anything for it -- merely starting winbollocks must never move windows)
+// and starts the periodic snapshot timer on hwnd.
+func startTopologyTracking(hwnd windows.Handle) {
+	if name, ok := currentTopologyName(); ok {
+		currentTopology = name
+		logf("startTopologyTracking: current monitor setup is %q", name)
+	}
+	if _, res := wincoe.SetTimer(hwnd, topologySnapshotTimerID, topologySnapshotIntervalMs, 0); res.Failed() {
+		logf("startTopologyTracking: SetTimer failed: %v; per-monitor-setup layouts won't be snapshotted this run", res.Err)
+	}
+}
+
+// scheduleTopologySettle (re)arms topologySettleTimerID, so the topology is
+// only re-examined once notifications have been quiet for
+// topologySettleMs (SetTimer with an existing ID restarts it).
+func scheduleTopologySettle(why string) {
+	if _, res := wincoe.SetTimer(loadMainMsgHwnd(), topologySettleTimerID, topologySettleMs, 0); res.Failed() {
+		logf("scheduleTopologySettle(%s): SetTimer failed: %v; handling the change right away instead", why, res.Err)
+		onTopologySettled()
+		return
+	}
+	if !topologySettlePending {
+		logf("scheduleTopologySettle: %s; waiting %dms for the display configuration to settle", why, topologySettleMs)
+	}
+	topologySettlePending = true
+}
+
+// onTopologySettled runs once display notifications have gone quiet: first
+// the optional off-screen rescue (see rescueWindowsAfterDisplayChange),
+// then, if the topology really changed, it becomes current and, if a
+// snapshot exists for it, that snapshot is restored.
+func onTopologySettled() {
+	if res := wincoe.KillTimer(loadMainMsgHwnd(), topologySettleTimerID); res.Failed() {
+		logf("onTopologySettled: KillTimer failed: %v", res.Err)
+	}
+	topologySettlePending = false
+
+	name, ok := currentTopologyName()
+	if !ok {
+		logf("onTopologySettled: no monitors right now; waiting for the next display change")
+		return
+	}
+	// Rescue first: a restored layout then overrides it for every window
+	// it has a saved place for (both are applied asynchronously, in order).
+	rescued := 0
+	if rescueWindowsAfterDisplayChange.Load() {
+		rescued = rescueOffscreenWindows()
+	}
+	if name == currentTopology {
+		logf("onTopologySettled: monitor setup unchanged (%q)", name)
+		if rescued > 0 {
+			showTrayInfo(selfName, rescuedWindowsMessage(rescued))
+		}
+		return
+	}
+	logf("onTopologySettled: monitor setup changed from %q to %q", currentTopology, name)
+	currentTopology = name
+	lastTopologySnapshot = ""
+
+	layout, found := topologyLayoutFor(name)
+	if !found {
+		if rescued > 0 {
+			showTrayInfo(selfName, rescuedWindowsMessage(rescued))
+		}
+		return
+	}
+	res := applyLayout(layout)
+	msg := fmt.Sprintf("Monitor setup changed (%s): put %d of %d window(s) back.", name, res.placed, res.saved)
+	if rescued > 0 {
+		msg += " " + rescuedWindowsMessage(rescued)
+	}
+	showTrayInfo(selfName, msg)
+}
+
+// topologyLayoutFor returns the saved layout for topology name, if
+// per-topology layouts are enabled and one has been saved.
+func topologyLayoutFor(name string) (winlayout.Layout, bool) {
+	if !perTopologyLayoutsEnabled.Load() {
+		return winlayout.Layout{}, false
+	}
+	layouts, err := readLayoutsFile(topologyLayoutsFilePath)
+	if err != nil {
+		logf("topologyLayoutFor: %v", err)
+		return winlayout.Layout{}, false
+	}
+	layout, found := winlayout.Find(layouts, name)
+	if !found {
+		logf("topologyLayoutFor: no saved layout for %q yet; it will be remembered from now on", name)
+	}
+	return layout, found
+}
+
+// snapshotTopologyLayout captures the current windows as currentTopology's
+// layout and stores it in topologyLayoutsFilePath if it differs from the
+// last one stored by more than window titles (see topologySnapshotKey). Skipped while a topology change is settling or a
+// move/resize gesture is in flight (half-dragged windows aren't where the
+// user wants them), and when there are no eligible windows at all (so
+// e.g. logging off, with everything closing, can't wipe a good snapshot).
+// There's deliberately no snapshot at exit for the same reason.
+func snapshotTopologyLayout() {
+	if !perTopologyLayoutsEnabled.Load() || topologySettlePending || currentTopology == "" || activeSession.Load() != nil {
+		return
+	}
+	name, ok := currentTopologyName()
+	if !ok {
+		return
+	}
+	if name != currentTopology {
+		// A change we weren't notified of (or whose notification is still
+		// queued): never file this snapshot under the old topology.
+		scheduleTopologySettle("snapshot found a different monitor setup")
+		return
+	}
+	layout := captureLayout(name)
+	if len(layout.Windows) == 0 {
+		return
+	}
+	key := topologySnapshotKey(layout)
+	if key == lastTopologySnapshot {
+		return
+	}
+	layouts, err := readLayoutsFile(topologyLayoutsFilePath)
+	if err != nil {
+		logf("snapshotTopologyLayout: %v", err)
+		return
+	}
+	if err := writeLayoutsFile(topologyLayoutsFilePath, topologyLayoutsFileHeader, winlayout.Upsert(layouts, layout)); err != nil {
+		logf("snapshotTopologyLayout: %v", err)
+		return
+	}
+	lastTopologySnapshot = key
+}
+
+// topologySnapshotKey is layout formatted without its windows' titles:
+// what a snapshot is compared by. Titles change all the time (documents,
+// tabs, clocks) without anything moving, and restoring only needs them to
+// tell apart windows that are otherwise alike, so a title change alone
+// isn't worth a rewrite.
+func topologySnapshotKey(layout winlayout.Layout) string {
+	untitled := layout
+	untitled.Windows = slices.Clone(layout.Windows)
+	for i := range untitled.Windows {
+		untitled.Windows[i].Title = ""
+	}
+	return string(winlayout.Format("", []winlayout.Layout{untitled}))
+}
+
+// handleTopologyTimer is wndProc's WM_TIMER hook for the topology timers,
+// reporting whether timerID was one of them.
+func handleTopologyTimer(timerID uintptr) bool {
+	switch timerID {
+	case topologySettleTimerID:
+		onTopologySettled()
+	case topologySnapshotTimerID:
+		snapshotTopologyLayout()
+	default:
+		return false
+	}
+	return true
+}
+
+/* ---------------- Display change notifications ---------------- */
+
+// Messages and WM_DEVICECHANGE details wincoe doesn't export.
+const (
+	WM_DISPLAYCHANGE = 0x007E
+	WM_DEVICECHANGE  = 0x0219
+
+	DBT_DEVICEARRIVAL           = 0x8000
+	DBT_DEVICEREMOVECOMPLETE    = 0x8004
+	DBT_DEVTYP_DEVICEINTERFACE  = 5
+	DEVICE_NOTIFY_WINDOW_HANDLE = 0
+)
+
+// GUID_DEVINTERFACE_MONITOR is the device interface class of monitors.
+var GUID_DEVINTERFACE_MONITOR = windows.GUID{
+	Data1: 0xE6F07B5F, Data2: 0xEE97, Data3: 0x4A90,
+	Data4: [8]byte{0xB0, 0x76, 0x33, 0xF5, 0x7B, 0xF4, 0xEA, 0xA7},
+}
+
+// DEV_BROADCAST_DEVICEINTERFACE_W is both RegisterDeviceNotificationW's
+// filter and the lParam of the matching WM_DEVICECHANGEs; DEV_BROADCAST_HDR
+// is the common header every WM_DEVICECHANGE lParam starts with.
+type (
+	DEV_BROADCAST_HDR struct {
+		DbchSize       uint32
+		DbchDeviceType uint32
+		DbchReserved   uint32
+	}
+	DEV_BROADCAST_DEVICEINTERFACE_W struct {
+		DbccSize       uint32
+		DbccDeviceType uint32
+		DbccReserved   uint32
+		DbccClassGUID  windows.GUID
+		DbccName       [1]uint16
+	}
+)
+
+// registerMonitorDeviceNotifications subscribes hwnd to monitor
+// arrival/removal WM_DEVICECHANGEs and returns the matching unregister
+// func (a no-op if registering failed). WM_DISPLAYCHANGE needs no
+// registration -- it's broadcast to every top-level window, which the main
+// message window is -- but doesn't fire for every monitor hot-plug on
+// every driver, hence both.
+func registerMonitorDeviceNotifications(hwnd windows.Handle) (unregister func()) {
+	filter := DEV_BROADCAST_DEVICEINTERFACE_W{
+		DbccDeviceType: DBT_DEVTYP_DEVICEINTERFACE,
+		DbccClassGUID:  GUID_DEVINTERFACE_MONITOR,
+	}
+	filter.DbccSize = uint32(unsafe.Sizeof(filter))
+	res := procRegisterDeviceNotificationW.Call(uintptr(hwnd), uintptr(unsafe.Pointer(&filter)), DEVICE_NOTIFY_WINDOW_HANDLE)
+	if res.Failed() {
+		logf("RegisterDeviceNotificationW failed, err: %v; monitor hot-plug will only be noticed via WM_DISPLAYCHANGE this run.", res.Err)
+		return func() {}
+	}
+	hNotify := res.R1
+	return func() {
+		if res2 := procUnregisterDeviceNotification.Call(hNotify); res2.Failed() {
+			logf("UnregisterDeviceNotification failed, err: %v", res2.Err)
+		}
+	}
+}
+
+// handleDisplayChange is wndProc's WM_DISPLAYCHANGE handler.
+func handleDisplayChange() {
+	refreshHotCornerMonitors()
+	scheduleTopologySettle("WM_DISPLAYCHANGE")
+}
+
+// handleMonitorDeviceChange is wndProc's WM_DEVICECHANGE handler. Besides
+// the monitor notifications registered for above, every top-level window
+// also gets unsolicited broadcasts (volumes, DBT_DEVNODES_CHANGED, ...),
+// which are ignored.
+func handleMonitorDeviceChange(wParam, lParam uintptr) {
+	if (wParam != DBT_DEVICEARRIVAL && wParam != DBT_DEVICEREMOVECOMPLETE) || lParam == 0 {
+		return
+	}
+	// Same pointer-in-a-uintptr reinterpretation as
+	// handleRemoteCommandCopyData: lParam is valid for this message only.
+	hdr := *(**DEV_BROADCAST_HDR)(unsafe.Pointer(&lParam))
+	if hdr.DbchDeviceType != DBT_DEVTYP_DEVICEINTERFACE {
+		return
+	}
+	iface := *(**DEV_BROADCAST_DEVICEINTERFACE_W)(unsafe.Pointer(&lParam))
+	if iface.DbccClassGUID != GUID_DEVINTERFACE_MONITOR {
+		return
+	}
+	what := "monitor arrived"
+	if wParam == DBT_DEVICEREMOVECOMPLETE {
+		what = "monitor removed"
+	}
+	scheduleTopologySettle(what)
+}
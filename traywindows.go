@@ -0,0 +1,416 @@
+//go:build windows && amd64
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/workturnedplay/wincoe"
+)
+
+/* ---------------- Hide windows to the tray ---------------- */
+
+// Hiding to the tray hides the window under the cursor (SW_HIDE, so it
+// leaves the taskbar and Alt+Tab too) and gives it a notification-area
+// icon of its own, with the window's icon and title; clicking that icon
+// shows and focuses the window again and removes the icon.
+//
+// A hidden window has no taskbar button and no other way back, so losing
+// track of one would orphan it invisibly until its process ends. Hence:
+//   - deinitTrayWindows shows every hidden window again when winbollocks
+//     exits (including via a panic, see primary_defer), and
+//   - trayWindowsFilePath lists the hidden windows on disk while any exist,
+//     so that after a hard kill the next run's restoreOrphanedTrayWindows
+//     shows them again, and
+//   - when Explorer restarts, taking every notification-area icon with it,
+//     handleTaskbarCreated adds their icons (and our own) back.
+
+// trayWindowsFilePath lists the currently hidden windows, one
+// "<hwnd> <pid>" line each; it's removed when nothing is hidden. Not meant
+// for editing -- see the top of this file.
+const trayWindowsFilePath = selfName + "_hidden_windows.txt"
+
+// firstTrayWindowIconUID is the NOTIFYICONDATA.UID of the first hidden
+// window's icon; the app's own icon is UID 1 (see initTray).
+const firstTrayWindowIconUID = 1000
+
+// Win32 bits for fetching a window's icon that wincoe doesn't export.
+const (
+	WM_GETICON       = 0x007F
+	ICON_SMALL       = 0
+	ICON_BIG         = 1
+	ICON_SMALL2      = 2
+	GCLP_HICON       = -14
+	GCLP_HICONSM     = -34
+	getIconTimeoutMs = 200 // ms, per WM_GETICON; a hung app just gets the default icon
+)
+
+// trayWindow is one hidden window and its notification-area icon.
+type trayWindow struct {
+	hwnd windows.Handle
+	pid  uint32
+	uid  uint32
+	icon windows.Handle // our own copy (CopyIcon), destroyed with the tray icon
+}
+
+// trayWindows is the hidden windows, by icon UID. Changed only on the main
+// thread, but also read by the hook thread (see isTrayWindow), hence the
+// mutex.
+var (
+	trayWindowsMu     sync.Mutex
+	trayWindows              = map[uint32]*trayWindow{}
+	nextTrayWindowUID uint32 = firstTrayWindowIconUID
+)
+
+// isTrayWindow reports whether hwnd is hidden to the tray. Any thread.
+func isTrayWindow(hwnd windows.Handle) bool {
+	trayWindowsMu.Lock()
+	defer trayWindowsMu.Unlock()
+	for _, tw := range trayWindows {
+		if tw.hwnd == hwnd {
+			return true
+		}
+	}
+	return false
+}
+
+// windowIconCopy returns a copy of hwnd's small icon, falling back to its
+// large icon, its class icons and finally the generic application icon.
+// The caller owns the copy (see destroyIcon).
+func windowIconCopy(hwnd windows.Handle) windows.Handle {
+	var icon uintptr
+	for _, which := range []uintptr{ICON_SMALL2, ICON_SMALL, ICON_BIG} {
+		if res := wincoe.SendMessageTimeout(hwnd, WM_GETICON, which, 0, wincoe.SMTO_ABORTIFHUNG, getIconTimeoutMs, &icon); res.Failed() || icon != 0 {
+			break
+		}
+	}
+	return iconCopyOrFallback(hwnd, icon)
+}
+
+// windowClassIconCopy is windowIconCopy without asking the window itself:
+// it reads only hwnd's class icons, which never waits on hwnd's thread, so
+// it is cheap enough to run for every entry of a menu being built.
+func windowClassIconCopy(hwnd windows.Handle) windows.Handle {
+	return iconCopyOrFallback(hwnd, 0)
+}
+
+// iconCopyOrFallback copies icon, or when it is 0, hwnd's class icons or
+// finally the generic application icon.
+func iconCopyOrFallback(hwnd windows.Handle, icon uintptr) windows.Handle {
+	for _, idx := range []int32{GCLP_HICONSM, GCLP_HICON} {
+		if icon != 0 {
+			break
+		}
+		// #nosec G115 -- safe: GCLP_* are small negative indexes, sign-extended as Win32 expects
+		icon = procGetClassLongPtrW.Call(uintptr(hwnd), uintptr(idx)).R1
+	}
+	if icon == 0 {
+		h, res := wincoe.LoadIconByID(0, wincoe.IDI_APPLICATION)
+		if res.Failed() {
+			logf("iconCopyOrFallback: LoadIcon(IDI_APPLICATION) failed: %v", res.Err)
+			return 0
+		}
+		icon = uintptr(h)
+	}
+	cp, res := wincoe.CopyIcon(windows.Handle(icon))
+	if res.Failed() {
+		logf("iconCopyOrFallback: CopyIcon failed for HWND=0x%X: %v", hwnd, res.Err)
+		return 0
+	}
+	return cp
+}
+
+// destroyIcon frees an icon from windowIconCopy or windowClassIconCopy.
+func destroyIcon(icon windows.Handle) {
+	if icon == 0 {
+		return
+	}
+	if res := procDestroyIcon.Call(uintptr(icon)); res.Failed() {
+		logf("destroyIcon: DestroyIcon(0x%X) failed: %v", icon, res.Err)
+	}
+}
+
+// trayWindowIconData is the NOTIFYICONDATA for tw's icon.
+func trayWindowIconData(tw *trayWindow) wincoe.NOTIFYICONDATA {
+	var nid wincoe.NOTIFYICONDATA
+	nid.CbSize = uint32(unsafe.Sizeof(nid))
+	nid.HWnd = loadMainMsgHwnd()
+	nid.UID = tw.uid
+	nid.UFlags = wincoe.NIF_TIP | wincoe.NIF_ICON | wincoe.NIF_MESSAGE
+	nid.UCallbackMessage = WM_TRAY_WINDOW_ICON
+	nid.HIcon = tw.icon
+	title := getWindowTextFast(tw.hwnd)
+	if title == "" {
+		title = fmt.Sprintf("Hidden window 0x%X", tw.hwnd)
+	}
+	copyUTF16Truncated(nid.SzTip[:], title)
+	return nid
+}
+
+// hideWindowToTray hides hwnd and adds its tray icon. Main thread only.
+func hideWindowToTray(hwnd windows.Handle) error {
+	if isTrayWindow(hwnd) {
+		return fmt.Errorf("HWND=0x%X is already hidden to the tray", hwnd)
+	}
+	var pid uint32
+	if _, res := wincoe.GetWindowThreadProcessId(hwnd, &pid); res.Failed() {
+		return fmt.Errorf("GetWindowThreadProcessId failed for HWND=0x%X: %w", hwnd, res.Err)
+	}
+	tw := &trayWindow{hwnd: hwnd, pid: pid, icon: windowIconCopy(hwnd)}
+	trayWindowsMu.Lock()
+	tw.uid = nextTrayWindowUID
+	nextTrayWindowUID++
+	trayWindowsMu.Unlock()
+
+	nid := trayWindowIconData(tw)
+	if res := wincoe.ShellNotifyIcon(wincoe.NIM_ADD, &nid); res.Failed() {
+		destroyIcon(tw.icon)
+		// No icon means no way back; so the window stays visible.
+		return fmt.Errorf("adding its tray icon failed: %w", res.Err)
+	}
+	trayWindowsMu.Lock()
+	trayWindows[tw.uid] = tw
+	trayWindowsMu.Unlock()
+	saveTrayWindows() // before hiding: the window must be on disk whenever it's hidden
+	showWindowAsync(hwnd, windows.SW_HIDE)
+	logf("hideWindowToTray: hid HWND=0x%X %q (pid %d) behind tray icon UID=%d", hwnd, getWindowTextFast(hwnd), pid, tw.uid)
+	return nil
+}
+
+// removeTrayWindow removes uid's tray icon, showing (and, if focus, focusing)
+// its window again unless it's gone. Main thread only.
+func removeTrayWindow(uid uint32, focus bool) {
+	trayWindowsMu.Lock()
+	tw := trayWindows[uid]
+	delete(trayWindows, uid)
+	trayWindowsMu.Unlock()
+	if tw == nil {
+		return
+	}
+	nid := wincoe.NOTIFYICONDATA{CbSize: uint32(unsafe.Sizeof(wincoe.NOTIFYICONDATA{})), HWnd: loadMainMsgHwnd(), UID: uid}
+	if res := wincoe.ShellNotifyIcon(wincoe.NIM_DELETE, &nid); res.Failed() {
+		logf("removeTrayWindow: NIM_DELETE failed for UID=%d: %v", uid, res.Err)
+	}
+	destroyIcon(tw.icon)
+	if wincoe.IsWindow(tw.hwnd) {
+		showWindowAsync(tw.hwnd, windows.SW_SHOW)
+		if focus && !forceForeground(tw.hwnd) {
+			logf("removeTrayWindow: couldn't focus HWND=0x%X after showing it", tw.hwnd)
+		}
+		logf("removeTrayWindow: showed HWND=0x%X %q again", tw.hwnd, getWindowTextFast(tw.hwnd))
+	} else {
+		logf("removeTrayWindow: HWND=0x%X is gone; dropped its tray icon UID=%d", tw.hwnd, uid)
+	}
+	saveTrayWindows()
+}
+
+// wmTaskbarCreated is the message Explorer broadcasts to top-level windows
+// once its taskbar is (re)created; 0 if it couldn't be registered. Set once
+// before the message loop runs, read by wndProc.
+var wmTaskbarCreated atomic.Uint32
+
+// registerTaskbarCreated registers "TaskbarCreated" and lets it through
+// UIPI to hwnd: Explorer runs at medium integrity, and an elevated
+// winbollocks (see runasadmin.bat) would otherwise never hear of a restart.
+func registerTaskbarCreated(hwnd windows.Handle) {
+	name, err := windows.UTF16PtrFromString("TaskbarCreated")
+	if err != nil {
+		logf("registerTaskbarCreated: UTF16PtrFromString failed: %v", err)
+		return
+	}
+	res := procRegisterWindowMessageW.Call(uintptr(unsafe.Pointer(name)))
+	if res.Failed() {
+		logf("registerTaskbarCreated: RegisterWindowMessage failed: %v; tray icons won't come back after an Explorer restart", res.Err)
+		return
+	}
+	// #nosec G115 -- safe: registered message IDs are 0xC000..0xFFFF
+	msg := uint32(res.R1)
+	wmTaskbarCreated.Store(msg)
+	const MSGFLT_ALLOW = 1
+	if res := procChangeWindowMessageFilterEx.Call(uintptr(hwnd), uintptr(msg), MSGFLT_ALLOW, 0); res.Failed() {
+		logf("registerTaskbarCreated: ChangeWindowMessageFilterEx failed: %v; an elevated instance won't notice Explorer restarts", res.Err)
+	}
+}
+
+// handleTaskbarCreated is wndProc's "TaskbarCreated" handler: Explorer
+// restarted and every notification-area icon is gone, so add ours back,
+// then the icon of every hidden window still around -- without one it
+// would have no way back. Hidden windows that died meanwhile are dropped,
+// and one whose icon can't be added is shown again instead.
+func handleTaskbarCreated() {
+	if res := wincoe.ShellNotifyIcon(wincoe.NIM_ADD, &trayIcon); res.Failed() {
+		logf("handleTaskbarCreated: re-adding our tray icon failed: %v", res.Err)
+	}
+	trayWindowsMu.Lock()
+	hidden := make([]*trayWindow, 0, len(trayWindows))
+	for _, tw := range trayWindows {
+		hidden = append(hidden, tw)
+	}
+	trayWindowsMu.Unlock()
+	for _, tw := range hidden {
+		if !wincoe.IsWindow(tw.hwnd) {
+			removeTrayWindow(tw.uid, false)
+			continue
+		}
+		nid := trayWindowIconData(tw)
+		if res := wincoe.ShellNotifyIcon(wincoe.NIM_ADD, &nid); res.Failed() {
+			// No icon means no way back, so show it rather than orphan it.
+			logf("handleTaskbarCreated: re-adding the icon of HWND=0x%X failed: %v; showing it again", tw.hwnd, res.Err)
+			removeTrayWindow(tw.uid, false)
+		}
+	}
+	logf("handleTaskbarCreated: Explorer restarted; re-added our tray icon and %d hidden window icon(s)", len(hidden))
+}
+
+// handleTrayWindowIconMessage is wndProc's WM_TRAY_WINDOW_ICON handler:
+// wParam is the icon's UID, lParam's low word the mouse message. Either
+// button brings the window back.
+func handleTrayWindowIconMessage(wParam, lParam uintptr) {
+	switch uint32(lParam & 0xFFFF) {
+	case wincoe.WM_LBUTTONUP, wincoe.WM_RBUTTONUP:
+		// #nosec G115 -- safe: wParam is a UID we assigned, well within uint32
+		removeTrayWindow(uint32(wParam), true)
+	}
+}
+
+// handleTrayWindowDestroyed is wndProc's WM_TRAY_WINDOW_DESTROYED
+// handler, posted by winEventProc when a hidden window is destroyed, so its
+// icon doesn't linger.
+func handleTrayWindowDestroyed(hwnd windows.Handle) {
+	trayWindowsMu.Lock()
+	var uids []uint32
+	for uid, tw := range trayWindows {
+		if tw.hwnd == hwnd {
+			uids = append(uids, uid)
+		}
+	}
+	trayWindowsMu.Unlock()
+	for _, uid := range uids {
+		removeTrayWindow(uid, false)
+	}
+}
+
+// noteTrayWindowDestroyed is winEventProc's EVENT_OBJECT_DESTROY hook: it
+// runs on the hook thread, so it only posts the cleanup to the main
+// thread, and only for windows that are actually hidden to the tray.
+func noteTrayWindowDestroyed(hwnd windows.Handle) {
+	if !isTrayWindow(hwnd) {
+		return
+	}
+	if main := loadMainMsgHwnd(); main != 0 {
+		if res := wincoe.PostMessage(main, WM_TRAY_WINDOW_DESTROYED, uintptr(hwnd), 0); res.Failed() {
+			logf("noteTrayWindowDestroyed: PostMessage failed for HWND=0x%X: %v; its tray icon stays until clicked", hwnd, res.Err)
+		}
+	}
+}
+
+// hideWindowUnderCursorToTray is the hide-to-tray hotkey's (and remote
+// command's) action.
+func hideWindowUnderCursorToTray() {
+	var pt wincoe.POINT
+	if res := wincoe.GetCursorPos(&pt); res.Failed() {
+		logf("hideWindowUnderCursorToTray: GetCursorPos failed: %v", res.Err)
+		return
+	}
+	hwnd, res := wincoe.RootWindowFromPoint(pt)
+	if hwnd == 0 || !isManageableTopLevelWindow(hwnd) {
+		logf("hideWindowUnderCursorToTray: no hideable window under the cursor at (%d,%d) (HWND=0x%X, res: %v)", pt.X, pt.Y, hwnd, res)
+		return
+	}
+	if err := hideWindowToTray(hwnd); err != nil {
+		logf("hideWindowUnderCursorToTray: %v", err)
+		showTrayInfo(selfName, fmt.Sprintf("Failed to hide window to the tray: %v", err))
+	}
+}
+
+// saveTrayWindows writes trayWindowsFilePath from trayWindows, or removes it
+// if nothing is hidden. Main thread only.
+func saveTrayWindows() {
+	trayWindowsMu.Lock()
+	var b strings.Builder
+	for _, tw := range trayWindows {
+		fmt.Fprintf(&b, "0x%X %d\n", tw.hwnd, tw.pid)
+	}
+	trayWindowsMu.Unlock()
+	if b.Len() == 0 {
+		if err := os.Remove(trayWindowsFilePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logf("saveTrayWindows: failed to remove %q: %v", trayWindowsFilePath, err)
+		}
+		return
+	}
+	header := "# Windows " + selfName + " hid to the tray; shown again at next start if it didn't exit cleanly.\n"
+	if err := settingsFileWriter.SafeWriteFile(trayWindowsFilePath, []byte(header+b.String()), 0644); err != nil {
+		logf("saveTrayWindows: failed to write %q: %v", trayWindowsFilePath, err)
+	}
+}
+
+// restoreOrphanedTrayWindows shows every window trayWindowsFilePath says a
+// previous run hid and never showed again (it was killed), as long as the
+// HWND still belongs to the same process -- HWND values get reused. Runs
+// once at startup.
+func restoreOrphanedTrayWindows() {
+	f, err := os.Open(trayWindowsFilePath)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			logf("restoreOrphanedTrayWindows: failed to open %q: %v", trayWindowsFilePath, err)
+		}
+		return
+	}
+	defer func() {
+		_ = f.Close()
+		if err := os.Remove(trayWindowsFilePath); err != nil {
+			logf("restoreOrphanedTrayWindows: failed to remove %q: %v", trayWindowsFilePath, err)
+		}
+	}()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var hwnd uintptr
+		var pid uint32
+		if _, err := fmt.Sscanf(line, "0x%X %d", &hwnd, &pid); err != nil {
+			logf("restoreOrphanedTrayWindows: skipping malformed line %q: %v", line, err)
+			continue
+		}
+		h := windows.Handle(hwnd)
+		var curPid uint32
+		if !wincoe.IsWindow(h) || wincoe.IsWindowVisible(h) {
+			continue
+		}
+		if _, res := wincoe.GetWindowThreadProcessId(h, &curPid); res.Failed() || curPid != pid {
+			continue
+		}
+		showWindowAsync(h, windows.SW_SHOWNA)
+		logf("restoreOrphanedTrayWindows: showed HWND=0x%X %q, left hidden by a previous run", h, getWindowTextFast(h))
+	}
+	if err := sc.Err(); err != nil {
+		logf("restoreOrphanedTrayWindows: failed reading %q: %v", trayWindowsFilePath, err)
+	}
+}
+
+// deinitTrayWindows shows every hidden window again and removes their
+// icons. Must run while the main message window still exists (the icons
+// are keyed by it), i.e. before deinitMainMsgHwnd.
+func deinitTrayWindows() {
+	trayWindowsMu.Lock()
+	uids := make([]uint32, 0, len(trayWindows))
+	for uid := range trayWindows {
+		uids = append(uids, uid)
+	}
+	trayWindowsMu.Unlock()
+	for _, uid := range uids {
+		removeTrayWindow(uid, false)
+	}
+}
@@ -0,0 +1,422 @@
+// Package winlayout holds the pure, OS-independent half of winbollocks'
+// named window layouts: the on-disk file format (parse/format), title
+// wildcard matching, and the saved-entry-to-live-window assignment with its
+// fallback tiers. Everything that actually enumerates or moves windows lives
+// in the main package (see layouts.go); this package never touches Win32, so
+// it builds and behaves the same on any OS.
+//
+// The layouts file is meant to be read and hand-edited by people, so the
+// format is deliberately line-oriented and forgiving:
+//
+//	# comment
+//	[Work]
+//	window = z=0 state=maximized rect=0,0,1280,1040 exe="code.exe" class="Chrome_WidgetWin_1" title="*winbollocks*"
+//	window = z=1 state=normal rect=1280,0,1920,1040 exe="WindowsTerminal.exe" class="CASCADIA_HOSTING_WINDOW_CLASS" title="*"
+//
+// Each [name] starts a layout; each "window =" line under it is one saved
+// window. Values may be bare (no spaces) or Go-style double-quoted. A bad
+// line is reported and skipped, never fatal for the rest of the file.
+package winlayout
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Rect mirrors a Win32 RECT (exclusive Right/Bottom) in screen coordinates.
+type Rect struct {
+	Left, Top, Right, Bottom int32
+}
+
+// State is a saved window's show state.
+type State string
+
+const (
+	StateNormal    State = "normal"
+	StateMaximized State = "maximized"
+	StateMinimized State = "minimized"
+)
+
+func (s State) valid() bool {
+	return s == StateNormal || s == StateMaximized || s == StateMinimized
+}
+
+// Window is one saved window. Rect is the window's restored (non-maximized,
+// non-minimized) rectangle, so a maximized entry remembers both which
+// monitor it maximizes on and where it goes when un-maximized. Z is its
+// stacking position at save time, 0 being the topmost saved window.
+//
+// Exe and Class are compared case-insensitively and exactly; Title is a
+// wildcard pattern (see MatchTitle), saved as the literal title (with any
+// wildcard characters escaped) and meant to be loosened by hand, e.g. to
+// "* - Visual Studio Code".
+type Window struct {
+	Z     int
+	State State
+	Rect  Rect
+	Exe   string
+	Class string
+	Title string
+}
+
+// Layout is one named set of saved windows.
+type Layout struct {
+	Name    string
+	Windows []Window
+}
+
+// ValidateName reports whether name can be stored as a layout name: it
+// must be non-empty after trimming and must not contain characters that
+// would break the "[name]" section line.
+func ValidateName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("layout name is empty")
+	}
+	if name != strings.TrimSpace(name) {
+		return fmt.Errorf("layout name %q has leading or trailing whitespace", name)
+	}
+	if strings.ContainsAny(name, "[]\r\n") {
+		return fmt.Errorf("layout name %q must not contain '[', ']' or line breaks", name)
+	}
+	return nil
+}
+
+// Find returns the layout named name (case-insensitively) and true, or a
+// zero Layout and false.
+func Find(layouts []Layout, name string) (Layout, bool) {
+	for _, l := range layouts {
+		if strings.EqualFold(l.Name, name) {
+			return l, true
+		}
+	}
+	return Layout{}, false
+}
+
+// Upsert returns layouts with l replacing any existing layout of the same
+// name (case-insensitively, keeping its position), or appended otherwise.
+func Upsert(layouts []Layout, l Layout) []Layout {
+	for i := range layouts {
+		if strings.EqualFold(layouts[i].Name, l.Name) {
+			out := append([]Layout(nil), layouts...)
+			out[i] = l
+			return out
+		}
+	}
+	return append(append([]Layout(nil), layouts...), l)
+}
+
+// TopologyName names a monitor arrangement, for keying per-topology
+// layouts: the monitor count, then each monitor's origin and resolution,
+// e.g. "2 monitors: 0,0 1920x1080 + 1920,-180 2560x1440". Monitors are
+// listed left to right (then top to bottom) regardless of the order they
+// are given in, since Windows' own enumeration order isn't stable across
+// docking. Work areas are deliberately not part of it: moving the taskbar
+// doesn't make it a different desk. The result is always a valid layout
+// name (see ValidateName).
+func TopologyName(monitors []Rect) string {
+	sorted := append([]Rect(nil), monitors...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Left != sorted[j].Left {
+			return sorted[i].Left < sorted[j].Left
+		}
+		return sorted[i].Top < sorted[j].Top
+	})
+	parts := make([]string, len(sorted))
+	for i, m := range sorted {
+		parts[i] = fmt.Sprintf("%d,%d %dx%d", m.Left, m.Top, m.Right-m.Left, m.Bottom-m.Top)
+	}
+	noun := "monitors"
+	if len(sorted) == 1 {
+		noun = "monitor"
+	}
+	return fmt.Sprintf("%d %s: %s", len(sorted), noun, strings.Join(parts, " + "))
+}
+
+// EscapeTitle turns a literal window title into a pattern that matches
+// exactly that title under MatchTitle.
+func EscapeTitle(title string) string {
+	var b strings.Builder
+	for _, r := range title {
+		if r == '*' || r == '?' || r == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// MatchTitle reports whether title matches pattern, case-insensitively.
+// '*' matches any run of characters (including none), '?' matches exactly
+// one, and '\' makes the next character literal. Unlike path.Match there is
+// no special treatment of '/', which window titles are full of.
+func MatchTitle(pattern, title string) bool {
+	return matchRunes([]rune(strings.ToLower(pattern)), []rune(strings.ToLower(title)))
+}
+
+func matchRunes(p, s []rune) bool {
+	// Classic iterative wildcard match with single-star backtracking.
+	pi, si := 0, 0
+	starP, starS := -1, 0
+	for si < len(s) {
+		switch {
+		case pi < len(p) && p[pi] == '*':
+			starP, starS = pi, si
+			pi++
+		case pi < len(p) && p[pi] == '\\' && pi+1 < len(p) && p[pi+1] == s[si]:
+			pi += 2
+			si++
+		case pi < len(p) && p[pi] != '\\' && (p[pi] == '?' || p[pi] == s[si]):
+			pi++
+			si++
+		case starP >= 0:
+			starS++
+			pi, si = starP+1, starS
+		default:
+			return false
+		}
+	}
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
+}
+
+// Parse reads a layouts file. It returns every layout it could make sense
+// of plus one error per line it had to skip; a nil error slice means the
+// file was entirely clean. Window entries are returned sorted by Z.
+func Parse(data []byte) ([]Layout, []error) {
+	var (
+		layouts []Layout
+		errs    []error
+		cur     = -1
+	)
+	for i, raw := range strings.Split(string(data), "\n") {
+		lineNum := i + 1
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				errs = append(errs, fmt.Errorf("line %d: unterminated layout header %q", lineNum, line))
+				cur = -1
+				continue
+			}
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if err := ValidateName(name); err != nil {
+				errs = append(errs, fmt.Errorf("line %d: %w", lineNum, err))
+				cur = -1
+				continue
+			}
+			if _, dup := Find(layouts, name); dup {
+				errs = append(errs, fmt.Errorf("line %d: duplicate layout %q, its windows are merged into the first one", lineNum, name))
+				for j := range layouts {
+					if strings.EqualFold(layouts[j].Name, name) {
+						cur = j
+					}
+				}
+				continue
+			}
+			layouts = append(layouts, Layout{Name: name})
+			cur = len(layouts) - 1
+			continue
+		}
+		key, val, found := strings.Cut(line, "=")
+		if !found || strings.TrimSpace(key) != "window" {
+			errs = append(errs, fmt.Errorf("line %d: expected \"window = ...\" or \"[layout name]\", got %q", lineNum, line))
+			continue
+		}
+		if cur < 0 {
+			errs = append(errs, fmt.Errorf("line %d: window entry outside of any [layout] section", lineNum))
+			continue
+		}
+		w, err := parseWindow(strings.TrimSpace(val))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", lineNum, err))
+			continue
+		}
+		layouts[cur].Windows = append(layouts[cur].Windows, w)
+	}
+	for i := range layouts {
+		sortByZ(layouts[i].Windows)
+	}
+	return layouts, errs
+}
+
+func sortByZ(ws []Window) {
+	sort.SliceStable(ws, func(i, j int) bool { return ws[i].Z < ws[j].Z })
+}
+
+func parseWindow(s string) (Window, error) {
+	w := Window{State: StateNormal, Title: "*"}
+	seenRect := false
+	for s != "" {
+		key, rest, found := strings.Cut(s, "=")
+		if !found {
+			return Window{}, fmt.Errorf("expected key=value, got %q", s)
+		}
+		key = strings.TrimSpace(key)
+		var val string
+		if strings.HasPrefix(rest, `"`) {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return Window{}, fmt.Errorf("%s: bad quoted value: %w", key, err)
+			}
+			val, _ = strconv.Unquote(quoted) // cannot fail, QuotedPrefix already validated it
+			rest = rest[len(quoted):]
+		} else {
+			end := strings.IndexAny(rest, " \t")
+			if end < 0 {
+				end = len(rest)
+			}
+			val, rest = rest[:end], rest[end:]
+		}
+		s = strings.TrimSpace(rest)
+
+		switch key {
+		case "z":
+			z, err := strconv.Atoi(val)
+			if err != nil || z < 0 {
+				return Window{}, fmt.Errorf("z: want a non-negative integer, got %q", val)
+			}
+			w.Z = z
+		case "state":
+			w.State = State(val)
+			if !w.State.valid() {
+				return Window{}, fmt.Errorf("state: want normal, maximized or minimized, got %q", val)
+			}
+		case "rect":
+			r, err := parseRect(val)
+			if err != nil {
+				return Window{}, fmt.Errorf("rect: %w", err)
+			}
+			w.Rect, seenRect = r, true
+		case "exe":
+			w.Exe = val
+		case "class":
+			w.Class = val
+		case "title":
+			w.Title = val
+		default:
+			return Window{}, fmt.Errorf("unknown key %q", key)
+		}
+	}
+	if !seenRect {
+		return Window{}, errors.New("missing rect")
+	}
+	if w.Exe == "" {
+		return Window{}, errors.New("missing exe")
+	}
+	return w, nil
+}
+
+func parseRect(s string) (Rect, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return Rect{}, fmt.Errorf("want left,top,right,bottom, got %q", s)
+	}
+	var v [4]int32
+	for i, p := range parts {
+		n, err := strconv.ParseInt(strings.TrimSpace(p), 10, 32)
+		if err != nil {
+			return Rect{}, fmt.Errorf("bad coordinate %q", p)
+		}
+		v[i] = int32(n)
+	}
+	r := Rect{v[0], v[1], v[2], v[3]}
+	if r.Left >= r.Right || r.Top >= r.Bottom {
+		return Rect{}, fmt.Errorf("empty or inverted rect %q", s)
+	}
+	return r, nil
+}
+
+// Format renders layouts in the same format Parse reads, preceded by
+// header (each line of which is emitted as a "# " comment).
+func Format(header string, layouts []Layout) []byte {
+	var b strings.Builder
+	for _, h := range strings.Split(header, "\n") {
+		b.WriteString("# " + h + "\n")
+	}
+	for _, l := range layouts {
+		fmt.Fprintf(&b, "\n[%s]\n", l.Name)
+		for _, w := range l.Windows {
+			fmt.Fprintf(&b, "window = z=%d state=%s rect=%d,%d,%d,%d exe=%s class=%s title=%s\n",
+				w.Z, w.State, w.Rect.Left, w.Rect.Top, w.Rect.Right, w.Rect.Bottom,
+				strconv.Quote(w.Exe), strconv.Quote(w.Class), strconv.Quote(w.Title))
+		}
+	}
+	return []byte(b.String())
+}
+
+// Candidate is a live window that a saved Window may be assigned to.
+type Candidate struct {
+	Exe, Class, Title string
+}
+
+// Tier says how strictly a saved window matched its live window.
+type Tier int
+
+const (
+	// TierExact: same exe, same class, and the title matches the pattern.
+	TierExact Tier = iota + 1
+	// TierClass: same exe and class; the title no longer matches (e.g. a
+	// different document is open now).
+	TierClass
+	// TierExe: only the exe matches (e.g. the app changed its window class
+	// between versions).
+	TierExe
+)
+
+// Assignment pairs Saved (an index into the saved windows) with Live (an
+// index into the candidates).
+type Assignment struct {
+	Saved, Live int
+	Tier        Tier
+}
+
+// Assign matches saved windows to live candidates, each live window used at
+// most once. It runs one pass per Tier, strictest first, so a window that
+// matches some entry exactly is never claimed by another entry's fallback.
+// Within a pass, saved entries are considered in slice order (callers pass
+// them sorted by Z) and each claims the first eligible candidate (callers
+// pass candidates in current Z order, so the topmost eligible one wins).
+// The result is sorted by Saved.
+func Assign(saved []Window, live []Candidate) []Assignment {
+	claimedLive := make([]bool, len(live))
+	doneSaved := make([]bool, len(saved))
+	var out []Assignment
+	for tier := TierExact; tier <= TierExe; tier++ {
+		for si, s := range saved {
+			if doneSaved[si] {
+				continue
+			}
+			for li, c := range live {
+				if claimedLive[li] || !matches(s, c, tier) {
+					continue
+				}
+				claimedLive[li], doneSaved[si] = true, true
+				out = append(out, Assignment{Saved: si, Live: li, Tier: tier})
+				break
+			}
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Saved < out[j].Saved })
+	return out
+}
+
+func matches(s Window, c Candidate, tier Tier) bool {
+	if !strings.EqualFold(s.Exe, c.Exe) {
+		return false
+	}
+	switch tier {
+	case TierExact:
+		return strings.EqualFold(s.Class, c.Class) && MatchTitle(s.Title, c.Title)
+	case TierClass:
+		return strings.EqualFold(s.Class, c.Class)
+	default:
+		return true
+	}
+}
@@ -0,0 +1,162 @@
+package winlayout
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+const sample = `# comment
+[Work]
+window = z=1 state=normal rect=1280,0,1920,1040 exe="WindowsTerminal.exe" class="CASCADIA_HOSTING_WINDOW_CLASS" title="*"
+window = z=0 state=maximized rect=0,0,1280,1040 exe=code.exe class="Chrome_WidgetWin_1" title="*winbollocks*"
+
+[Deep focus]
+window = z=0 rect=10,10,500,400 exe="notepad.exe"
+`
+
+func TestParse(t *testing.T) {
+	layouts, errs := Parse([]byte(sample))
+	if len(errs) != 0 {
+		t.Fatalf("Parse errors: %v", errs)
+	}
+	if len(layouts) != 2 || layouts[0].Name != "Work" || layouts[1].Name != "Deep focus" {
+		t.Fatalf("got layouts %+v", layouts)
+	}
+	work := layouts[0].Windows
+	if len(work) != 2 || work[0].Exe != "code.exe" || work[0].State != StateMaximized || work[1].Z != 1 {
+		t.Errorf("Work windows not sorted by z or misparsed: %+v", work)
+	}
+	want := Window{Z: 0, State: StateNormal, Rect: Rect{10, 10, 500, 400}, Exe: "notepad.exe", Title: "*"}
+	if got := layouts[1].Windows; len(got) != 1 || got[0] != want {
+		t.Errorf("Deep focus windows = %+v, want defaults filled in: %+v", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		wantWindows int // in the first layout, -1 for no layout at all
+		wantErr     string
+	}{
+		{"outside section", `window = rect=0,0,1,1 exe=a.exe`, -1, "outside of any"},
+		{"unterminated header", "[Work\n", -1, "unterminated"},
+		{"bad name", "[ ]\n", -1, "empty"},
+		{"missing rect", "[a]\nwindow = exe=a.exe\n", 0, "missing rect"},
+		{"missing exe", "[a]\nwindow = rect=0,0,1,1\n", 0, "missing exe"},
+		{"inverted rect", "[a]\nwindow = rect=5,0,1,1 exe=a.exe\n", 0, "inverted"},
+		{"bad state", "[a]\nwindow = state=huge rect=0,0,1,1 exe=a.exe\n", 0, "state"},
+		{"negative z", "[a]\nwindow = z=-1 rect=0,0,1,1 exe=a.exe\n", 0, "non-negative"},
+		{"unknown key", "[a]\nwindow = colour=red rect=0,0,1,1 exe=a.exe\n", 0, "unknown key"},
+		{"not a window line", "[a]\nfoo = bar\n", 0, "expected"},
+		{"duplicate merges", "[a]\nwindow = rect=0,0,1,1 exe=a.exe\n[A]\nwindow = rect=0,0,1,1 exe=b.exe\n", 2, "duplicate"},
+	}
+	for _, tt := range tests {
+		layouts, errs := Parse([]byte(tt.data))
+		if len(errs) == 0 || !strings.Contains(errs[0].Error(), tt.wantErr) {
+			t.Errorf("%s: errors = %v, want one containing %q", tt.name, errs, tt.wantErr)
+		}
+		switch {
+		case tt.wantWindows < 0 && len(layouts) != 0:
+			t.Errorf("%s: got layouts %+v, want none", tt.name, layouts)
+		case tt.wantWindows >= 0 && (len(layouts) != 1 || len(layouts[0].Windows) != tt.wantWindows):
+			t.Errorf("%s: got layouts %+v, want one with %d window(s)", tt.name, layouts, tt.wantWindows)
+		}
+	}
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	layouts, _ := Parse([]byte(sample))
+	layouts[1].Windows[0].Title = EscapeTitle(`say "hi" *now*`)
+	again, errs := Parse(Format("header line 1\nheader line 2", layouts))
+	if len(errs) != 0 {
+		t.Fatalf("Parse(Format(...)) errors: %v", errs)
+	}
+	if !slices.EqualFunc(layouts, again, func(a, b Layout) bool { return a.Name == b.Name && slices.Equal(a.Windows, b.Windows) }) {
+		t.Errorf("round trip changed layouts:\n got %+v\nwant %+v", again, layouts)
+	}
+}
+
+func TestMatchTitle(t *testing.T) {
+	tests := []struct {
+		pattern, title string
+		want           bool
+	}{
+		{"*", "", true},
+		{"* - Visual Studio Code", "main.go - winbollocks - Visual Studio Code", true},
+		{"* - visual studio code", "main.go - Visual Studio Code", true},
+		{"?otepad", "Notepad", true},
+		{"?otepad", "otepad", false},
+		{"a*b*c", "aXXbYYc", true},
+		{"a*b*c", "aXXbYY", false},
+		{`C:\\path/to\*`, `C:\path/to*`, true},
+		{EscapeTitle("*literal?"), "*literal?", true},
+		{EscapeTitle("*literal?"), "xliteral!", false},
+	}
+	for _, tt := range tests {
+		if got := MatchTitle(tt.pattern, tt.title); got != tt.want {
+			t.Errorf("MatchTitle(%q, %q) = %v, want %v", tt.pattern, tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestAssign(t *testing.T) {
+	saved := []Window{
+		{Z: 0, Exe: "code.exe", Class: "Chrome_WidgetWin_1", Title: "*winbollocks*"},
+		{Z: 1, Exe: "code.exe", Class: "Chrome_WidgetWin_1", Title: "*other*"},
+		{Z: 2, Exe: "term.exe", Class: "OldClass", Title: "*"},
+		{Z: 3, Exe: "gone.exe", Class: "X", Title: "*"},
+	}
+	live := []Candidate{
+		{Exe: "CODE.EXE", Class: "Chrome_WidgetWin_1", Title: "notes - Visual Studio Code"},
+		{Exe: "code.exe", Class: "Chrome_WidgetWin_1", Title: "main.go - winbollocks"},
+		{Exe: "term.exe", Class: "NewClass", Title: "pwsh"},
+	}
+	want := []Assignment{
+		{Saved: 0, Live: 1, Tier: TierExact},
+		{Saved: 1, Live: 0, Tier: TierClass},
+		{Saved: 2, Live: 2, Tier: TierExe},
+	}
+	if got := Assign(saved, live); !slices.Equal(got, want) {
+		t.Errorf("Assign = %+v, want %+v", got, want)
+	}
+}
+
+func TestTopologyName(t *testing.T) {
+	tests := []struct {
+		name     string
+		monitors []Rect
+		want     string
+	}{
+		{"single", []Rect{{0, 0, 1920, 1080}}, "1 monitor: 0,0 1920x1080"},
+		{"sorted left to right", []Rect{{1920, -180, 4480, 1260}, {0, 0, 1920, 1080}}, "2 monitors: 0,0 1920x1080 + 1920,-180 2560x1440"},
+		{"stacked sorted top to bottom", []Rect{{0, 1080, 1920, 2160}, {0, 0, 1920, 1080}}, "2 monitors: 0,0 1920x1080 + 0,1080 1920x1080"},
+	}
+	for _, tt := range tests {
+		got := TopologyName(tt.monitors)
+		if got != tt.want {
+			t.Errorf("%s: TopologyName = %q, want %q", tt.name, got, tt.want)
+		}
+		if err := ValidateName(got); err != nil {
+			t.Errorf("%s: TopologyName result isn't a valid layout name: %v", tt.name, err)
+		}
+	}
+}
+
+func TestUpsertAndFind(t *testing.T) {
+	layouts := []Layout{{Name: "Layout 1"}, {Name: "Work"}}
+	got := Upsert(layouts, Layout{Name: "layout 1", Windows: []Window{{Exe: "a.exe"}}})
+	if len(got) != 2 || got[0].Name != "layout 1" || len(layouts[0].Windows) != 0 {
+		t.Errorf("Upsert must replace case-insensitively in place without touching its input, got %+v", got)
+	}
+	if got = Upsert(layouts, Layout{Name: "New"}); len(got) != 3 || got[2].Name != "New" {
+		t.Errorf("Upsert must append a new name, got %+v", got)
+	}
+	if l, ok := Find(layouts, "WORK"); !ok || l.Name != "Work" {
+		t.Errorf("Find(WORK) = %+v, %v", l, ok)
+	}
+	if _, ok := Find(layouts, "nope"); ok {
+		t.Error("Find(nope) found something")
+	}
+}
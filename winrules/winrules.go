@@ -0,0 +1,361 @@
+// Package winrules is the pure half of winbollocks' per-application rules:
+// parsing the rules file and deciding which setting overrides apply to a
+// given window. Like snapengine and winlayout it never touches Win32 -- the
+// main package (see rules.go there) gathers a window's exe, class, title
+// and integrity level and asks an Engine for its Overrides -- so matching
+// is unit-testable on any OS.
+//
+// The rules file is a hand-written text file of [sections], one rule each:
+//
+//	# Never touch games, and don't snap the terminal.
+//	[games]
+//	exe = eldenring.exe
+//	ignore = true
+//
+//	[terminal]
+//	class = CASCADIA_HOSTING_WINDOW_CLASS
+//	snapToEdgesEnabled = false
+//
+// exe and class are compared case-insensitively and exactly, title is a Go
+// regular expression searched anywhere in the title (anchor it with ^...$,
+// make it case-insensitive with (?i)), and integrity is one of low, medium,
+// high or system. A rule matches a window when every matcher it has
+// matches, and must have at least one. Every other key is a setting
+// override, "key = true|false", where the key is either Ignore or one of
+// the setting names the caller passes to Parse. When several rules match,
+// all of them apply, in file order, so a later rule overrides an earlier
+// one's value for the same setting.
+package winrules
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Ignore is the override key that makes winbollocks leave a window alone
+// entirely, as if every gesture on it were bypassed.
+const Ignore = "ignore"
+
+// Integrity is a process integrity level, coarsened to the four levels
+// people actually write rules about.
+type Integrity uint8
+
+const (
+	IntegrityUnknown Integrity = iota // couldn't be determined; matches no integrity matcher
+	IntegrityLow
+	IntegrityMedium
+	IntegrityHigh
+	IntegritySystem
+)
+
+var integrityNames = map[string]Integrity{
+	"low":    IntegrityLow,
+	"medium": IntegrityMedium,
+	"high":   IntegrityHigh,
+	"system": IntegritySystem,
+}
+
+// IntegrityFromRID maps a token's mandatory-label RID
+// (SECURITY_MANDATORY_*_RID) to an Integrity. Untrusted counts as low, and
+// medium-plus as medium.
+func IntegrityFromRID(rid uint32) Integrity {
+	switch {
+	case rid < 0x2000:
+		return IntegrityLow
+	case rid < 0x3000:
+		return IntegrityMedium
+	case rid < 0x4000:
+		return IntegrityHigh
+	}
+	return IntegritySystem
+}
+
+// Window is what rules are matched against. It is comparable, and is the
+// Engine's cache key.
+type Window struct {
+	Exe       string
+	Class     string
+	Title     string
+	Integrity Integrity
+}
+
+// Rule is one [section] of the rules file.
+type Rule struct {
+	Name      string
+	Line      int // line of the [section] header, for log messages
+	Exe       string
+	Class     string
+	Title     *regexp.Regexp
+	Integrity Integrity // IntegrityUnknown = no integrity matcher
+	Set       map[string]bool
+}
+
+func (r Rule) hasMatcher() bool {
+	return r.Exe != "" || r.Class != "" || r.Title != nil || r.Integrity != IntegrityUnknown
+}
+
+func (r Rule) matches(w Window) bool {
+	if r.Exe != "" && !strings.EqualFold(r.Exe, w.Exe) {
+		return false
+	}
+	if r.Class != "" && !strings.EqualFold(r.Class, w.Class) {
+		return false
+	}
+	if r.Title != nil && !r.Title.MatchString(w.Title) {
+		return false
+	}
+	if r.Integrity != IntegrityUnknown && r.Integrity != w.Integrity {
+		return false
+	}
+	return true
+}
+
+// Parse reads a rules file. settings is the set of setting names a rule may
+// override (besides Ignore); any other key is an error. It returns every
+// rule it could make sense of plus one error per problem; a rule with an
+// error in its header or without any matcher is dropped entirely (applying
+// its overrides to the wrong windows would be worse than not applying
+// them), while a single bad override line only loses that line.
+func Parse(data []byte, settings []string) ([]Rule, []error) {
+	known := make(map[string]string, len(settings)+1) // lowercased -> canonical
+	known[strings.ToLower(Ignore)] = Ignore
+	for _, s := range settings {
+		known[strings.ToLower(s)] = s
+	}
+
+	var (
+		rules []Rule
+		errs  []error
+		cur   *Rule
+		bad   bool // cur had an error that disqualifies the whole rule
+	)
+	flush := func() {
+		if cur == nil {
+			return
+		}
+		switch {
+		case bad:
+			// already reported
+		case !cur.hasMatcher():
+			errs = append(errs, fmt.Errorf("line %d: rule %q has no exe, class, title or integrity to match on, ignoring it", cur.Line, cur.Name))
+		default:
+			rules = append(rules, *cur)
+		}
+		cur, bad = nil, false
+	}
+
+	for i, raw := range strings.Split(string(data), "\n") {
+		lineNum := i + 1
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			flush()
+			if !strings.HasSuffix(line, "]") {
+				errs = append(errs, fmt.Errorf("line %d: unterminated rule header %q", lineNum, line))
+				cur, bad = &Rule{Line: lineNum}, true
+				continue
+			}
+			cur = &Rule{Name: strings.TrimSpace(line[1 : len(line)-1]), Line: lineNum, Set: map[string]bool{}}
+			continue
+		}
+		key, val, found := strings.Cut(line, "=")
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		if !found || key == "" {
+			errs = append(errs, fmt.Errorf("line %d: expected \"key = value\", got %q", lineNum, line))
+			continue
+		}
+		if cur == nil {
+			errs = append(errs, fmt.Errorf("line %d: %q is outside any [rule] section", lineNum, key))
+			continue
+		}
+		if bad {
+			continue
+		}
+		val, err := unquote(val)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", lineNum, err))
+			if isMatcher(key) {
+				bad = true
+			}
+			continue
+		}
+		if isMatcher(key) {
+			if err := setMatcher(cur, strings.ToLower(key), val); err != nil {
+				errs = append(errs, fmt.Errorf("line %d: rule %q: %w, ignoring the whole rule", lineNum, cur.Name, err))
+				bad = true
+			}
+			continue
+		}
+		name, ok := known[strings.ToLower(key)]
+		if !ok {
+			errs = append(errs, fmt.Errorf("line %d: unknown setting %q", lineNum, key))
+			continue
+		}
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %s must be true or false, got %q", lineNum, name, val))
+			continue
+		}
+		cur.Set[name] = b
+	}
+	flush()
+	return rules, errs
+}
+
+func isMatcher(key string) bool {
+	switch strings.ToLower(key) {
+	case "exe", "class", "title", "integrity":
+		return true
+	}
+	return false
+}
+
+func setMatcher(r *Rule, key, val string) error {
+	if val == "" {
+		return fmt.Errorf("empty %s", key)
+	}
+	switch key {
+	case "exe":
+		r.Exe = val
+	case "class":
+		r.Class = val
+	case "title":
+		re, err := regexp.Compile(val)
+		if err != nil {
+			return fmt.Errorf("bad title regexp: %w", err)
+		}
+		r.Title = re
+	case "integrity":
+		lvl, ok := integrityNames[strings.ToLower(val)]
+		if !ok {
+			return fmt.Errorf("integrity must be low, medium, high or system, got %q", val)
+		}
+		r.Integrity = lvl
+	}
+	return nil
+}
+
+// unquote accepts a bare value or a Go-style double-quoted one (needed for
+// values with leading/trailing spaces or a '#').
+func unquote(v string) (string, error) {
+	if !strings.HasPrefix(v, `"`) {
+		return v, nil
+	}
+	s, err := strconv.Unquote(v)
+	if err != nil {
+		return "", errors.New("malformed quoted value " + v)
+	}
+	return s, nil
+}
+
+// Overrides is the combined effect of every rule matching one window. The
+// zero value overrides nothing. Immutable once returned by an Engine, so it
+// can be shared freely between threads.
+type Overrides struct {
+	set map[string]bool
+}
+
+// Get returns the overridden value of setting and true, or false, false if
+// no matching rule sets it.
+func (o Overrides) Get(setting string) (value, ok bool) {
+	value, ok = o.set[setting]
+	return value, ok
+}
+
+// Bool returns the overridden value of setting, or global if no matching
+// rule sets it.
+func (o Overrides) Bool(setting string, global bool) bool {
+	if v, ok := o.set[setting]; ok {
+		return v
+	}
+	return global
+}
+
+// Ignored reports whether a matching rule says to leave the window alone.
+func (o Overrides) Ignored() bool {
+	return o.set[Ignore]
+}
+
+// Empty reports whether no override applies.
+func (o Overrides) Empty() bool {
+	return len(o.set) == 0
+}
+
+// DefaultCacheSize bounds an Engine's result cache.
+const DefaultCacheSize = 1024
+
+// Engine evaluates a fixed rule set, caching the result per distinct
+// Window so repeat lookups (the same window under the cursor gesture after
+// gesture) are a map hit. Safe for concurrent use. A nil *Engine has no
+// rules.
+type Engine struct {
+	rules     []Rule
+	cacheSize int
+	mentioned map[string]bool // every setting some rule sets, see Mentions
+
+	mu    sync.Mutex
+	cache map[Window]Overrides
+}
+
+// NewEngine returns an Engine for rules, caching at most cacheSize results
+// (the cache is simply emptied when full: windows come and go, and a cold
+// lookup is only a regexp or two).
+func NewEngine(rules []Rule, cacheSize int) *Engine {
+	mentioned := map[string]bool{}
+	for _, r := range rules {
+		for k := range r.Set {
+			mentioned[k] = true
+		}
+	}
+	return &Engine{rules: rules, cacheSize: max(cacheSize, 1), mentioned: mentioned, cache: map[Window]Overrides{}}
+}
+
+// Mentions reports whether any rule sets setting, to either value. Lets a
+// caller skip per-window evaluation entirely for a feature that is off
+// globally and that no rule turns on anywhere.
+func (e *Engine) Mentions(setting string) bool {
+	return e != nil && e.mentioned[setting]
+}
+
+// Len returns the number of rules.
+func (e *Engine) Len() int {
+	if e == nil {
+		return 0
+	}
+	return len(e.rules)
+}
+
+// Evaluate returns the overrides for w.
+func (e *Engine) Evaluate(w Window) Overrides {
+	if e.Len() == 0 {
+		return Overrides{}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if o, ok := e.cache[w]; ok {
+		return o
+	}
+	var o Overrides
+	for _, r := range e.rules {
+		if !r.matches(w) || len(r.Set) == 0 {
+			continue
+		}
+		if o.set == nil {
+			o.set = make(map[string]bool, len(r.Set))
+		}
+		for k, v := range r.Set {
+			o.set[k] = v
+		}
+	}
+	if len(e.cache) >= e.cacheSize {
+		clear(e.cache)
+	}
+	e.cache[w] = o
+	return o
+}
@@ -0,0 +1,195 @@
+package winrules
+
+import (
+	"strings"
+	"testing"
+)
+
+var settings = []string{"snapToEdgesEnabled", "focusOnDrag", "bypassGesturesWhenFullscreen"}
+
+const sample = `
+# comment
+; also a comment
+[games]
+exe = EldenRing.exe
+ignore = true
+
+[terminal]
+class = CASCADIA_HOSTING_WINDOW_CLASS
+snapToEdgesEnabled = false
+
+[editors]
+title = "(?i) - visual studio code$"
+snaptoedgesenabled = true
+focusOnDrag = false
+
+[admin]
+integrity = high
+bypassGesturesWhenFullscreen = true
+`
+
+func mustParse(t *testing.T, data string) []Rule {
+	t.Helper()
+	rules, errs := Parse([]byte(data), settings)
+	if len(errs) != 0 {
+		t.Fatalf("Parse errors: %v", errs)
+	}
+	return rules
+}
+
+func TestParse(t *testing.T) {
+	rules := mustParse(t, sample)
+	if len(rules) != 4 {
+		t.Fatalf("got %d rules, want 4", len(rules))
+	}
+	if r := rules[0]; r.Name != "games" || r.Line != 4 || r.Exe != "EldenRing.exe" || !r.Set[Ignore] {
+		t.Errorf("games rule = %+v", r)
+	}
+	if r := rules[2]; r.Title == nil || r.Set["snapToEdgesEnabled"] != true || r.Set["focusOnDrag"] != false {
+		t.Errorf("editors rule = %+v (setting keys must be canonicalized)", r)
+	}
+	if rules[3].Integrity != IntegrityHigh {
+		t.Errorf("admin rule integrity = %v, want high", rules[3].Integrity)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		wantRules int
+		wantErr   string
+	}{
+		{"outside section", "exe = a.exe\n", 0, "outside any [rule]"},
+		{"unterminated header", "[oops\nexe = a.exe\nignore = true\n", 0, "unterminated"},
+		{"no matcher", "[x]\nignore = true\n", 0, "has no exe, class, title or integrity"},
+		{"bad regexp drops rule", "[x]\ntitle = (\nignore = true\n", 0, "bad title regexp"},
+		{"bad integrity drops rule", "[x]\nintegrity = root\nignore = true\n", 0, "integrity must be"},
+		{"empty matcher drops rule", "[x]\nexe =\nignore = true\n", 0, "empty exe"},
+		{"unknown setting keeps rule", "[x]\nexe = a.exe\nwobble = true\n", 1, `unknown setting "wobble"`},
+		{"bad bool keeps rule", "[x]\nexe = a.exe\nignore = maybe\n", 1, "must be true or false"},
+		{"not key value", "[x]\nexe = a.exe\njunk\n", 1, "expected \"key = value\""},
+		{"bad quoting", "[x]\nexe = \"a.exe\n", 0, "malformed quoted value"},
+	}
+	for _, tt := range tests {
+		rules, errs := Parse([]byte(tt.data), settings)
+		if len(rules) != tt.wantRules {
+			t.Errorf("%s: got %d rules, want %d", tt.name, len(rules), tt.wantRules)
+		}
+		if len(errs) == 0 || !strings.Contains(errs[0].Error(), tt.wantErr) {
+			t.Errorf("%s: errors = %v, want one containing %q", tt.name, errs, tt.wantErr)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	e := NewEngine(mustParse(t, sample), DefaultCacheSize)
+	tests := []struct {
+		name    string
+		w       Window
+		setting string
+		want    bool
+		wantOK  bool
+	}{
+		{"exe case-insensitive", Window{Exe: "eldenring.EXE", Integrity: IntegrityMedium}, Ignore, true, true},
+		{"class", Window{Exe: "WindowsTerminal.exe", Class: "cascadia_hosting_window_class"}, "snapToEdgesEnabled", false, true},
+		{"title regexp", Window{Title: "main.go - winbollocks - Visual Studio Code"}, "focusOnDrag", false, true},
+		{"title regexp is a search, anchored here", Window{Title: "Visual Studio Code - notes"}, "focusOnDrag", false, false},
+		{"integrity", Window{Exe: "taskmgr.exe", Integrity: IntegrityHigh}, "bypassGesturesWhenFullscreen", true, true},
+		{"integrity mismatch", Window{Exe: "taskmgr.exe", Integrity: IntegrityMedium}, "bypassGesturesWhenFullscreen", false, false},
+		{"no match", Window{Exe: "notepad.exe"}, Ignore, false, false},
+	}
+	for _, tt := range tests {
+		got, ok := e.Evaluate(tt.w).Get(tt.setting)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("%s: Get(%q) = %v, %v; want %v, %v", tt.name, tt.setting, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestEvaluateAllMatchersMustMatch(t *testing.T) {
+	e := NewEngine(mustParse(t, "[x]\nexe = code.exe\nclass = Chrome_WidgetWin_1\nignore = true\n"), DefaultCacheSize)
+	if e.Evaluate(Window{Exe: "code.exe", Class: "Other"}).Ignored() {
+		t.Error("rule matched with only one of two matchers satisfied")
+	}
+	if !e.Evaluate(Window{Exe: "code.exe", Class: "Chrome_WidgetWin_1"}).Ignored() {
+		t.Error("rule didn't match with both matchers satisfied")
+	}
+}
+
+func TestEvaluateLaterRulesWin(t *testing.T) {
+	e := NewEngine(mustParse(t, `
+[all terminals]
+class = CASCADIA_HOSTING_WINDOW_CLASS
+snapToEdgesEnabled = false
+focusOnDrag = false
+
+[but not this one]
+title = ^scratch$
+snapToEdgesEnabled = true
+`), DefaultCacheSize)
+	o := e.Evaluate(Window{Class: "CASCADIA_HOSTING_WINDOW_CLASS", Title: "scratch"})
+	if !o.Bool("snapToEdgesEnabled", false) {
+		t.Error("later rule didn't override earlier one")
+	}
+	if o.Bool("focusOnDrag", true) {
+		t.Error("earlier rule's other override was lost")
+	}
+	if !o.Bool("bypassGesturesWhenFullscreen", true) {
+		t.Error("unset setting didn't fall back to the global value")
+	}
+}
+
+func TestEvaluateCache(t *testing.T) {
+	e := NewEngine(mustParse(t, sample), 2)
+	w := Window{Exe: "eldenring.exe"}
+	first := e.Evaluate(w)
+	if len(e.cache) != 1 {
+		t.Fatalf("cache has %d entries after one lookup, want 1", len(e.cache))
+	}
+	if again := e.Evaluate(w); !again.Ignored() || !first.Ignored() {
+		t.Error("cached result differs")
+	}
+	e.Evaluate(Window{Exe: "a.exe"})
+	e.Evaluate(Window{Exe: "b.exe"}) // cache full: emptied, then this one added
+	if len(e.cache) != 1 {
+		t.Errorf("cache has %d entries after overflowing, want 1", len(e.cache))
+	}
+}
+
+func TestMentions(t *testing.T) {
+	e := NewEngine(mustParse(t, sample), DefaultCacheSize)
+	for _, s := range []string{"snapToEdgesEnabled", "focusOnDrag", Ignore} {
+		if !e.Mentions(s) {
+			t.Errorf("Mentions(%q) = false, want true", s)
+		}
+	}
+	if e.Mentions("snapToThirdsEnabled") {
+		t.Error("Mentions reported a setting no rule sets")
+	}
+}
+
+func TestNilAndEmptyEngine(t *testing.T) {
+	var e *Engine
+	if e.Len() != 0 || !e.Evaluate(Window{Exe: "x.exe"}).Empty() || e.Mentions("focusOnDrag") {
+		t.Error("nil Engine must have no rules and override nothing")
+	}
+	if !NewEngine(nil, DefaultCacheSize).Evaluate(Window{}).Empty() {
+		t.Error("empty Engine must override nothing")
+	}
+}
+
+func TestIntegrityFromRID(t *testing.T) {
+	tests := []struct {
+		rid  uint32
+		want Integrity
+	}{
+		{0x0000, IntegrityLow}, {0x1000, IntegrityLow}, {0x2000, IntegrityMedium}, {0x2100, IntegrityMedium},
+		{0x3000, IntegrityHigh}, {0x4000, IntegritySystem}, {0x5000, IntegritySystem},
+	}
+	for _, tt := range tests {
+		if got := IntegrityFromRID(tt.rid); got != tt.want {
+			t.Errorf("IntegrityFromRID(0x%X) = %v, want %v", tt.rid, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Package winselect is the model behind rubber-band window selection: the
+// rectangle a Win+Ctrl+LMB drag covers, which windows it touches, and the
+// set of windows selected so far. Like tiling and snapengine it is pure --
+// no Win32, no globals -- so it is tested on synthetic desktops; the main
+// package (see selection.go) draws the band and the highlights, feeds in
+// the desktop's windows and acts on the selection.
+//
+// Rectangles follow Win32 RECT conventions (exclusive Right/Bottom, screen
+// pixels) and describe each window's VISIBLE frame.
+package winselect
+
+import "slices"
+
+// Point is a screen position.
+type Point struct {
+	X, Y int32
+}
+
+// Rect is a screen-space rectangle with exclusive Right/Bottom.
+type Rect struct {
+	Left, Top, Right, Bottom int32
+}
+
+// Empty reports whether r covers no pixel.
+func (r Rect) Empty() bool { return r.Right <= r.Left || r.Bottom <= r.Top }
+
+// Intersects reports whether r and o share at least one pixel.
+func (r Rect) Intersects(o Rect) bool {
+	return !r.Empty() && !o.Empty() &&
+		r.Left < o.Right && o.Left < r.Right && r.Top < o.Bottom && o.Top < r.Bottom
+}
+
+// Band returns the rectangle a drag from anchor to cur covers, both points
+// included, whichever direction the drag went. A drag that never moved
+// covers the single pixel under it.
+func Band(anchor, cur Point) Rect {
+	return Rect{
+		Left:   min(anchor.X, cur.X),
+		Top:    min(anchor.Y, cur.Y),
+		Right:  max(anchor.X, cur.X) + 1,
+		Bottom: max(anchor.Y, cur.Y) + 1,
+	}
+}
+
+// IsClick reports whether band is a drag that never moved (see Band).
+func IsClick(band Rect) bool {
+	return band.Right-band.Left == 1 && band.Bottom-band.Top == 1
+}
+
+// Window is one window as this package sees it: whatever the caller
+// identifies its windows by, and the window's rectangle -- its visible
+// frame, for Touched.
+type Window[ID comparable] struct {
+	ID    ID
+	Frame Rect
+}
+
+// Touched returns the IDs of the candidates whose frame shares a pixel
+// with band, in candidate order -- callers pass the desktop's Z-order,
+// topmost first, and get the selection in it. A click (see IsClick)
+// touches only the topmost window under it, not everything stacked
+// beneath, the way clicking a window selects just that one.
+func Touched[ID comparable](band Rect, candidates []Window[ID]) []ID {
+	var out []ID
+	for _, w := range candidates {
+		if !band.Intersects(w.Frame) {
+			continue
+		}
+		out = append(out, w.ID)
+		if IsClick(band) {
+			break
+		}
+	}
+	return out
+}
+
+// Offset is where a window sits relative to another one's top-left.
+type Offset[ID comparable] struct {
+	ID     ID
+	DX, DY int32
+}
+
+// Offsets returns each of members' offset from anchor's top-left, leaving
+// anchor itself out: what stays fixed while the group moves with anchor.
+func Offsets[ID comparable](anchor Window[ID], members []Window[ID]) []Offset[ID] {
+	out := make([]Offset[ID], 0, len(members))
+	for _, m := range members {
+		if m.ID == anchor.ID {
+			continue
+		}
+		out = append(out, Offset[ID]{ID: m.ID, DX: m.Frame.Left - anchor.Frame.Left, DY: m.Frame.Top - anchor.Frame.Top})
+	}
+	return out
+}
+
+// Set is the current selection: distinct IDs in the order they were
+// selected. The zero value is an empty set. Not safe for concurrent use.
+type Set[ID comparable] struct {
+	ids []ID
+}
+
+// Len returns the number of selected IDs.
+func (s *Set[ID]) Len() int { return len(s.ids) }
+
+// Contains reports whether id is selected.
+func (s *Set[ID]) Contains(id ID) bool { return slices.Contains(s.ids, id) }
+
+// IDs returns a copy of the selected IDs, in selection order.
+func (s *Set[ID]) IDs() []ID { return slices.Clone(s.ids) }
+
+// Replace makes ids (minus repeats) the whole selection.
+func (s *Set[ID]) Replace(ids []ID) {
+	s.ids = s.ids[:0]
+	s.Add(ids)
+}
+
+// Add appends the ids not already selected, returning how many were new.
+func (s *Set[ID]) Add(ids []ID) int {
+	n := 0
+	for _, id := range ids {
+		if !s.Contains(id) {
+			s.ids = append(s.ids, id)
+			n++
+		}
+	}
+	return n
+}
+
+// Remove deselects id, reporting whether it was selected.
+func (s *Set[ID]) Remove(id ID) bool {
+	i := slices.Index(s.ids, id)
+	if i < 0 {
+		return false
+	}
+	s.ids = slices.Delete(s.ids, i, i+1)
+	return true
+}
+
+// Clear deselects everything.
+func (s *Set[ID]) Clear() { s.ids = nil }
+
+// Prune deselects every ID keep rejects (windows that are gone), returning
+// them.
+func (s *Set[ID]) Prune(keep func(ID) bool) (removed []ID) {
+	s.ids = slices.DeleteFunc(s.ids, func(id ID) bool {
+		if keep(id) {
+			return false
+		}
+		removed = append(removed, id)
+		return true
+	})
+	return removed
+}
@@ -0,0 +1,127 @@
+package winselect
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestBand(t *testing.T) {
+	tests := []struct {
+		name        string
+		anchor, cur Point
+		want        Rect
+	}{
+		{"down-right", Point{10, 20}, Point{110, 220}, Rect{10, 20, 111, 221}},
+		{"up-left", Point{110, 220}, Point{10, 20}, Rect{10, 20, 111, 221}},
+		{"down-left", Point{110, 20}, Point{10, 220}, Rect{10, 20, 111, 221}},
+		{"negative coordinates", Point{-1920, -300}, Point{-1800, 40}, Rect{-1920, -300, -1799, 41}},
+		{"no movement", Point{5, 5}, Point{5, 5}, Rect{5, 5, 6, 6}},
+	}
+	for _, tt := range tests {
+		if got := Band(tt.anchor, tt.cur); got != tt.want {
+			t.Errorf("%s: Band = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+	if !IsClick(Band(Point{5, 5}, Point{5, 5})) || IsClick(Band(Point{5, 5}, Point{6, 5})) {
+		t.Errorf("IsClick must hold exactly for a band that never moved")
+	}
+}
+
+func TestIntersects(t *testing.T) {
+	r := Rect{0, 0, 100, 100}
+	tests := []struct {
+		name string
+		o    Rect
+		want bool
+	}{
+		{"inside", Rect{10, 10, 20, 20}, true},
+		{"containing", Rect{-10, -10, 200, 200}, true},
+		{"overlapping corner", Rect{99, 99, 150, 150}, true},
+		{"touching right edge", Rect{100, 0, 150, 100}, false}, // Right is exclusive
+		{"touching bottom edge", Rect{0, 100, 100, 150}, false},
+		{"empty", Rect{50, 50, 50, 60}, false},
+	}
+	for _, tt := range tests {
+		if got := r.Intersects(tt.o); got != tt.want {
+			t.Errorf("%s: Intersects = %v, want %v", tt.name, got, tt.want)
+		}
+		if got := tt.o.Intersects(r); got != tt.want {
+			t.Errorf("%s (swapped): Intersects = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// desktop is a synthetic Z-order, topmost first: an editor over a
+// terminal, a browser beside them, and a window on a monitor to the left.
+var desktop = []Window[string]{
+	{"editor", Rect{100, 100, 900, 700}},
+	{"terminal", Rect{500, 400, 1200, 900}},
+	{"browser", Rect{1300, 0, 1920, 1040}},
+	{"left-monitor", Rect{-1600, 200, -200, 800}},
+}
+
+func TestTouched(t *testing.T) {
+	tests := []struct {
+		name        string
+		anchor, cur Point
+		want        []string
+	}{
+		{"nothing", Point{1210, 950}, Point{1290, 1000}, nil},
+		{"one", Point{1400, 500}, Point{1500, 600}, []string{"browser"}},
+		{"partly covering two, in Z-order", Point{1100, 800}, Point{800, 50}, []string{"editor", "terminal"}},
+		{"across monitors", Point{-300, 300}, Point{150, 150}, []string{"editor", "left-monitor"}},
+		{"everything", Point{-2000, -100}, Point{2000, 1100}, []string{"editor", "terminal", "browser", "left-monitor"}},
+		{"click picks only the topmost", Point{600, 500}, Point{600, 500}, []string{"editor"}},
+		{"click on the one beneath where it shows", Point{1000, 800}, Point{1000, 800}, []string{"terminal"}},
+	}
+	for _, tt := range tests {
+		if got := Touched(Band(tt.anchor, tt.cur), desktop); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: Touched = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOffsets(t *testing.T) {
+	got := Offsets(desktop[1], desktop[:3])
+	want := []Offset[string]{{"editor", -400, -300}, {"browser", 800, -400}}
+	if !slices.Equal(got, want) {
+		t.Errorf("Offsets = %+v, want %+v", got, want)
+	}
+}
+
+func TestSet(t *testing.T) {
+	var s Set[int]
+	if s.Len() != 0 || s.Contains(1) {
+		t.Fatalf("zero Set must be empty")
+	}
+	s.Replace([]int{3, 1, 3, 2})
+	if got := s.IDs(); !slices.Equal(got, []int{3, 1, 2}) {
+		t.Errorf("after Replace: IDs = %v, want [3 1 2] (repeats dropped, order kept)", got)
+	}
+	if n := s.Add([]int{2, 4, 5, 4}); n != 2 {
+		t.Errorf("Add returned %d new, want 2", n)
+	}
+	if got := s.IDs(); !slices.Equal(got, []int{3, 1, 2, 4, 5}) {
+		t.Errorf("after Add: IDs = %v, want [3 1 2 4 5]", got)
+	}
+	if !s.Remove(1) || s.Remove(1) || s.Contains(1) {
+		t.Errorf("Remove must report whether the ID was selected, once")
+	}
+	ids := s.IDs()
+	ids[0] = 99
+	if s.Contains(99) {
+		t.Errorf("IDs must return a copy")
+	}
+	removed := s.Prune(func(id int) bool { return id%2 == 0 })
+	if !slices.Equal(removed, []int{3, 5}) || !slices.Equal(s.IDs(), []int{2, 4}) {
+		t.Errorf("Prune removed %v leaving %v, want [3 5] leaving [2 4]", removed, s.IDs())
+	}
+	s.Replace([]int{7})
+	if !slices.Equal(s.IDs(), []int{7}) {
+		t.Errorf("Replace must drop the previous selection, got %v", s.IDs())
+	}
+	s.Clear()
+	if s.Len() != 0 {
+		t.Errorf("Clear left %v", s.IDs())
+	}
+}